@@ -3,6 +3,9 @@ package btree
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
 )
 
 const PAGE_SIZE = 4096
@@ -14,14 +17,23 @@ const PAGE_SIZE = 4096
    - | pageMaxSize | 2B Has maximum bytes per page
    - | minItens | 2B Has minimum itens per Node
    - | maxItens | 2B Has maximum itens per node
+   - | freeList | 8B Pointer to the first released page, that can be reused (0 means no page)
+   - | version | 4B Version of the format of the pages, files of other versions can't be read
+   - | checksum | 4B CRC32C of the header fields before it, written by GetBytes
 */
 
+// Version of the format of the pages, increased whenever a change makes files written before unreadable
+const BTREE_FORMAT_VERSION = 1
+
 // Btree sizes in bytes declaration
 const (
-	BTREE_ROOT_SIZE     = 16
-	BTREE_NAME_SIZE     = 400
-	BTREE_MIN_NODE_SIZE = 4
-	BTREE_MAX_NODE_SIZE = 4
+	BTREE_ROOT_SIZE      = 16
+	BTREE_NAME_SIZE      = 400
+	BTREE_MIN_NODE_SIZE  = 4
+	BTREE_MAX_NODE_SIZE  = 4
+	BTREE_FREE_LIST_SIZE = 8
+	BTREE_VERSION_SIZE   = 4
+	BTREE_CHECKSUM_SIZE  = 4
 )
 
 // Btree Page offset
@@ -30,20 +42,23 @@ const (
 	BTREE_OFFSET_NAME          = BTREE_OFFSET_ROOT + BTREE_ROOT_SIZE
 	BTREE_OFFSET_MIN_NODE_SIZE = BTREE_OFFSET_NAME + BTREE_NAME_SIZE
 	BTREE_OFFSET_MAX_NODE_SIZE = BTREE_OFFSET_MIN_NODE_SIZE + BTREE_MIN_NODE_SIZE
+	BTREE_OFFSET_FREE_LIST     = BTREE_OFFSET_MAX_NODE_SIZE + BTREE_MAX_NODE_SIZE
+	BTREE_OFFSET_VERSION       = BTREE_OFFSET_FREE_LIST + BTREE_FREE_LIST_SIZE
+	BTREE_OFFSET_CHECKSUM      = BTREE_OFFSET_VERSION + BTREE_VERSION_SIZE
 )
 
 /*
 Base Btree Implementation
 */
 type BTree struct {
-	data      []byte                // Page header
-	pageSize  uint32                // Page Size. It's still hardcoded
-	root      uint64                // Indicates Where the root page starts
-	SetHeader func(BTree)           // Update header whenever needed
-	Get       func(uint64) TreeNode // Returns a Tree Node
-	New       func(TreeNode) uint64 // Allocate a new Page
-	Del       func(uint64)
-	Set       func(TreeNode, uint64) bool // Del a new page
+	data      []byte                      // Page header
+	pageSize  uint32                      // Page Size. It's still hardcoded
+	root      uint64                      // Indicates Where the root page starts
+	SetHeader func(BTree)                 // Update header whenever needed
+	Get       func(uint64) TreeNode       // Returns a Tree Node, panics with *ErrPageCorrupted or *ErrPageRead
+	New       func(TreeNode) uint64       // Allocate a new Page
+	Del       func(uint64)                // Release a page, so it can be reused by New
+	Set       func(TreeNode, uint64) bool // Update a page
 }
 
 type TreeNodePage struct {
@@ -56,6 +71,59 @@ type BTreeKeyValue struct {
 	Value []byte
 }
 
+// ErrPageCorrupted is reported when a page read doesn't match its checksum
+type ErrPageCorrupted struct {
+	Page uint64
+}
+
+func (e *ErrPageCorrupted) Error() string {
+	return fmt.Sprintf("page %d is corrupted", e.Page)
+}
+
+// ErrPageRead is reported when a page can't be read for any other reason than its content, like an I/O error
+type ErrPageRead struct {
+	Page uint64
+	Err  error
+}
+
+func (e *ErrPageRead) Error() string {
+	return fmt.Sprintf("page %d can't be read: %v", e.Page, e.Err)
+}
+
+func (e *ErrPageRead) Unwrap() error {
+	return e.Err
+}
+
+/*
+Callbacks can't return errors, so the Get callback reports corrupted pages by panicking with an *ErrPageCorrupted,
+and pages it can't read with an *ErrPageRead. Functions returning an error defer RecoverPageCorrupted(&err) to turn
+them back into their error. Any other panic goes on
+*/
+func RecoverPageCorrupted(err *error) {
+	r := recover()
+
+	if r == nil {
+		return
+	}
+
+	switch pageErr := r.(type) {
+	case *ErrPageCorrupted:
+		*err = pageErr
+	case *ErrPageRead:
+		*err = pageErr
+	default:
+		panic(r)
+	}
+}
+
+// Returns whether err was reported by the Get callback of a tree, see RecoverPageCorrupted
+func IsPageError(err error) bool {
+	var corrupted *ErrPageCorrupted
+	var read *ErrPageRead
+
+	return errors.As(err, &corrupted) || errors.As(err, &read)
+}
+
 func NewTree(pageSize int) *BTree {
 	// Returns a pointer to the new BTree in Memory
 	nBTree := &BTree{
@@ -66,6 +134,7 @@ func NewTree(pageSize int) *BTree {
 	// For new Trees root will be zero, meaning that there is no page
 	nBTree.root = 0
 	nBTree.SetRoot(0)
+	binary.LittleEndian.PutUint32(nBTree.data[BTREE_OFFSET_VERSION:BTREE_OFFSET_CHECKSUM], BTREE_FORMAT_VERSION)
 
 	return nBTree
 }
@@ -81,10 +150,38 @@ func LoadTree(bTree []byte, pageSize uint32) *BTree {
 	return tree
 }
 
+// Returns the header with its checksum updated, so it must be called whenever the header is going to be written
 func (b *BTree) GetBytes() []byte {
+	binary.LittleEndian.PutUint32(b.data[BTREE_OFFSET_CHECKSUM:BTREE_OFFSET_CHECKSUM+BTREE_CHECKSUM_SIZE], getHeaderChecksum(b.data))
 	return []byte(b.data)
 }
 
+func (b *BTree) GetVersion() uint32 {
+	return binary.LittleEndian.Uint32(b.data[BTREE_OFFSET_VERSION:BTREE_OFFSET_CHECKSUM])
+}
+
+/*
+Verifies the header read from a file. Returns an error when it was written in another format version, and an
+*ErrPageCorrupted for page 0 when it doesn't match the checksum written by GetBytes
+*/
+func (b *BTree) VerifyHeader() error {
+	if version := b.GetVersion(); version != BTREE_FORMAT_VERSION {
+		return fmt.Errorf("format version %d is not supported, only version %d can be read", version, BTREE_FORMAT_VERSION)
+	}
+
+	checksum := binary.LittleEndian.Uint32(b.data[BTREE_OFFSET_CHECKSUM : BTREE_OFFSET_CHECKSUM+BTREE_CHECKSUM_SIZE])
+	if checksum != getHeaderChecksum(b.data) {
+		return &ErrPageCorrupted{Page: 0}
+	}
+
+	return nil
+}
+
+// CRC32C of the header fields, the rest of the page is not used
+func getHeaderChecksum(data []byte) uint32 {
+	return crc32.Checksum(data[:BTREE_OFFSET_CHECKSUM], nodeCrcTable)
+}
+
 func (b *BTree) SetRoot(root uint64) {
 	// Insert value into data structure
 	binary.LittleEndian.PutUint64(b.data[BTREE_OFFSET_ROOT:BTREE_ROOT_SIZE], root)
@@ -107,6 +204,15 @@ func (b *BTree) GetRoot() uint64 {
 	return uint64(binary.LittleEndian.Uint64(b.data[BTREE_OFFSET_ROOT:BTREE_ROOT_SIZE]))
 }
 
+// Returns the first page of the free pages list, zero means that there is no free page
+func (b *BTree) GetFreeListHead() uint64 {
+	return binary.LittleEndian.Uint64(b.data[BTREE_OFFSET_FREE_LIST : BTREE_OFFSET_FREE_LIST+BTREE_FREE_LIST_SIZE])
+}
+
+func (b *BTree) SetFreeListHead(page uint64) {
+	binary.LittleEndian.PutUint64(b.data[BTREE_OFFSET_FREE_LIST:BTREE_OFFSET_FREE_LIST+BTREE_FREE_LIST_SIZE], page)
+}
+
 /*
 BTreeInsert
 Main function to insert a key value to a bTree
@@ -148,6 +254,11 @@ BTreeDelete - Base function to delete a key value from bTree
 func BTreeDelete(bTree *BTree, key []byte) {
 	// Load root page
 	rootAddr := bTree.GetRoot()
+	// Empty tree
+	if rootAddr == 0 {
+		return
+	}
+
 	rootPage := bTree.Get(rootAddr)
 	// Lookup tree to find
 	leaf, history := findLeaf(bTree, rootPage, key, rootAddr, make([]TreeNodePage, 0))
@@ -162,6 +273,11 @@ func BTreeDelete(bTree *BTree, key []byte) {
 func BTreeUpdate(bTree *BTree, key []byte, value []byte) {
 	// Load root page
 	rootAddr := bTree.GetRoot()
+	// Empty tree
+	if rootAddr == 0 {
+		return
+	}
+
 	rootPage := bTree.Get(rootAddr)
 	// Lookup tree to find
 	leaf, history := findLeaf(bTree, rootPage, key, rootAddr, make([]TreeNodePage, 0))
@@ -188,6 +304,11 @@ func BTreeGetOne(bTree *BTree, key []byte) *BTreeKeyValue {
 	var keyValue *BTreeKeyValue = nil
 	// Load root page
 	rootAddr := bTree.GetRoot()
+	// Empty tree
+	if rootAddr == 0 {
+		return nil
+	}
+
 	rootPage := bTree.Get(rootAddr)
 	// Lookup tree to find
 	leaf, _ := findLeaf(bTree, rootPage, key, rootAddr, make([]TreeNodePage, 0))
@@ -223,13 +344,18 @@ func BTreeGet(bTree *BTree, key []byte) []BTreeKeyValue {
 	var keyValues []BTreeKeyValue = make([]BTreeKeyValue, 0)
 	// Load root page
 	rootAddr := bTree.GetRoot()
+	// Empty tree
+	if rootAddr == 0 {
+		return keyValues
+	}
+
 	rootPage := bTree.Get(rootAddr)
 	// Lookup tree to find many keys values if they exist
 	leavesFound, history := findLeaves(bTree, rootPage, key, rootAddr, make([]TreeNodePage, 0))
-	/* if leavesFound is empty, and history is also empty, means that the root page is a Leaf.
-	Therefore, we induce that there might be a key in root page
+	/* if leavesFound is empty, and the root page is a Leaf, we induce that there might be a key in root page.
+	An internal root page with no leaves found means that the key is smaller than every key in the tree
 	*/
-	if len(leavesFound) == 0 && len(history) == 0 {
+	if len(leavesFound) == 0 && len(history) == 0 && rootPage.GetType() == TREE_LEAF {
 		// Get all key values from root page
 		leavesFound = append(leavesFound, TreeNodePage{node: rootPage, page: rootAddr})
 	}
@@ -246,7 +372,7 @@ func BTreeGet(bTree *BTree, key []byte) []BTreeKeyValue {
 					keyValue.Value = allLeafValues[j].GetValue()
 					// In case it has no sequence
 					if leavesFound[i].node.GetLeafHasSeq() == uint16(1) {
-						keyValue.Value = getAllBytesFromSequences(bTree, leavesFound[j].node)
+						keyValue.Value = getAllBytesFromSequences(bTree, leavesFound[i].node)
 					}
 					keyValues = append(keyValues, *keyValue)
 				}
@@ -267,20 +393,27 @@ in the bTree. In this case, we will use this function to get the first leaf and
 the next leaf in the bTree.
 
 For a given key []byte, this function will return the leaf that contains the key or the leaf that contains the next key in the
-bTree. If the key is not found, it will return the leaf that contains the next key in the bTree.
+bTree. If every key of the leaf is smaller than the given key, the cursor is left at the last key of the leaf, so that Next()
+reaches the next key in the bTree.
 */
 
 func (tree *BTree) FindLeafForCrawling(key []byte) *BTreeCrawler {
 	crawler := newBTreeCrawler(tree)
 	rootAddr := tree.GetRoot()
+	// Empty tree, the crawler has no key
+	if rootAddr == 0 {
+		return crawler
+	}
+
 	page := tree.Get(rootAddr)
 	// While loop to find the leaf
 	for {
 		if page.GetType() == TREE_NODE {
 			cur := -1
-			// In case all keys are smaller than our key we assume that the lask key may be within the last child
-			if cur = lookupKey(page, key, true); cur == -1 {
-				cur = int(page.GetNItens() - 1)
+			// The key is within the last child whose first key is smaller or equal to it. In case all keys are
+			// greater than our key, the first child holds the smallest keys
+			if cur = lookupKey(page, key, false); cur == -1 {
+				cur = 0
 			}
 
 			crawler.Net = append(crawler.Net, page)
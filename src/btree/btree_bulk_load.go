@@ -0,0 +1,110 @@
+package btree
+
+import (
+	"bytes"
+	"fmt"
+)
+
+const (
+	// Bytes left free in every page built by a bulk load, so the first insertions into it don't split it
+	BULK_LOAD_FREE_BYTES = PAGE_SIZE / 10
+)
+
+/*
+BTreeBulkLoad builds an empty tree from key values sorted by their keys. Instead of looking for the leaf of every key,
+leaves are filled one after the other, and then the nodes above them, level by level, until there is a single root.
+Returns an error if the tree is not empty or the keys are not sorted, without changing anything
+*/
+func BTreeBulkLoad(bTree *BTree, keyValues []BTreeKeyValue) error {
+	if bTree.GetRoot() != 0 {
+		return fmt.Errorf("bulk load needs an empty tree")
+	}
+
+	for i := 1; i < len(keyValues); i++ {
+		if bytes.Compare(keyValues[i-1].Key, keyValues[i].Key) > 0 {
+			return fmt.Errorf("bulk load needs keys sorted, key %d is smaller than the previous one", i)
+		}
+	}
+
+	if len(keyValues) == 0 {
+		return nil
+	}
+
+	level := bulkLoadLeaves(bTree, keyValues)
+	for len(level) > 1 {
+		level = bulkLoadNodes(bTree, level)
+	}
+
+	// A single leaf or node is the root, whose parent is the header
+	bTree.SetRoot(level[0].page)
+	bTree.SetHeader(*bTree)
+
+	return nil
+}
+
+// Fills leaves with key values, returning the leaves created in the order of their keys
+func bulkLoadLeaves(bTree *BTree, keyValues []BTreeKeyValue) []TreeNodePage {
+	leaves := make([]TreeNodePage, 0)
+	leaf := NewNodeLeaf()
+
+	flush := func() {
+		if leaf.GetNItens() > 0 {
+			leaves = append(leaves, TreeNodePage{node: *leaf, page: bTree.New(*leaf)})
+			leaf = NewNodeLeaf()
+		}
+	}
+
+	for _, keyValue := range keyValues {
+		// Large values need sequences, and their leaves hold nothing else
+		if (len(keyValue.Key) + len(keyValue.Value) + 10) > PAGE_SIZE-LEAF_VAL_START_OFFSET {
+			flush()
+			leaves = append(leaves, *createLeafAndSequencesForLargeBytes(bTree, keyValue.Key, keyValue.Value))
+			continue
+		}
+
+		if mustSplitNode(*leaf, len(keyValue.Key)+BULK_LOAD_FREE_BYTES, len(keyValue.Value)) {
+			flush()
+		}
+
+		leaf.PutLeafNewKeyValue(keyValue.Key, keyValue.Value)
+	}
+
+	flush()
+	return leaves
+}
+
+/*
+Creates the nodes that point to the pages of a level, returning them in the order of their keys. Pages of the level
+are written again, now pointing to their parents
+*/
+func bulkLoadNodes(bTree *BTree, children []TreeNodePage) []TreeNodePage {
+	nodes := make([]TreeNodePage, 0)
+	node := NewNodeNode()
+	first := 0
+
+	flush := func(last int) {
+		page := bTree.New(*node)
+
+		for i := first; i < last; i++ {
+			setParentAddr(&children[i].node, page)
+			bTree.Set(children[i].node, children[i].page)
+		}
+
+		nodes = append(nodes, TreeNodePage{node: *node, page: page})
+		node = NewNodeNode()
+		first = last
+	}
+
+	for i, child := range children {
+		key := getFirstKey(child.node)
+
+		if node.GetNItens() > 0 && mustSplitNode(*node, len(key)+BULK_LOAD_FREE_BYTES, 0) {
+			flush(i)
+		}
+
+		node.PutNodeNewChild(key, child.page)
+	}
+
+	flush(len(children))
+	return nodes
+}
@@ -0,0 +1,240 @@
+package btree
+
+import (
+	"bytes"
+)
+
+/*
+Concurrent operations
+
+These work just like BTreeInsert, BTreeDelete, BTreeUpdate, BTreeGet and FindLeafForCrawling, but many goroutines
+can run them on the same tree, as long as each one of them has its own guard from the same Latches (see Latches).
+The callbacks of the tree must be safe for concurrent use.
+
+Writers return still holding the latches they took, so that nobody reads the leaf they changed before it's
+committed. The caller must release the guard once the change is committed, or discarded. When the guard ends up
+exclusive, the operation changed the structure of the tree and anything it did must be discarded before releasing
+it, otherwise other operations see it half done.
+*/
+
+// ConcurrentBTreeInsert inserts a key value, holding the write latch of its leaf or the structure latch when it returns
+func ConcurrentBTreeInsert(bTree *BTree, guard *LatchGuard, key []byte, value []byte) {
+	if !guard.IsExclusive() {
+		leaf, history := findLeafWithLatches(bTree, guard, key, true)
+
+		if leaf != nil {
+			changed := changeLeafAlone(leaf.node, len(history) == 0, func(node *TreeNode) bool {
+				if mustSplitNode(*node, len(key), len(value)) {
+					return false
+				}
+
+				node.PutLeafNewKeyValue(key, value)
+				return true
+			})
+
+			if changed != nil {
+				bTree.Set(*changed, leaf.page)
+				return
+			}
+		}
+
+		guard.Upgrade()
+	}
+
+	BTreeInsert(bTree, key, value)
+}
+
+// ConcurrentBTreeDelete deletes a key, holding the write latch of its leaf or the structure latch when it returns
+func ConcurrentBTreeDelete(bTree *BTree, guard *LatchGuard, key []byte) {
+	if !guard.IsExclusive() {
+		leaf, history := findLeafWithLatches(bTree, guard, key, true)
+
+		// Nothing to delete
+		if leaf == nil || leaf.node.GetLeafKeyValueByKey(key) == nil {
+			return
+		}
+
+		changed := changeLeafAlone(leaf.node, len(history) == 0, func(node *TreeNode) bool {
+			node.DeleteLeafKeyValueByKey(key)
+			return true
+		})
+
+		if changed != nil {
+			bTree.Set(*changed, leaf.page)
+			return
+		}
+
+		guard.Upgrade()
+	}
+
+	BTreeDelete(bTree, key)
+}
+
+// ConcurrentBTreeUpdate updates a key value, holding the write latch of its leaf or the structure latch when it returns
+func ConcurrentBTreeUpdate(bTree *BTree, guard *LatchGuard, key []byte, value []byte) {
+	if !guard.IsExclusive() {
+		leaf, history := findLeafWithLatches(bTree, guard, key, true)
+
+		// Just like BTreeUpdate, there is nothing to update
+		if leaf == nil {
+			return
+		}
+
+		changed := changeLeafAlone(leaf.node, len(history) == 0, func(node *TreeNode) bool {
+			node.DeleteLeafKeyValueByKey(key)
+
+			if mustSplitNode(*node, len(key), len(value)) {
+				return false
+			}
+
+			node.PutLeafNewKeyValue(key, value)
+			return true
+		})
+
+		if changed != nil {
+			bTree.Set(*changed, leaf.page)
+			return
+		}
+
+		guard.Upgrade()
+	}
+
+	BTreeUpdate(bTree, key, value)
+}
+
+/*
+ConcurrentBTreeGet returns every key value stored for the key. Every page read is read latched until it returns,
+so leaves being changed are never read half written
+*/
+func ConcurrentBTreeGet(bTree *BTree, guard *LatchGuard, key []byte) []BTreeKeyValue {
+	latched := *bTree
+	pages := make([]uint64, 0)
+
+	latched.Get = func(page uint64) TreeNode {
+		if !guard.holds(page) {
+			guard.RLatch(page)
+			pages = append(pages, page)
+		}
+
+		return bTree.Get(page)
+	}
+
+	defer func() {
+		for _, page := range pages {
+			guard.Unlatch(page)
+		}
+	}()
+
+	return BTreeGet(&latched, key)
+}
+
+/*
+ConcurrentFindLeafForCrawling works like FindLeafForCrawling, going down the tree with latch coupling. The crawler
+holds copies of the pages found, and latches nothing once it's returned, so crawling alongside writers may see
+their changes as they are committed
+*/
+func ConcurrentFindLeafForCrawling(bTree *BTree, guard *LatchGuard, key []byte) *BTreeCrawler {
+	crawler := newBTreeCrawler(bTree)
+	pageAddr := bTree.GetRoot()
+	// Empty tree, the crawler has no key
+	if pageAddr == 0 {
+		return crawler
+	}
+
+	guard.RLatch(pageAddr)
+	page := bTree.Get(pageAddr)
+
+	for page.GetType() == TREE_NODE {
+		cur := -1
+		if cur = lookupKey(page, key, false); cur == -1 {
+			cur = 0
+		}
+
+		crawler.Net = append(crawler.Net, page)
+		crawler.Cursor = append(crawler.Cursor, cur)
+
+		// The child is latched before its parent is released
+		child := page.GetNodeChildByIndex(cur).GetAddr()
+		guard.RLatch(child)
+		guard.Unlatch(pageAddr)
+		pageAddr = child
+		page = bTree.Get(pageAddr)
+	}
+
+	crawler = findLeafInPage(page, key, crawler)
+	crawler.CurrentKeyValues = getAllLeafKeyValues(&page)
+	guard.Unlatch(pageAddr)
+
+	return crawler
+}
+
+/*
+Works like findLeaf, going down the tree with latch coupling. The leaf is returned latched, for writing when write
+is set, while every ancestor is released on the way, since internal nodes don't change while the structure latch is
+held shared. Returns nil, holding nothing, when the tree is empty or the key is smaller than every key in it
+*/
+func findLeafWithLatches(bTree *BTree, guard *LatchGuard, key []byte, write bool) (*TreeNodePage, []TreeNodePage) {
+	page := bTree.GetRoot()
+	if page == 0 {
+		return nil, nil
+	}
+
+	history := make([]TreeNodePage, 0)
+	guard.RLatch(page)
+	node := bTree.Get(page)
+
+	for {
+		// Read latches can't be upgraded, so the leaf is read again once its write latch is taken
+		if write && node.GetType() == TREE_LEAF {
+			guard.Unlatch(page)
+			guard.WLatch(page)
+			node = bTree.Get(page)
+		}
+
+		if len(history) > 0 {
+			guard.Unlatch(history[len(history)-1].page)
+		}
+
+		if node.GetType() == TREE_LEAF {
+			return &TreeNodePage{node: node, page: page}, history
+		}
+
+		idx := -1
+		if node.GetNItens() > 0 {
+			idx = lookupKey(node, key, false)
+		}
+
+		if idx == -1 {
+			guard.Unlatch(page)
+			return nil, nil
+		}
+
+		history = append(history, TreeNodePage{node: node, page: page})
+		page = node.GetNodeChildByIndex(idx).addr
+		guard.RLatch(page)
+		node = bTree.Get(page)
+	}
+}
+
+/*
+Applies change to a copy of the leaf, returning the copy when no other page has to change along with it: the leaf
+has no sequence, keeps its first key, so that its parent stays the same, and doesn't get empty or, unless it's the
+root, too small. The change itself tells whether the leaf has room for it. Returns nil otherwise
+*/
+func changeLeafAlone(leaf TreeNode, isRoot bool, change func(*TreeNode) bool) *TreeNode {
+	if leaf.GetType() != TREE_LEAF || leaf.GetLeafHasSeq() == 1 || leaf.GetNItens() == 0 {
+		return nil
+	}
+
+	changed := LoadTreeNode(append([]byte(nil), leaf.data...))
+
+	if !change(changed) || changed.GetNItens() == 0 {
+		return nil
+	}
+
+	if !bytes.Equal(getFirstKey(*changed), getFirstKey(leaf)) || (!isRoot && isUnderflowed(changed)) {
+		return nil
+	}
+
+	return changed
+}
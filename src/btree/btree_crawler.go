@@ -31,13 +31,14 @@ func findLeafInPage(page TreeNode, key []byte, crawler *BTreeCrawler) *BTreeCraw
 		}
 	}
 
-	crawler.Net = append(crawler.Net, page)
-	crawler.Cursor = append(crawler.Cursor, found)
-	// If the key is not found, we will return the next leaf
+	// If the key is not found, the cursor stays at the last key, so that calling Next goes to the next leaf
 	if found == -1 {
-		crawler = nil
+		found = int(page.GetNItens()) - 1
 	}
 
+	crawler.Net = append(crawler.Net, page)
+	crawler.Cursor = append(crawler.Cursor, found)
+
 	return crawler
 }
 
@@ -53,6 +54,11 @@ that are not in index and all values must be evaluated
 func GoToFirstLeaf(tree *BTree) *BTreeCrawler {
 	crawler := newBTreeCrawler(tree)
 	rootAddr := tree.GetRoot()
+	// Empty tree, the crawler has no key
+	if rootAddr == 0 {
+		return crawler
+	}
+
 	page := tree.Get(rootAddr)
 	// While loop to find the leaf
 	for {
@@ -80,6 +86,11 @@ that are not in index and all values must be evaluated from backyards
 func GoToLastLeaf(tree *BTree) *BTreeCrawler {
 	crawler := newBTreeCrawler(tree)
 	rootAddr := tree.GetRoot()
+	// Empty tree, the crawler has no key
+	if rootAddr == 0 {
+		return crawler
+	}
+
 	page := tree.Get(rootAddr)
 	// While loop to find the leaf
 	for {
@@ -104,7 +115,9 @@ func GoToLastLeaf(tree *BTree) *BTreeCrawler {
 This function makes the crawler go to the next key value in the bTree if it is leaf
 Otherwise it finds the next branch containing leaf and goes after it
 */
-func (crawler *BTreeCrawler) Next() error {
+func (crawler *BTreeCrawler) Next() (err error) {
+	defer RecoverPageCorrupted(&err)
+
 	if len(crawler.Net) == 0 {
 		return fmt.Errorf("no more keys")
 	}
@@ -129,7 +142,7 @@ func (crawler *BTreeCrawler) Next() error {
 	if lastNodeIdx == int(lastNode.GetNItens()-1) {
 
 		removeLastIdx(crawler)
-		crawler.Next()
+		return crawler.Next()
 	} else {
 		// If it is not the last key in leaf, we just increment the Cursor
 		crawler.Cursor[len(crawler.Cursor)-1]++
@@ -139,9 +152,14 @@ func (crawler *BTreeCrawler) Next() error {
 			crawler.Net = append(crawler.Net, nextNode)
 			crawler.Cursor = append(crawler.Cursor, 0)
 
-			if nextNode.GetType() == TREE_LEAF {
-				crawler.CurrentKeyValues = getAllLeafKeyValues(&nextNode)
+			// Goes down until the first leaf of the branch
+			for nextNode.GetType() == TREE_NODE {
+				nextNode = crawler.bTree.Get(nextNode.GetNodeChildByIndex(0).GetAddr())
+				crawler.Net = append(crawler.Net, nextNode)
+				crawler.Cursor = append(crawler.Cursor, 0)
 			}
+
+			crawler.CurrentKeyValues = getAllLeafKeyValues(&nextNode)
 		}
 	}
 
@@ -151,7 +169,9 @@ func (crawler *BTreeCrawler) Next() error {
 /*
 Same as Next() but goes to the previous key value in the bTree
 */
-func (crawler *BTreeCrawler) Previous() error {
+func (crawler *BTreeCrawler) Previous() (err error) {
+	defer RecoverPageCorrupted(&err)
+
 	if len(crawler.Net) == 0 {
 		return fmt.Errorf("no more keys")
 	}
@@ -174,7 +194,7 @@ func (crawler *BTreeCrawler) Previous() error {
 
 	if lastNodeIdx == 0 {
 		removeLastIdx(crawler)
-		crawler.Previous()
+		return crawler.Previous()
 	} else {
 		// If it is not the last key in leaf, we just decrement the Cursor
 		crawler.Cursor[len(crawler.Cursor)-1]--
@@ -185,10 +205,15 @@ func (crawler *BTreeCrawler) Previous() error {
 			crawler.Net = append(crawler.Net, nextNode)
 			crawler.Cursor = append(crawler.Cursor, int(nextNode.GetNItens())-1)
 
-			// Update the current key values
-			if nextNode.GetType() == TREE_LEAF {
-				crawler.CurrentKeyValues = getAllLeafKeyValues(&nextNode)
+			// Goes down until the last leaf of the branch
+			for nextNode.GetType() == TREE_NODE {
+				nextNode = crawler.bTree.Get(nextNode.GetNodeChildByIndex(int(nextNode.GetNItens()) - 1).GetAddr())
+				crawler.Net = append(crawler.Net, nextNode)
+				crawler.Cursor = append(crawler.Cursor, int(nextNode.GetNItens())-1)
 			}
+
+			// Update the current key values
+			crawler.CurrentKeyValues = getAllLeafKeyValues(&nextNode)
 		}
 	}
 
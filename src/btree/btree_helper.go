@@ -4,6 +4,11 @@ import (
 	"bytes"
 )
 
+const (
+	// Nodes and leaves using less bytes than this borrow items from a sibling or are merged into it
+	NODE_UNDERFLOW_BYTES = PAGE_SIZE / 4
+)
+
 // This structure is used to map all leaves and history from bTree
 type TreeNodeHistoryPages struct {
 	TreeNode uint64
@@ -17,6 +22,14 @@ Starts bTree when it's a new table, or new view.
 */
 
 func startsNewBTree(bTree *BTree, key []byte, value []byte) {
+	// Large values need sequences, the root is the leaf that points to them
+	if (len(key) + len(value) + 10) > PAGE_SIZE-LEAF_VAL_START_OFFSET {
+		leaf := createLeafAndSequencesForLargeBytes(bTree, key, value)
+		bTree.SetRoot(leaf.page)
+		bTree.SetHeader(*bTree)
+		return
+	}
+
 	newLeaf := NewNodeLeaf()
 	// Means that it is top page
 	setParentAddr(newLeaf, 0)
@@ -84,7 +97,7 @@ func findLeafByOrder(bTree *BTree, node TreeNode, page uint64, history []TreeNod
 		nodeChild := node.GetNodeChildByIndex(idxToSearch)
 		// Read First Node
 		foundNode := bTree.Get(nodeChild.addr)
-		history = append(history, TreeNodePage{node: foundNode, page: nodeChild.addr})
+		history = append(history, TreeNodePage{node: node, page: page})
 		return findLeafByOrder(bTree, foundNode, nodeChild.addr, history, order)
 	}
 
@@ -170,6 +183,11 @@ After the map we'll have the following array
 
 func MapAllLeavesToArray(bTree *BTree) []TreeNodeHistoryPages {
 	var mappedLeaves []TreeNodeHistoryPages = make([]TreeNodeHistoryPages, 0)
+	// Empty tree
+	if bTree.GetRoot() == 0 {
+		return mappedLeaves
+	}
+
 	root := *new(TreeNodePage)
 	// Bind base history to root
 	root = TreeNodePage{
@@ -260,59 +278,51 @@ func getAllBytesFromSequences(bTree *BTree, node TreeNode) []byte {
 	return value
 }
 
+/*
+Inserts the leaf that holds a large value (with sequences) next to the leaf found for its key. Keys from the
+found leaf that are greater than the new one are moved to a new leaf, placed right after the large one, so
+leaves are kept sorted
+*/
 func insertOneKeyLeafAndReorderTree(bTree *BTree, tPage TreeNodePage, SeqPage TreeNodePage, history []TreeNodePage) {
-
-	if len(history) == 0 { // Case first node is a leaf node
-		createRootNodeAndInsertLeaves(bTree, []TreeNodePage{tPage, SeqPage})
-		return
+	key := SeqPage.node.GetLeafKeyValueByIndex(0).key
+	// The leaf is followed by the new one, unless every key of the leaf is greater
+	newPages := []TreeNodePage{tPage, SeqPage}
+
+	if bytes.Compare(key, getFirstKey(tPage.node)) < 0 {
+		newPages = []TreeNodePage{SeqPage, tPage}
+	} else if tPage.node.GetLeafHasSeq() == 0 {
+		lower, greater := splitLeafByKey(&tPage.node, key)
+		if greater.GetNItens() > 0 {
+			setParentAddr(lower, tPage.node.GetParentAddr())
+			tPage.node = *lower
+			bTree.Set(tPage.node, tPage.page)
+			newPages = []TreeNodePage{tPage, SeqPage, {node: *greater, page: bTree.New(*greater)}}
+		}
 	}
 
-	parentNode := history[len(history)-1]
-	// Verify if must split node
-	keyLen := SeqPage.node.GetLeafKeyValueByIndex(0).GetKeyLen()
-
-	// If the parent key must be splitted we must create a new node and insert it into parent
-	if mustSplitNode(parentNode.node, int(keyLen), 8) {
-		splitParentNodeRecursivellyAndReorderTreeIfNeeded(bTree, SeqPage, history)
+	if len(history) == 0 { // Case first node is a leaf node
+		createRootNodeAndInsertLeaves(bTree, newPages)
 		return
 	}
 
-	// Set parent addr
-	parentNode.node.PutNodeNewChild(SeqPage.node.GetLeafKeyValueByIndex(0).GetKey(), SeqPage.page)
-	// Set parent into bTree
-	bTree.Set(parentNode.node, parentNode.page)
+	insertNodesRecursivelly(bTree, tPage.page, newPages, history)
 }
 
-func splitParentNodeRecursivellyAndReorderTreeIfNeeded(bTree *BTree, SeqPage TreeNodePage, history []TreeNodePage) {
-
-	var key []byte = nil
-
-	if SeqPage.node.GetType() == TREE_LEAF {
-		key = SeqPage.node.GetLeafKeyValueByIndex(0).GetKey()
-	} else {
-		key = SeqPage.node.GetNodeChildByIndex(0).GetKey()
-	}
-
-	parentNode := history[len(history)-1]
-
-	// If the parent key must be splitted we must create a new node and insert it into parent
-	splittedNode := parentNode.node.SplitNode(key, SeqPage.page)
-	newPage := bTree.New(splittedNode[1])
-	// Verify whether parent is root
-	if parentNode.page == bTree.GetRoot() {
-		createRootNodeAndInsertLeaves(bTree, []TreeNodePage{{node: splittedNode[0], page: parentNode.page}, {node: splittedNode[1], page: newPage}})
-		return
-	}
+// Splits the leaf values in two new leaves, the first one with keys lower or equal to key and the second with the greater ones
+func splitLeafByKey(n *TreeNode, key []byte) (*TreeNode, *TreeNode) {
+	lower := NewNodeLeaf()
+	greater := NewNodeLeaf()
 
-	if len(history)-2 > 0 {
-		if mustSplitNode(history[len(history)-2].node, len(key), 8) {
-			splitParentNodeRecursivellyAndReorderTreeIfNeeded(bTree, TreeNodePage{node: splittedNode[0], page: parentNode.page}, history[:len(history)-1])
-			return
+	allLeafMembers := getAllLeafKeyValues(n)
+	for i := 0; i < len(allLeafMembers); i++ {
+		if bytes.Compare(allLeafMembers[i].key, key) <= 0 {
+			lower.PutLeafNewKeyValue(allLeafMembers[i].key, allLeafMembers[i].value)
+		} else {
+			greater.PutLeafNewKeyValue(allLeafMembers[i].key, allLeafMembers[i].value)
 		}
 	}
 
-	parentNode.node.PutNodeNewChild(key, SeqPage.page)
-	bTree.Set(parentNode.node, parentNode.page)
+	return lower, greater
 }
 
 /*
@@ -347,6 +357,8 @@ func splitBackyardsRecursively(
 		bTree.Set(*newInternalNode, newNodeAddress)
 		bTree.SetRoot(newNodeAddress)
 		bTree.SetHeader(*bTree)
+		// The old root leaf was copied into the new leaves
+		releasePage(bTree, tPage.page)
 		return
 	}
 
@@ -368,112 +380,63 @@ func splitBackyardsRecursively(
 	}
 
 	// Insert into node recursivelly
-	insertNodesRecursivelly(bTree, tPage, newLeavesPages, history)
+	insertNodesRecursivelly(bTree, tPage.page, newLeavesPages, history)
+	// The old leaf is not referenced anymore
+	releasePage(bTree, tPage.page)
 }
 
-func shiftValuesBetweenLeaves(bTree *BTree, tPage TreeNodePage, history []TreeNodePage, key []byte, value []byte) {
-	parentAddr := tPage.node.GetParentAddr()
-	// check if parent is not zero, indicating that there is a node above it
-	if len(history) == 0 {
-		splitBackyardsRecursively(
-			bTree,
-			tPage,
-			history,
-			key,
-			value,
-		)
-		return
+/*
+When a leaf overflows, the values that don't fit anymore are moved to its right sibling (the next leaf
+under the same parent), as long as the sibling has room for all of them. This avoids splitting leaves
+when inserting unsorted data. Returns false when the values couldn't be shifted and the leaf must be split
+*/
+func shiftValuesToRightSibling(bTree *BTree, tPage TreeNodePage, history []TreeNodePage, key []byte, value []byte) bool {
+	if len(history) == 0 || tPage.node.GetLeafHasSeq() == 1 {
+		return false
 	}
-	// If it came to here, at least a leaf must be split
-	splittedLeaf := tPage.node.SplitLeaf(key, value)
-	setParentAddr(&splittedLeaf[0], parentAddr)
-	// Get first key from splittedLeaf
-	firstKey := splittedLeaf[0].GetLeafKeyValueByIndex(0)
-	// Parent node directly related to tPage
-	parentNode := history[len(history)-1]
-	// Delete it by address
-	parentNode.node.DeleteNodeChildrenByAddress(tPage.page)
-	// Insert again the new address with new key
-	parentNode.node.PutNodeNewChild(firstKey.key, tPage.page)
-	// We don't update it yet, we check for the right part of the split
-	rightLeaf := splittedLeaf[1]
-	// We can update the value already in page using left side splitted leaf and parent
-	bTree.Set(splittedLeaf[0], tPage.page)
-	bTree.Set(parentNode.node, parentNode.page)
-
-	// For every new value we check if it is possible to insert into the next one
-	keyValuesToBeReinserted := getAllLeafKeyValues(&rightLeaf)
 
-	parentKeyAddr := getAllNodeKeyAddr(&parentNode.node)
-
-	mappedLeaves := MapAllLeavesToArray(bTree)
+	parentNode := history[len(history)-1]
+	idx := getNodeChildIndexByPage(&parentNode.node, tPage.page)
+	if idx == -1 || idx+1 >= int(parentNode.node.GetNItens()) {
+		return false
+	}
 
-	// Find where is the start leaf from tPage
-	startIndex := 0
-	for i := 0; i < len(mappedLeaves); i++ {
-		if mappedLeaves[i].TreeNode == tPage.page {
-			startIndex = i + 1 // Should never be the last
-			break
-		}
+	siblingAddr := parentNode.node.GetNodeChildByIndex(idx + 1).addr
+	sibling := bTree.Get(siblingAddr)
+	if sibling.GetType() != TREE_LEAF || sibling.GetLeafHasSeq() == 1 {
+		return false
 	}
 
-	mappedLeaves = mappedLeaves[startIndex:]
+	splittedLeaf := tPage.node.SplitLeaf(key, value)
+	// Values that need more than one more leaf can't be shifted
+	if len(splittedLeaf) > 2 {
+		return false
+	}
 
-	for i := 0; i < len(keyValuesToBeReinserted); i++ { // Iterates over keyValuesLeftBehind to be added
-		/* For every value from the right leaf, we must add it to another one, so we find
-		the index of the left leaf at parentKeyAddr
+	keyValuesToBeShifted := getAllLeafKeyValues(&splittedLeaf[1])
 
-		TODO: Explain better*/
+	// Every value must fit into the sibling, otherwise it's better to split the leaf
+	bytesToBeShifted := 0
+	for i := 0; i < len(keyValuesToBeShifted); i++ {
+		bytesToBeShifted += 10 + int(keyValuesToBeShifted[i].keyLength) + int(keyValuesToBeShifted[i].valueLength)
+	}
 
-		indexOfLeaf := 0
+	if bytesToBeShifted > int(GetFreeBytes(&sibling)) {
+		return false
+	}
 
-		isLastIndex := false
-		if len(mappedLeaves) <= 1 {
-			isLastIndex = true
-		}
+	for i := 0; i < len(keyValuesToBeShifted); i++ {
+		sibling.PutLeafNewKeyValue(keyValuesToBeShifted[i].key, keyValuesToBeShifted[i].value)
+	}
 
-		// Get next leaf
-		nextLeafAddress := mappedLeaves[indexOfLeaf].TreeNode
-		nextLeaf := bTree.Get(nextLeafAddress)
-		if !isLastIndex {
-			// Call this function recursivelly for the next ordered leaf
-			shiftValuesBetweenLeaves(
-				bTree,
-				TreeNodePage{
-					node: nextLeaf,
-					page: nextLeafAddress},
-				history, keyValuesToBeReinserted[i].GetKey(),
-				keyValuesToBeReinserted[i].GetValue())
-			continue
-		}
-		// If it's the last index call the other one
-		if !mustSplitNode(nextLeaf, len(keyValuesToBeReinserted[i].GetKey()), len(keyValuesToBeReinserted[i].GetValue())) {
-			// Get first key from next leaf
-			nextLeafFirstKey := nextLeaf.GetLeafKeyValueByIndex(0).GetKey()
-			nextLeaf.PutLeafNewKeyValue(keyValuesToBeReinserted[i].GetKey(), keyValuesToBeReinserted[i].GetValue())
-
-			// Update leaf
-			bTree.Set(nextLeaf, nextLeafAddress)
-
-			// Compare if key has changed and update parent
-			if !bytes.Equal(nextLeafFirstKey, nextLeaf.GetLeafKeyValueByIndex(0).GetKey()) {
-				// Delete address
-				parentNode.node.DeleteNodeChildrenByAddress(nextLeafAddress)
-				// Put next Leaf with key and address
-				parentNode.node.PutNodeNewChild(nextLeaf.GetLeafKeyValueByIndex(0).GetKey(), nextLeafAddress)
-				// Update Parent
-				bTree.Set(parentNode.node, parentNode.page)
-			}
-			continue
-		}
+	setParentAddr(&splittedLeaf[0], parentNode.page)
+	bTree.Set(splittedLeaf[0], tPage.page)
+	bTree.Set(sibling, siblingAddr)
 
-		splitBackyardsRecursively(bTree,
-			TreeNodePage{
-				node: nextLeaf,
-				page: parentKeyAddr[indexOfLeaf].GetAddr()},
-			history, keyValuesToBeReinserted[i].GetKey(),
-			keyValuesToBeReinserted[i].GetValue())
-	}
+	// Both leaves may have a new first key now
+	updateParentKeysRecursivelly(bTree, TreeNodePage{node: splittedLeaf[0], page: tPage.page}, history)
+	updateParentKeysRecursivelly(bTree, TreeNodePage{node: sibling, page: siblingAddr}, history)
+	return true
 }
 
 /*
@@ -484,10 +447,9 @@ Insert value changing the bTree structure whenever it is needed.
 If the found leaf that will receive key value is not full and must not be splitted, the key value is
 inserted imediatelly.
 
-If not, there can happen two cases, the value will be inserted at the last leaf, or it will be inserted
-in the middle of a previous leaf (Probably a full one) and a lot of values shiffting must occour.
-
-So, lets map all availables leaves and compare if the last page found is equal to the given page
+If not, the values that overflow the leaf are shifted to the next leaf when it has room for them (when
+inserting unsorted data), otherwise the leaf is split and the new leaves are inserted into the parent,
+which may also be split, up to the root
 */
 func insertAndReorderTree(bTree *BTree, tPage TreeNodePage, history []TreeNodePage, key []byte, value []byte) {
 	keyLen := len(key)
@@ -495,157 +457,112 @@ func insertAndReorderTree(bTree *BTree, tPage TreeNodePage, history []TreeNodePa
 
 	// There is overflow in page, must split it or shfit values (when inserting unsorted data)
 	if mustSplitNode(tPage.node, keyLen, valueLen) {
-		if (keyLen + valueLen + 10) > PAGE_SIZE-26 { // 26 is header size for leaf
+		if (keyLen + valueLen + 10) > PAGE_SIZE-LEAF_VAL_START_OFFSET {
 			// Special case
 			leaf := createLeafAndSequencesForLargeBytes(bTree, key, value)
 			insertOneKeyLeafAndReorderTree(bTree, tPage, *leaf, history)
 			return
 		}
-		// Verify whether the leaf if the last one by comparing he page number
-		lastLeaf, _ := findLastLeaf(bTree)
 
-		if lastLeaf.page == tPage.page {
-			// Last leaf split's backyards Node Recursivelly
-			splitBackyardsRecursively(bTree, tPage, history, key, value)
+		if shiftValuesToRightSibling(bTree, tPage, history, key, value) {
 			return
 		}
-		shiftValuesBetweenLeaves(bTree, tPage, history, key, value)
+
+		splitBackyardsRecursively(bTree, tPage, history, key, value)
 		return
 	}
 
 	tPage.node.PutLeafNewKeyValue(key, value)
 	bTree.Set(tPage.node, tPage.page)
+	// The new key may be the smallest one of the leaf
+	updateParentKeysRecursivelly(bTree, tPage, history)
 }
 
-func getTotalKeyLen(nodes []TreeNode) int {
-	totalKeyLen := 0
-	for i := 0; i < len(nodes); i++ {
-		if nodes[i].GetType() == TREE_LEAF {
-			totalKeyLen += len(nodes[i].GetLeafKeyValueByIndex(0).key)
-			continue
-		}
-
-		totalKeyLen += len(nodes[i].GetNodeChildByIndex(0).key)
+// Returns the number of bytes needed by a node to reference all the given pages
+func getNodeChildrenLen(children []NodeKeyAddr) int {
+	totalLen := 0
+	for i := 0; i < len(children); i++ {
+		totalLen += len(children[i].key) + 10
 	}
-	totalKeyLen += 10
-	return totalKeyLen
+	return totalLen
 }
 
-func insertNewPagesToNode(bTree *BTree, nodeToInsert TreeNodePage, newPages []TreeNodePage) {
-	// Insert new nodes information to parent node
-	if newPages[0].node.GetType() == TREE_LEAF {
-		nodeToInsert.node.PutNodeNewChild(newPages[0].node.GetLeafKeyValueByIndex(0).key, newPages[0].page)
-		nodeToInsert.node.PutNodeNewChild(newPages[1].node.GetLeafKeyValueByIndex(0).key, newPages[1].page)
-	} else {
-		nodeToInsert.node.PutNodeNewChild(newPages[0].node.GetNodeChildByIndex(0).key, newPages[0].page)
-		nodeToInsert.node.PutNodeNewChild(newPages[1].node.GetNodeChildByIndex(0).key, newPages[1].page)
+func insertNewPagesToNode(bTree *BTree, nodeToInsert TreeNodePage, children []NodeKeyAddr, newPages []TreeNodePage) {
+	nodeToInsert.node.setNodeChildren(children)
+	// Update parent from nodes
+	for i := 0; i < len(newPages); i++ {
+		setParentAddr(&newPages[i].node, nodeToInsert.page)
 	}
 
-	// Update parent from nodes
-	setParentAddr(&newPages[0].node, nodeToInsert.page)
-	setParentAddr(&newPages[1].node, nodeToInsert.page)
 	// Update pages
 	bTree.Set(nodeToInsert.node, nodeToInsert.page)
-	bTree.Set(newPages[0].node, newPages[0].page)
-	bTree.Set(newPages[1].node, newPages[1].page)
+	for i := 0; i < len(newPages); i++ {
+		bTree.Set(newPages[i].node, newPages[i].page)
+	}
 }
 
+/*
+Replaces the child oldPage of the last node in history with the new pages, which take its place among the children
+of the node. If the node can't hold them, it's split into two new nodes which replace it in its own parent,
+recursivelly. When the root is split, a new root is created above both halves
+*/
 func insertNodesRecursivelly(
 	bTree *BTree,
-	oldPage TreeNodePage,
+	oldPage uint64,
 	newPages []TreeNodePage,
 	history []TreeNodePage,
 ) {
 	// Get nodeToInsert, it will never happen when there is an empty history, so we can do it
 	nodeToInsert := history[len(history)-1]
-	// New pages always come divided into 2 pieces
-	newPageOne := newPages[0]
-	newPageTwo := newPages[1]
+	newChildren := make([]NodeKeyAddr, len(newPages))
+	for i := 0; i < len(newPages); i++ {
+		newChildren[i] = newNodeKeyAddr(getFirstKey(newPages[i].node), newPages[i].page)
+	}
 
-	totalKeyLen := getTotalKeyLen([]TreeNode{newPageOne.node, newPageTwo.node})
-	// Remove oldPage reference from node that will receive new pages
-	nodeToInsert.node.DeleteNodeChildrenByAddress(oldPage.page)
+	allNodeMembers := replaceNodeChild(getAllNodeKeyAddr(&nodeToInsert.node), oldPage, newChildren)
 
 	// No need to split node
-	if !mustSplitNode(nodeToInsert.node, totalKeyLen, 8) {
-		insertNewPagesToNode(bTree, nodeToInsert, newPages)
+	if getNodeChildrenLen(allNodeMembers) <= PAGE_SIZE-NODE_P_KEY_ADDR_OFFSET {
+		insertNewPagesToNode(bTree, nodeToInsert, allNodeMembers, newPages)
 		return
 	}
 
-	// We verify if value can be shifted to another existing leaf or we really need to create another leaf
-	var splittedNode []TreeNode = nil
-	ourInsertion := 1
-	if newPageOne.node.GetType() == TREE_LEAF {
-		// Leaf case (This is duplicated code unfortunatelly) must remake this
-		splittedNode = nodeToInsert.node.SplitNode(newPageOne.node.GetLeafKeyValueByIndex(0).key, newPageOne.page)
-		// Where did our key insertion go to the first or second leaf?
-		if splittedNode[0].GetNodeChildByKey(newPageOne.node.GetLeafKeyValueByIndex(0).key) != nil {
-			ourInsertion = 0
-		}
-
-		// Our insertion is sorted, therefore we must insert the second new node into the second splitted node
-		splittedNode[1].PutNodeNewChild(newPageTwo.node.GetLeafKeyValueByIndex(0).key, newPageTwo.page)
-
-	} else {
-		splittedNode = nodeToInsert.node.SplitNode(newPageOne.node.GetNodeChildByIndex(0).key, newPageOne.page)
-		// Where is our insertion?
-		if splittedNode[0].GetNodeChildByKey(newPageOne.node.GetNodeChildByIndex(0).key) != nil {
-			ourInsertion = 0
-		}
-
-		// Our insertion is sorted, therefore we must insert the second new node into the second splitted node
-		splittedNode[1].PutNodeNewChild(newPageTwo.node.GetNodeChildByIndex(0).key, newPageTwo.page)
-	}
+	splittedNode := splitNodeChildren(allNodeMembers)
 
 	// Create our new pages
-	addr0 := bTree.New(splittedNode[0])
-	addr1 := bTree.New(splittedNode[1])
-
-	// Set the parent addresses
-	if ourInsertion == 0 {
-		setParentAddr(&newPageOne.node, addr0)
-	} else {
-		setParentAddr(&newPageOne.node, addr1)
+	newNodes := make([]TreeNodePage, len(splittedNode))
+	for i := 0; i < len(splittedNode); i++ {
+		newNodes[i] = TreeNodePage{node: splittedNode[i], page: bTree.New(splittedNode[i])}
+		// Every child must point to the node that holds it now
+		updateChildrenParentAddr(bTree, newNodes[i].node, newNodes[i].page)
 	}
 
-	setParentAddr(&newPageTwo.node, addr1)
-
-	// Update those pages
-	bTree.Set(newPageOne.node, newPageOne.page)
-	bTree.Set(newPageTwo.node, newPageTwo.page)
-
-	// Has parent
 	if len(history) > 1 {
 		// Call the stack recursivelly
+		insertNodesRecursivelly(bTree, nodeToInsert.page, newNodes, history[:len(history)-1])
+	} else {
+		// No parent, create new root Node
+		createRootNodeAndInsertLeaves(bTree, newNodes)
+	}
 
-		insertNodesRecursivelly(
-			bTree,
-			nodeToInsert,
-			[]TreeNodePage{
-				{node: splittedNode[0], page: addr0},
-				{node: splittedNode[1], page: addr1},
-			},
-			history[:len(history)-1])
-
-	} else { // No parent :(
-		// Create new root Node
-		newRoot := NewNodeNode()
-		setParentAddr(newRoot, 0)
-		newRootAddress := bTree.New(*newRoot)
-		setParentAddr(&splittedNode[0], newRootAddress)
-		setParentAddr(&splittedNode[1], newRootAddress)
-		newRoot.PutNodeNewChild(splittedNode[0].GetNodeChildByIndex(0).key, addr0)
-		newRoot.PutNodeNewChild(splittedNode[1].GetNodeChildByIndex(0).key, addr1)
-		bTree.Set(*newRoot, newRootAddress)
-		bTree.Set(splittedNode[0], addr0)
-		bTree.Set(splittedNode[1], addr1)
-		// Set bTree to be redirected to new Root
-		bTree.SetRoot(newRootAddress)
-		// Commit changes
-		bTree.SetHeader(*bTree)
+	// The splitted node was copied into two new pages
+	releasePage(bTree, nodeToInsert.page)
+}
+
+// Sets the parent address of every child of the given node
+func updateChildrenParentAddr(bTree *BTree, node TreeNode, parentAddr uint64) {
+	allNodeKeyAddr := getAllNodeKeyAddr(&node)
+	for i := 0; i < len(allNodeKeyAddr); i++ {
+		setChildParentAddr(bTree, allNodeKeyAddr[i].addr, parentAddr)
 	}
 }
 
+func setChildParentAddr(bTree *BTree, page uint64, parentAddr uint64) {
+	child := bTree.Get(page)
+	setParentAddr(&child, parentAddr)
+	bTree.Set(child, page)
+}
+
 func createLeafAndSequencesForLargeBytes(bTree *BTree, key []byte, value []byte) *TreeNodePage {
 	// Create in memory all leaves and leaf to be returned
 	leaf, sequence := CreateLeafWithSequence(key, value)
@@ -750,47 +667,312 @@ func lookupKeys(node TreeNode, key []byte) []int {
 
 }
 
+/*
+Deletes the key from the given leaf and keeps the parents updated. Whenever the first key of the leaf
+changes, the key that points to it in the parent node is also changed. Leaves that get empty are removed
+from the tree and their pages (including sequences) are released to be reused. Leaves that get too small
+borrow values from a sibling or are merged into it
+*/
 func DeleteKeyValueInLeafAndUpdateNodesRecursivelly(bTree *BTree, key []byte, tPage TreeNodePage, history []TreeNodePage) {
-	tmp := tPage.node.GetLeafKeyValueByIndex(0).GetKey()
-	firstKeyBeforeDelete := make([]byte, len(tmp))
-	copy(firstKeyBeforeDelete, tmp)
 	tPage.node.DeleteLeafKeyValueByKey(key)
-	nItensAfterDeletion := tPage.node.GetNItens()
-	firstkeyAfterDeletion := make([]byte, 0)
 
-	if nItensAfterDeletion > uint16(0) {
-		firstkeyAfterDeletion = tPage.node.GetLeafKeyValueByIndex(0).GetKey()
+	if tPage.node.GetNItens() == 0 {
+		removeEmptyPageRecursivelly(bTree, tPage, history)
+		return
 	}
 
-	hasFirstKeyChanged := !bytes.Equal(firstKeyBeforeDelete, firstkeyAfterDeletion)
-
-	tmpPage := tPage.page
 	bTree.Set(tPage.node, tPage.page)
+	updateParentKeysRecursivelly(bTree, tPage, history)
+	rebalanceNodeRecursivelly(bTree, tPage, history)
+}
+
+/*
+Removes an empty page from its parent, releasing it. If the parent also gets empty, it's removed as well.
+When the root gets empty, the bTree is reset to have no root at all
+*/
+func removeEmptyPageRecursivelly(bTree *BTree, tPage TreeNodePage, history []TreeNodePage) {
+	releasePageAndSequences(bTree, tPage)
 
-	// Single leaf deletion, no need to update parents
-	if !hasFirstKeyChanged && nItensAfterDeletion > 0 {
+	if len(history) == 0 {
+		bTree.SetRoot(0)
+		bTree.SetHeader(*bTree)
 		return
 	}
 
-	if tPage.page == bTree.GetRoot() {
-		// If it is the root, we must update the root
-		if nItensAfterDeletion == 0 {
-			bTree.SetRoot(0)
-			bTree.SetHeader(*bTree)
+	parent := history[len(history)-1]
+	idx := getNodeChildIndexByPage(&parent.node, tPage.page)
+	parent.node.DeleteNodeChildrenByAddress(tPage.page)
+
+	if parent.node.GetNItens() == 0 {
+		removeEmptyPageRecursivelly(bTree, parent, history[:len(history)-1])
+		return
+	}
+
+	bTree.Set(parent.node, parent.page)
+
+	// Removing the first child changes the first key of the parent
+	if idx == 0 {
+		updateParentKeysRecursivelly(bTree, parent, history[:len(history)-1])
+	}
+
+	rebalanceNodeRecursivelly(bTree, parent, history[:len(history)-1])
+}
+
+/*
+Returns the number of bytes used by the items of a node or leaf, without its header
+*/
+func getNodeUsedBytes(node *TreeNode) int {
+	if node.GetType() == TREE_NODE {
+		return int(getNodeOffset(node)) - NODE_P_KEY_ADDR_OFFSET
+	}
+
+	return int(getNodeOffset(node)) - LEAF_VAL_START_OFFSET
+}
+
+// Leaves with sequences hold one single large value and are never merged or redistributed
+func isUnderflowed(node *TreeNode) bool {
+	if node.GetType() == TREE_LEAF && node.GetLeafHasSeq() == 1 {
+		return false
+	}
+
+	return getNodeUsedBytes(node) < NODE_UNDERFLOW_BYTES
+}
+
+/*
+Keeps nodes and leaves from getting too small after deletions. An underflowed page is merged into a sibling
+under the same parent when both fit in one page, the parent then loses a child and is checked as well.
+When they don't fit, the items of both pages are redistributed between them. The root is collapsed whenever
+it's an internal node with one single child
+*/
+func rebalanceNodeRecursivelly(bTree *BTree, tPage TreeNodePage, history []TreeNodePage) {
+	if len(history) == 0 {
+		collapseRoot(bTree, tPage)
+		return
+	}
+
+	if !isUnderflowed(&tPage.node) {
+		return
+	}
+
+	parent := history[len(history)-1]
+	left, right := getSiblingsToRebalance(bTree, tPage, parent)
+	if left == nil {
+		return
+	}
+
+	// Right page fits into the left one
+	if getNodeUsedBytes(&right.node) <= int(GetFreeBytes(&left.node)) {
+		mergeSiblings(bTree, *left, *right, parent)
+		rebalanceNodeRecursivelly(bTree, parent, history[:len(history)-1])
+		return
+	}
+
+	redistributeSiblings(bTree, *left, *right, parent)
+}
+
+/*
+Returns the given page and one of its siblings ordered as left and right. The left sibling is preferred,
+the right one is used for the first child of the parent. Returns nil when there is no sibling that can be used
+*/
+func getSiblingsToRebalance(bTree *BTree, tPage TreeNodePage, parent TreeNodePage) (*TreeNodePage, *TreeNodePage) {
+	idx := getNodeChildIndexByPage(&parent.node, tPage.page)
+	if idx == -1 || parent.node.GetNItens() < 2 {
+		return nil, nil
+	}
+
+	for _, siblingIdx := range []int{idx - 1, idx + 1} {
+		if siblingIdx < 0 || siblingIdx >= int(parent.node.GetNItens()) {
+			continue
+		}
+
+		siblingAddr := parent.node.GetNodeChildByIndex(siblingIdx).addr
+		sibling := TreeNodePage{node: bTree.Get(siblingAddr), page: siblingAddr}
+
+		if sibling.node.GetType() == TREE_LEAF && sibling.node.GetLeafHasSeq() == 1 {
+			continue
+		}
+
+		if siblingIdx < idx {
+			return &sibling, &tPage
+		}
+
+		return &tPage, &sibling
+	}
+
+	return nil, nil
+}
+
+/*
+Moves every item from the right page to the left one, removing the right page from the parent and releasing it
+*/
+func mergeSiblings(bTree *BTree, left TreeNodePage, right TreeNodePage, parent TreeNodePage) {
+	if left.node.GetType() == TREE_NODE {
+		allNodeKeyAddr := getAllNodeKeyAddr(&right.node)
+		for i := 0; i < len(allNodeKeyAddr); i++ {
+			left.node.PutNodeNewChild(allNodeKeyAddr[i].key, allNodeKeyAddr[i].addr)
+			setChildParentAddr(bTree, allNodeKeyAddr[i].addr, left.page)
+		}
+	} else {
+		allLeafKeyValues := getAllLeafKeyValues(&right.node)
+		for i := 0; i < len(allLeafKeyValues); i++ {
+			left.node.PutLeafNewKeyValue(allLeafKeyValues[i].key, allLeafKeyValues[i].value)
+		}
+	}
+
+	bTree.Set(left.node, left.page)
+	// The right page is never the first child, so the parent keeps its first key
+	parent.node.DeleteNodeChildrenByAddress(right.page)
+	bTree.Set(parent.node, parent.page)
+	releasePage(bTree, right.page)
+}
+
+/*
+Redistributes the items of two sibling pages, so each one of them gets about half of the bytes. Since the
+right page gets a new first key, its key in the parent is updated. Nothing is done when the parent has no
+room for the new key
+*/
+func redistributeSiblings(bTree *BTree, left TreeNodePage, right TreeNodePage, parent TreeNodePage) {
+	var newLeft, newRight *TreeNode = nil, nil
+
+	if left.node.GetType() == TREE_NODE {
+		newLeft, newRight = NewNodeNode(), NewNodeNode()
+		allNodeKeyAddr := append(getAllNodeKeyAddr(&left.node), getAllNodeKeyAddr(&right.node)...)
+		half := (getNodeUsedBytes(&left.node) + getNodeUsedBytes(&right.node)) / 2
+		for i := 0; i < len(allNodeKeyAddr); i++ {
+			if getNodeUsedBytes(newLeft) < half || i == 0 {
+				newLeft.PutNodeNewChild(allNodeKeyAddr[i].key, allNodeKeyAddr[i].addr)
+			} else {
+				newRight.PutNodeNewChild(allNodeKeyAddr[i].key, allNodeKeyAddr[i].addr)
+			}
 		}
+	} else {
+		newLeft, newRight = NewNodeLeaf(), NewNodeLeaf()
+		allLeafKeyValues := append(getAllLeafKeyValues(&left.node), getAllLeafKeyValues(&right.node)...)
+		half := (getNodeUsedBytes(&left.node) + getNodeUsedBytes(&right.node)) / 2
+		for i := 0; i < len(allLeafKeyValues); i++ {
+			if getNodeUsedBytes(newLeft) < half || i == 0 {
+				newLeft.PutLeafNewKeyValue(allLeafKeyValues[i].key, allLeafKeyValues[i].value)
+			} else {
+				newRight.PutLeafNewKeyValue(allLeafKeyValues[i].key, allLeafKeyValues[i].value)
+			}
+		}
+	}
+
+	if newRight.GetNItens() == 0 {
+		return
+	}
+
+	oldRightKey := getFirstKey(right.node)
+	newRightKey := getFirstKey(*newRight)
+	if len(newRightKey) > len(oldRightKey)+int(GetFreeBytes(&parent.node)) {
 		return
 	}
 
-	// If it is the first key, we must update all parents
+	setParentAddr(newLeft, parent.page)
+	setParentAddr(newRight, parent.page)
+	bTree.Set(*newLeft, left.page)
+	bTree.Set(*newRight, right.page)
+
+	// Children that changed from one node to another must point to their new parent
+	if newLeft.GetType() == TREE_NODE {
+		updateChildrenParentAddr(bTree, *newLeft, left.page)
+		updateChildrenParentAddr(bTree, *newRight, right.page)
+	}
+
+	parent.node.setNodeChildKey(right.page, newRightKey)
+	bTree.Set(parent.node, parent.page)
+}
+
+/*
+An internal root with one single child is not needed, the child becomes the new root and the old root page
+is released
+*/
+func collapseRoot(bTree *BTree, root TreeNodePage) {
+	for root.node.GetType() == TREE_NODE && root.node.GetNItens() == 1 {
+		childAddr := root.node.GetNodeChildByIndex(0).addr
+		child := bTree.Get(childAddr)
+		setParentAddr(&child, 0)
+		bTree.Set(child, childAddr)
+		bTree.SetRoot(childAddr)
+		bTree.SetHeader(*bTree)
+		releasePage(bTree, root.page)
+		root = TreeNodePage{node: child, page: childAddr}
+	}
+}
+
+/*
+Rewrites the key used by the parent to point to the given child whenever the first key of the child
+has changed. Since the first key of a node is the first key of its first child, the update goes up
+while the child is the first item of its parent
+*/
+func updateParentKeysRecursivelly(bTree *BTree, child TreeNodePage, history []TreeNodePage) {
 	for i := len(history) - 1; i >= 0; i-- {
-		node := history[i].node
-		page := history[i].page
-		// print first key addr for node
-		node.DeleteNodeChildrenByKey(firstKeyBeforeDelete)
-		if hasFirstKeyChanged && len(firstkeyAfterDeletion) > 0 {
-			node.PutNodeNewChild(firstkeyAfterDeletion, tmpPage)
+		parent := history[i]
+		firstKey := getFirstKey(child.node)
+		idx := getNodeChildIndexByPage(&parent.node, child.page)
+
+		if idx == -1 || bytes.Equal(parent.node.GetNodeChildByIndex(idx).key, firstKey) {
+			return
 		}
-		bTree.Set(node, page)
-		tmpPage = page
+
+		parent.node.setNodeChildKey(child.page, firstKey)
+		bTree.Set(parent.node, parent.page)
+
+		if idx > 0 {
+			return
+		}
+
+		child = parent
 	}
 }
+
+// Returns a copy of the first key of a node or leaf
+func getFirstKey(node TreeNode) []byte {
+	var key []byte = nil
+	if node.GetType() == TREE_NODE {
+		key = node.GetNodeChildByIndex(0).key
+	} else {
+		key = node.GetLeafKeyValueByIndex(0).key
+	}
+
+	r := make([]byte, len(key))
+	copy(r, key)
+	return r
+}
+
+// Returns the position of the child that points to the given page, or -1 if there is none
+func getNodeChildIndexByPage(node *TreeNode, page uint64) int {
+	allNodeKeyAddr := getAllNodeKeyAddr(node)
+	for i := 0; i < len(allNodeKeyAddr); i++ {
+		if allNodeKeyAddr[i].addr == page {
+			return i
+		}
+	}
+
+	return -1
+}
+
+/*
+function: releasePage
+
+Gives a page that is no longer referenced by the tree back to the storage, through the Del callback
+*/
+func releasePage(bTree *BTree, page uint64) {
+	if bTree.Del != nil {
+		bTree.Del(page)
+	}
+}
+
+/*
+Releases a page and, in case it's a leaf with sequences, all the leaf sequence pages linked to it
+*/
+func releasePageAndSequences(bTree *BTree, tPage TreeNodePage) {
+	seq := tPage.node
+	for seq.GetType() != TREE_NODE && seq.GetLeafHasSeq() == 1 {
+		nextAddr := seq.GetLeafSeqPointer()
+		seq = bTree.Get(nextAddr)
+		releasePage(bTree, nextAddr)
+	}
+
+	releasePage(bTree, tPage.page)
+}
@@ -0,0 +1,193 @@
+package btree
+
+import (
+	"sync"
+)
+
+/*
+Latches
+
+Latches keep goroutines working on the same bTree from seeing pages in the middle of a change. Every page has its
+own read/write latch, and operations go down the tree with latch coupling: the latch of a child is taken before the
+latch of its parent is released, so no writer gets in between them.
+
+Most insertions and deletions change one single leaf, so they are done holding the write latch of that leaf alone,
+alongside any number of readers and writers of other leaves. Operations that change more than one page (splits,
+merges, new roots, large values, ...) hold the structure latch exclusively instead, which waits for every other
+operation to finish. Every other operation holds it shared, so internal nodes never change under a latched descent.
+A writer that reaches its leaf and finds out the change won't fit in it releases every latch and starts again
+holding the structure latch.
+
+Latches are taken through a LatchGuard, which keeps track of everything held by one operation. Page latches are not
+needed while the structure latch is held exclusively, so taking them does nothing then.
+*/
+
+const (
+	LATCH_SHARED    = iota // Operations that change one leaf at most
+	LATCH_EXCLUSIVE        // Operations that change the structure of the tree
+)
+
+type Latches struct {
+	mu        sync.Mutex
+	pages     map[uint64]*pageLatch
+	structure sync.RWMutex
+}
+
+type pageLatch struct {
+	sync.RWMutex
+	users int // Guards holding or waiting for the latch, it's dropped once there is none
+}
+
+type heldLatch struct {
+	page  uint64
+	latch *pageLatch
+	write bool
+}
+
+type LatchGuard struct {
+	latches  *Latches
+	mode     int
+	held     []heldLatch
+	released bool
+}
+
+func NewLatches() *Latches {
+	return &Latches{
+		pages: make(map[uint64]*pageLatch),
+	}
+}
+
+// Shared returns a guard holding the structure latch shared, whose operation may take page latches
+func (l *Latches) Shared() *LatchGuard {
+	l.structure.RLock()
+
+	return &LatchGuard{latches: l, mode: LATCH_SHARED, held: make([]heldLatch, 0)}
+}
+
+// Exclusive returns a guard holding the structure latch exclusively, once every other operation is done
+func (l *Latches) Exclusive() *LatchGuard {
+	l.structure.Lock()
+
+	return &LatchGuard{latches: l, mode: LATCH_EXCLUSIVE, held: make([]heldLatch, 0)}
+}
+
+func (l *Latches) acquire(page uint64) *pageLatch {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	latch, ok := l.pages[page]
+	if !ok {
+		latch = new(pageLatch)
+		l.pages[page] = latch
+	}
+
+	latch.users++
+	return latch
+}
+
+func (l *Latches) drop(page uint64, latch *pageLatch) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	latch.users--
+	if latch.users == 0 {
+		delete(l.pages, page)
+	}
+}
+
+func (g *LatchGuard) IsExclusive() bool {
+	return g.mode == LATCH_EXCLUSIVE
+}
+
+// RLatch takes the read latch of the page, held until Unlatch or Release
+func (g *LatchGuard) RLatch(page uint64) {
+	g.latch(page, false)
+}
+
+// WLatch takes the write latch of the page, held until Unlatch or Release
+func (g *LatchGuard) WLatch(page uint64) {
+	g.latch(page, true)
+}
+
+func (g *LatchGuard) latch(page uint64, write bool) {
+	if g.IsExclusive() {
+		return
+	}
+
+	latch := g.latches.acquire(page)
+
+	if write {
+		latch.Lock()
+	} else {
+		latch.RLock()
+	}
+
+	g.held = append(g.held, heldLatch{page: page, latch: latch, write: write})
+}
+
+// Unlatch releases the latch held for the page, if any
+func (g *LatchGuard) Unlatch(page uint64) {
+	for i := len(g.held) - 1; i >= 0; i-- {
+		if g.held[i].page == page {
+			g.unlock(g.held[i])
+			g.held = append(g.held[:i], g.held[i+1:]...)
+			return
+		}
+	}
+}
+
+func (g *LatchGuard) holds(page uint64) bool {
+	for _, held := range g.held {
+		if held.page == page {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (g *LatchGuard) unlock(held heldLatch) {
+	if held.write {
+		held.latch.Unlock()
+	} else {
+		held.latch.RUnlock()
+	}
+
+	g.latches.drop(held.page, held.latch)
+}
+
+/*
+Upgrade releases everything held and takes the structure latch exclusively, for operations that found out they
+change more than one page. Anything read before must be read again, since other operations may run in between
+*/
+func (g *LatchGuard) Upgrade() {
+	if g.IsExclusive() {
+		return
+	}
+
+	g.Release()
+	g.latches.structure.Lock()
+	g.mode = LATCH_EXCLUSIVE
+	g.released = false
+}
+
+// Release releases every latch held by the guard. Releasing it twice does nothing
+func (g *LatchGuard) Release() {
+	if g.released {
+		return
+	}
+
+	g.released = true
+
+	for i := len(g.held) - 1; i >= 0; i-- {
+		g.unlock(g.held[i])
+	}
+
+	g.held = g.held[:0]
+
+	if g.IsExclusive() {
+		g.latches.structure.Unlock()
+	} else {
+		g.latches.structure.RUnlock()
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"sort"
 )
 
@@ -12,6 +13,7 @@ import (
    B-Tree Node structure Fixed Z Size in bytes - for instance 4096 one page
 
    - | type| 2B - A Node can be two diferent types: "Node" or "Leaf"
+   - | checksum | 4B - CRC32C of the whole page, except the checksum itself. Every page type has it
    - | nItems | 2B - Number of items, either  that the node holds
    - | freeBytes | 2B - Number of free bytes in the node
    - | pParent | 8B - Pointer to parent node
@@ -23,8 +25,8 @@ import (
    	     - | pNChild | n * 8B
     /
    --------- Case Leaf -----------
-   - | hasSeq  | 2B - If it has a sequence to complete the row case data exceeds max page value
-   - | vSeq | 8B - Pointer to the sequence of the value in case it exceeds the maximum number of bytes (Todo)
+   - | vSeq | 8B - Pointer to the sequence of the value in case it exceeds the maximum number of bytes. Since
+                  page 0 is the header, a leaf has a sequence whenever it isn't zero
     \
          - | kLen | 2B - Len in bytes of the index
          - | ValLen | 8B - idx Values Len (Total size in bytes of a database structure to be saved)
@@ -34,10 +36,16 @@ import (
 
    -------- Case Leaf Sequence ---------
    - | type | 2B
+   - | checksum | 4B
    - | hasSeq | 2B
    - | vSeq | 8B
    - | nBytes | 2B
    - | bytes | Rest
+
+   -------- Case Free Page ---------
+   - | type | 2B
+   - | checksum | 4B
+   - | next | 8B - Pointer to the next free page (0 means it is the last one)
 */
 
 /* TreeNode implementation */
@@ -45,11 +53,13 @@ const (
 	TREE_NODE = iota
 	TREE_LEAF
 	TREE_LEAF_SEQUENCE
+	TREE_FREE_PAGE
 )
 
 /* Lens */
 const (
 	NODE_TYPE_LEN            = 2
+	NODE_CHECKSUM_LEN        = 4
 	NODE_OFFSET_LEN          = 2
 	NODE_N_ITENS_LEN         = 2
 	NODE_FREE_BYTES_LEN      = 2
@@ -68,7 +78,8 @@ const (
 /* Offsets Header */
 const (
 	NODE_TYPE_OFFSET        = 0
-	NODE_N_ITENS_OFFSET     = NODE_TYPE_OFFSET + NODE_TYPE_LEN
+	NODE_CHECKSUM_OFFSET    = NODE_TYPE_OFFSET + NODE_TYPE_LEN
+	NODE_N_ITENS_OFFSET     = NODE_CHECKSUM_OFFSET + NODE_CHECKSUM_LEN
 	NODE_FREE_BYTES_OFFSET  = NODE_N_ITENS_OFFSET + NODE_N_ITENS_LEN
 	NODE_PARENT_ADDR_OFFSET = NODE_FREE_BYTES_OFFSET + NODE_FREE_BYTES_LEN
 	NODE_OFFSET_OFFSET      = NODE_PARENT_ADDR_OFFSET + NODE_PARENT_ADDR
@@ -77,20 +88,26 @@ const (
 
 /* Leaft offsets */
 const (
-	LEAF_HAS_SEQ_OFFSET   = NODE_OFFSET_OFFSET + NODE_OFFSET_LEN
-	LEAF_SEQ_P_OFFSET     = LEAF_HAS_SEQ_OFFSET + LEAF_HAS_SEQ_LEN
+	LEAF_SEQ_P_OFFSET     = NODE_OFFSET_OFFSET + NODE_OFFSET_LEN
 	LEAF_KEY_LEN_OFFSET   = LEAF_SEQ_P_OFFSET + LEAF_SEQ_P_LEN
 	LEAF_VAL_LEN_OFFSET   = LEAF_KEY_LEN_OFFSET + LEAF_KEY_LEN_LEN
 	LEAF_VAL_START_OFFSET = LEAF_SEQ_P_OFFSET + LEAF_SEQ_P_LEN
 )
 
 const (
-	LEAF_SEQ_HAS_SEQ_OFFSET = NODE_TYPE_OFFSET + NODE_TYPE_LEN
+	LEAF_SEQ_HAS_SEQ_OFFSET = NODE_CHECKSUM_OFFSET + NODE_CHECKSUM_LEN
 	LEAF_SEQ_SEQ_OFFSET     = LEAF_SEQ_HAS_SEQ_OFFSET + LEAF_HAS_SEQ_LEN
 	LEAF_SEQ_N_BYTES_OFFSET = LEAF_SEQ_SEQ_OFFSET + LEAF_SEQ_P_LEN
 	LEAF_SEQ_BYTES_OFFSET   = LEAF_SEQ_N_BYTES_OFFSET + LEAF_SEQ_N_BYTES
 )
 
+const (
+	FREE_PAGE_NEXT_LEN    = 8
+	FREE_PAGE_NEXT_OFFSET = NODE_CHECKSUM_OFFSET + NODE_CHECKSUM_LEN
+)
+
+var nodeCrcTable = crc32.MakeTable(crc32.Castagnoli)
+
 /* Basic types declaration */
 type NodeKeyAddr struct {
 	keyLen uint16
@@ -154,6 +171,8 @@ func (n *TreeNode) PrintNode() {
 	} else if n.GetType() == TREE_LEAF {
 		nType = "Leaf"
 
+	} else if n.GetType() == TREE_FREE_PAGE {
+		nType = "Free Page"
 	} else {
 		nType = "Leaf Sequence"
 	}
@@ -172,6 +191,8 @@ func (n *TreeNode) PrintNode() {
 			fmt.Printf("Key: %s\n", allLeafKeyValues[i].key)
 			fmt.Printf("Value: %s\n", allLeafKeyValues[i].value)
 		}
+	} else if n.GetType() == TREE_FREE_PAGE {
+		fmt.Printf("NextFreePage: %d\n", n.GetFreePageNext())
 	} else if n.GetType() == TREE_LEAF_SEQUENCE {
 		fmt.Printf("HasSeq: %d\n", n.GetLeafHasSeq())
 		fmt.Printf("SeqPointer: %d\n", n.GetLeafSeqPointer())
@@ -195,10 +216,35 @@ func LoadTreeNode(data []byte) *TreeNode {
 
 /* Basic Getters and Setters for header */
 
+// Returns the page content with its checksum updated, so it must be called whenever the page is going to be written
 func (n *TreeNode) GetBytes() []byte {
+	binary.LittleEndian.PutUint32(n.data[NODE_CHECKSUM_OFFSET:NODE_CHECKSUM_OFFSET+NODE_CHECKSUM_LEN], getNodeChecksum(n.data))
 	return n.data
 }
 
+/*
+Verifies whether the page content matches the checksum written by GetBytes, returning an *ErrPageCorrupted for the
+given page number otherwise. Pages that were torn while written or changed on disk are detected this way
+*/
+func (n *TreeNode) VerifyChecksum(page uint64) error {
+	if len(n.data) != PAGE_SIZE {
+		return &ErrPageCorrupted{Page: page}
+	}
+
+	checksum := binary.LittleEndian.Uint32(n.data[NODE_CHECKSUM_OFFSET : NODE_CHECKSUM_OFFSET+NODE_CHECKSUM_LEN])
+	if checksum != getNodeChecksum(n.data) {
+		return &ErrPageCorrupted{Page: page}
+	}
+
+	return nil
+}
+
+// CRC32C of the whole page, skipping the checksum itself
+func getNodeChecksum(data []byte) uint32 {
+	checksum := crc32.Checksum(data[:NODE_CHECKSUM_OFFSET], nodeCrcTable)
+	return crc32.Update(checksum, nodeCrcTable, data[NODE_CHECKSUM_OFFSET+NODE_CHECKSUM_LEN:])
+}
+
 func (n *TreeNode) GetType() uint16 {
 	return uint16(binary.LittleEndian.Uint16(n.data[NODE_TYPE_OFFSET : NODE_TYPE_OFFSET+NODE_TYPE_LEN]))
 }
@@ -248,16 +294,16 @@ func NewNodeNode() *TreeNode {
 	// Set headers
 	setType(nodeNode, TREE_NODE)
 	setNItens(nodeNode, 0)
-	setNodeOffset(nodeNode, 16)
-	setFreeBytes(nodeNode, PAGE_SIZE-NODE_FREE_BYTES_LEN-NODE_TYPE_LEN-NODE_N_ITENS_LEN-NODE_PARENT_ADDR-NODE_OFFSET_LEN)
+	setNodeOffset(nodeNode, NODE_P_KEY_ADDR_OFFSET)
+	setFreeBytes(nodeNode, PAGE_SIZE-NODE_P_KEY_ADDR_OFFSET)
 	return nodeNode
 }
 
 func (n *TreeNode) ResetNode() {
 	setType(n, TREE_NODE)
 	setNItens(n, 0)
-	setNodeOffset(n, 16)
-	setFreeBytes(n, PAGE_SIZE-NODE_FREE_BYTES_LEN-NODE_TYPE_LEN-NODE_N_ITENS_LEN-NODE_PARENT_ADDR-NODE_OFFSET_LEN)
+	setNodeOffset(n, NODE_P_KEY_ADDR_OFFSET)
+	setFreeBytes(n, PAGE_SIZE-NODE_P_KEY_ADDR_OFFSET)
 }
 
 /*
@@ -341,6 +387,43 @@ func (n *TreeNode) DeleteNodeChildrenByAddress(addr uint64) {
 	copy(n.data, tmp.data)
 }
 
+/*
+Rewrites every child of the node with the given ones, in their order. Children with the same key are read in the
+order they are stored, so the order given is kept among them, while PutNodeNewChild places a child after every other
+child with the same key
+*/
+func (n *TreeNode) setNodeChildren(children []NodeKeyAddr) {
+	tmp := NewNodeNode()
+	setParentAddr(tmp, n.GetParentAddr())
+	for i := 0; i < len(children); i++ {
+		tmp.PutNodeNewChild(children[i].key, children[i].addr)
+	}
+	copy(n.data, tmp.data)
+}
+
+// Changes the key of the child that points to addr, keeping its place among the children
+func (n *TreeNode) setNodeChildKey(addr uint64, key []byte) {
+	n.setNodeChildren(replaceNodeChild(getAllNodeKeyAddr(n), addr, []NodeKeyAddr{newNodeKeyAddr(key, addr)}))
+}
+
+// Returns the children with the one that points to addr replaced by the given ones, in its place
+func replaceNodeChild(children []NodeKeyAddr, addr uint64, replacement []NodeKeyAddr) []NodeKeyAddr {
+	r := make([]NodeKeyAddr, 0, len(children)+len(replacement))
+	for i := 0; i < len(children); i++ {
+		if children[i].addr == addr {
+			r = append(r, replacement...)
+			continue
+		}
+		r = append(r, children[i])
+	}
+
+	return r
+}
+
+func newNodeKeyAddr(key []byte, addr uint64) NodeKeyAddr {
+	return NodeKeyAddr{keyLen: uint16(len(key)), key: key, addr: addr}
+}
+
 func (n *TreeNode) DeleteNodeChildrenByKey(key []byte) {
 	allNodeKeyAddr := getAllNodeKeyAddr(n)
 	tmp := NewNodeNode()
@@ -354,9 +437,10 @@ func (n *TreeNode) DeleteNodeChildrenByKey(key []byte) {
 	copy(n.data, tmp.data)
 }
 
+// Duplicated keys keep the order they are stored in, less must be strict or sort may leave keys out of order
 func sortNodeChildren(c []NodeKeyAddr) {
-	sort.Slice(c, func(i, j int) bool {
-		return bytes.Compare(c[i].key, c[j].key) <= 0
+	sort.SliceStable(c, func(i, j int) bool {
+		return bytes.Compare(c[i].key, c[j].key) < 0
 	})
 }
 
@@ -416,12 +500,8 @@ func NewNodeLeaf() *TreeNode {
 	setLeafHasSeq(nodeLeaf, 0)
 	setLeafSeqPointer(nodeLeaf, 0)
 	setNItens(nodeLeaf, 0)
-	setNodeOffset(nodeLeaf, 26)
-	setFreeBytes(nodeLeaf,
-		PAGE_SIZE-NODE_FREE_BYTES_LEN-
-			NODE_TYPE_LEN-NODE_N_ITENS_LEN-
-			NODE_PARENT_ADDR-LEAF_HAS_SEQ_LEN-NODE_OFFSET_LEN-
-			LEAF_SEQ_P_LEN)
+	setNodeOffset(nodeLeaf, LEAF_VAL_START_OFFSET)
+	setFreeBytes(nodeLeaf, PAGE_SIZE-LEAF_VAL_START_OFFSET)
 
 	return nodeLeaf
 }
@@ -435,6 +515,26 @@ func NewNodeLeafSequence() *TreeNode {
 	return nodeLeafSequence
 }
 
+/*
+Creates a page that is no longer used by the tree. Free pages are linked to each other
+through the next pointer, composing the free list that starts at the bTree header
+*/
+func NewFreePage(next uint64) *TreeNode {
+	freePage := &TreeNode{data: make([]byte, PAGE_SIZE)}
+	setType(freePage, TREE_FREE_PAGE)
+	binary.LittleEndian.PutUint64(freePage.data[FREE_PAGE_NEXT_OFFSET:FREE_PAGE_NEXT_OFFSET+FREE_PAGE_NEXT_LEN], next)
+
+	return freePage
+}
+
+func (n *TreeNode) GetFreePageNext() uint64 {
+	if n.GetType() != TREE_FREE_PAGE {
+		return 0
+	}
+
+	return binary.LittleEndian.Uint64(n.data[FREE_PAGE_NEXT_OFFSET : FREE_PAGE_NEXT_OFFSET+FREE_PAGE_NEXT_LEN])
+}
+
 func (n *TreeNode) setLeafSequenceNumberBytes(numberBytes uint16) {
 	if n.GetType() == TREE_LEAF_SEQUENCE {
 		binary.LittleEndian.PutUint16(n.data[LEAF_SEQ_N_BYTES_OFFSET:LEAF_SEQ_N_BYTES_OFFSET+LEAF_SEQ_N_BYTES], numberBytes)
@@ -473,9 +573,12 @@ func (n *TreeNode) GetLeafSequenceBytes() []byte {
 	return nil
 }
 
+// Leaves don't store the flag, their sequence pointer tells whether they have one, so it's only cleared
 func setLeafHasSeq(n *TreeNode, hasSeq uint16) {
 	if n.GetType() != TREE_LEAF_SEQUENCE {
-		binary.LittleEndian.PutUint16(n.data[LEAF_HAS_SEQ_OFFSET:LEAF_HAS_SEQ_OFFSET+LEAF_HAS_SEQ_LEN], hasSeq)
+		if hasSeq == 0 {
+			setLeafSeqPointer(n, 0)
+		}
 		return
 	}
 	binary.LittleEndian.PutUint16(n.data[LEAF_SEQ_HAS_SEQ_OFFSET:LEAF_SEQ_HAS_SEQ_OFFSET+LEAF_HAS_SEQ_LEN], hasSeq)
@@ -483,7 +586,10 @@ func setLeafHasSeq(n *TreeNode, hasSeq uint16) {
 
 func (n *TreeNode) GetLeafHasSeq() uint16 {
 	if n.GetType() != TREE_LEAF_SEQUENCE {
-		return binary.LittleEndian.Uint16(n.data[LEAF_HAS_SEQ_OFFSET : LEAF_HAS_SEQ_OFFSET+LEAF_HAS_SEQ_LEN])
+		if n.GetLeafSeqPointer() != 0 {
+			return 1
+		}
+		return 0
 	}
 
 	return binary.LittleEndian.Uint16(n.data[LEAF_SEQ_HAS_SEQ_OFFSET : LEAF_SEQ_HAS_SEQ_OFFSET+LEAF_HAS_SEQ_LEN])
@@ -571,9 +677,10 @@ func (n *TreeNode) PutLeafNewKeyValue(key []byte, value []byte) error {
 	return nil
 }
 
+// Same as sortNodeChildren
 func sortLeafKeyValues(c []LeafKeyValue) {
-	sort.Slice(c, func(i, j int) bool {
-		return bytes.Compare(c[i].key, c[j].key) <= 0
+	sort.SliceStable(c, func(i, j int) bool {
+		return bytes.Compare(c[i].key, c[j].key) < 0
 	})
 }
 
@@ -618,7 +725,8 @@ func (n *TreeNode) SplitLeaf(key []byte, value []byte) []TreeNode {
 	/* Some comments here. When a Leaf is split, we must ensure that it is saved with the right
 	   members in each leaf. Since we don't sort in the insertion, we must check between two leaves
 	   where our new value will be inserted. The left leaf will return filled with all possible data
-	   trying to use the most of it space, whereas the second one, will have just the remaining data
+	   trying to use the most of it space, whereas the next ones, will have just the remaining data.
+	   When values are large, two leaves may not be enough, so a third one is used
 	*/
 	// Case Leaf to be splitted has sequence
 	if n.GetLeafHasSeq() == 1 {
@@ -627,6 +735,10 @@ func (n *TreeNode) SplitLeaf(key []byte, value []byte) []TreeNode {
 		newLeaf := NewNodeLeaf()
 		newLeaf.PutLeafNewKeyValue(key, value)
 		r[1] = *newLeaf
+		// The new key may be lower than the one with sequence
+		if bytes.Compare(key, n.GetLeafKeyValueByIndex(0).key) < 0 {
+			r[0], r[1] = r[1], r[0]
+		}
 		return r
 	}
 
@@ -654,7 +766,10 @@ func (n *TreeNode) SplitLeaf(key []byte, value []byte) []TreeNode {
 
 		member := allLeafMembers[i]
 		if 10+member.keyLength+uint16(member.valueLength) > freeBytes {
-			activeLeaf = 1
+			activeLeaf++
+			if activeLeaf == len(newLeaves) {
+				newLeaves = append(newLeaves, *NewNodeLeaf())
+			}
 		}
 		newLeaves[activeLeaf].PutLeafNewKeyValue(member.key, member.value)
 	}
@@ -676,7 +791,15 @@ func (n *TreeNode) SplitNode(key []byte, addr uint64) []TreeNode {
 
 	// Sort them
 	sortNodeChildren(allNodeMembers)
+	return splitNodeChildren(allNodeMembers)
+}
 
+/*
+Distributes sorted children between new nodes. The first node is filled up with as many children as
+possible, and the second one gets the remaining, unless they don't fit into it either (with long keys),
+then a third one is used
+*/
+func splitNodeChildren(allNodeMembers []NodeKeyAddr) []TreeNode {
 	// create two new Leaves
 	newNodes := []TreeNode{*NewNodeNode(), *NewNodeNode()}
 
@@ -686,7 +809,10 @@ func (n *TreeNode) SplitNode(key []byte, addr uint64) []TreeNode {
 		freeBytes := GetFreeBytes(&newNodes[activeNode])
 		member := allNodeMembers[i]
 		if 10+member.keyLen > freeBytes {
-			activeNode = 1
+			activeNode++
+			if activeNode == len(newNodes) {
+				newNodes = append(newNodes, *NewNodeNode())
+			}
 		}
 		newNodes[activeNode].PutNodeNewChild(member.key, member.addr)
 	}
@@ -714,8 +840,8 @@ func CreateLeafWithSequence(key []byte, value []byte) (*TreeNode, []TreeNode) {
 		we calculate the total free bytes that are going to be used for the value length in the first leaf.
 		For that, we take
 		PAGE_SIZE - normally 4096
-		VALUES_OFFSET - 26
-		Resulting in 4070 free bytes for key and value. Since the key is given and we have 2 fixed length fields
+		VALUES_OFFSET - 28
+		Resulting in 4068 free bytes for key and value. Since the key is given and we have 2 fixed length fields
 		LEAF_KEY_LEN and LEAF_VAL_LEN, we can measure the remaining bytes that will be filled with a part of values bytes
 	*/
 	valueBytesForFirstLeaf := PAGE_SIZE - LEAF_VAL_START_OFFSET - LEAF_KEY_LEN_LEN - len(key) - LEAF_VAL_LEN_LEN
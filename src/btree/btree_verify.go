@@ -0,0 +1,347 @@
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+/*
+Integrity check
+
+Verify walks every page reachable from the root of a bTree, and then every page of the free list, reporting
+whatever breaks the structure the other functions of this package rely on:
+
+  - Pages that can't be read or don't match their checksum
+  - Pages of an unexpected type, or reached more than once
+  - Parent pointers that don't point to the node holding the page
+  - Items that don't end at the node offset, and free bytes that don't match it
+  - Keys out of order across leaves or out of the range given by their parent, and separator keys that differ
+    from the first key of their child
+  - Broken sequence chains of large values
+
+It's meant to diagnose broken trees, so it doesn't stop at the first problem found
+*/
+
+// VerifyError describes one problem found in a page
+type VerifyError struct {
+	Page    uint64
+	Problem string
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("page %d: %s", e.Page, e.Problem)
+}
+
+type VerifyResult struct {
+	Problems []error
+	Pages    map[uint64]bool // Pages reached from the root or from the free list
+}
+
+type treeVerifier struct {
+	bTree   *BTree
+	result  *VerifyResult
+	lastKey []byte // Last key of the previous leaf, since leaves must be sorted among them
+}
+
+func Verify(bTree *BTree) VerifyResult {
+	v := treeVerifier{
+		bTree: bTree,
+		result: &VerifyResult{
+			Problems: make([]error, 0),
+			Pages:    make(map[uint64]bool),
+		},
+	}
+
+	// Root = 0 means that there is no item in it
+	if root := bTree.GetRoot(); root != 0 {
+		v.verifyPage(root, 0, nil, nil)
+	}
+
+	v.verifyFreeList()
+	return *v.result
+}
+
+/*
+Reports every page of a file with nPages pages, header included, that is neither in the tree nor in the free list.
+Those pages can't be reached anymore, so they are lost
+*/
+func (r *VerifyResult) CheckUnreachablePages(nPages uint64) {
+	for page := uint64(1); page < nPages; page++ {
+		if !r.Pages[page] {
+			r.Problems = append(r.Problems, &VerifyError{Page: page, Problem: "page is neither in the tree nor in the free list"})
+		}
+	}
+}
+
+func (v *treeVerifier) report(page uint64, format string, args ...any) {
+	v.result.Problems = append(v.result.Problems, &VerifyError{Page: page, Problem: fmt.Sprintf(format, args...)})
+}
+
+// Reads a page, reporting instead of panicking when it can't be read. Returns nil in that case
+func (v *treeVerifier) readPage(page uint64) *TreeNode {
+	if v.result.Pages[page] {
+		v.report(page, "page is reached more than once")
+		return nil
+	}
+
+	v.result.Pages[page] = true
+	node, err := getPageForVerifying(v.bTree, page)
+
+	if err != nil {
+		v.result.Problems = append(v.result.Problems, err)
+		return nil
+	}
+
+	return &node
+}
+
+func getPageForVerifying(bTree *BTree, page uint64) (node TreeNode, err error) {
+	defer func() {
+		r := recover()
+
+		if r == nil {
+			return
+		}
+
+		if pageErr, ok := r.(error); ok && IsPageError(pageErr) {
+			err = pageErr
+			return
+		}
+
+		err = &VerifyError{Page: page, Problem: fmt.Sprintf("page can't be read: %v", r)}
+	}()
+
+	return bTree.Get(page), nil
+}
+
+/*
+Verifies a page of the tree and everything below it. Every key must be between low and high, the keys of the
+parent around it (nil when there is none). Returns a copy of the first key of the page, or nil when it has none
+*/
+func (v *treeVerifier) verifyPage(page uint64, parent uint64, low []byte, high []byte) []byte {
+	node := v.readPage(page)
+
+	if node == nil {
+		return nil
+	}
+
+	if node.GetParentAddr() != parent {
+		v.report(page, "parent pointer is %d, expected %d", node.GetParentAddr(), parent)
+	}
+
+	switch node.GetType() {
+	case TREE_NODE:
+		return v.verifyNode(page, node, low, high)
+	case TREE_LEAF:
+		return v.verifyLeaf(page, node, parent == 0, low, high)
+	default:
+		v.report(page, "unexpected page type %d in the tree", node.GetType())
+	}
+
+	return nil
+}
+
+func (v *treeVerifier) verifyNode(page uint64, node *TreeNode, low []byte, high []byte) []byte {
+	if !v.verifyItemsLayout(page, node) {
+		return nil
+	}
+
+	children := getAllNodeKeyAddr(node)
+
+	if len(children) == 0 {
+		v.report(page, "node has no children")
+		return nil
+	}
+
+	for i, child := range children {
+		if !isKeyInRange(child.key, low, high) {
+			v.report(page, "key %x is out of the range given by the parent", child.key)
+		}
+
+		if child.addr == 0 {
+			v.report(page, "child %d points to the header page", i)
+			continue
+		}
+
+		childHigh := high
+		if i < len(children)-1 {
+			childHigh = children[i+1].key
+		}
+
+		firstKey := v.verifyPage(child.addr, page, child.key, childHigh)
+
+		if firstKey != nil && !bytes.Equal(firstKey, child.key) {
+			v.report(page, "separator key %x differs from the first key %x of page %d", child.key, firstKey, child.addr)
+		}
+	}
+
+	r := make([]byte, len(children[0].key))
+	copy(r, children[0].key)
+	return r
+}
+
+func (v *treeVerifier) verifyLeaf(page uint64, leaf *TreeNode, isRoot bool, low []byte, high []byte) []byte {
+	if !v.verifyItemsLayout(page, leaf) {
+		return nil
+	}
+
+	keyValues := getAllLeafKeyValues(leaf)
+
+	// Only the root may be empty, when every key was deleted
+	if len(keyValues) == 0 {
+		if !isRoot {
+			v.report(page, "leaf has no keys")
+		}
+
+		return nil
+	}
+
+	for _, keyValue := range keyValues {
+		if !isKeyInRange(keyValue.key, low, high) {
+			v.report(page, "key %x is out of the range given by the parent", keyValue.key)
+			break
+		}
+	}
+
+	if v.lastKey != nil && bytes.Compare(keyValues[0].key, v.lastKey) < 0 {
+		v.report(page, "first key %x is smaller than the last key %x of the previous leaf", keyValues[0].key, v.lastKey)
+	}
+
+	v.lastKey = keyValues[len(keyValues)-1].key
+
+	switch leaf.GetLeafHasSeq() {
+	case 0:
+	case 1:
+		if len(keyValues) != 1 {
+			v.report(page, "leaf with sequence holds %d keys, expected 1", len(keyValues))
+		}
+
+		v.verifySequence(page, leaf.GetLeafSeqPointer())
+	default:
+		v.report(page, "invalid sequence flag %d", leaf.GetLeafHasSeq())
+	}
+
+	r := make([]byte, len(keyValues[0].key))
+	copy(r, keyValues[0].key)
+	return r
+}
+
+// Follows the sequence chain of a large value, starting at the given page
+func (v *treeVerifier) verifySequence(leafPage uint64, next uint64) {
+	previous := leafPage
+
+	for {
+		if next == 0 {
+			v.report(previous, "sequence pointer is missing")
+			return
+		}
+
+		sequence := v.readPage(next)
+
+		if sequence == nil {
+			return
+		}
+
+		if sequence.GetType() != TREE_LEAF_SEQUENCE {
+			v.report(next, "expected a leaf sequence page, found type %d", sequence.GetType())
+			return
+		}
+
+		if sequence.getLeafSequenceNumberBytes() > LEAF_SEQ_FREE_BYTES_SIZE {
+			v.report(next, "sequence holds %d bytes, more than the page supports", sequence.getLeafSequenceNumberBytes())
+		}
+
+		switch sequence.GetLeafHasSeq() {
+		case 0:
+			return
+		case 1:
+			previous, next = next, sequence.GetLeafSeqPointer()
+		default:
+			v.report(next, "invalid sequence flag %d", sequence.GetLeafHasSeq())
+			return
+		}
+	}
+}
+
+func (v *treeVerifier) verifyFreeList() {
+	page := v.bTree.GetFreeListHead()
+
+	for page != 0 {
+		freePage := v.readPage(page)
+
+		if freePage == nil {
+			return
+		}
+
+		if freePage.GetType() != TREE_FREE_PAGE {
+			v.report(page, "page in the free list has type %d", freePage.GetType())
+			return
+		}
+
+		page = freePage.GetFreePageNext()
+	}
+}
+
+/*
+Walks the items of a node or leaf as they are stored, before reading them, so that a broken page is reported
+instead of panicking. Items must end exactly at the node offset, and free bytes must be whatever is after it
+*/
+func (v *treeVerifier) verifyItemsLayout(page uint64, node *TreeNode) bool {
+	start, itemHeaderLen := NODE_P_KEY_ADDR_OFFSET, LEAF_KEY_LEN_LEN+NODE_P_CHILD_ADD_LEN
+	if node.GetType() == TREE_LEAF {
+		start, itemHeaderLen = LEAF_VAL_START_OFFSET, LEAF_KEY_LEN_LEN+LEAF_VAL_LEN_LEN
+	}
+
+	offset := int(getNodeOffset(node))
+
+	if offset < start || offset > PAGE_SIZE {
+		v.report(page, "offset %d is out of the page", offset)
+		return false
+	}
+
+	position := start
+	for i := 0; i < int(node.GetNItens()); i++ {
+		if position+itemHeaderLen > offset {
+			v.report(page, "item %d starts after the offset %d", i, offset)
+			return false
+		}
+
+		itemLen := itemHeaderLen + int(binary.LittleEndian.Uint16(node.data[position:position+2]))
+		if node.GetType() == TREE_LEAF {
+			valueLen := binary.LittleEndian.Uint64(node.data[position+2 : position+2+LEAF_VAL_LEN_LEN])
+
+			if valueLen > PAGE_SIZE {
+				v.report(page, "item %d has a value of %d bytes, more than a page", i, valueLen)
+				return false
+			}
+
+			itemLen += int(valueLen)
+		}
+
+		position += itemLen
+		if position > offset {
+			v.report(page, "item %d ends after the offset %d", i, offset)
+			return false
+		}
+	}
+
+	if position != offset {
+		v.report(page, "items end at %d, but offset is %d", position, offset)
+		return false
+	}
+
+	if int(GetFreeBytes(node)) != PAGE_SIZE-offset {
+		v.report(page, "free bytes is %d, expected %d", GetFreeBytes(node), PAGE_SIZE-offset)
+	}
+
+	return true
+}
+
+func isKeyInRange(key []byte, low []byte, high []byte) bool {
+	if low != nil && bytes.Compare(key, low) < 0 {
+		return false
+	}
+
+	return high == nil || bytes.Compare(key, high) <= 0
+}
@@ -0,0 +1,380 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+
+	btree "github.com/nicolasvancan/monvandb/src/btree"
+	files "github.com/nicolasvancan/monvandb/src/files"
+)
+
+/*
+Altering tables
+
+Columns can be added, dropped, renamed and have their types changed without touching the rows stored already, since
+their values are found by the Ids of the columns (see FromColumnValuesToRow). Changing the type of a column gives it a
+new Id, and its values stored under the old one are converted when read. Every change waits until the writers using
+the table are done, and is kept once the table metadata file is written. Changes replace the columns, keys and indexes
+of the table by changed copies, so readers go on with the ones they read already.
+
+Old values are kept in the rows until they are written again. RewriteRows writes every row with the current columns,
+in small batches, alongside readers and writers.
+
+Columns of the table key and indexed columns can't be dropped nor have their types changed, since their values are
+encoded in the keys of the DataFiles.
+*/
+
+const (
+	REWRITE_BATCH_SIZE = 256 // Rows written at once by RewriteRows
+)
+
+// Runs a change of the columns, which is undone if the table metadata file can't be written
+func (t *Table) alter(change func() (undo func(), err error)) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	undo, err := change()
+
+	if err != nil {
+		return err
+	}
+
+	if err := t.writeMetadata(); err != nil {
+		undo()
+		return err
+	}
+
+	return nil
+}
+
+// Returns the position of a column, or an error if there is none with the given name
+func (t *Table) getColumnPosition(name string) (int, error) {
+	for i := range t.Columns {
+		if t.Columns[i].Name == name {
+			return i, nil
+		}
+	}
+
+	return -1, fmt.Errorf("column %s does not exist in table %s", name, t.Name)
+}
+
+// Returns an error when the values of the column are encoded in the keys of the table or of an index
+func (t *Table) checkColumnNotInKeys(name string) error {
+	for _, column := range t.GetKeyColumns() {
+		if column.Name == name {
+			return fmt.Errorf("column %s is part of the key of table %s", name, t.Name)
+		}
+	}
+
+	for _, index := range t.Indexes {
+		if slices.Contains(index.Columns, name) {
+			return fmt.Errorf("column %s of table %s is indexed by %s", name, t.Name, index.Name)
+		}
+	}
+
+	return nil
+}
+
+/*
+AddColumn adds a column to the table. Rows stored already take its default value, so a column that isn't nullable must
+have one. It can't be part of the table key
+*/
+func (t *Table) AddColumn(column Column) error {
+	return t.alter(func() (func(), error) {
+		if _, err := t.getColumnPosition(column.Name); err == nil {
+			return nil, fmt.Errorf("column %s already exists in table %s", column.Name, t.Name)
+		}
+
+		if column.Primary {
+			return nil, fmt.Errorf("column %s can't be added to the key of table %s", column.Name, t.Name)
+		}
+
+		if column.Sequence != "" && (t.sequences == nil || !t.sequences.exists(column.Sequence)) {
+			return nil, fmt.Errorf("sequence %s of column %s does not exist", column.Sequence, column.Name)
+		}
+
+		if !column.Nullable && column.Default == nil {
+			return nil, fmt.Errorf("column %s must be nullable or have a default value", column.Name)
+		}
+
+		if t.NextColumnId == 0 || t.NextColumnId == ^uint16(0) {
+			return nil, fmt.Errorf("table %s can't have more columns added", t.Name)
+		}
+
+		column.Id = t.NextColumnId
+		column.OldIds = nil
+		t.Columns = append(t.Columns, column)
+		t.NextColumnId++
+
+		return func() {
+			t.Columns = t.Columns[:len(t.Columns)-1]
+			t.NextColumnId--
+		}, nil
+	})
+}
+
+// DropColumn removes a column from the table. Its values are left in the rows stored already until they are rewritten
+func (t *Table) DropColumn(name string) error {
+	return t.alter(func() (func(), error) {
+		position, err := t.getColumnPosition(name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := t.checkColumnNotInKeys(name); err != nil {
+			return nil, err
+		}
+
+		dropped := t.Columns[position]
+		t.Columns = slices.Delete(slices.Clone(t.Columns), position, position+1)
+
+		return func() {
+			t.Columns = slices.Insert(t.Columns, position, dropped)
+		}, nil
+	})
+}
+
+/*
+RenameColumn changes the name of a column, in the table key and in the indexes as well, and of the sequence of an auto
+increment column. Columns of indexes being built can't be renamed
+*/
+func (t *Table) RenameColumn(name string, newName string) error {
+	return t.alter(func() (func(), error) {
+		position, err := t.getColumnPosition(name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := t.getColumnPosition(newName); err == nil {
+			return nil, fmt.Errorf("column %s already exists in table %s", newName, t.Name)
+		}
+
+		for _, index := range t.Indexes {
+			if slices.Contains(index.Columns, name) && !index.isReady() {
+				return nil, fmt.Errorf("column %s of table %s is indexed by %s, which is being built", name, t.Name, index.Name)
+			}
+		}
+
+		renamedSequence, err := t.renameAutoIncrementSequence(t.Columns[position], newName)
+
+		if err != nil {
+			return nil, err
+		}
+
+		columns, compositeKey, primaryKey, indexes := t.Columns, t.CompositeKey, t.PrimaryKey, t.Indexes
+		t.renameColumn(name, newName)
+
+		return func() {
+			t.Columns, t.CompositeKey, t.PrimaryKey, t.Indexes = columns, compositeKey, primaryKey, indexes
+
+			if renamedSequence {
+				t.sequences.rename(getAutoIncrementSequenceName(t.Name, newName), getAutoIncrementSequenceName(t.Name, name))
+			}
+		}, nil
+	})
+}
+
+// Replaces the columns, the key and the indexes naming the column by renamed copies
+func (t *Table) renameColumn(name string, newName string) {
+	rename := func(columns []Column) []Column {
+		renamed := slices.Clone(columns)
+		for i := range renamed {
+			if renamed[i].Name == name {
+				renamed[i].Name = newName
+			}
+		}
+
+		return renamed
+	}
+
+	t.Columns = rename(t.Columns)
+	t.CompositeKey = rename(t.CompositeKey)
+
+	if t.PrimaryKey != nil && t.PrimaryKey.Name == name {
+		primaryKey := *t.PrimaryKey
+		primaryKey.Name = newName
+		t.PrimaryKey = &primaryKey
+	}
+
+	// Indexes are found by the names of their columns. None of the renamed ones is being built, so they are written by
+	// nobody while the table is held
+	indexes := make(map[string]*Index, len(t.Indexes))
+	for key, index := range t.Indexes {
+		if !slices.Contains(index.Columns, name) {
+			indexes[key] = index
+			continue
+		}
+
+		columns := slices.Clone(index.Columns)
+		for i := range columns {
+			if columns[i] == name {
+				columns[i] = newName
+			}
+		}
+
+		indexes[getIndexesKey(columns)] = &Index{
+			Name:      index.Name,
+			Column:    columns[0],
+			Columns:   columns,
+			Unique:    index.Unique,
+			Path:      index.Path,
+			PDataFile: index.PDataFile,
+		}
+	}
+
+	t.Indexes = indexes
+}
+
+/*
+ChangeColumnType changes the type of a column. Every value stored already must be converted to the new type (see
+convertToColumnType), otherwise nothing is changed. Stored values are converted whenever they are read, until the
+rows are rewritten
+*/
+func (t *Table) ChangeColumnType(name string, columnType int) error {
+	return t.alter(func() (func(), error) {
+		position, err := t.getColumnPosition(name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := t.checkColumnNotInKeys(name); err != nil {
+			return nil, err
+		}
+
+		if _, ok := columnTypeNames[columnType]; !ok {
+			return nil, fmt.Errorf("column type %d does not exist", columnType)
+		}
+
+		if t.NextColumnId == 0 || t.NextColumnId == ^uint16(0) {
+			return nil, fmt.Errorf("column %s of table %s can't have its type changed anymore", name, t.Name)
+		}
+
+		if err := t.checkColumnConversion(name, columnType); err != nil {
+			return nil, err
+		}
+
+		old := t.Columns[position]
+		changed := old
+		changed.Type = columnType
+		changed.Id = t.NextColumnId
+		changed.OldIds = append(slices.Clone(old.OldIds), old.Id)
+
+		if changed.Default != nil {
+			if changed.Default, err = convertToColumnType(changed.Default, columnType); err != nil {
+				return nil, err
+			}
+		}
+
+		t.Columns = slices.Clone(t.Columns)
+		t.Columns[position] = changed
+		t.NextColumnId++
+
+		return func() {
+			t.Columns[position] = old
+			t.NextColumnId--
+		}, nil
+	})
+}
+
+// Returns an error if any value of the column stored in the table can't be converted to the type
+func (t *Table) checkColumnConversion(name string, columnType int) error {
+	rows := t.Scan(context.Background())
+	defer rows.Close()
+
+	for rows.Next() {
+		if _, err := convertToColumnType(rows.Row()[name], columnType); err != nil {
+			return fmt.Errorf("column %s can't have its type changed: %v", name, err)
+		}
+	}
+
+	return rows.Err()
+}
+
+/*
+RewriteRows writes every row of the table again with its current columns, leaving out the values of dropped columns
+and converting the ones stored before the types of their columns changed. Rows are read from a snapshot and written
+in batches, so readers and writers go on meanwhile. Rows written since the snapshot are written with the current
+columns already
+*/
+func (t *Table) RewriteRows() (err error) {
+	snapshot := t.PDataFile.Snapshot()
+	keyValues, err := scanKeys(snapshot.GetBTree())
+	snapshot.Close()
+
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(keyValues); start += REWRITE_BATCH_SIZE {
+		end := min(start+REWRITE_BATCH_SIZE, len(keyValues))
+
+		if err := t.rewriteBatch(keyValues[start:end]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RewriteRowsInBackground runs RewriteRows in another goroutine, sending its result through the returned channel
+func (t *Table) RewriteRowsInBackground() <-chan error {
+	done := make(chan error, 1)
+
+	go func() {
+		done <- t.RewriteRows()
+		close(done)
+	}()
+
+	return done
+}
+
+// Returns the keys of every row of the tree
+func scanKeys(tree *btree.BTree) (keys [][]byte, err error) {
+	// Scanning the data file may find corrupted pages
+	defer btree.RecoverPageCorrupted(&err)
+
+	keyValues, _ := scan(tree)
+	keys = make([][]byte, len(keyValues))
+	for i := range keyValues {
+		keys[i] = keyValues[i].Key
+	}
+
+	return keys, nil
+}
+
+// Writes again the rows with the given keys that are still in the table, holding it like any other writer
+func (t *Table) rewriteBatch(keys [][]byte) error {
+	t.txMu.RLock()
+	defer t.txMu.RUnlock()
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.PDataFile.Batch(func(tx *files.FileTx) error {
+		for _, key := range keys {
+			stored, err := tx.Get(key)
+
+			if err != nil {
+				return err
+			}
+
+			for _, row := range t.FromKeyValueToRawRow(stored) {
+				rewritten := t.FromRawRowToKeyValue(row)
+
+				if bytes.Equal(rewritten.Value, stored[0].Value) {
+					continue
+				}
+
+				if err := tx.Update(key, rewritten.Value); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
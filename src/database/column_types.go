@@ -0,0 +1,213 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+/*
+Column types
+
+Each column type has one Go type its values are converted to: int32 for INT, int16 for SMALL_INT, int64 for BIG_INT,
+float32 for FLOAT, float64 for DOUBLE, string, bool, time.Time for TIMESTAMP and []byte for BLOB. Numbers are converted
+among them as long as they fit, strings are parsed, and timestamps are written as RFC3339 strings.
+
+Values written to a table are held to their columns more strictly (see coerceToColumnType): numbers are only taken
+by numeric columns, and strings only by string columns, besides timestamps given as RFC3339 strings
+*/
+
+var columnTypeNames = map[int]string{
+	COL_TYPE_INT:       "INT",
+	COL_TYPE_SMALL_INT: "SMALL_INT",
+	COL_TYPE_BIG_INT:   "BIG_INT",
+	COL_TYPE_STRING:    "STRING",
+	COL_TYPE_FLOAT:     "FLOAT",
+	COL_TYPE_DOUBLE:    "DOUBLE",
+	COL_TYPE_BOOL:      "BOOL",
+	COL_TYPE_TIMESTAMP: "TIMESTAMP",
+	COL_TYPE_BLOB:      "BLOB",
+}
+
+func getColumnTypeName(columnType int) string {
+	if name, ok := columnTypeNames[columnType]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("type %d", columnType)
+}
+
+// Converts a value to the Go type of the column type. Null values stay null
+func convertToColumnType(value interface{}, columnType int) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	converted, ok := convertValue(reflect.ValueOf(value), columnType)
+
+	if !ok {
+		return nil, fmt.Errorf("value %v of type %T can't be converted to %s", value, value, getColumnTypeName(columnType))
+	}
+
+	return converted, nil
+}
+
+/*
+Converts a value written to a column to the Go type of its type, returning a ColumnTypeError when the value is of
+another kind, or doesn't fit in the column type. Null values stay null
+*/
+func coerceToColumnType(table string, column Column, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	v := reflect.ValueOf(value)
+
+	if isKindOfColumnType(v, column.Type) {
+		if converted, ok := convertValue(v, column.Type); ok {
+			return converted, nil
+		}
+	}
+
+	return nil, &ColumnTypeError{Table: table, Column: column.Name, Type: column.Type, Value: value}
+}
+
+// Whether the value is of a kind taken by the column type, even if it may not fit in it
+func isKindOfColumnType(v reflect.Value, columnType int) bool {
+	switch columnType {
+	case COL_TYPE_INT, COL_TYPE_SMALL_INT, COL_TYPE_BIG_INT, COL_TYPE_FLOAT, COL_TYPE_DOUBLE:
+		return isNumber(v)
+	case COL_TYPE_STRING:
+		return v.Kind() == reflect.String
+	case COL_TYPE_BOOL:
+		return v.Kind() == reflect.Bool
+	case COL_TYPE_TIMESTAMP:
+		_, ok := v.Interface().(time.Time)
+		return ok || v.Kind() == reflect.String
+	case COL_TYPE_BLOB:
+		_, ok := v.Interface().([]byte)
+		return ok
+	}
+
+	return false
+}
+
+func isNumber(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+
+	return false
+}
+
+func convertValue(v reflect.Value, columnType int) (interface{}, bool) {
+	switch columnType {
+	case COL_TYPE_INT:
+		i, ok := toInt64(v, math.MinInt32, math.MaxInt32)
+		return int32(i), ok
+	case COL_TYPE_SMALL_INT:
+		i, ok := toInt64(v, math.MinInt16, math.MaxInt16)
+		return int16(i), ok
+	case COL_TYPE_BIG_INT:
+		return toInt64(v, math.MinInt64, math.MaxInt64)
+	case COL_TYPE_FLOAT:
+		f, ok := toFloat64(v)
+		return float32(f), ok && math.Abs(f) <= math.MaxFloat32
+	case COL_TYPE_DOUBLE:
+		return toFloat64(v)
+	case COL_TYPE_STRING:
+		return toString(v)
+	case COL_TYPE_BOOL:
+		if v.Kind() == reflect.Bool {
+			return v.Bool(), true
+		}
+
+		if v.Kind() == reflect.String {
+			b, err := strconv.ParseBool(v.String())
+			return b, err == nil
+		}
+	case COL_TYPE_TIMESTAMP:
+		if t, ok := v.Interface().(time.Time); ok {
+			return t, true
+		}
+
+		if v.Kind() == reflect.String {
+			t, err := time.Parse(time.RFC3339Nano, v.String())
+			return t, err == nil
+		}
+	case COL_TYPE_BLOB:
+		if b, ok := v.Interface().([]byte); ok {
+			return b, true
+		}
+
+		if v.Kind() == reflect.String {
+			return []byte(v.String()), true
+		}
+	}
+
+	return nil, false
+}
+
+// Integers between min and max, including floats without fractions and strings holding them
+func toInt64(v reflect.Value, min int64, max int64) (int64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), v.Int() >= min && v.Int() <= max
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint()), v.Uint() <= uint64(max)
+	case reflect.Float32, reflect.Float64:
+		// float64(max) rounds up to -min, which is out of the range, so floats must be strictly less than it
+		f := v.Float()
+		return int64(f), f == math.Trunc(f) && f >= float64(min) && f < -float64(min)
+	case reflect.String:
+		i, err := strconv.ParseInt(v.String(), 10, 64)
+		return i, err == nil && i >= min && i <= max
+	}
+
+	return 0, false
+}
+
+func toFloat64(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.String:
+		f, err := strconv.ParseFloat(v.String(), 64)
+		return f, err == nil
+	}
+
+	return 0, false
+}
+
+func toString(v reflect.Value) (string, bool) {
+	switch value := v.Interface().(type) {
+	case string:
+		return value, true
+	case []byte:
+		return string(value), true
+	case time.Time:
+		return value.Format(time.RFC3339Nano), true
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), true
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64), true
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), true
+	}
+
+	return "", false
+}
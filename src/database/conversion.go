@@ -7,7 +7,23 @@ import (
 
 /*
 Basic table interface package, which contains basic methodes for the table structure
+
+Values of a stored row are found by the Ids of their columns, so rows written before columns were added, dropped or
+changed are still read. Columns added since a row was written take their default value, values of dropped columns
+are left out, and values written before the type of their column changed are converted to the new one.
+*/
+
+/*
+Returns the Id of the column at the given position. Tables whose columns have no Ids, since they were not created by
+CreateTable nor loaded, find the values of the columns by their positions
 */
+func (t *Table) getColumnId(position int) uint16 {
+	if t.NextColumnId == 0 {
+		return uint16(position)
+	}
+
+	return t.Columns[position].Id
+}
 
 // Functions that are used to convert a row to column values and vice versa
 func (t *Table) MapRowToColumnValues(row RawRow) []ColumnValue {
@@ -21,7 +37,7 @@ func (t *Table) MapRowToColumnValues(row RawRow) []ColumnValue {
 
 		// Create a new column value
 		columnValues[index] = ColumnValue{
-			Col:   uint16(index),
+			Col:   t.getColumnId(index),
 			Value: value,
 		}
 	}
@@ -32,13 +48,26 @@ func (t *Table) MapRowToColumnValues(row RawRow) []ColumnValue {
 func (t *Table) FromColumnValuesToRow(columnValues []ColumnValue) RawRow {
 	// Create a map to hold the column values
 	row := make(RawRow)
+	values := make(map[uint16]interface{}, len(columnValues))
+	for _, columnValue := range columnValues {
+		values[columnValue.Col] = columnValue.Value
+	}
 
 	// Loop through the columns and add them to the map
-	for index := range t.Columns {
-		// Get the column value
-		columnValue := columnValues[index]
-		// Add the value to the row
-		row[t.Columns[index].Name] = columnValue.Value
+	for index, column := range t.Columns {
+		if value, ok := values[t.getColumnId(index)]; ok {
+			row[column.Name] = value
+			continue
+		}
+
+		// Every value was checked to be converted when the type changed
+		row[column.Name] = column.Default
+		for i := len(column.OldIds) - 1; i >= 0; i-- {
+			if value, ok := values[column.OldIds[i]]; ok {
+				row[column.Name], _ = convertToColumnType(value, column.Type)
+				break
+			}
+		}
 	}
 
 	return row
@@ -50,7 +79,7 @@ func (t *Table) FromRawRowToKeyValue(row RawRow) btree.BTreeKeyValue {
 
 	// Serialize the column values
 	serializedColumnValues, _ := utils.Serialize(columnValues)
-	key, _ := utils.Serialize(row[t.PrimaryKey.Name])
+	key, _ := t.GetRowKey(row)
 	return btree.BTreeKeyValue{
 		Key:   key,
 		Value: serializedColumnValues,
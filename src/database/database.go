@@ -4,6 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"sync"
 
 	files "github.com/nicolasvancan/monvandb/src/files"
 	utils "github.com/nicolasvancan/monvandb/src/utils"
@@ -68,20 +71,54 @@ func LoadDatabase(path string) (*Database, error) {
 	// Initialize the tables
 	database.Tables = make(map[string]*Table)
 
+	// Transactions prepared by the tables are recovered according to the decisions of the database log
+	txLog, err := database.getTxLog()
+
+	if err != nil {
+		return nil, err
+	}
+
+	sequences, err := database.getSequences()
+
+	if err != nil {
+		return nil, err
+	}
+
 	// Load the tables
 	for key, value := range database.TablePaths {
-		table, err := LoadTable(value)
+		table, err := loadTable(value, txLog)
 
 		if err != nil {
 			return nil, err
 		}
 
+		table.sequences = sequences
 		database.Tables[key] = table
 	}
 
+	// Every table was recovered, so old decisions are not needed anymore
+	if err = txLog.Compact(); err != nil {
+		return nil, err
+	}
+
 	return database, nil
 }
 
+func (d *Database) getTxLog() (*files.TxLog, error) {
+	if d.txLog != nil {
+		return d.txLog, nil
+	}
+
+	txLog, err := files.OpenTxLog(d.Path + utils.SEPARATOR + utils.TX_LOG_FILE)
+
+	if err != nil {
+		return nil, fmt.Errorf("error opening database transaction log: %v", err)
+	}
+
+	d.txLog = txLog
+	return txLog, nil
+}
+
 // Basic Database function Get Table
 // Since it returs a pointer to the table struct
 // whenever there is a change in the table struct, the change will be reflected in the database struct
@@ -104,6 +141,11 @@ func createNewTableFiles(tableDef Table, path string) error {
 	if err != nil {
 		return err
 	}
+	// A folder left by a table dropped before a crash isn't part of the database
+	if err = utils.RemoveFolder(path); err != nil {
+		return fmt.Errorf("error removing folder of dropped table: %v", err)
+	}
+
 	// Create a new table folder
 	err = utils.CreateFolder(path, os.ModePerm)
 
@@ -111,14 +153,8 @@ func createNewTableFiles(tableDef Table, path string) error {
 		return fmt.Errorf("error creating folder for new table: %v", err)
 	}
 	metadataPath := path + utils.SEPARATOR + utils.METDATA_FILE
-	// Create a new table data file
-	_, err = utils.CreateFile(metadataPath)
-
-	if err != nil {
-		return fmt.Errorf("error creating metadata file for new table: %v", err)
-	}
 	// Write the table metadata to the file
-	err = utils.WriteToFile(metadataPath, json)
+	err = utils.WriteFileAtomically(metadataPath, json)
 
 	if err != nil {
 		return fmt.Errorf("could not write to table metadata file: %v", err)
@@ -149,21 +185,27 @@ func (d *Database) CreateTable(tableName string, columns []Column) error {
 	// Create new table structure
 	newTable := &Table{
 		Name:    tableName,
-		Columns: columns,
+		Columns: slices.Clone(columns),
 		Path:    tablePath,
 		Indexes: make(map[string]*Index),
 	}
-	// Create new table files
-	err := createNewTableFiles(*newTable, tablePath)
+	newTable.setColumnIds()
+	// Get all Primary columns
+	primaryColumns := newTable.getPrimaryColumns()
+	if len(primaryColumns) == 0 {
+		return errors.New("table must have at least one primary column")
+	}
+
+	sequences, err := d.getSequences()
 
 	if err != nil {
 		return err
 	}
 
-	// Get all Primary columns
-	primaryColumns := newTable.getPrimaryColumns()
-	if len(primaryColumns) == 0 {
-		return errors.New("table must have at least one primary column")
+	for _, column := range newTable.Columns {
+		if column.Sequence != "" && !sequences.exists(column.Sequence) {
+			return fmt.Errorf("sequence %s of column %s does not exist", column.Sequence, column.Name)
+		}
 	}
 
 	newTable.PrimaryKey = &primaryColumns[0]
@@ -174,6 +216,13 @@ func (d *Database) CreateTable(tableName string, columns []Column) error {
 		newTable.CompositeKey = primaryColumns
 	}
 
+	// Create new table files, keys are stored along with the columns
+	err = createNewTableFiles(*newTable, tablePath)
+
+	if err != nil {
+		return err
+	}
+
 	// Add table to database
 	d.TablePaths[tableName] = tablePath
 
@@ -184,38 +233,80 @@ func (d *Database) CreateTable(tableName string, columns []Column) error {
 		return err
 	}
 
-	err = utils.WriteToFile(d.Path+string(os.PathSeparator)+"metadata.json", json)
+	err = utils.WriteFileAtomically(d.Path+string(os.PathSeparator)+"metadata.json", json)
 
 	if err != nil {
 		return fmt.Errorf("could not write to database metadata.json file: %v", err)
 	}
 
-	d.Tables[tableName], err = LoadTable(tablePath)
+	txLog, err := d.getTxLog()
+
+	if err != nil {
+		return err
+	}
+
+	table, err := loadTable(tablePath, txLog)
 
 	if err != nil {
 		return err
 	}
 
+	table.sequences = sequences
+	d.Tables[tableName] = table
 	return nil
 }
 
-// TODO: Create test
+// CreateIndex creates an index of a column of the table, built offline (see IndexOptions)
 func (d *Database) CreateIndex(tableName string, indexedColumn string, indexName string) error {
+	return d.CreateIndexWithOptions(tableName, indexedColumn, indexName, IndexOptions{})
+}
+
+/*
+CreateIndexWithOptions creates an index of a column of the table, holding every row already in it. Rows written by
+transactions while an index is built online are indexed by them outside the transaction, so a transaction rolled back
+meanwhile may leave the index out of date. A unique index can't be created while rows repeat its values, failing with
+a *ConstraintError
+*/
+func (d *Database) CreateIndexWithOptions(tableName string, indexedColumn string, indexName string, options IndexOptions) error {
+	return d.CreateCompositeIndex(tableName, []string{indexedColumn}, indexName, options)
+}
+
+/*
+CreateCompositeIndex creates an index of many columns of the table, just like CreateIndexWithOptions. Its keys are made
+of the values of the columns in the given order, and it finds rows by its first column as well
+*/
+func (d *Database) CreateCompositeIndex(tableName string, indexedColumns []string, indexName string, options IndexOptions) error {
 	table, err := d.GetTable(tableName)
 
 	if err != nil {
 		return err
 	}
 
-	// Check if the column exists
-	column := table.GetColumnByName(indexedColumn)
+	if len(indexedColumns) == 0 {
+		return fmt.Errorf("index %s of table %s must have at least one column", indexName, tableName)
+	}
+
+	// Check if the columns exist
+	for i, indexedColumn := range indexedColumns {
+		if table.GetColumnByName(indexedColumn) == nil {
+			return fmt.Errorf("column %s does not exist in table %s", indexedColumn, tableName)
+		}
+
+		if slices.Contains(indexedColumns[:i], indexedColumn) {
+			return fmt.Errorf("column %s is repeated in index %s", indexedColumn, indexName)
+		}
+	}
 
-	if column == nil {
-		return fmt.Errorf("column %s does not exist in table %s", indexedColumn, tableName)
+	// Index files are named after the index, so its name is reserved before they are opened
+	if err = table.reserveNewIndex(indexName, indexedColumns); err != nil {
+		return err
 	}
+
+	defer table.releaseNewIndex(indexName)
+
 	// Create new pointer to DataFile for index
 	indexPath := table.Path + utils.SEPARATOR + indexName + ".index.db"
-	indexDataFile, err := files.OpenDataFile(indexPath)
+	indexDataFile, err := openIndexDataFile(indexPath)
 
 	if err != nil {
 		return err
@@ -223,31 +314,181 @@ func (d *Database) CreateIndex(tableName string, indexedColumn string, indexName
 	// Create the index
 	index := Index{
 		Name:      indexName,
-		Column:    indexedColumn,
+		Column:    indexedColumns[0],
+		Columns:   indexedColumns,
+		Unique:    options.Unique,
 		Path:      indexPath,
 		PDataFile: indexDataFile,
 	}
 
-	// Add the index to the table
-	table.Indexes[indexedColumn] = &index
+	// Fill the index with the rows of the table, adding it to the table
+	if options.Online {
+		err = table.buildIndexOnline(&index)
+	} else {
+		err = table.buildIndexOffline(&index)
+	}
+
+	if err != nil {
+		indexDataFile.Close()
+		removeIndexFiles(indexPath)
+		return err
+	}
 
-	// Update the table metadata file
-	json, err := utils.ToJson(table)
+	// Update the table metadata file, the index is found by loadTable only once it's done
+	table.mu.RLock()
+	err = table.writeMetadata()
+	table.mu.RUnlock()
+
+	if err != nil {
+		table.removeNewIndex(&index)
+	}
+
+	return err
+}
+
+/*
+DropTable removes a table and its indexes, once the writers and transactions using it are done. The table is gone as
+soon as the database metadata file is written, its files are removed afterwards. It must not be used by transactions
+anymore
+*/
+func (d *Database) DropTable(tableName string) error {
+	table, err := d.GetTable(tableName)
 
 	if err != nil {
 		return err
 	}
 
-	err = utils.WriteToFile(table.Path+string(os.PathSeparator)+utils.METDATA_FILE, json)
+	table.txMu.Lock()
+	defer table.txMu.Unlock()
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	delete(d.Tables, tableName)
+	delete(d.TablePaths, tableName)
+
+	json, err := utils.ToJson(d)
+
+	if err == nil {
+		err = utils.WriteFileAtomically(d.Path+utils.SEPARATOR+utils.METDATA_FILE, json)
+	}
 
 	if err != nil {
-		return fmt.Errorf("could not write to table metadata file: %v", err)
+		d.Tables[tableName] = table
+		d.TablePaths[tableName] = table.Path
+		return fmt.Errorf("could not write to database metadata file: %v", err)
 	}
 
-	return nil
+	table.close()
+
+	if err := utils.RemoveFolder(table.Path); err != nil {
+		return err
+	}
+
+	// Sequences of auto increment columns are not used by other tables
+	return table.dropAutoIncrementSequences()
 }
 
+/*
+DropIndex removes an index of the table, once the writers and transactions using the table are done. Just like
+DropTable, the index is gone as soon as the table metadata file is written. Indexes still being built can't be dropped
+*/
+func (d *Database) DropIndex(tableName string, indexName string) error {
+	table, err := d.GetTable(tableName)
+
+	if err != nil {
+		return err
+	}
+
+	table.txMu.Lock()
+	defer table.txMu.Unlock()
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	for key, index := range table.Indexes {
+		if index.Name != indexName {
+			continue
+		}
+
+		if !index.isReady() {
+			return fmt.Errorf("index %s of table %s is being built", indexName, tableName)
+		}
+
+		delete(table.Indexes, key)
+
+		if err := table.writeMetadata(); err != nil {
+			table.Indexes[key] = index
+			return err
+		}
+
+		index.PDataFile.Close()
+		return removeIndexFiles(index.Path)
+	}
+
+	return fmt.Errorf("index %s not found in table %s", indexName, tableName)
+}
+
+/*
+DropDatabase closes every file of the database and removes its folder. The database is gone as soon as its folder is
+renamed, so a crash never leaves part of it behind
+*/
+func DropDatabase(d *Database) error {
+	for _, table := range d.Tables {
+		table.mu.Lock()
+		table.close()
+		table.mu.Unlock()
+	}
+
+	if d.txLog != nil {
+		d.txLog.Close()
+		d.txLog = nil
+	}
+
+	d.Tables = make(map[string]*Table)
+	d.TablePaths = make(map[string]string)
+	d.sequences = nil
+
+	return utils.RemoveFolder(d.Path)
+}
+
+// Closes the DataFiles of the table and its indexes
+func (t *Table) close() {
+	t.PDataFile.Close()
+
+	for _, index := range t.Indexes {
+		index.PDataFile.Close()
+	}
+}
+
+/*
+Loads the table stored at path. Tables are always inside their database folder, whose transaction log tells which
+prepared transactions of the table must be recovered
+*/
 func LoadTable(path string) (*Table, error) {
+	txLog, err := files.OpenTxLog(filepath.Dir(path) + utils.SEPARATOR + utils.TX_LOG_FILE)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer txLog.Close()
+
+	sequences, err := loadSequences(filepath.Dir(path))
+
+	if err != nil {
+		return nil, err
+	}
+
+	table, err := loadTable(path, txLog)
+
+	if err != nil {
+		return nil, err
+	}
+
+	table.sequences = sequences
+	return table, nil
+}
+
+func loadTable(path string, txLog *files.TxLog) (*Table, error) {
 	// Read the table metadata
 	tableMetadata, err := utils.ReadFromFile(path + utils.SEPARATOR + utils.METDATA_FILE)
 
@@ -264,11 +505,60 @@ func LoadTable(path string) (*Table, error) {
 	}
 
 	// Initialize the table data file
-	table.PDataFile, err = files.OpenDataFile(path + utils.SEPARATOR + utils.TABLE_FILE)
+	table.PDataFile, err = files.OpenDataFileWithOptions(
+		path+utils.SEPARATOR+utils.TABLE_FILE,
+		files.DataFileOptions{IsCommitted: txLog.IsCommitted},
+	)
 
 	if err != nil {
 		return nil, err
 	}
 
+	// Indexes are changed by the same transactions as the table
+	for _, index := range table.Indexes {
+		index.PDataFile, err = files.OpenDataFileWithOptions(index.Path, files.DataFileOptions{IsCommitted: txLog.IsCommitted})
+
+		if err != nil {
+			return nil, err
+		}
+
+		// Indexes created before indexes of many columns only have their first column
+		if len(index.Columns) == 0 {
+			index.Columns = []string{index.Column}
+		}
+	}
+
+	// Tables created before columns had Ids store their values by position
+	if table.NextColumnId == 0 {
+		table.setColumnIds()
+	}
+
+	table.mu = new(sync.RWMutex)
+	table.txMu = new(sync.RWMutex)
+	table.newIndexes = make(map[string]string)
 	return table, nil
 }
+
+// Gives every column of the table its position as Id
+func (t *Table) setColumnIds() {
+	for i := range t.Columns {
+		t.Columns[i].Id = uint16(i)
+	}
+
+	t.NextColumnId = uint16(len(t.Columns))
+}
+
+// Writes the table metadata file, replacing it at once
+func (t *Table) writeMetadata() error {
+	json, err := utils.ToJson(t)
+
+	if err != nil {
+		return err
+	}
+
+	if err := utils.WriteFileAtomically(t.Path+utils.SEPARATOR+utils.METDATA_FILE, json); err != nil {
+		return fmt.Errorf("could not write to table metadata file: %v", err)
+	}
+
+	return nil
+}
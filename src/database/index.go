@@ -0,0 +1,549 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	btree "github.com/nicolasvancan/monvandb/src/btree"
+	files "github.com/nicolasvancan/monvandb/src/files"
+	utils "github.com/nicolasvancan/monvandb/src/utils"
+)
+
+/*
+Index entries
+
+An index is a DataFile with one entry for each row of the table. The key of an entry is the index key of the row,
+made of the values of the indexed columns encoded one after the other, followed by the key of the row, so that rows
+with the same values have entries of their own, sorted by their keys. The value of an entry is the key of the row,
+which is read from the table DataFile. Since no encoded value is the prefix of another one, the entries of some values
+are the ones starting with them, and an index of many columns finds rows by its first column as well.
+
+Unique indexes
+
+Writers check that no other row has the same index key before writing a row, holding the index until it's written.
+Rows with a null value in any of the indexed columns are never duplicates of each other, as in SQL.
+
+Index creation
+
+An index must hold every row of the table once it's created, including the ones inserted before it. Rows are read
+from a snapshot of the table data file.
+
+Offline, the table can't be used until the index is done, and the rows are bulk loaded into the index at once (see
+btree.BTreeBulkLoad). Online, the index is written by every writer as soon as it's created, while the rows of the
+snapshot are inserted in small batches alongside them. Rows written since the snapshot are skipped, since their
+writers indexed them already. Reads don't use the index until it's done.
+*/
+
+const (
+	INDEX_BACKFILL_BATCH_SIZE = 256 // Rows inserted into an index at once when it's built online
+)
+
+type IndexOptions struct {
+	Online bool // Keeps the table open for reads and writes while the rows already in it are indexed
+	Unique bool // Rejects rows repeating the values of the indexed columns of another row
+}
+
+// ConstraintError is returned by writes that would break a constraint of a table, such as a unique index
+type ConstraintError struct {
+	Table   string
+	Index   string
+	Columns []string
+}
+
+func (e *ConstraintError) Error() string {
+	return fmt.Sprintf("duplicate value of %s violates unique index %s of table %s", strings.Join(e.Columns, ", "), e.Index, e.Table)
+}
+
+// Keys of the rows written while an index is built online, whose rows in the snapshot are out of date
+type indexBuild struct {
+	mu      sync.Mutex
+	touched map[string]bool // Nil once the index is done
+}
+
+// Whether the index holds every row of the table
+func (i *Index) isReady() bool {
+	if i.build == nil {
+		return true
+	}
+
+	i.build.mu.Lock()
+	defer i.build.mu.Unlock()
+
+	return i.build.touched == nil
+}
+
+/*
+Runs a write of the row of the given key to the index. While the index is built online, the build is told that the row
+was written, and it waits until the write is done
+*/
+func (i *Index) write(rowKey []byte, write func() error) error {
+	if i.build == nil {
+		return write()
+	}
+
+	i.build.mu.Lock()
+	defer i.build.mu.Unlock()
+
+	if i.build.touched != nil {
+		i.build.touched[string(rowKey)] = true
+	}
+
+	return write()
+}
+
+// Indexes of a table are found by their columns, joined by commas when there are many
+func getIndexesKey(columns []string) string {
+	return strings.Join(columns, ",")
+}
+
+/*
+Returns the index of a column, as long as it's ready to be read. When the column has no index of its own, an index of
+many columns starting with it is returned, the one with fewer columns
+*/
+func (t *Table) getIndex(column string) *Index {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if index, ok := t.Indexes[column]; ok {
+		if index.isReady() {
+			return index
+		}
+
+		return nil
+	}
+
+	var found *Index
+	for _, index := range t.Indexes {
+		if index.Columns[0] != column || !index.isReady() {
+			continue
+		}
+
+		if found == nil || len(index.Columns) < len(found.Columns) ||
+			(len(index.Columns) == len(found.Columns) && index.Name < found.Name) {
+			found = index
+		}
+	}
+
+	return found
+}
+
+// Returns every index of the table that is ready to be read
+func (t *Table) getIndexes() []*Index {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	indexes := make([]*Index, 0, len(t.Indexes))
+	for _, index := range t.Indexes {
+		if index.isReady() {
+			indexes = append(indexes, index)
+		}
+	}
+
+	return indexes
+}
+
+// Returns the entry in the index of the row with the given key
+func (t *Table) getIndexEntry(index *Index, row RawRow, rowKey []byte) (btree.BTreeKeyValue, error) {
+	key := make([]byte, 0)
+	for _, column := range index.Columns {
+		value, err := t.EncodeKeyForColumn(column, row[column])
+
+		if err != nil {
+			return btree.BTreeKeyValue{}, err
+		}
+
+		key = append(key, value...)
+	}
+
+	return btree.BTreeKeyValue{Key: append(key, rowKey...), Value: rowKey}, nil
+}
+
+// Whether the entry is the one the row has in the index, which it isn't anymore once the indexed values of the row change
+func (t *Table) isIndexEntryOfRow(index *Index, entry btree.BTreeKeyValue, row RawRow) bool {
+	current, err := t.getIndexEntry(index, row, entry.Value)
+	return err == nil && bytes.Equal(current.Key, entry.Key)
+}
+
+// Returns the ready index whose DataFile is the given one, or nil when there is none
+func (t *Table) getIndexOfDataFile(dataFile *files.DataFile) *Index {
+	for _, index := range t.getIndexes() {
+		if index.PDataFile == dataFile {
+			return index
+		}
+	}
+
+	return nil
+}
+
+// Returns the index key of an entry, which is followed by the row key
+func getEntryIndexKey(entry btree.BTreeKeyValue) []byte {
+	return entry.Key[:len(entry.Key)-len(entry.Value)]
+}
+
+// Whether rows with the index key of the entry can't be repeated in the index
+func isUniqueEntry(index *Index, entry btree.BTreeKeyValue) (bool, error) {
+	if !index.Unique {
+		return false, nil
+	}
+
+	values, err := utils.DecodeKey(getEntryIndexKey(entry))
+
+	if err != nil {
+		return false, err
+	}
+
+	for _, value := range values {
+		if value == nil {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (t *Table) newConstraintError(index *Index) *ConstraintError {
+	return &ConstraintError{Table: t.Name, Index: index.Name, Columns: index.Columns}
+}
+
+/*
+Returns a ConstraintError when a unique index has an entry of another row with the index key of the entry. The index
+is read through dataFile, which is the DataFile of the index or the transaction writing to it
+*/
+func (t *Table) checkUniqueEntry(index *Index, entry btree.BTreeKeyValue, dataFile dataFileOperations) (err error) {
+	// Crawling the index may find corrupted pages
+	defer btree.RecoverPageCorrupted(&err)
+
+	if unique, err := isUniqueEntry(index, entry); !unique || err != nil {
+		return err
+	}
+
+	indexKey := getEntryIndexKey(entry)
+	crawler := dataFile.GetIterator(indexKey)
+
+	// The crawler starts at the leaf of the index key, which may have smaller keys
+	for kv := crawler.GetKeyValue(); kv != nil; kv = crawler.GetKeyValue() {
+		if bytes.Compare(kv.Key, indexKey) >= 0 {
+			if !bytes.HasPrefix(kv.Key, indexKey) {
+				break
+			}
+
+			if !bytes.Equal(kv.Value, entry.Value) {
+				return t.newConstraintError(index)
+			}
+		}
+
+		if crawler.Next() != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+/*
+Locks the unique indexes of the table and checks that the row doesn't repeat the index key of another row in any of
+them. The returned function unlocks them, once the row is written, and must be called even when an error is returned.
+Indexes are locked in the order of their names by every writer
+*/
+func (t *Table) lockAndCheckUniqueIndexes(row RawRow, rowKey []byte, tx *Tx) (unlock func(), err error) {
+	indexes := make([]*Index, 0)
+	for _, index := range t.Indexes {
+		if index.Unique {
+			indexes = append(indexes, index)
+		}
+	}
+
+	sort.Slice(indexes, func(a, b int) bool {
+		return indexes[a].Name < indexes[b].Name
+	})
+
+	for _, index := range indexes {
+		index.mu.Lock()
+	}
+
+	unlock = func() {
+		for _, index := range indexes {
+			index.mu.Unlock()
+		}
+	}
+
+	for _, index := range indexes {
+		entry, err := t.getIndexEntry(index, row, rowKey)
+
+		if err != nil {
+			return unlock, err
+		}
+
+		if err := t.checkUniqueEntry(index, entry, tx.getDataFileOperations(index.PDataFile)); err != nil {
+			return unlock, err
+		}
+	}
+
+	return unlock, nil
+}
+
+/*
+Replaces the entries of the old rows in the index by the entry of the new row, all of them with the given row key.
+There may be no old rows or no new one, and nothing is written when the entry stays the same
+*/
+func (t *Table) writeIndexEntries(index *Index, rowKey []byte, oldRows []RawRow, newRow RawRow, tx *Tx) error {
+	oldEntries := make([]btree.BTreeKeyValue, len(oldRows))
+	for i, row := range oldRows {
+		entry, err := t.getIndexEntry(index, row, rowKey)
+
+		if err != nil {
+			return err
+		}
+
+		oldEntries[i] = entry
+	}
+
+	var newEntry *btree.BTreeKeyValue
+	if newRow != nil {
+		entry, err := t.getIndexEntry(index, newRow, rowKey)
+
+		if err != nil {
+			return err
+		}
+
+		newEntry = &entry
+	}
+
+	if newEntry != nil && len(oldEntries) == 1 && bytes.Equal(oldEntries[0].Key, newEntry.Key) {
+		return nil
+	}
+
+	dataFile := tx.getDataFileOperations(index.PDataFile)
+	return index.write(rowKey, func() error {
+		for _, entry := range oldEntries {
+			if err := dataFile.Delete(entry.Key); err != nil {
+				return err
+			}
+		}
+
+		if newEntry == nil {
+			return nil
+		}
+
+		return dataFile.Insert(newEntry.Key, newEntry.Value)
+	})
+}
+
+/*
+Returns the rows pointed by index entries, as key values read from the table tree, in the order of the entries. An
+entry read from a snapshot older than the table tree may point to a row that is gone, which is skipped, or that was
+changed since
+*/
+func resolveIndexEntries(tree *btree.BTree, entries []btree.BTreeKeyValue) []btree.BTreeKeyValue {
+	keyValues := make([]btree.BTreeKeyValue, 0, len(entries))
+	for _, entry := range entries {
+		keyValues = append(keyValues, btree.BTreeGet(tree, entry.Value)...)
+	}
+
+	return keyValues
+}
+
+/*
+Reserves the name and the columns of a new index, returning an error when the table has an index, or another index
+being created, of the same columns or of the same name
+*/
+func (t *Table) reserveNewIndex(indexName string, indexedColumns []string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := getIndexesKey(indexedColumns)
+	for name, newKey := range t.newIndexes {
+		if name == indexName || newKey == key {
+			return fmt.Errorf("index %s of columns %s is being created in table %s", name, newKey, t.Name)
+		}
+	}
+
+	if _, ok := t.Indexes[key]; ok {
+		return fmt.Errorf("columns %s of table %s are already indexed", key, t.Name)
+	}
+
+	for _, index := range t.Indexes {
+		if index.Name == indexName {
+			return fmt.Errorf("index %s already exists in table %s", indexName, t.Name)
+		}
+	}
+
+	t.newIndexes[indexName] = key
+	return nil
+}
+
+func (t *Table) releaseNewIndex(indexName string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.newIndexes, indexName)
+}
+
+/*
+Removes an index built by CreateCompositeIndex whose creation failed afterwards, along with its files, once the
+writers and the transactions using it are done
+*/
+func (t *Table) removeNewIndex(index *Index) {
+	t.txMu.Lock()
+	defer t.txMu.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.Indexes, getIndexesKey(index.Columns))
+	index.PDataFile.Close()
+	removeIndexFiles(index.Path)
+}
+
+// Opens an empty DataFile for an index, removing what was left by an index build that didn't finish
+func openIndexDataFile(path string) (*files.DataFile, error) {
+	if err := removeIndexFiles(path); err != nil {
+		return nil, err
+	}
+
+	return files.OpenDataFile(path)
+}
+
+// Removes the files of the index DataFile at path, if there are any
+func removeIndexFiles(path string) error {
+	for _, file := range []string{path, files.GetWalPath(path)} {
+		if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+Returns the entries of the rows of the table in the index, sorted by their keys. For a unique index, returns a
+ConstraintError when rows repeat an index key
+*/
+func (t *Table) getIndexEntries(tree *btree.BTree, index *Index) (entries []btree.BTreeKeyValue, err error) {
+	// Scanning the data file may find corrupted pages
+	defer btree.RecoverPageCorrupted(&err)
+
+	keyValues, _ := scan(tree)
+	rows := t.FromKeyValueToRawRow(keyValues)
+
+	entries = make([]btree.BTreeKeyValue, len(rows))
+	for i, row := range rows {
+		if entries[i], err = t.getIndexEntry(index, row, keyValues[i].Key); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(entries, func(a, b int) bool {
+		return bytes.Compare(entries[a].Key, entries[b].Key) < 0
+	})
+
+	// Entries of the same index key are next to each other
+	for i := 1; i < len(entries); i++ {
+		if !bytes.Equal(getEntryIndexKey(entries[i-1]), getEntryIndexKey(entries[i])) {
+			continue
+		}
+
+		unique, err := isUniqueEntry(index, entries[i])
+
+		if err != nil {
+			return nil, err
+		}
+
+		if unique {
+			return nil, t.newConstraintError(index)
+		}
+	}
+
+	return entries, nil
+}
+
+// Builds the index while the table waits, holding it exclusively
+func (t *Table) buildIndexOffline(index *Index) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	snapshot := t.PDataFile.Snapshot()
+	defer snapshot.Close()
+
+	entries, err := t.getIndexEntries(snapshot.GetBTree(), index)
+
+	if err != nil {
+		return err
+	}
+
+	if err := index.PDataFile.BulkLoad(entries); err != nil {
+		return err
+	}
+
+	t.Indexes[getIndexesKey(index.Columns)] = index
+	return nil
+}
+
+/*
+Builds the index while the table is used. The index is added to the table before the snapshot is taken, so every
+writer that doesn't see the index wrote its rows before the snapshot. A unique index fails once a row of the snapshot
+repeats the index key of any row indexed already, even one written since
+*/
+func (t *Table) buildIndexOnline(index *Index) error {
+	index.build = &indexBuild{touched: make(map[string]bool)}
+
+	t.mu.Lock()
+	t.Indexes[getIndexesKey(index.Columns)] = index
+	snapshot := t.PDataFile.Snapshot()
+	t.mu.Unlock()
+
+	entries, err := t.getIndexEntries(snapshot.GetBTree(), index)
+	snapshot.Close()
+
+	for start := 0; err == nil && start < len(entries); start += INDEX_BACKFILL_BATCH_SIZE {
+		end := min(start+INDEX_BACKFILL_BATCH_SIZE, len(entries))
+		err = t.backfillIndex(index, entries[start:end])
+	}
+
+	if err != nil {
+		t.mu.Lock()
+		delete(t.Indexes, getIndexesKey(index.Columns))
+		t.mu.Unlock()
+
+		return err
+	}
+
+	index.build.mu.Lock()
+	index.build.touched = nil
+	index.build.mu.Unlock()
+
+	return nil
+}
+
+/*
+Inserts the entries of rows not written since the build started. Writers wait until they are committed, the ones of
+a unique index from the check of their rows
+*/
+func (t *Table) backfillIndex(index *Index, entries []btree.BTreeKeyValue) error {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	index.build.mu.Lock()
+	defer index.build.mu.Unlock()
+
+	return index.PDataFile.Batch(func(fileTx *files.FileTx) error {
+		for _, entry := range entries {
+			if index.build.touched[string(entry.Value)] {
+				continue
+			}
+
+			if err := t.checkUniqueEntry(index, entry, fileTx); err != nil {
+				return err
+			}
+
+			if err := fileTx.Insert(entry.Key, entry.Value); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,284 @@
+package database
+
+import (
+	"context"
+	"slices"
+
+	btree "github.com/nicolasvancan/monvandb/src/btree"
+)
+
+/*
+Row iterators
+
+A RowIterator reads the rows of a range one at a time, crawling the data file as Next is called, so only the row being
+read is kept in memory. Like Range, it reads a snapshot of the data files taken when it's created, which is kept until
+the iterator is closed, therefore iterators must always be closed. Rows read through an index are found in a table
+snapshot taken right after the index one, so rows written in between may not have the values of their entries anymore,
+and are skipped.
+
+	rows := table.Scan(ctx)
+	defer rows.Close()
+
+	for rows.Next() {
+		row := rows.Row()
+	}
+
+	if err := rows.Err(); err != nil {
+	}
+
+Iterators stop once their context is done, returning its error from Err.
+*/
+
+type RowIterator struct {
+	ctx     context.Context
+	table   *Table
+	options RangeOptions
+	filter  func(RawRow) bool // Rows it returns false for are skipped, nil takes every row
+	crawler *btree.BTreeCrawler
+	crawled *btree.BTree // Tree of the data file of the options
+	tree    *btree.BTree // Table tree rows of index entries are read from, nil when crawling the table itself
+	index   *Index       // Index crawled, whose entries are checked against the rows they point to
+	release []func()
+	row     RawRow
+	pending []RawRow // Rows of the current key not returned yet
+	read    int      // Rows returned so far, up to the limit of the options
+	started bool
+	done    bool
+	err     error
+}
+
+/*
+Scan returns an iterator over every row of the table, sorted by their keys. Nothing is read until Next is called
+*/
+func (t *Table) Scan(ctx context.Context) *RowIterator {
+	return t.newRowIterator(ctx, getFullScanRangeOptions(t), nil)
+}
+
+/*
+RangeIter returns an iterator over the rows Range returns for the same arguments, reading them as they are needed
+*/
+func (t *Table) RangeIter(ctx context.Context, input []ColumnComparsion, limit int, order int) *RowIterator {
+	return t.rangeIter(ctx, input, limit, order, nil)
+}
+
+/*
+RangeIterFromOptions returns an iterator over the rows RangeFromOptions returns for the same options, which may read
+the data file of an index
+*/
+func RangeIterFromOptions(ctx context.Context, t *Table, options RangeOptions) *RowIterator {
+	return t.newRowIterator(ctx, options, nil)
+}
+
+func (t *Table) rangeIter(ctx context.Context, input []ColumnComparsion, limit int, order int, tx *Tx) *RowIterator {
+	// The range of keys read is the one of an indexed column, when every row matching the comparisons is in it
+	rangeOperation := getFullScanRangeOptions(t)
+	if isConjunction(input) {
+		rangeOperation = MergeOperationsBasedOnIndexedColumnsAndReturnRangeOptions(t, input)
+	}
+
+	rangeOperation.Limit = limit
+	rangeOperation.Order = order
+	rangeOperation.tx = tx
+
+	// Invert the order of from and to
+	if order == DESC {
+		reverseAscToDesc(&rangeOperation)
+	}
+
+	var filter func(RawRow) bool
+	if len(input) > 0 {
+		filter = newPredicate(t, input).matches
+	}
+
+	return t.newRowIterator(ctx, rangeOperation, filter)
+}
+
+/*
+SortedIter returns an iterator over the rows of the table whose value of the column isn't null, sorted by it, or false
+when the column is neither the leading key column nor indexed. Since the data file crawled is sorted by the column, the
+iterator starts at its first leaf, or at its last one in DESC order, so its first row has the smallest or greatest value
+*/
+func (t *Table) SortedIter(ctx context.Context, column string, order int) (*RowIterator, bool) {
+	options := getFullScanRangeOptions(t)
+	options.Order = order
+
+	if !t.isLeadingKeyColumn(column) {
+		index := t.getIndex(column)
+
+		if index == nil {
+			return nil, false
+		}
+
+		options.PDataFile = index.PDataFile
+	}
+
+	return t.newRowIterator(ctx, options, func(row RawRow) bool { return row[column] != nil }), true
+}
+
+func (t *Table) newRowIterator(ctx context.Context, options RangeOptions, filter func(RawRow) bool) *RowIterator {
+	it := &RowIterator{ctx: ctx, table: t, options: options, filter: filter}
+
+	tree, release := readTree(options.PDataFile, options.tx)
+	it.crawled = tree
+	it.release = append(it.release, release)
+
+	// Entries of indexes are resolved with the table tree
+	if options.PDataFile != t.PDataFile {
+		tableTree, releaseTable := readTree(t.PDataFile, options.tx)
+		it.tree = tableTree
+		it.index = t.getIndexOfDataFile(options.PDataFile)
+		it.release = append(it.release, releaseTable)
+	}
+
+	return it
+}
+
+// Next moves the iterator to the next row, returning false once there are no more rows or an error is found
+func (it *RowIterator) Next() bool {
+	if it.done {
+		return false
+	}
+
+	if err := it.ctx.Err(); err != nil {
+		it.fail(err)
+		return false
+	}
+
+	if it.options.Limit > -1 && it.read >= it.options.Limit {
+		it.Close()
+		return false
+	}
+
+	row, err := it.nextRow()
+
+	if err != nil {
+		it.fail(err)
+		return false
+	}
+
+	if row == nil {
+		it.Close()
+		return false
+	}
+
+	it.row = row
+	it.read++
+	return true
+}
+
+// Row returns the row the iterator is at, after Next returned true
+func (it *RowIterator) Row() RawRow {
+	return it.row
+}
+
+// Err returns the error that stopped the iterator, if any
+func (it *RowIterator) Err() error {
+	return it.err
+}
+
+// Close releases the snapshots read by the iterator. Closing it twice does nothing
+func (it *RowIterator) Close() {
+	it.done = true
+	it.row, it.pending, it.crawler, it.crawled, it.tree = nil, nil, nil, nil, nil
+
+	for _, release := range it.release {
+		release()
+	}
+
+	it.release = nil
+}
+
+func (it *RowIterator) fail(err error) {
+	it.err = err
+	it.Close()
+}
+
+// Returns the next row matching the filter, or nil when the range is over
+func (it *RowIterator) nextRow() (row RawRow, err error) {
+	// Crawling the data file may find corrupted pages
+	defer btree.RecoverPageCorrupted(&err)
+
+	for {
+		for len(it.pending) > 0 {
+			row, it.pending = it.pending[0], it.pending[1:]
+
+			if it.filter == nil || it.filter(row) {
+				return row, nil
+			}
+		}
+
+		keyValue, err := it.nextKeyValue()
+
+		if keyValue == nil || err != nil {
+			return nil, err
+		}
+
+		it.pending = it.readKeyValue(*keyValue)
+
+		// The context is checked between keys as well, since many of them may be skipped by the filter
+		if err := it.ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Returns the next key value of the range, or nil when it's over
+func (it *RowIterator) nextKeyValue() (*btree.BTreeKeyValue, error) {
+	if !it.started {
+		it.started = true
+		it.crawler = getCrawlerBasedOnOptions(it.crawled, it.options)
+
+		if it.crawler == nil {
+			return nil, nil
+		}
+	} else if err := getCrawlerAdvanceFunction(it.crawler, it.options)(); err != nil {
+		if btree.IsPageError(err) {
+			return nil, err
+		}
+
+		// There are no more keys
+		return nil, nil
+	}
+
+	keyValue := it.crawler.GetKeyValue()
+
+	if keyValue == nil {
+		return nil, nil
+	}
+
+	// Case there is a key to be verified, check the compare to see if we reached the desired results
+	if it.options.To != nil {
+		if comp, err := compare(keyValue.Key, it.options.To, it.options.TComparator); comp || err != nil {
+			return nil, err
+		}
+	}
+
+	return keyValue, nil
+}
+
+// Returns the rows of a key value of the data file crawled, which are none when an index entry is out of date
+func (it *RowIterator) readKeyValue(keyValue btree.BTreeKeyValue) []RawRow {
+	if it.tree == nil {
+		return it.table.FromKeyValueToRawRow([]btree.BTreeKeyValue{keyValue})
+	}
+
+	rows := it.table.FromKeyValueToRawRow(resolveIndexEntries(it.tree, []btree.BTreeKeyValue{keyValue}))
+
+	if it.index == nil {
+		return rows
+	}
+
+	return slices.DeleteFunc(rows, func(row RawRow) bool { return !it.table.isIndexEntryOfRow(it.index, keyValue, row) })
+}
+
+// Reads every row left in the iterator, closing it
+func (it *RowIterator) collect() ([]RawRow, error) {
+	defer it.Close()
+
+	rows := make([]RawRow, 0)
+	for it.Next() {
+		rows = append(rows, it.Row())
+	}
+
+	return rows, it.Err()
+}
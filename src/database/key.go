@@ -0,0 +1,160 @@
+package database
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	utils "github.com/nicolasvancan/monvandb/src/utils"
+)
+
+/*
+Keys of tables and indexes are encoded with utils.EncodeKey, so the bTree keeps them sorted the same way as the
+column values. Before encoding, values are converted based on the column type, that way, for instance, an int32
+and an int64 with the same value give the same key for an integer column, and an integer used to compare with a
+float column is encoded as float
+*/
+
+// Converts a value to the type used to encode keys for the given column
+func toKeyValue(column *Column, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	v := reflect.ValueOf(value)
+
+	switch column.Type {
+	case COL_TYPE_INT, COL_TYPE_SMALL_INT, COL_TYPE_BIG_INT:
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return v.Int(), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return v.Uint(), nil
+		}
+	case COL_TYPE_FLOAT, COL_TYPE_DOUBLE:
+		switch v.Kind() {
+		case reflect.Float32, reflect.Float64:
+			return v.Float(), nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return float64(v.Int()), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return float64(v.Uint()), nil
+		}
+	case COL_TYPE_STRING:
+		if v.Kind() == reflect.String {
+			return v.String(), nil
+		}
+	case COL_TYPE_BOOL:
+		if v.Kind() == reflect.Bool {
+			return v.Bool(), nil
+		}
+	case COL_TYPE_TIMESTAMP:
+		if t, ok := value.(time.Time); ok {
+			return t, nil
+		}
+	case COL_TYPE_BLOB:
+		if b, ok := value.([]byte); ok {
+			return b, nil
+		}
+	}
+
+	return nil, fmt.Errorf("value %v of type %T can't be used as key for column %s", value, value, column.Name)
+}
+
+// Encodes the value as a key for the given column
+func encodeColumnKey(column *Column, value interface{}) ([]byte, error) {
+	keyValue, err := toKeyValue(column, value)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.EncodeKey(keyValue)
+}
+
+/*
+Encodes the value as a key for the column with the given name. Values for columns that don't exist are encoded as
+they are
+*/
+func (t *Table) EncodeKeyForColumn(colName string, value interface{}) ([]byte, error) {
+	column := t.GetColumnByName(colName)
+
+	if column == nil {
+		return utils.EncodeKey(value)
+	}
+
+	return encodeColumnKey(column, value)
+}
+
+/*
+Returns the columns whose values make the key of a row in the table DataFile, in the order they are encoded: the
+primary key, followed by the composite key columns when there are any
+*/
+func (t *Table) GetKeyColumns() []Column {
+	columns := make([]Column, 0)
+
+	if t.PrimaryKey != nil {
+		columns = append(columns, *t.PrimaryKey)
+	}
+
+	return append(columns, t.CompositeKey...)
+}
+
+// Returns the key of a row in the table DataFile, with the values of every key column encoded one after the other
+func (t *Table) GetRowKey(row RawRow) ([]byte, error) {
+	columns := t.GetKeyColumns()
+	values := make([]interface{}, len(columns))
+
+	for i := range columns {
+		values[i] = row[columns[i].Name]
+	}
+
+	return t.EncodeKeyPrefix(values...)
+}
+
+/*
+Encodes values for the leading key columns of the table, in order. Since no encoded value is the prefix of another
+one, the result is the prefix shared by the keys of every row that has those values, and the whole key of a row when
+a value is given for each key column
+*/
+func (t *Table) EncodeKeyPrefix(values ...interface{}) ([]byte, error) {
+	columns := t.GetKeyColumns()
+
+	if len(values) > len(columns) {
+		return nil, fmt.Errorf("table %s has %d key columns, got %d values", t.Name, len(columns), len(values))
+	}
+
+	key := make([]byte, 0)
+	for i, value := range values {
+		column, err := encodeColumnKey(&columns[i], value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		key = append(key, column...)
+	}
+
+	return key, nil
+}
+
+// Whether the column is the first one of the key of the table DataFile, so that rows are sorted by it
+func (t *Table) isLeadingKeyColumn(colName string) bool {
+	columns := t.GetKeyColumns()
+	return len(columns) > 0 && columns[0].Name == colName
+}
+
+// Returns the smallest key greater than every key starting with prefix, or nil if there is none
+func keyPrefixEnd(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xFF {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+
+	return nil
+}
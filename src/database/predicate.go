@@ -0,0 +1,312 @@
+package database
+
+import (
+	"bytes"
+	"cmp"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	utils "github.com/nicolasvancan/monvandb/src/utils"
+)
+
+/*
+Predicates
+
+The comparisons of a WHERE clause are given as []ColumnComparsion, arranged in layers. Comparisons with the same Id
+belong to the same layer, and are joined by its LayerLogicalOp: AND, OR, or NOT, which is true when not all of them
+are. A layer is the child of the layer whose Id is its ParentId, or of no other layer when it's -1, and is joined to
+the comparisons of its parent by its ParentLogicalOp, in the order of their Ids (NOT joins it as AND NOT). For
+instance, WHERE a = 1 AND (b = 2 OR c = 3) is made of the layer 0, with a = 1, and its child 1, with b = 2 and c = 3
+joined by OR, which is joined to a = 1 by AND. Layers without comparisons take the value of their first child.
+
+Values are compared as the keys of their column, so numbers of different types are compared by their values, and
+strings given for timestamp columns are read as RFC3339 timestamps. Following SQL, comparisons with null values are
+never true, and LIKE patterns match the whole value, with % matching any text and _ any single character.
+
+Comparisons with columns of other tables can't be evaluated with the rows of one table, so they are left to the joins
+and taken as true.
+*/
+
+type predicate struct {
+	root *predicateLayer
+}
+
+type predicateLayer struct {
+	id          int
+	op          int // Joins the comparisons of the layer
+	parentOp    int // Joins the layer to the comparisons of its parent
+	comparisons []comparsionMatcher
+	children    []*predicateLayer
+}
+
+type comparsionMatcher struct {
+	comparsion ColumnComparsion
+	column     *Column
+	like       *regexp.Regexp // Pattern of LIKE and NLIKE comparisons
+}
+
+// Builds the predicate of the comparisons over rows of the table. Without comparisons, every row matches it
+func newPredicate(t *Table, input []ColumnComparsion) *predicate {
+	layers := make(map[int]*predicateLayer)
+	root := &predicateLayer{id: -1}
+
+	getLayer := func(id int) *predicateLayer {
+		if _, ok := layers[id]; !ok {
+			layers[id] = &predicateLayer{id: id}
+		}
+
+		return layers[id]
+	}
+
+	for _, comparsion := range input {
+		layer := getLayer(comparsion.Id)
+
+		if len(layer.comparisons) == 0 {
+			layer.op, layer.parentOp = comparsion.LayerLogicalOp, comparsion.ParentLogicalOp
+		}
+
+		matcher := comparsionMatcher{comparsion: comparsion, column: t.GetColumnByName(comparsion.ColumnName)}
+
+		if pattern, ok := comparsion.Value.Value.(string); ok && (comparsion.Condition == LIKE || comparsion.Condition == NLIKE) {
+			matcher.like = compileLikePattern(pattern)
+		}
+
+		layer.comparisons = append(layer.comparisons, matcher)
+	}
+
+	// Layers are linked to their parents once every one of them is known
+	parents := make(map[int]int)
+	for _, comparsion := range input {
+		parents[comparsion.Id] = comparsion.ParentId
+	}
+
+	for _, id := range getSortedLayerIds(parents) {
+		parent := root
+
+		if parents[id] != -1 && parents[id] != id {
+			parent = getLayer(parents[id])
+		}
+
+		parent.children = append(parent.children, layers[id])
+	}
+
+	// Parents without comparisons of their own are children of the root
+	for _, id := range getSortedLayerIds(layers) {
+		if _, ok := parents[id]; !ok {
+			root.children = append(root.children, layers[id])
+		}
+	}
+
+	return &predicate{root: root}
+}
+
+// Matcher returns whether rows of the table match the comparsions, the same way Range filters them
+func (t *Table) Matcher(input []ColumnComparsion) func(RawRow) bool {
+	return newPredicate(t, input).matches
+}
+
+func getSortedLayerIds[V any](layers map[int]V) []int {
+	ids := make([]int, 0, len(layers))
+	for id := range layers {
+		ids = append(ids, id)
+	}
+
+	slices.Sort(ids)
+	return ids
+}
+
+func (p *predicate) matches(row RawRow) bool {
+	return p.root.matches(row, make(map[*predicateLayer]bool))
+}
+
+// Whether the row matches the layer. Visited layers are skipped, so that layers that are parents of each other end
+func (l *predicateLayer) matches(row RawRow, visited map[*predicateLayer]bool) bool {
+	visited[l] = true
+	result, started := true, false
+
+	if len(l.comparisons) > 0 {
+		result, started = l.matchesComparisons(row), true
+	}
+
+	for _, child := range l.children {
+		if visited[child] {
+			continue
+		}
+
+		childResult := child.matches(row, visited)
+
+		if !started {
+			result, started = childResult, true
+			continue
+		}
+
+		switch child.parentOp {
+		case OR:
+			result = result || childResult
+		case NOT:
+			result = result && !childResult
+		default:
+			result = result && childResult
+		}
+	}
+
+	return result
+}
+
+func (l *predicateLayer) matchesComparisons(row RawRow) bool {
+	for _, comparsion := range l.comparisons {
+		matches := comparsion.matches(row)
+
+		if l.op == OR && matches {
+			return true
+		}
+
+		if l.op != OR && !matches {
+			return l.op == NOT
+		}
+	}
+
+	return l.op != OR && l.op != NOT
+}
+
+func (c comparsionMatcher) matches(row RawRow) bool {
+	conditionValue := c.comparsion.Value
+
+	if conditionValue.IsOtherTable {
+		return true
+	}
+
+	value, other := row[c.comparsion.ColumnName], conditionValue.Value
+
+	if conditionValue.Transformation != nil {
+		value = conditionValue.Transformation(value, conditionValue.TransformationParams)
+	}
+
+	if conditionValue.IsOtherColumn {
+		other = row[conditionValue.ColumnName]
+	}
+
+	if value == nil || other == nil {
+		return false
+	}
+
+	switch c.comparsion.Condition {
+	case IN, NIN:
+		return c.matchesList(value, other)
+	case LIKE, NLIKE:
+		text, ok := value.(string)
+		return ok && c.like != nil && c.like.MatchString(text) == (c.comparsion.Condition == LIKE)
+	}
+
+	comparsion, ok := compareColumnValues(c.column, value, other)
+
+	if !ok {
+		return false
+	}
+
+	switch c.comparsion.Condition {
+	case EQ:
+		return comparsion == 0
+	case NE:
+		return comparsion != 0
+	case GT:
+		return comparsion > 0
+	case GTE:
+		return comparsion >= 0
+	case LT:
+		return comparsion < 0
+	case LTE:
+		return comparsion <= 0
+	}
+
+	return false
+}
+
+// Whether the value is in the list, for IN, or is not, for NIN
+func (c comparsionMatcher) matchesList(value interface{}, list interface{}) bool {
+	items := reflect.ValueOf(list)
+
+	if items.Kind() != reflect.Slice && items.Kind() != reflect.Array {
+		return false
+	}
+
+	found := false
+	for i := 0; i < items.Len() && !found; i++ {
+		comparsion, ok := compareColumnValues(c.column, value, items.Index(i).Interface())
+		found = ok && comparsion == 0
+	}
+
+	return found == (c.comparsion.Condition == IN)
+}
+
+/*
+Compares two values as keys of the column, returning false when they can't be compared. Numbers that don't fit in the
+column type are compared as floats
+*/
+func compareColumnValues(column *Column, a interface{}, b interface{}) (int, bool) {
+	if column != nil {
+		a, errA := coerceToColumnType("", *column, a)
+		b, errB := coerceToColumnType("", *column, b)
+
+		if errA == nil && errB == nil {
+			keyA, errA := encodeColumnKey(column, a)
+			keyB, errB := encodeColumnKey(column, b)
+
+			if errA == nil && errB == nil {
+				return bytes.Compare(keyA, keyB), true
+			}
+		}
+	}
+
+	if isNumber(reflect.ValueOf(a)) && isNumber(reflect.ValueOf(b)) {
+		floatA, _ := toFloat64(reflect.ValueOf(a))
+		floatB, _ := toFloat64(reflect.ValueOf(b))
+		return cmp.Compare(floatA, floatB), true
+	}
+
+	// Values of other types are only compared with values of the same type
+	keyA, errA := utils.EncodeKey(a)
+	keyB, errB := utils.EncodeKey(b)
+
+	if errA != nil || errB != nil || keyA[0] != keyB[0] {
+		return 0, false
+	}
+
+	return bytes.Compare(keyA, keyB), true
+}
+
+// Compiles a LIKE pattern to a regular expression matching whole values
+func compileLikePattern(pattern string) *regexp.Regexp {
+	var expression strings.Builder
+	expression.WriteString("(?s)^")
+
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			expression.WriteString(".*")
+		case '_':
+			expression.WriteString(".")
+		default:
+			expression.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	expression.WriteString("$")
+	return regexp.MustCompile(expression.String())
+}
+
+/*
+Whether the comparisons are all joined by AND, so that every row matching them is in the range of any one of them.
+Otherwise, rows matching one side of an OR may be out of the range of the other
+*/
+func isConjunction(input []ColumnComparsion) bool {
+	for _, comparsion := range input {
+		if comparsion.LayerLogicalOp != AND || comparsion.ParentLogicalOp != AND {
+			return false
+		}
+	}
+
+	return true
+}
@@ -2,11 +2,11 @@ package database
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 
 	btree "github.com/nicolasvancan/monvandb/src/btree"
 	file "github.com/nicolasvancan/monvandb/src/files"
-	"github.com/nicolasvancan/monvandb/src/utils"
 )
 
 type RangeOptimizerOptions struct {
@@ -31,11 +31,11 @@ func NewRangeOptions() RangeOptions {
 func mergeAnd(r *RangeOptions, other RangeOptions) {
 	// Create a new RangeOptions
 	if (bytes.Compare(other.From, r.From) < 0 && other.From != nil) || r.From == nil {
-		r.From = other.From
+		r.From, r.FComparator = other.From, other.FComparator
 	}
 
 	if (bytes.Compare(other.To, r.To) > 0 && other.To != nil) || r.To == nil {
-		r.To = other.To
+		r.To, r.TComparator = other.To, other.TComparator
 	}
 }
 
@@ -43,20 +43,20 @@ func mergeOr(r *RangeOptions, other RangeOptions) {
 	// Create a new RangeOptions
 	if r.Order == DESC {
 		if bytes.Compare(other.From, r.From) < 0 || r.From == nil {
-			r.From = other.From
+			r.From, r.FComparator = other.From, other.FComparator
 		}
 
 		if bytes.Compare(other.To, r.To) > 0 || r.To == nil {
-			r.To = other.To
+			r.To, r.TComparator = other.To, other.TComparator
 		}
 
 	} else {
 		if bytes.Compare(other.From, r.From) > 0 || r.From == nil {
-			r.From = other.From
+			r.From, r.FComparator = other.From, other.FComparator
 		}
 
 		if bytes.Compare(other.To, r.To) < 0 || r.To == nil {
-			r.To = other.To
+			r.To, r.TComparator = other.To, other.TComparator
 		}
 	}
 }
@@ -107,76 +107,7 @@ The range query is going to be used in the following queries:
 */
 
 func RangeFromOptions(t *Table, options RangeOptions) ([]RawRow, error) {
-	// If from and to are not set, we return all the rows
-	if options.From == nil && options.To == nil && options.Limit < 0 {
-		// Scan the whole file
-		scannedData, err := scan(options.PDataFile)
-		return t.FromKeyValueToRawRow(scannedData), err
-	}
-
-	// Get crawler based on the options
-	crawler := getCrawlerBasedOnOptions(options)
-	// Returns all data gathered from crawling the data file
-	return crawlDataFileBasedOnOptions(t, crawler, options)
-}
-
-/*
-Crawl through the datafile based on the options
-*/
-
-func crawlDataFileBasedOnOptions(t *Table, crawler *btree.BTreeCrawler, options RangeOptions) ([]RawRow, error) {
-	// Pointer to the function that is used to advance the crawler
-	var advance func() error = getCrawlerAdvanceFunction(crawler, options)
-
-	// Loop through the datafile
-	limitIdx := 0
-	// In case there is a limit
-	if options.Limit > -1 {
-		rows := make([]RawRow, 0)
-		var err error = nil
-		for err == nil && limitIdx < options.Limit {
-			// Get the key value
-			kv := crawler.GetKeyValue()
-			// Get the row from the key value
-			rows = append(rows, t.FromKeyValueToRawRow(([]btree.BTreeKeyValue{*kv}))[0])
-
-			// Increment the limit index
-			limitIdx++
-			//Advance
-			err = advance()
-		}
-
-		return rows, nil
-	}
-
-	// Case there is a key to be verified
-	if options.To != nil {
-
-		rows := make([]RawRow, 0)
-		for advance() == nil {
-			// Get the key value
-			kv := crawler.GetKeyValue()
-			// Get the row from the key value
-			rows = append(rows, t.FromKeyValueToRawRow(([]btree.BTreeKeyValue{*kv}))[0])
-
-			// Check the compare to see if we reached the desired results
-			if comp, err := compare(kv.Key, options.To, options.TComparator); comp || err != nil {
-				break
-			}
-		}
-
-		return rows, nil
-	} else {
-		// Where To is nil, we just crawl the data file from begin to end
-		rows := make([]RawRow, 0)
-		for advance() == nil {
-			// Get the key value
-			kv := crawler.GetKeyValue()
-			// Get the row from the key value
-			rows = append(rows, t.FromKeyValueToRawRow(([]btree.BTreeKeyValue{*kv}))[0])
-		}
-		return rows, nil
-	}
+	return t.newRowIterator(context.Background(), options, nil).collect()
 }
 
 func getCrawlerAdvanceFunction(crawler *btree.BTreeCrawler, options RangeOptions) func() error {
@@ -191,34 +122,44 @@ func getCrawlerAdvanceFunction(crawler *btree.BTreeCrawler, options RangeOptions
 }
 
 /*
-Get the crawler based on the options
+Get the crawler based on the options. Returns nil when no key matches the From option
 */
-func getCrawlerBasedOnOptions(options RangeOptions) *btree.BTreeCrawler {
+func getCrawlerBasedOnOptions(tree *btree.BTree, options RangeOptions) *btree.BTreeCrawler {
 	// Variable to return
 	var crawler *btree.BTreeCrawler = nil
 
 	// Change direction based on the order
 	if options.Order == ASC {
-		crawler = btree.GoToFirstLeaf(options.PDataFile.GetBTree())
+		crawler = btree.GoToFirstLeaf(tree)
 	} else {
-		crawler = btree.GoToLastLeaf(options.PDataFile.GetBTree())
+		crawler = btree.GoToLastLeaf(tree)
 	}
 
 	// If from is not null, we find the proper key
 	if options.From != nil {
 		// Find leaf node for the from value
-		crawler = options.PDataFile.GetIterator(options.From)
-		// Set the crawler position to the from value based on From Comparator
+		crawler = tree.FindLeafForCrawling(options.From)
+		// The crawler is at the first key greater or equal to From, it goes on in the order of the range until
+		// a key matches the From Comparator
+		advance := getCrawlerAdvanceFunction(crawler, options)
 		for {
-			comp, err := compare(crawler.GetKeyValue().Key, options.From, options.FComparator)
+			kv := crawler.GetKeyValue()
+			// Empty data file
+			if kv == nil {
+				return nil
+			}
+
+			comp, err := compare(kv.Key, options.From, options.FComparator)
 
 			if err != nil || !comp {
-				crawler.Next()
+				// If the crawler is at the end of the file, there is no key in the range
+				if advance() != nil {
+					return nil
+				}
 				continue
 			}
 
 			break
-			// If the crawler is at the end of the file, break the loop
 		}
 	}
 
@@ -244,9 +185,43 @@ func compare(val1 []byte, val2 []byte, comparator int) (bool, error) {
 
 }
 
-func scan(pDataFile *file.DataFile) ([]btree.BTreeKeyValue, error) {
+/*
+Returns the tree rows are read from. Readers get a snapshot of the last commit, so writers can change the data file
+while they crawl it, but reads done by a transaction must see its own changes in the data files it changed. release
+must be called once the reading is done
+*/
+func readTree(pDataFile *file.DataFile, tx *Tx) (tree *btree.BTree, release func()) {
+	if fileTx := tx.getFileTx(pDataFile); fileTx != nil {
+		return fileTx.GetBTree(), func() {}
+	}
+
+	snapshot := pDataFile.Snapshot()
+	return snapshot.GetBTree(), snapshot.Close
+}
+
+// Returns every key value whose key starts with prefix, in order
+func crawlPrefix(tree *btree.BTree, prefix []byte) []btree.BTreeKeyValue {
+	keyValues := make([]btree.BTreeKeyValue, 0)
+	crawler := getCrawlerBasedOnOptions(tree, RangeOptions{From: prefix, FComparator: GTE, Order: ASC})
+
+	if crawler == nil {
+		return keyValues
+	}
+
+	for kv := crawler.GetKeyValue(); kv != nil && bytes.HasPrefix(kv.Key, prefix); kv = crawler.GetKeyValue() {
+		keyValues = append(keyValues, *kv)
+
+		if crawler.Next() != nil {
+			break
+		}
+	}
+
+	return keyValues
+}
+
+func scan(tree *btree.BTree) ([]btree.BTreeKeyValue, error) {
 	// Create a crawler at the beginning of the file
-	crawler := btree.GoToFirstLeaf(pDataFile.GetBTree())
+	crawler := btree.GoToFirstLeaf(tree)
 	// Loop through the datafile
 	keyValues := make([]btree.BTreeKeyValue, 0)
 
@@ -290,9 +265,9 @@ func getRangeOptionsBasedOnColumnComparsion(table *Table, ops ColumnComparsion)
 	// Check if the column name is indexed
 	if table.isColumnIndexed(ops.ColumnName) {
 		// If the column name is not the primary key
-		if ops.ColumnName != table.PrimaryKey.Name {
+		if !table.isLeadingKeyColumn(ops.ColumnName) {
 			// pointer of DataFile will be passed to rangeOptions pointer
-			index := table.Indexes[ops.ColumnName]
+			index := table.getIndex(ops.ColumnName)
 			rangeOptions.PDataFile = index.PDataFile
 		}
 	}
@@ -301,13 +276,20 @@ func getRangeOptionsBasedOnColumnComparsion(table *Table, ops ColumnComparsion)
 	if ops.Value.Value != nil {
 		// case transformation function is nil
 		if ops.Value.Transformation == nil {
-			valueBytes, _ := utils.Serialize(ops.Value.Value)
+			valueBytes, _ := table.EncodeKeyForColumn(ops.ColumnName, ops.Value.Value)
+
+			// Keys made of many columns, as well as index entries, are greater than their leading value, so the bounds
+			// that must go past every key starting with it are moved to the first key after them
+			valueEnd, endComparator := valueBytes, GT
+			if rangeOptions.PDataFile != table.PDataFile || (table.isLeadingKeyColumn(ops.ColumnName) && len(table.GetKeyColumns()) > 1) {
+				valueEnd, endComparator = keyPrefixEnd(valueBytes), GTE
+			}
 
 			// Greater
 			if ops.Condition == GT {
 				// We copy the value to avoid any change in the original value
-				rangeOptions.From = valueBytes
-				rangeOptions.FComparator = GT
+				rangeOptions.From = valueEnd
+				rangeOptions.FComparator = endComparator
 			} else if ops.Condition == GTE {
 				rangeOptions.From = valueBytes
 				rangeOptions.FComparator = GTE
@@ -315,8 +297,8 @@ func getRangeOptionsBasedOnColumnComparsion(table *Table, ops ColumnComparsion)
 				rangeOptions.To = valueBytes
 				rangeOptions.TComparator = GTE
 			} else if ops.Condition == LTE {
-				rangeOptions.To = valueBytes
-				rangeOptions.TComparator = GT
+				rangeOptions.To = valueEnd
+				rangeOptions.TComparator = endComparator
 			}
 		}
 	}
@@ -325,63 +307,56 @@ func getRangeOptionsBasedOnColumnComparsion(table *Table, ops ColumnComparsion)
 }
 
 /*
-Group operations by indexed columns
+Group operations by indexed columns, returning the columns in the order they are first compared. Comparisons of columns
+that are not indexed can't bound the keys of the table, so they are grouped with the leading key column with no bounds
 */
-func getGroupedOperationsByIndexedColumn(table *Table, ops []ColumnComparsion) map[string][]RangeOptimizerOptions {
+func getGroupedOperationsByIndexedColumn(table *Table, ops []ColumnComparsion) (map[string][]RangeOptimizerOptions, []string) {
 	// Loop through the operations
 	columnsRangeOptions := make(map[string][]RangeOptimizerOptions)
+	columns := make([]string, 0)
 	// group by columns
 	for _, op := range ops {
-		// check if the column is indexed
-		if table.isColumnIndexed(op.ColumnName) {
-			colName := op.ColumnName
-			// Case is the first for column, create an empty slice
-			if _, ok := columnsRangeOptions[colName]; !ok {
-				columnsRangeOptions[colName] = make([]RangeOptimizerOptions, 0)
-			}
-
-			columnsRangeOptions[colName] = append(columnsRangeOptions[colName],
-				RangeOptimizerOptions{
-					RangeOptions: getRangeOptionsBasedOnColumnComparsion(table, op),
-					Comparsion:   op,
-				})
+		colName := op.ColumnName
+		rangeOptions := getRangeOptionsBasedOnColumnComparsion(table, op)
 
-			continue
+		// Bind not indexed columns to the leading key column, which sorts the table data file
+		if !table.isColumnIndexed(op.ColumnName) {
+			colName = table.GetKeyColumns()[0].Name
+			rangeOptions = getFullScanRangeOptions(table)
 		}
 
-		// Bind initial value to pkCol
-		pkColName := table.PrimaryKey.Name
-
-		// Verify if it is composed key table
-		if table.IsComposedKeyTable() {
-			pkColName = table.CompositeKey[0].Name
+		// Case is the first for column, create an empty slice
+		if _, ok := columnsRangeOptions[colName]; !ok {
+			columnsRangeOptions[colName] = make([]RangeOptimizerOptions, 0)
+			columns = append(columns, colName)
 		}
 
-		// Insert everything in the common pk data file
-		if _, ok := columnsRangeOptions[pkColName]; !ok {
-			columnsRangeOptions[pkColName] = make([]RangeOptimizerOptions, 0)
-		}
-
-		columnsRangeOptions[pkColName] = append(columnsRangeOptions[pkColName],
+		columnsRangeOptions[colName] = append(columnsRangeOptions[colName],
 			RangeOptimizerOptions{
-				RangeOptions: getRangeOptionsBasedOnColumnComparsion(table, op),
+				RangeOptions: rangeOptions,
 				Comparsion:   op,
 			})
 	}
 
-	return columnsRangeOptions
+	return columnsRangeOptions, columns
+}
+
+// Range options reading every row of the table
+func getFullScanRangeOptions(table *Table) RangeOptions {
+	rangeOptions := NewRangeOptions()
+	rangeOptions.PDataFile = table.PDataFile
+	return rangeOptions
 }
 
 func MergeOperationsBasedOnIndexedColumnsAndReturnRangeOptions(table *Table, ops []ColumnComparsion) RangeOptions {
 	// Group operations by indexed columns
-	groupedOps := getGroupedOperationsByIndexedColumn(table, ops)
+	groupedOps, columns := getGroupedOperationsByIndexedColumn(table, ops)
 	mergedOps := make([]RangeOptimizerOptions, 0)
-	for _, ops := range groupedOps {
-
+	for _, column := range columns {
+		ops := groupedOps[column]
 		lowestLayerOp := findLowestLayerOp(ops)
 		mergedOps = append(mergedOps, ops[lowestLayerOp])
 	}
-	fmt.Printf("MergedOps len %d\n", len(mergedOps))
 
 	preferedRange := 0
 	if len(mergedOps) > 1 {
@@ -389,8 +364,8 @@ func MergeOperationsBasedOnIndexedColumnsAndReturnRangeOptions(table *Table, ops
 	}
 
 	// Returns a full table scan
-	if preferedRange == -1 {
-		return NewRangeOptions()
+	if preferedRange == -1 || len(mergedOps) == 0 {
+		return getFullScanRangeOptions(table)
 	}
 
 	return mergedOps[preferedRange].RangeOptions
@@ -495,16 +470,20 @@ func countNotNilRangeBoundaries(options []RangeOptions) int {
 	return count
 }
 
+/*
+Comparators of descending ranges. The From Comparator tells the first key taken and the To Comparator the first key
+left out, so when they swap places, a key taken by one must be left out by the other
+*/
 func reverseComparator(comparator int) int {
 	switch comparator {
 	case GTE:
-		return LTE
-	case GT:
 		return LT
+	case GT:
+		return LTE
 	case LT:
-		return GT
-	case LTE:
 		return GTE
+	case LTE:
+		return GT
 	}
 
 	return comparator
@@ -0,0 +1,496 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	utils "github.com/nicolasvancan/monvandb/src/utils"
+)
+
+/*
+Sequences
+
+A sequence gives increasing (or decreasing) integers, never giving the same one twice, even after the database is
+loaded again. Sequences of a database are stored together in its sequences file, which is written once every Cache
+values: the values up to Reserved are given from memory, and whatever was left of them when the database is loaded
+again is skipped. Values are given outside transactions, so rolled back writes leave gaps.
+
+Columns take the next value of a sequence when a row is written without them (see Column.Sequence). Auto increment
+columns of the table key have a sequence of their own, created with the first row written without them, that starts
+after the greatest value stored in the column.
+*/
+
+const (
+	SEQUENCE_CACHE_SIZE = 32 // Values reserved at once by sequences created with no Cache
+)
+
+type Sequence struct {
+	Name      string
+	Start     int64 // First value given
+	Increment int64 // Added to a value to get the next one, never zero
+	Cache     int64 // Values reserved each time the sequences file is written
+	Reserved  int64 // Values up to this one may have been given, so they are not given again after loading
+	Started   bool  // Whether a value was ever reserved
+	last      int64 // Last value given since the sequence was loaded
+	given     bool  // Whether last is set
+}
+
+// Options of new sequences. Zero values give sequences starting at 1 and increasing by 1
+type SequenceOptions struct {
+	Start     int64
+	Increment int64
+	Cache     int64
+}
+
+// Sequences of a database, which are changed one at a time
+type sequences struct {
+	mu   sync.Mutex
+	path string
+	all  map[string]*Sequence
+}
+
+func loadSequences(databasePath string) (*sequences, error) {
+	s := &sequences{
+		path: databasePath + utils.SEPARATOR + utils.SEQUENCES_FILE,
+		all:  make(map[string]*Sequence),
+	}
+
+	if !utils.FileExists(s.path) {
+		return s, nil
+	}
+
+	data, err := utils.ReadFromFile(s.path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := utils.FromJson(data, &s.all); err != nil {
+		return nil, fmt.Errorf("error reading sequences file: %v", err)
+	}
+
+	return s, nil
+}
+
+func (s *sequences) write() error {
+	json, err := utils.ToJson(s.all)
+
+	if err != nil {
+		return err
+	}
+
+	if err := utils.WriteFileAtomically(s.path, json); err != nil {
+		return fmt.Errorf("could not write to sequences file: %v", err)
+	}
+
+	return nil
+}
+
+func (s *sequences) get(name string) (*Sequence, error) {
+	sequence, ok := s.all[name]
+
+	if !ok {
+		return nil, fmt.Errorf("sequence %s does not exist", name)
+	}
+
+	return sequence, nil
+}
+
+func (s *sequences) exists(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.all[name]
+	return ok
+}
+
+func (s *sequences) create(name string, options SequenceOptions) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.createLocked(name, options)
+}
+
+func (s *sequences) createLocked(name string, options SequenceOptions) error {
+	if _, ok := s.all[name]; ok {
+		return fmt.Errorf("sequence %s already exists", name)
+	}
+
+	sequence := &Sequence{Name: name, Start: options.Start, Increment: options.Increment, Cache: options.Cache}
+
+	if sequence.Increment == 0 {
+		sequence.Increment = 1
+	}
+
+	if sequence.Start == 0 {
+		sequence.Start = 1
+	}
+
+	if sequence.Cache <= 0 {
+		sequence.Cache = SEQUENCE_CACHE_SIZE
+	}
+
+	s.all[name] = sequence
+
+	if err := s.write(); err != nil {
+		delete(s.all, name)
+		return err
+	}
+
+	return nil
+}
+
+func (s *sequences) drop(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sequence, err := s.get(name)
+
+	if err != nil {
+		return err
+	}
+
+	delete(s.all, name)
+
+	if err := s.write(); err != nil {
+		s.all[name] = sequence
+		return err
+	}
+
+	return nil
+}
+
+// Gives the sequence another name, keeping the values it gave
+func (s *sequences) rename(name string, newName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sequence, err := s.get(name)
+
+	if err != nil {
+		return err
+	}
+
+	if _, ok := s.all[newName]; ok {
+		return fmt.Errorf("sequence %s already exists", newName)
+	}
+
+	delete(s.all, name)
+	sequence.Name = newName
+	s.all[newName] = sequence
+
+	if err := s.write(); err != nil {
+		delete(s.all, newName)
+		sequence.Name = name
+		s.all[name] = sequence
+		return err
+	}
+
+	return nil
+}
+
+/*
+Returns the next value of the sequence with the given name. When it doesn't exist and start is given, it's created
+first with the value returned by start
+*/
+func (s *sequences) next(name string, start func() (int64, error)) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.all[name]; !ok && start != nil {
+		first, err := start()
+
+		if err != nil {
+			return 0, err
+		}
+
+		if err := s.createLocked(name, SequenceOptions{Start: first}); err != nil {
+			return 0, err
+		}
+	}
+
+	sequence, err := s.get(name)
+
+	if err != nil {
+		return 0, err
+	}
+
+	value, ok := sequence.Start, true
+	if sequence.given {
+		value, ok = addInt64(sequence.last, sequence.Increment)
+	} else if sequence.Started {
+		value, ok = addInt64(sequence.Reserved, sequence.Increment)
+	}
+
+	if !ok {
+		return 0, fmt.Errorf("sequence %s has no values left", name)
+	}
+
+	// Values are reserved before they are given
+	if !sequence.Started || sequence.isAfterReserved(value) {
+		reserved, started := sequence.Reserved, sequence.Started
+		sequence.Reserved, sequence.Started = value, true
+
+		if span, ok := multiplyInt64(sequence.Increment, sequence.Cache-1); ok {
+			if last, ok := addInt64(value, span); ok {
+				sequence.Reserved = last
+			}
+		}
+
+		if err := s.write(); err != nil {
+			sequence.Reserved, sequence.Started = reserved, started
+			return 0, err
+		}
+	}
+
+	sequence.last, sequence.given = value, true
+	return value, nil
+}
+
+func (s *sequences) current(name string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sequence, err := s.get(name)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if !sequence.given {
+		return 0, fmt.Errorf("sequence %s has not given any value since it was loaded", name)
+	}
+
+	return sequence.last, nil
+}
+
+func (s *sequences) set(name string, value int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sequence, err := s.get(name)
+
+	if err != nil {
+		return err
+	}
+
+	reserved, started := sequence.Reserved, sequence.Started
+	sequence.Reserved, sequence.Started = value, true
+
+	if err := s.write(); err != nil {
+		sequence.Reserved, sequence.Started = reserved, started
+		return err
+	}
+
+	sequence.last, sequence.given = value, true
+	return nil
+}
+
+// Whether the value is past the values reserved, in the direction of the sequence
+func (sequence *Sequence) isAfterReserved(value int64) bool {
+	if sequence.Increment > 0 {
+		return value > sequence.Reserved
+	}
+
+	return value < sequence.Reserved
+}
+
+// Adds b to a, returning false when the result doesn't fit in an int64
+func addInt64(a int64, b int64) (int64, bool) {
+	sum := a + b
+	return sum, (b >= 0) == (sum >= a)
+}
+
+// Multiplies a by b, returning false when the result doesn't fit in an int64
+func multiplyInt64(a int64, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+
+	product := a * b
+	return product, product/b == a && !(a == -1 && b == math.MinInt64) && !(b == -1 && a == math.MinInt64)
+}
+
+func (d *Database) getSequences() (*sequences, error) {
+	if d.sequences != nil {
+		return d.sequences, nil
+	}
+
+	s, err := loadSequences(d.Path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	d.sequences = s
+	return s, nil
+}
+
+// CreateSequence creates a sequence in the database, failing when there is another one with the same name
+func (d *Database) CreateSequence(name string, options SequenceOptions) error {
+	s, err := d.getSequences()
+
+	if err != nil {
+		return err
+	}
+
+	return s.create(name, options)
+}
+
+// DropSequence removes a sequence from the database. Sequences used by columns can't be dropped
+func (d *Database) DropSequence(name string) error {
+	s, err := d.getSequences()
+
+	if err != nil {
+		return err
+	}
+
+	for _, table := range d.Tables {
+		for _, column := range table.Columns {
+			if column.Sequence == name {
+				return fmt.Errorf("sequence %s is used by column %s of table %s", name, column.Name, table.Name)
+			}
+		}
+	}
+
+	return s.drop(name)
+}
+
+// NextVal returns the next value of the sequence
+func (d *Database) NextVal(name string) (int64, error) {
+	s, err := d.getSequences()
+
+	if err != nil {
+		return 0, err
+	}
+
+	return s.next(name, nil)
+}
+
+/*
+CurrVal returns the last value given by the sequence, either by NextVal or SetVal. Values given before the database
+was loaded are not known
+*/
+func (d *Database) CurrVal(name string) (int64, error) {
+	s, err := d.getSequences()
+
+	if err != nil {
+		return 0, err
+	}
+
+	return s.current(name)
+}
+
+// SetVal sets the last value given by the sequence, so NextVal continues from it
+func (d *Database) SetVal(name string, value int64) error {
+	s, err := d.getSequences()
+
+	if err != nil {
+		return err
+	}
+
+	return s.set(name, value)
+}
+
+// Name of the sequence of an auto increment column of the table key
+func getAutoIncrementSequenceName(tableName string, columnName string) string {
+	return tableName + "." + columnName
+}
+
+/*
+Returns the value given by the sequence of the column to rows written without it, and false for columns without one.
+The sequence of an auto increment column starts after the greatest value stored in it
+*/
+func (t *Table) nextSequenceValue(column Column) (int64, bool, error) {
+	name := column.Sequence
+	var start func() (int64, error)
+
+	if name == "" && column.AutoIncrement && column.Primary {
+		name = getAutoIncrementSequenceName(t.Name, column.Name)
+		start = t.getAutoIncrementStart(column)
+	}
+
+	if name == "" {
+		return 0, false, nil
+	}
+
+	if t.sequences == nil {
+		return 0, true, fmt.Errorf("table %s was not loaded with its database sequences", t.Name)
+	}
+
+	value, err := t.sequences.next(name, start)
+	return value, true, err
+}
+
+func (t *Table) getAutoIncrementStart(column Column) func() (int64, error) {
+	return func() (int64, error) {
+		rows := []RawRow{t.getLastItem()}
+
+		// Only the last row has the greatest value of the leading key column
+		if !t.isLeadingKeyColumn(column.Name) {
+			var err error
+			if rows, err = t.RangeByKey(nil, nil, -1, ASC); err != nil {
+				return 0, err
+			}
+		}
+
+		greatest := int64(0)
+		for _, row := range rows {
+			if row == nil || row[column.Name] == nil {
+				continue
+			}
+
+			value, err := convertToColumnType(row[column.Name], COL_TYPE_BIG_INT)
+
+			if err != nil {
+				return 0, err
+			}
+
+			greatest = max(greatest, value.(int64))
+		}
+
+		first, ok := addInt64(greatest, 1)
+
+		if !ok {
+			return 0, fmt.Errorf("column %s of table %s has no values left", column.Name, t.Name)
+		}
+
+		return first, nil
+	}
+}
+
+// Drops the sequences of the auto increment columns of the table that were created already
+/*
+Renames the sequence of an auto increment column of the table key along with the column, so that values it gave are not
+given again. Returns false when the column has no such sequence yet
+*/
+func (t *Table) renameAutoIncrementSequence(column Column, newName string) (bool, error) {
+	if column.Sequence != "" || !column.AutoIncrement || !column.Primary || t.sequences == nil {
+		return false, nil
+	}
+
+	name := getAutoIncrementSequenceName(t.Name, column.Name)
+
+	if !t.sequences.exists(name) {
+		return false, nil
+	}
+
+	return true, t.sequences.rename(name, getAutoIncrementSequenceName(t.Name, newName))
+}
+
+func (t *Table) dropAutoIncrementSequences() error {
+	for _, column := range t.Columns {
+		if column.Sequence != "" || !column.AutoIncrement || !column.Primary {
+			continue
+		}
+
+		if name := getAutoIncrementSequenceName(t.Name, column.Name); t.sequences.exists(name) {
+			if err := t.sequences.drop(name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -1,9 +1,11 @@
 package database
 
 import (
+	"bytes"
+	"context"
+	"errors"
+
 	btree "github.com/nicolasvancan/monvandb/src/btree"
-	"github.com/nicolasvancan/monvandb/src/files"
-	utils "github.com/nicolasvancan/monvandb/src/utils"
 )
 
 /*
@@ -58,88 +60,119 @@ the function uses one *PDataFile to access the data.
 
 When running for indexed columns,
 */
-func (t *Table) Get(column string, value any) ([]RawRow, error) {
+func (t *Table) Get(column string, value any) (rows []RawRow, err error) {
+	return t.get(column, value, nil)
+}
+
+// Same as Get, reading the data files as changed by the transaction when tx is given
+func (t *Table) get(column string, value any, tx *Tx) (rows []RawRow, err error) {
+	// Rows are sorted by the leading key column, so they are found with the key of the data file
+	if t.isLeadingKeyColumn(column) {
+		return t.getByKey([]any{value}, tx)
+	}
+
+	// Scanning the data file may find corrupted pages
+	defer btree.RecoverPageCorrupted(&err)
 
 	row := make([]RawRow, 0)
-	// Get the pk column
-	pk := t.PrimaryKey
-	serializedValue, err := utils.Serialize(value)
+	serializedValue, err := t.EncodeKeyForColumn(column, value)
 
 	if err != nil {
 		return nil, err
 	}
 
-	// If the column is the primary key, we can use the datafile to get the data
-	if pk.Name == column {
-		// Serialize the value
+	// Only rows whose value encodes the same way are kept, rows found through the index may have changed since
+	matches := func(foundRow RawRow) bool {
+		foundValue, err := t.EncodeKeyForColumn(column, foundRow[column])
+		return err == nil && bytes.Equal(foundValue, serializedValue)
+	}
+
+	index := t.getIndex(column)
 
-		row = append(row, t.FromKeyValueToRawRow(t.PDataFile.Get(serializedValue))...)
-		return row, nil
+	// Worst case, there must be a scan through the datafile, reading one row at a time
+	if index == nil {
+		options := getFullScanRangeOptions(t)
+		options.tx = tx
+		return t.newRowIterator(context.Background(), options, matches).collect()
 	}
 
-	// We try to find indexed DataFiles
-	index, ok := t.Indexes[column]
-	if ok {
-		// Get the index DataFile
-		indexDataFile := index.PDataFile
-		// Get the value from the index
-		indexValue := indexDataFile.Get(serializedValue)
-		row = append(row, t.FromKeyValueToRawRow(indexValue)...)
-	} else {
-		// Worst case, there must be a scan through the datafile
-		// Create a crawler at the beginning of the file
-		crawler := btree.GoToFirstLeaf(t.PDataFile.GetBTree())
-		// Loop through the datafile
-		keyValues := make([]btree.BTreeKeyValue, 0)
-
-		for {
-			kv := crawler.GetKeyValue()
-			keyValues = append(keyValues, *kv)
-
-			// If the crawler is at the end of the file, break the loop
-			if err = crawler.Next(); err != nil {
-				break
-			}
+	tree, release := readTree(t.PDataFile, tx)
+	defer release()
+
+	// Get the entries of the value from the index, and their rows from the table
+	indexTree, releaseIndex := readTree(index.PDataFile, tx)
+	defer releaseIndex()
+
+	keyValues := resolveIndexEntries(tree, crawlPrefix(indexTree, serializedValue))
+	for _, foundRow := range t.FromKeyValueToRawRow(keyValues) {
+		if matches(foundRow) {
+			row = append(row, foundRow)
 		}
-		row = append(row, t.FromKeyValueToRawRow(keyValues)...)
 	}
 
 	return row, nil
 }
 
+/*
+GetByKey returns the rows whose leading key columns are equal to values, sorted by their keys. For a table whose key
+is made of more than one column, a prefix of them finds every row starting with it, while a value for each key column
+finds one row at most
+*/
+func (t *Table) GetByKey(values ...any) ([]RawRow, error) {
+	return t.getByKey(values, nil)
+}
+
+func (t *Table) getByKey(values []any, tx *Tx) (rows []RawRow, err error) {
+	// Crawling the data file may find corrupted pages
+	defer btree.RecoverPageCorrupted(&err)
+
+	prefix, err := t.EncodeKeyPrefix(values...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tree, release := readTree(t.PDataFile, tx)
+	defer release()
+
+	if len(values) == len(t.GetKeyColumns()) {
+		return t.FromKeyValueToRawRow(btree.BTreeGet(tree, prefix)), nil
+	}
+
+	return t.FromKeyValueToRawRow(crawlPrefix(tree, prefix)), nil
+}
+
 /*
 Insert a row into the table for given []RawRow.
 */
 
 func (t *Table) Insert(rows []RawRow) (int, error) {
+	// Writers wait for the transactions changing the table
+	t.txMu.RLock()
+	defer t.txMu.RUnlock()
+
+	return t.insert(rows, nil)
+}
+
+// Same as Insert, writing the rows as part of the transaction when tx is given
+func (t *Table) insert(rows []RawRow, tx *Tx) (int, error) {
 	// First step - Validate rows
 
-	validatedRows, err := t.ValidateRawRows(rows)
+	validatedRows, err := t.validateRawRows(rows, tx)
 
 	// Returns case there is an error on rows validation process
 	if err != nil {
 		return 0, err
 	}
 
+	// Indexes can't be created while rows are written
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
 	// We have to insert it into the base table and also into the indexes
 	for i, row := range validatedRows {
-		// Insert the row into the base table
-		serializedRow := t.FromRawRowToKeyValue(row)
-		t.PDataFile.Insert(serializedRow.Key, serializedRow.Value)
-		// If there is indexed tables, we have to insert the row into the indexed tables
-		for _, index := range t.Indexes {
-			// Get the index DataFile
-			indexDataFile := index.PDataFile
-			// get the column name to be serialized
-			indexKey := row[index.Column]
-			// Serialize the value
-			serializedIndexKey, err := utils.Serialize(indexKey)
-
-			if err != nil {
-				return i, err
-			}
-			// Insert the row into the index table
-			indexDataFile.Insert(serializedIndexKey, serializedRow.Value)
+		if err := t.writeRow(row, nil, tx); err != nil {
+			return i, err
 		}
 	}
 
@@ -147,41 +180,120 @@ func (t *Table) Insert(rows []RawRow) (int, error) {
 }
 
 /*
-To update rows we use the principle of deleting and reinserting it.
+Update replaces the stored rows with the same keys by the given ones, inserting the rows that aren't stored. Indexes
+point to the rows by their keys, so only the ones whose columns changed are written
 */
 
 func (t *Table) Update(rows []RawRow) (int, error) {
-	// Delete rows
-	_, err := t.Delete(rows)
+	// Writers wait for the transactions changing the table
+	t.txMu.RLock()
+	defer t.txMu.RUnlock()
+
+	return t.update(rows, nil)
+}
+
+// Same as Update, writing the rows as part of the transaction when tx is given
+func (t *Table) update(rows []RawRow, tx *Tx) (int, error) {
+	// Indexes can't be created while rows are written
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for i, row := range rows {
+		// The stored row is replaced, so it's not looked for
+		if err := t.validateColumnValues(&row); err != nil {
+			return i, err
+		}
+
+		rowKey, err := t.GetRowKey(row)
+
+		if err != nil {
+			return i, err
+		}
+
+		if err := t.writeRow(row, rowKey, tx); err != nil {
+			return i, err
+		}
+	}
+
+	return len(rows), nil
+}
+
+/*
+Writes a validated row to the table and its indexes, replacing the stored row with the given key, if any. Nothing is
+written when the row repeats the values of a unique index. The row is replaced in one single operation, so it's never
+missing from the table, and outside a transaction a failure writing the indexes writes the stored row back, along with
+the entries already changed
+*/
+func (t *Table) writeRow(row RawRow, replacedKey []byte, tx *Tx) error {
+	serializedRow := t.FromRawRowToKeyValue(row)
+	unlock, err := t.lockAndCheckUniqueIndexes(row, serializedRow.Key, tx)
+	defer unlock()
 
 	if err != nil {
-		return 0, err
+		return err
 	}
-	// Insert rows
-	i, err := t.Insert(rows)
-	return i, err
+
+	dataFile := tx.getDataFileOperations(t.PDataFile)
+	stored := make([]btree.BTreeKeyValue, 0)
+	if replacedKey != nil {
+		if stored, err = dataFile.Get(replacedKey); err != nil {
+			return err
+		}
+	}
+
+	if err := putRow(dataFile, serializedRow, stored); err != nil {
+		return err
+	}
+
+	// If there is indexed tables, we have to write the row into the indexed tables
+	storedRows := t.FromKeyValueToRawRow(stored)
+	written := make([]*Index, 0, len(t.Indexes))
+
+	for _, index := range t.Indexes {
+		if err := t.writeIndexEntries(index, serializedRow.Key, storedRows, row, tx); err != nil {
+			// A transaction is rolled back as a whole
+			if tx == nil {
+				err = errors.Join(err, t.undoRowWrite(serializedRow, stored, row, written))
+			}
+
+			return err
+		}
+
+		written = append(written, index)
+	}
+
+	return nil
 }
 
-func reinsertKeys(
-	t *Table,
-	indexDataFile *files.DataFile,
-	existingKeys []RawRow,
-	row RawRow,
-	serializedIndexKey []byte,
-) {
-	musReinsert := make([]RawRow, 0)
-	for _, iRow := range existingKeys {
-		indexDataFile.Delete(serializedIndexKey)
-		if iRow[t.PrimaryKey.Name] != row[t.PrimaryKey.Name] {
-			musReinsert = append(musReinsert, iRow)
+// Writes the row, replacing the stored one if there is any
+func putRow(dataFile dataFileOperations, row btree.BTreeKeyValue, stored []btree.BTreeKeyValue) error {
+	if len(stored) == 0 {
+		return dataFile.Insert(row.Key, row.Value)
+	}
+
+	return dataFile.Update(row.Key, row.Value)
+}
+
+// Writes the stored row back in place of the written one, along with the entries of the indexes already written
+func (t *Table) undoRowWrite(written btree.BTreeKeyValue, stored []btree.BTreeKeyValue, row RawRow, indexes []*Index) error {
+	storedRows := t.FromKeyValueToRawRow(stored)
+
+	for _, index := range indexes {
+		var storedRow RawRow
+		if len(storedRows) > 0 {
+			storedRow = storedRows[0]
+		}
+
+		if err := t.writeIndexEntries(index, written.Key, []RawRow{row}, storedRow, nil); err != nil {
+			return err
 		}
 	}
 
-	// Reinsert
-	for _, iRow := range musReinsert {
-		serializedIndexRow := t.FromRawRowToKeyValue(iRow)
-		indexDataFile.Insert(serializedIndexKey, serializedIndexRow.Value)
+	if len(stored) == 0 {
+		return t.PDataFile.Delete(written.Key)
 	}
+
+	return t.PDataFile.Update(stored[0].Key, stored[0].Value)
 }
 
 /*
@@ -192,41 +304,63 @@ return the keys of the rows to be deleted. Therefore, the input for this method
 */
 
 func (t *Table) Delete(rows []RawRow) (int, error) {
+	// Writers wait for the transactions changing the table
+	t.txMu.RLock()
+	defer t.txMu.RUnlock()
+
+	return t.delete(rows, nil)
+}
+
+// Same as Delete, deleting the rows as part of the transaction when tx is given
+func (t *Table) delete(rows []RawRow, tx *Tx) (int, error) {
+	// Indexes can't be created while rows are deleted
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
 	// Iterate over the input
 	for _, row := range rows {
-		// Delete the row from the base table
-		serializedRow := t.FromRawRowToKeyValue(row)
+		rowKey, err := t.GetRowKey(row)
+
+		if err != nil {
+			return 0, err
+		}
+
+		storedRows, err := t.deleteRow(rowKey, tx)
+
+		if err != nil {
+			return 0, err
+		}
 
-		t.PDataFile.Delete(serializedRow.Key)
 		// If there is indexed tables, we have to delete the row from the indexed tables
 		for _, index := range t.Indexes {
-			// Get the index DataFile
-			indexDataFile := index.PDataFile
-			// Delete the row from the index table
-			indexColumn := row[index.Column]
-			serializedIndexKey, err := utils.Serialize(indexColumn)
-
-			if err != nil {
+			if err := t.writeIndexEntries(index, rowKey, storedRows, nil, tx); err != nil {
 				return 0, err
 			}
-			// Get the keys from the index
-			existingKeys := indexDataFile.Get(serializedIndexKey)
-
-			// Indicates that there is more than one key for the same value
-			// All keys must be deleted and the keys that are different from
-			// the one that is being deleted must be inserted again
-			if len(existingKeys) > 1 {
-				deserializedExistingKeys := t.FromKeyValueToRawRow(existingKeys)
-				reinsertKeys(t, indexDataFile, deserializedExistingKeys, row, serializedIndexKey)
-			} else {
-				indexDataFile.Delete(serializedIndexKey)
-			}
 		}
-
 	}
+
 	return len(rows), nil
 }
 
+/*
+Deletes the row with the given key from the table DataFile, returning it as it was stored. Its entries in the indexes
+are found with the stored values
+*/
+func (t *Table) deleteRow(rowKey []byte, tx *Tx) ([]RawRow, error) {
+	dataFile := tx.getDataFileOperations(t.PDataFile)
+	stored, err := dataFile.Get(rowKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := dataFile.Delete(rowKey); err != nil {
+		return nil, err
+	}
+
+	return t.FromKeyValueToRawRow(stored), nil
+}
+
 /*
 Range:
 
@@ -236,8 +370,8 @@ clauses. For instance, let's say a query statement comes as written below:
 
 SELECT * FROM table WHERE notIndexedColumn = value and indexedColumn > 10 ORDER BY column LIMIT 10
 
-In this case, the query parser (that is not implemented yet) will parse the query and return a QueryOptions struct
-which can be used to execute the range for table or indexes
+In this case, the query parser (see the parser package) parses the query, and its WHERE clause is lowered to the
+[]ColumnComparsion given to the range of the table or indexes
 
 	It receives an array of column operations related to the table. All columns operation in the array are not
 
@@ -319,26 +453,63 @@ For the second table (t2):
 When the inputs field for table is given to the Range query, it will evaluate and fetch only
 necessary columns, in this case, it will create a RangeOptions struct optimized for the demand
 and return the result.
+
+Rows read from the range are then filtered by every comparison of the input (see newPredicate), so only the ones
+matching the whole WHERE clause are returned, up to the limit. Returns an *btree.ErrPageCorrupted or an
+*btree.ErrPageRead when a page of the range can't be read.
+*/
+func (t *Table) Range(input []ColumnComparsion, limit int, order int) ([]RawRow, error) {
+	return t.rangeRows(input, limit, order, nil)
+}
+
+// Same as Range, reading the data files as changed by the transaction when tx is given
+func (t *Table) rangeRows(input []ColumnComparsion, limit int, order int, tx *Tx) ([]RawRow, error) {
+	rows, err := t.rangeIter(context.Background(), input, limit, order, tx).collect()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+/*
+RangeByKey returns the rows whose key is between from and to, both included, comparing only the leading key columns
+given by each one of them. For a table whose key is (a, b), from [1] and to [3] give every row with a between 1 and
+3, while from [1, 2] and to [1, 5] give the rows with a equal to 1 and b between 2 and 5. Empty bounds are open
 */
-func (t *Table) Range(input []ColumnComparsion, limit int, order int) []RawRow {
-	// create temporary variable for holding values of RangeOptions
-	rangeOperation := MergeOperationsBasedOnIndexedColumnsAndReturnRangeOptions(t, input)
+func (t *Table) RangeByKey(from []any, to []any, limit int, order int) ([]RawRow, error) {
+	rangeOperation := NewRangeOptions()
+	rangeOperation.PDataFile = t.PDataFile
 	rangeOperation.Limit = limit
 	rangeOperation.Order = order
 
-	// Invert the order of from and to
-	if order == DESC {
-		reverseAscToDesc(&rangeOperation)
+	if len(from) > 0 {
+		key, err := t.EncodeKeyPrefix(from...)
+
+		if err != nil {
+			return nil, err
+		}
+
+		rangeOperation.From = key
 	}
 
-	// Get the range
-	rows, err := RangeFromOptions(t, rangeOperation)
+	// The range stops before the first key greater than every key starting with to
+	if len(to) > 0 {
+		key, err := t.EncodeKeyPrefix(to...)
 
-	if err != nil {
-		return nil
+		if err != nil {
+			return nil, err
+		}
+
+		rangeOperation.To = keyPrefixEnd(key)
+	}
+
+	if order == DESC {
+		reverseAscToDesc(&rangeOperation)
 	}
 
-	return rows
+	return RangeFromOptions(t, rangeOperation)
 }
 
 func (t *Table) getLastItem() RawRow {
@@ -362,23 +533,18 @@ func (t *Table) GetColumnByName(colName string) *Column {
 	return nil
 }
 
-func (t *Table) isColumnIndexed(colName string) bool {
+// IsColumnIndexed returns whether rows are found by the values of the column, either by the key of the table or an index
+func (t *Table) IsColumnIndexed(colName string) bool {
+	return t.isColumnIndexed(colName)
+}
 
-	if t.IsComposedKeyTable() {
-		return false
-	}
+func (t *Table) isColumnIndexed(colName string) bool {
 
-	if t.PrimaryKey.Name == colName {
+	if t.isLeadingKeyColumn(colName) {
 		return true
 	}
 
-	for _, index := range t.Indexes {
-		if index.Column == colName {
-			return true
-		}
-	}
-
-	return false
+	return t.getIndex(colName) != nil
 }
 
 func (t *Table) IsComposedKeyTable() bool {
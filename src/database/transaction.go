@@ -0,0 +1,269 @@
+package database
+
+import (
+	"errors"
+	"slices"
+
+	btree "github.com/nicolasvancan/monvandb/src/btree"
+	files "github.com/nicolasvancan/monvandb/src/files"
+)
+
+/*
+Transactions
+
+Every row written to a table is also written to each one of its indexes, and all of them are different DataFiles. A
+Tx groups every change done to those DataFiles, from any number of tables, so that either all of them are committed
+or none of them.
+
+A Tx owns the tables it changes until it ends, so writes done to them outside it wait, and its changes are made
+through the handles of the transactions it started in their DataFiles (see files.FileTx). A Tx is used by one
+goroutine at a time, and Txs that change the same tables in different orders may wait for each other forever.
+
+Changes are kept in memory by each DataFile until Commit. When only one DataFile was changed, it's committed as usual,
+otherwise the transaction is committed in two phases through the TxLog of the database (see files.TxLog), so that a
+crash in the middle of the commit can't leave some of the DataFiles changed and others not.
+
+Reads done through the transaction see its changes, unlike reads done through the tables, which read snapshots of the
+last commit. Any write that fails rolls the whole transaction back, and the transaction can't be used anymore after
+that.
+*/
+
+var ErrTxDone = errors.New("transaction has already been committed or rolled back")
+
+type Tx struct {
+	database     *Database
+	txLog        *files.TxLog
+	tables       []*Table        // Tables owned by the transaction
+	participants []*files.FileTx // Transactions of the DataFiles changed by the transaction
+	done         bool
+}
+
+// The operations tables do on their DataFiles, either on the DataFile itself or through the transaction of a Tx
+type dataFileOperations interface {
+	Get(key []byte) ([]btree.BTreeKeyValue, error)
+	Insert(key []byte, value []byte) error
+	Update(key []byte, value []byte) error
+	Delete(key []byte) error
+	GetIterator(key []byte) *btree.BTreeCrawler
+}
+
+// Begin starts a new transaction for the database tables
+func (d *Database) Begin() (*Tx, error) {
+	txLog, err := d.getTxLog()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tx{
+		database:     d,
+		txLog:        txLog,
+		tables:       make([]*Table, 0),
+		participants: make([]*files.FileTx, 0),
+	}, nil
+}
+
+func (tx *Tx) Insert(tableName string, rows []RawRow) (int, error) {
+	table, err := tx.join(tableName)
+
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := table.insert(rows, tx)
+	return n, tx.rollbackOnError(err)
+}
+
+func (tx *Tx) Update(tableName string, rows []RawRow) (int, error) {
+	table, err := tx.join(tableName)
+
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := table.update(rows, tx)
+	return n, tx.rollbackOnError(err)
+}
+
+func (tx *Tx) Delete(tableName string, rows []RawRow) (int, error) {
+	table, err := tx.join(tableName)
+
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := table.delete(rows, tx)
+	return n, tx.rollbackOnError(err)
+}
+
+func (tx *Tx) Get(tableName string, column string, value any) ([]RawRow, error) {
+	table, err := tx.getTable(tableName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return table.get(column, value, tx)
+}
+
+func (tx *Tx) Range(tableName string, input []ColumnComparsion, limit int, order int) ([]RawRow, error) {
+	table, err := tx.getTable(tableName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return table.rangeRows(input, limit, order, tx)
+}
+
+/*
+Commits every change of the transaction. When it returns an error, nothing was committed, unless the error comes
+from the DataFiles after the TxLog committed the transaction, in which case it will be recovered as committed
+*/
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+
+	tx.done = true
+	defer tx.releaseTables()
+
+	switch len(tx.participants) {
+	case 0:
+		return nil
+	case 1:
+		return tx.participants[0].Commit()
+	}
+
+	// First phase, every DataFile writes its changes to its log
+	txId := tx.txLog.Next()
+	for _, fileTx := range tx.participants {
+		if err := fileTx.Prepare(txId); err != nil {
+			tx.rollbackParticipants()
+			return err
+		}
+	}
+
+	// The transaction is committed once the TxLog says so
+	if err := tx.txLog.Commit(txId); err != nil {
+		tx.rollbackParticipants()
+		return err
+	}
+
+	// Second phase, changes are made visible
+	var commitErr error
+	for _, fileTx := range tx.participants {
+		if err := fileTx.CommitPrepared(); err != nil && commitErr == nil {
+			commitErr = err
+		}
+	}
+
+	return commitErr
+}
+
+// Discards every change of the transaction
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return ErrTxDone
+	}
+
+	tx.done = true
+	tx.rollbackParticipants()
+	tx.releaseTables()
+	return nil
+}
+
+func (tx *Tx) rollbackParticipants() {
+	for _, fileTx := range tx.participants {
+		fileTx.Rollback()
+	}
+}
+
+// Lets writers outside transactions change the tables owned by the transaction again
+func (tx *Tx) releaseTables() {
+	for _, table := range tx.tables {
+		table.txMu.Unlock()
+	}
+
+	tx.tables = nil
+}
+
+func (tx *Tx) rollbackOnError(err error) error {
+	if err != nil && !tx.done {
+		tx.Rollback()
+	}
+
+	return err
+}
+
+func (tx *Tx) getTable(tableName string) (*Table, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+
+	return tx.database.GetTable(tableName)
+}
+
+/*
+Returns the table, owning it and starting a transaction in its DataFile and in the DataFiles of its indexes. It waits
+until the writers and the transactions changing the table are done
+*/
+func (tx *Tx) join(tableName string) (*Table, error) {
+	table, err := tx.getTable(tableName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !slices.Contains(tx.tables, table) {
+		table.txMu.Lock()
+		tx.tables = append(tx.tables, table)
+	}
+
+	dataFiles := []*files.DataFile{table.PDataFile}
+	// Indexes being built are written outside the transaction, see CreateIndexWithOptions
+	for _, index := range table.getIndexes() {
+		dataFiles = append(dataFiles, index.PDataFile)
+	}
+
+	for _, dataFile := range dataFiles {
+		if tx.getFileTx(dataFile) != nil {
+			continue
+		}
+
+		fileTx, err := dataFile.Begin()
+
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		tx.participants = append(tx.participants, fileTx)
+	}
+
+	return table, nil
+}
+
+// Returns the transaction started by tx in the DataFile, or nil if there is none, even when tx is nil
+func (tx *Tx) getFileTx(dataFile *files.DataFile) *files.FileTx {
+	if tx == nil {
+		return nil
+	}
+
+	for _, fileTx := range tx.participants {
+		if fileTx.GetDataFile() == dataFile {
+			return fileTx
+		}
+	}
+
+	return nil
+}
+
+// Returns the operations on the DataFile, which are done through the transaction of tx in it, if there is one
+func (tx *Tx) getDataFileOperations(dataFile *files.DataFile) dataFileOperations {
+	if fileTx := tx.getFileTx(dataFile); fileTx != nil {
+		return fileTx
+	}
+
+	return dataFile
+}
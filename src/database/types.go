@@ -1,6 +1,8 @@
 package database
 
 import (
+	"sync"
+
 	files "github.com/nicolasvancan/monvandb/src/files"
 )
 
@@ -22,6 +24,8 @@ type Database struct {
 	Tables     map[string]*Table // reference to Tables
 	TablePaths map[string]string // Paths to the tables
 	Path       string            // Path to the database dir
+	txLog      *files.TxLog      // Coordinator of transactions changing many DataFiles, opened when needed
+	sequences  *sequences        // Sequences of the database, loaded when needed
 }
 
 type Table struct {
@@ -31,7 +35,12 @@ type Table struct {
 	PrimaryKey   *Column           // reference to PrimaryKey
 	CompositeKey []Column          // Case column is composite
 	Indexes      map[string]*Index // reference to Indexes
+	NextColumnId uint16            // Id of the next column added to the table
 	PDataFile    *files.DataFile   // private Access btree (Simple)
+	mu           *sync.RWMutex     // Held by writers for a whole operation, and exclusively to change Indexes
+	txMu         *sync.RWMutex     // Held by transactions changing the table, so writers outside them wait
+	newIndexes   map[string]string // Columns of the indexes being created, by their names, guarded by mu
+	sequences    *sequences        // Sequences of the database giving values to columns
 }
 
 type RawRow = map[string]interface{}
@@ -69,21 +78,26 @@ type RangeOptions struct {
 	Order       int             // Order of the range wheter is ASC os DESC
 	Limit       int             // Limit of the range
 	PDataFile   *files.DataFile // Pointer to the data file to be used
+	tx          *Tx             // Reads the data files as changed by the transaction instead of snapshots, when set
 }
 
 type Index struct {
 	Name      string
-	Column    string
+	Column    string   // First indexed column
+	Columns   []string // Indexed columns, in the order their values are encoded in the keys of the index
+	Unique    bool     // Rows can't repeat the values of the indexed columns, unless one of them is null
 	Path      string
 	PDataFile *files.DataFile
+	mu        sync.Mutex  // Held by writers of a unique index from the check of a row until it's written
+	build     *indexBuild // Rows written while the index is built online, nil when it was built offline
 }
 
 type ColumnValue struct {
 	Value interface{} // Value of the column
 	Col   uint16      // Refers to the respective column of a table for example:
 	// Table X has column Y and column Z, which are stored in a Table struct as an array of Columns struct
-	// Each position of this array represents a column in the table. Whenever a column is serialized,
-	// the position of the column is stored in the Col field
+	// Each column has an Id, which is kept when other columns are added or dropped. Whenever a column is serialized,
+	// the Id of the column is stored in the Col field
 }
 
 // Alias for []SerializedColumnValue
@@ -96,6 +110,9 @@ type Column struct {
 	Nullable      bool
 	AutoIncrement bool
 	Primary       bool
+	Sequence      string   // Sequence of the database giving the value of the column to rows written without it
+	Id            uint16   // Identifies the values of the column in stored rows, never used again by another column
+	OldIds        []uint16 // Ids the column had before its type was changed, whose values are converted when read
 }
 
 /*
@@ -2,8 +2,6 @@ package database
 
 import (
 	"fmt"
-
-	"github.com/nicolasvancan/monvandb/src/utils"
 )
 
 /*
@@ -14,6 +12,18 @@ This might turn the insert process slower, but it is necessary to keep the data
 
 */
 
+// ColumnTypeError is returned when a value written to a column is not of its type, nor can be converted to it
+type ColumnTypeError struct {
+	Table  string
+	Column string
+	Type   int // One of the COL_TYPE constants
+	Value  interface{}
+}
+
+func (e *ColumnTypeError) Error() string {
+	return fmt.Sprintf("value %v of type %T is not a valid %s for column %s of table %s", e.Value, e.Value, getColumnTypeName(e.Type), e.Column, e.Table)
+}
+
 /*
 This is the main function where all funcions end up here. It is used to validate the columns of a table and the incomming
 values that are going to be inserted. The function receives the columns of the table and the rows that are going to be inserted.
@@ -22,36 +32,61 @@ The function returns an error if any, otherwise it returns nil.
 */
 
 func (t *Table) ValidateRawRows(rows []RawRow) ([]RawRow, error) {
+	return t.validateRawRows(rows, nil)
+}
+
+// Same as ValidateRawRows, looking for the rows in the table as changed by the transaction when tx is given
+func (t *Table) validateRawRows(rows []RawRow, tx *Tx) ([]RawRow, error) {
 	validatedRows := make([]RawRow, 0)
 	for _, row := range rows {
-		err := t.ValidateColumns(&row)
+		err := t.validateColumns(&row, tx)
 		if err != nil {
 			return nil, err
 		}
 		validatedRows = append(validatedRows, row)
 	}
+
+	if err := validateUniqueAmongRows(t, validatedRows); err != nil {
+		return nil, err
+	}
+
 	return validatedRows, nil
 }
 
 func (t *Table) ValidateColumns(row *RawRow) error {
-	columns := t.Columns
-	for _, column := range columns {
-		fillupMissingFields(t, row, column)
-		err := validateIfColumnExist(column, t)
+	return t.validateColumns(row, nil)
+}
+
+func (t *Table) validateColumns(row *RawRow, tx *Tx) error {
+	if err := t.validateColumnValues(row); err != nil {
+		return err
+	}
+
+	return validateUnique(t, *row, tx)
+}
+
+// Fills up the missing fields of the row and validates its values, without looking for the row in the table
+func (t *Table) validateColumnValues(row *RawRow) error {
+	for _, column := range t.Columns {
+		err := fillupMissingFields(t, row, column)
+
 		if err != nil {
 			return err
 		}
-		err = validateNull(column, (*row)[column.Name])
 
+		err = validateIfColumnExist(column, t)
 		if err != nil {
 			return err
 		}
-
-		err = validateUnique(t, *row)
+		err = validateNull(column, (*row)[column.Name])
 
 		if err != nil {
 			return err
 		}
+
+		if (*row)[column.Name], err = coerceToColumnType(t.Name, column, (*row)[column.Name]); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -67,26 +102,30 @@ func validateIfColumnExist(column Column, t *Table) error {
 	return nil
 }
 
-func fillupMissingFields(t *Table, row *RawRow, column Column) {
-	// Fill up missing fields with default values
-	if _, ok := (*row)[column.Name]; !ok {
-		if !column.AutoIncrement && !column.Primary && !ok {
-			(*row)[column.Name] = column.Default
-			return
-		}
+func fillupMissingFields(t *Table, row *RawRow, column Column) error {
+	value, ok := (*row)[column.Name]
+
+	if ok && value != nil {
+		return nil
 	}
 
-	// Get last Value, cast it to int64 and add one (This should be only with autoincrement columns)
-	// Meaning that the columns is an integer between all possiblities
-	// SMALL_INT, BIG_INT, INT, etc
-	if column.AutoIncrement && column.Primary {
-		lastValue := t.getLastItem()
-		if lastValue != nil {
-			(*row)[column.Name] = lastValue[column.Name].(int64) + 1
-		} else {
-			(*row)[column.Name] = 1
+	// Columns with a sequence take its next value, auto increment columns of the key have one of their own
+	next, hasSequence, err := t.nextSequenceValue(column)
+
+	if hasSequence {
+		if err == nil {
+			(*row)[column.Name] = next
 		}
+
+		return err
+	}
+
+	// Fill up missing fields with default values
+	if !ok && !column.AutoIncrement && !column.Primary {
+		(*row)[column.Name] = column.Default
 	}
+
+	return nil
 }
 
 func validateNull(column Column, value interface{}) error {
@@ -97,43 +136,48 @@ func validateNull(column Column, value interface{}) error {
 	return nil
 }
 
-func validateUnique(table *Table, row RawRow) error {
-
-	// It is a table without any constraints
-	if table.PrimaryKey == nil && table.CompositeKey == nil {
-		return fmt.Errorf("not indexed table. Cannot validate uniqueness")
-	}
+// Rows inserted together can't repeat a key either, since none of them is in the table when they are validated
+func validateUniqueAmongRows(table *Table, rows []RawRow) error {
+	keys := make(map[string]bool)
 
-	// Uniqueness works only for primary keys or composite keys (Constraints)
-	var pk *Column = nil
-	var key []byte = make([]byte, 0)
-	if table.PrimaryKey != nil {
-		pk = table.PrimaryKey
-		// Indicates that the PK is present and not composite key
-		tmp := row[pk.Name]
-		serialized, err := utils.Serialize(tmp)
+	for _, row := range rows {
+		key, err := table.GetRowKey(row)
 
 		if err != nil {
 			return err
 		}
 
-		key = append(key, serialized...)
+		if keys[string(key)] {
+			return fmt.Errorf("row already exists in table")
+		}
+
+		keys[string(key)] = true
 	}
 
-	if table.CompositeKey != nil {
-		// If composite key is different than nil, then it is a composite key
-		for _, column := range table.CompositeKey {
-			tmp := row[column.Name]
-			serialized, err := utils.Serialize(tmp)
+	return nil
+}
 
-			if err != nil {
-				return err
-			}
+func validateUnique(table *Table, row RawRow, tx *Tx) error {
 
-			key = append(key, serialized...)
-		}
+	// It is a table without any constraints
+	if len(table.GetKeyColumns()) == 0 {
+		return fmt.Errorf("not indexed table. Cannot validate uniqueness")
 	}
-	if len(table.PDataFile.Get(key)) > 0 {
+
+	// Uniqueness works only for primary keys or composite keys (Constraints)
+	key, err := table.GetRowKey(row)
+
+	if err != nil {
+		return err
+	}
+
+	existing, err := tx.getDataFileOperations(table.PDataFile).Get(key)
+
+	if err != nil {
+		return err
+	}
+
+	if len(existing) > 0 {
 		return fmt.Errorf("row already exists in table")
 	}
 
@@ -0,0 +1,568 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+
+	database "github.com/nicolasvancan/monvandb/src/database"
+)
+
+/*
+Aggregations
+
+GroupBy groups the rows by the values of some columns, giving one row per group, with the values of the grouped
+columns and of its aggregates, and keeping the ones matching its HAVING function. Following SQL, aggregates skip null
+values: COUNT counts the values that aren't null, or every row when it has no column, COUNT(DISTINCT) counts the
+different ones, and SUM, AVG, MIN and MAX are null when there are no values. Rows whose grouped columns are null are
+grouped together.
+
+Without grouped columns, every row is in the same group, which is given even when there are no rows.
+
+Groups are given in the order they were found, as long as they fit in memory. Once there are MaxGroups of them, the
+rows of the groups found afterwards are spilled to files (see spillFile), whose groups are given after the ones kept
+in memory, one file at a time. COUNT(DISTINCT) keeps the values of each group in memory.
+
+MIN and MAX of columns indexed in a table, either by its primary key or by an index, are read from the first row of the
+table sorted by them, without scanning it, when they are the only aggregates of a full scan without groups:
+
+	rows, err := executor.FullScan(table).HashAggregate(nil, executor.Min("id"), executor.Max("id")).Collect(ctx)
+*/
+
+// Aggregate functions
+const (
+	COUNT = iota
+	SUM
+	AVG
+	MIN
+	MAX
+	COUNT_DISTINCT
+)
+
+const (
+	AGGREGATE_MAX_GROUPS = 1 << 16 // Groups kept in memory by default before spilling rows to disk
+)
+
+var aggregateNames = map[int]string{
+	COUNT:          "count",
+	SUM:            "sum",
+	AVG:            "avg",
+	MIN:            "min",
+	MAX:            "max",
+	COUNT_DISTINCT: "count",
+}
+
+// Aggregate is a value computed over the rows of a group, named Name in the rows given by HashAggregate
+type Aggregate struct {
+	Func   int
+	Column string // Column aggregated, which COUNT may leave empty to count rows
+	Name   string
+}
+
+func newAggregate(function int, column string) Aggregate {
+	argument := column
+
+	if argument == "" {
+		argument = "*"
+	}
+
+	return Aggregate{Func: function, Column: column, Name: fmt.Sprintf("%s(%s)", aggregateNames[function], argument)}
+}
+
+// Count counts the values of the column that aren't null, or every row when column is empty
+func Count(column string) Aggregate {
+	return newAggregate(COUNT, column)
+}
+
+// CountDistinct counts the different values of the column that aren't null
+func CountDistinct(column string) Aggregate {
+	return newAggregate(COUNT_DISTINCT, column).As(fmt.Sprintf("count(distinct %s)", column))
+}
+
+func Sum(column string) Aggregate {
+	return newAggregate(SUM, column)
+}
+
+func Avg(column string) Aggregate {
+	return newAggregate(AVG, column)
+}
+
+func Min(column string) Aggregate {
+	return newAggregate(MIN, column)
+}
+
+func Max(column string) Aggregate {
+	return newAggregate(MAX, column)
+}
+
+// As renames the aggregate
+func (a Aggregate) As(name string) Aggregate {
+	a.Name = name
+	return a
+}
+
+// Computes an aggregate over the values of a group, given one at a time
+type accumulator interface {
+	add(value interface{}) error
+	result() interface{}
+}
+
+func newAccumulator(aggregate Aggregate) (accumulator, error) {
+	switch aggregate.Func {
+	case COUNT:
+		return &countAccumulator{rows: aggregate.Column == ""}, nil
+	case SUM, AVG:
+		return &sumAccumulator{column: aggregate.Column, avg: aggregate.Func == AVG}, nil
+	case MIN, MAX:
+		return &extremeAccumulator{max: aggregate.Func == MAX}, nil
+	case COUNT_DISTINCT:
+		return &distinctAccumulator{column: aggregate.Column, values: make(map[interface{}]bool)}, nil
+	}
+
+	return nil, fmt.Errorf("aggregate function %d does not exist", aggregate.Func)
+}
+
+type countAccumulator struct {
+	rows  bool // Counts rows instead of values
+	count int64
+}
+
+func (c *countAccumulator) add(value interface{}) error {
+	if c.rows || value != nil {
+		c.count++
+	}
+
+	return nil
+}
+
+func (c *countAccumulator) result() interface{} {
+	return c.count
+}
+
+// Sums integers as int64 until a float is found, or the sum doesn't fit in an int64
+type sumAccumulator struct {
+	column string
+	avg    bool
+	count  int64
+	ints   int64
+	floats float64
+	float  bool // Whether the sum is kept as a float
+}
+
+func (s *sumAccumulator) add(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+
+	kind, normalized := normalizeValue(value)
+
+	if kind != kindInt && kind != kindFloat {
+		return fmt.Errorf("value %v of column %s is not a number", value, s.column)
+	}
+
+	s.count++
+
+	if kind == kindInt && !s.float {
+		i := normalized.(int64)
+
+		if sum := s.ints + i; (i >= 0) == (sum >= s.ints) {
+			s.ints = sum
+			return nil
+		}
+	}
+
+	if !s.float {
+		s.float, s.floats = true, float64(s.ints)
+	}
+
+	s.floats += toFloat(kind, normalized)
+	return nil
+}
+
+func (s *sumAccumulator) result() interface{} {
+	if s.count == 0 {
+		return nil
+	}
+
+	if s.avg && s.float {
+		return s.floats / float64(s.count)
+	}
+
+	if s.avg {
+		return float64(s.ints) / float64(s.count)
+	}
+
+	if s.float {
+		return s.floats
+	}
+
+	return s.ints
+}
+
+type extremeAccumulator struct {
+	max   bool
+	value interface{}
+}
+
+func (e *extremeAccumulator) add(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+
+	if comparsion := compareValues(value, e.value); e.value == nil || (e.max && comparsion > 0) || (!e.max && comparsion < 0) {
+		e.value = value
+	}
+
+	return nil
+}
+
+func (e *extremeAccumulator) result() interface{} {
+	return e.value
+}
+
+// Counts values by their hash keys, so that numbers of different types with the same value are counted once
+type distinctAccumulator struct {
+	column string
+	values map[interface{}]bool
+}
+
+func (d *distinctAccumulator) add(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+
+	key, ok := hashKey(value)
+
+	if !ok {
+		return fmt.Errorf("value %v of column %s can't be counted", value, d.column)
+	}
+
+	d.values[key] = true
+	return nil
+}
+
+func (d *distinctAccumulator) result() interface{} {
+	return int64(len(d.values))
+}
+
+type group struct {
+	values       []interface{} // Values of the grouped columns
+	accumulators []accumulator
+}
+
+// GroupBy groups rows by the values of Columns, giving the groups matching Having
+type GroupBy struct {
+	Columns   []string
+	Having    func(database.RawRow) bool // Filters the rows of the groups, nil keeps every one of them
+	MaxGroups int                        // Groups kept in memory before spilling rows to disk, AGGREGATE_MAX_GROUPS when 0
+	SpillDir  string                     // Directory of the spill files, the temporary directory of the system when empty
+}
+
+type hashAggregateOperator struct {
+	unaryOperator
+	groupBy    GroupBy
+	aggregates []Aggregate
+	ctx        context.Context
+	groups     []*group     // Groups in memory not given yet, in the order they were found
+	spilled    []*spillFile // Files of the rows of groups that didn't fit in memory, not aggregated yet
+}
+
+// GroupBy gives a row for each group of rows with the same values of the grouped columns, with their aggregates
+func (p *Plan) GroupBy(groupBy GroupBy, aggregates ...Aggregate) *Plan {
+	if groupBy.MaxGroups <= 0 {
+		groupBy.MaxGroups = AGGREGATE_MAX_GROUPS
+	}
+
+	return p.with(func(child Operator) Operator {
+		aggregate := &hashAggregateOperator{unaryOperator: unaryOperator{child}, groupBy: groupBy, aggregates: aggregates}
+
+		if scan, ok := child.(*scanOperator); ok && scan.full && len(groupBy.Columns) == 0 && areIndexedExtremes(scan.table, aggregates) {
+			return &indexExtremesOperator{table: scan.table, aggregates: aggregates, having: groupBy.Having, fallback: aggregate}
+		}
+
+		return aggregate
+	})
+}
+
+// HashAggregate gives a row for each group of rows with the same values of the groupBy columns, with their aggregates
+func (p *Plan) HashAggregate(groupBy []string, aggregates ...Aggregate) *Plan {
+	return p.GroupBy(GroupBy{Columns: groupBy}, aggregates...)
+}
+
+func (h *hashAggregateOperator) newGroup(values []interface{}) (*group, error) {
+	g := &group{values: values, accumulators: make([]accumulator, len(h.aggregates))}
+
+	for i, aggregate := range h.aggregates {
+		accumulator, err := newAccumulator(aggregate)
+
+		if err != nil {
+			return nil, err
+		}
+
+		g.accumulators[i] = accumulator
+	}
+
+	return g, nil
+}
+
+// Returns the record of a row: the values of its grouped columns, followed by the ones of the aggregated columns
+func (h *hashAggregateOperator) record(row database.RawRow) []interface{} {
+	record := make([]interface{}, 0, len(h.groupBy.Columns)+len(h.aggregates))
+
+	for _, column := range h.groupBy.Columns {
+		record = append(record, row[column])
+	}
+
+	for _, aggregate := range h.aggregates {
+		record = append(record, row[aggregate.Column])
+	}
+
+	return record
+}
+
+func (h *hashAggregateOperator) Open(ctx context.Context) error {
+	if err := h.child.Open(ctx); err != nil {
+		return err
+	}
+
+	h.ctx = ctx
+
+	err := h.aggregate(0, func() ([]interface{}, error) {
+		row, err := h.child.Next()
+
+		if row == nil || err != nil {
+			return nil, err
+		}
+
+		return h.record(row), nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// Without grouped columns there is always one group
+	if len(h.groupBy.Columns) == 0 && len(h.groups) == 0 {
+		g, err := h.newGroup(nil)
+
+		if err != nil {
+			return err
+		}
+
+		h.groups = append(h.groups, g)
+	}
+
+	return nil
+}
+
+/*
+Groups the records, until next returns nil. Records of groups found once MaxGroups are in memory are spilled to the
+files of the level, unless it's the last one
+*/
+func (h *hashAggregateOperator) aggregate(level int, next func() ([]interface{}, error)) error {
+	groups := make(map[string]*group)
+	partitions := make([]*spillFile, SPILL_PARTITIONS)
+	h.groups = make([]*group, 0)
+	columns := len(h.groupBy.Columns)
+
+	for {
+		if err := h.ctx.Err(); err != nil {
+			return err
+		}
+
+		record, err := next()
+
+		if err != nil {
+			return err
+		}
+
+		if record == nil {
+			break
+		}
+
+		values := record[:columns]
+		key, ok := hashKeys(values, true)
+
+		if !ok {
+			return fmt.Errorf("values %v can't be grouped", values)
+		}
+
+		g, ok := groups[key]
+
+		if !ok && len(h.groups) >= h.groupBy.MaxGroups && level < SPILL_MAX_LEVEL {
+			if err := h.spill(partitions, spillPartition(key, level), level, record); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if !ok {
+			if g, err = h.newGroup(values); err != nil {
+				return err
+			}
+
+			groups[key] = g
+			h.groups = append(h.groups, g)
+		}
+
+		for i := range h.aggregates {
+			if err := g.accumulators[i].add(record[columns+i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Writes the record to the file of the partition, creating it when needed
+func (h *hashAggregateOperator) spill(partitions []*spillFile, partition int, level int, record []interface{}) error {
+	if partitions[partition] == nil {
+		file, err := newSpillFile(h.groupBy.SpillDir, level+1)
+
+		if err != nil {
+			return fmt.Errorf("error creating file to spill groups: %v", err)
+		}
+
+		// Files are kept by the operator as soon as they are created, so that Close removes them
+		partitions[partition] = file
+		h.spilled = append(h.spilled, file)
+	}
+
+	if err := partitions[partition].write(record); err != nil {
+		return fmt.Errorf("error spilling values %v: %v", record, err)
+	}
+
+	return nil
+}
+
+func (h *hashAggregateOperator) Next() (database.RawRow, error) {
+	for {
+		for len(h.groups) > 0 {
+			g := h.groups[0]
+			h.groups = h.groups[1:]
+
+			if row := h.groupRow(g); h.groupBy.Having == nil || h.groupBy.Having(row) {
+				return row, nil
+			}
+		}
+
+		if len(h.spilled) == 0 {
+			return nil, nil
+		}
+
+		// Groups in memory are over, the ones of the next file take their place
+		file := h.spilled[0]
+		h.spilled = h.spilled[1:]
+
+		err := file.rewind()
+
+		if err == nil {
+			err = h.aggregate(file.level, file.read)
+		}
+
+		file.remove()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (h *hashAggregateOperator) groupRow(g *group) database.RawRow {
+	row := make(database.RawRow, len(h.groupBy.Columns)+len(h.aggregates))
+	for i, column := range h.groupBy.Columns {
+		row[column] = g.values[i]
+	}
+
+	for i, aggregate := range h.aggregates {
+		row[aggregate.Name] = g.accumulators[i].result()
+	}
+
+	return row
+}
+
+func (h *hashAggregateOperator) Close() {
+	for _, file := range h.spilled {
+		file.remove()
+	}
+
+	h.groups, h.spilled = nil, nil
+	h.child.Close()
+}
+
+// Whether every aggregate is the MIN or MAX of a column indexed in the table
+func areIndexedExtremes(table *database.Table, aggregates []Aggregate) bool {
+	for _, aggregate := range aggregates {
+		if (aggregate.Func != MIN && aggregate.Func != MAX) || !table.IsColumnIndexed(aggregate.Column) {
+			return false
+		}
+	}
+
+	return len(aggregates) > 0
+}
+
+/*
+Gives the MIN and MAX of indexed columns of a table, from the first row of the table sorted by each column. When a
+column isn't indexed by the time the operator is opened, the scan of the table is aggregated instead
+*/
+type indexExtremesOperator struct {
+	table      *database.Table
+	aggregates []Aggregate
+	having     func(database.RawRow) bool
+	fallback   Operator // Aggregation of the scan of the table
+	row        database.RawRow
+	scanned    bool // Whether the fallback is used
+}
+
+func (i *indexExtremesOperator) Open(ctx context.Context) error {
+	i.row = make(database.RawRow, len(i.aggregates))
+
+	for _, aggregate := range i.aggregates {
+		order := database.ASC
+
+		if aggregate.Func == MAX {
+			order = database.DESC
+		}
+
+		rows, ok := i.table.SortedIter(ctx, aggregate.Column, order)
+
+		if !ok {
+			i.row, i.scanned = nil, true
+			return i.fallback.Open(ctx)
+		}
+
+		i.row[aggregate.Name] = nil
+
+		if rows.Next() {
+			i.row[aggregate.Name] = rows.Row()[aggregate.Column]
+		}
+
+		rows.Close()
+
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	if i.having != nil && !i.having(i.row) {
+		i.row = nil
+	}
+
+	return nil
+}
+
+func (i *indexExtremesOperator) Next() (database.RawRow, error) {
+	if i.scanned {
+		return i.fallback.Next()
+	}
+
+	row := i.row
+	i.row = nil
+	return row, nil
+}
+
+func (i *indexExtremesOperator) Close() {
+	i.row = nil
+	i.fallback.Close()
+}
@@ -0,0 +1,404 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	database "github.com/nicolasvancan/monvandb/src/database"
+)
+
+/*
+Joins
+
+Joins give the rows made of a row of each side, for every pair of rows matching the join condition. Joined rows have
+the columns of both rows, those of the right one replacing the left ones with the same names, so sides with columns
+named alike should be qualified with As.
+
+Left joins also give the left rows matching no right row, and right joins the right rows matching no left row, without
+the columns of the other side, which are read as null. Left rows of joined tables have their columns set to null.
+
+Every join but the index nested loop one reads the right side when it's opened, keeping it in memory. The rows of the
+left one are read as they are needed, except for sort merge joins, which sort both sides first:
+
+  - Nested loop joins compare each left row to every right row.
+  - Hash joins keep the right rows by the values of their join column, looking up the value of each left row.
+  - Sort merge joins sort both sides by their join columns, going through them in order.
+  - Index nested loop joins get the right rows of each left row from a table, by the value of an indexed column,
+    therefore they can't be right joins.
+*/
+
+// Kinds of joins
+const (
+	INNER_JOIN = iota
+	LEFT_JOIN
+	RIGHT_JOIN
+)
+
+// Operator with a left and a right child
+type binaryOperator struct {
+	left  Operator
+	right Operator
+}
+
+func (b *binaryOperator) Close() {
+	b.left.Close()
+	b.right.Close()
+}
+
+// Returns a new plan joining the plan to the right one with the operator
+func (p *Plan) join(right *Plan, build func(left Operator, right Operator) Operator) *Plan {
+	if right.err != nil {
+		return right
+	}
+
+	return p.with(func(child Operator) Operator {
+		return build(child, right.root)
+	})
+}
+
+func joinRows(left database.RawRow, right database.RawRow) database.RawRow {
+	joined := make(database.RawRow, len(left)+len(right))
+
+	for column, value := range left {
+		joined[column] = value
+	}
+
+	for column, value := range right {
+		joined[column] = value
+	}
+
+	return joined
+}
+
+// Finds the right rows a left row may be joined to, which are checked by the join condition afterwards
+type joinStrategy interface {
+	// Prepares the strategy with the rows of the right side, which are nil when it doesn't read them
+	build(rights []database.RawRow) error
+	// Returns the positions of the right rows found, or the rows themselves when the right side isn't read
+	probe(left database.RawRow) ([]int, []database.RawRow, error)
+}
+
+// Strategy probing the left rows in its own order, which reads every one of them when the join is opened
+type leftSorter interface {
+	sortLeft(lefts []database.RawRow) []database.RawRow
+}
+
+type joinOperator struct {
+	binaryOperator
+	kind     int
+	strategy joinStrategy
+	matches  func(left database.RawRow, right database.RawRow) bool // Join condition, nil joins every pair found
+	pad      []string                                               // Right columns set to null in left rows matching none
+	readAll  bool                                                   // Whether the right side is read when opened
+
+	rights  []database.RawRow
+	used    []bool            // Right rows joined to some left row
+	sorted  bool              // Whether the left rows were read and sorted by the strategy
+	lefts   []database.RawRow // Sorted left rows not probed yet
+	row     database.RawRow   // Left row being joined
+	found   []int             // Positions of the right rows found for the row, not joined yet
+	fetched []database.RawRow // Right rows found for the row, when the right side isn't read
+	joined  bool              // Whether the row was joined to some right row
+	unused  int               // Next right row checked for right joins, once the left rows are over
+	over    bool              // Whether the left rows are over
+}
+
+func (j *joinOperator) Open(ctx context.Context) error {
+	if err := j.left.Open(ctx); err != nil {
+		return err
+	}
+
+	if err := j.right.Open(ctx); err != nil {
+		return err
+	}
+
+	if j.readAll {
+		rights, err := readAll(ctx, j.right)
+
+		if err != nil {
+			return err
+		}
+
+		j.rights, j.used = rights, make([]bool, len(rights))
+	}
+
+	if err := j.strategy.build(j.rights); err != nil {
+		return err
+	}
+
+	sorter, ok := j.strategy.(leftSorter)
+
+	if !ok {
+		return nil
+	}
+
+	lefts, err := readAll(ctx, j.left)
+
+	if err != nil {
+		return err
+	}
+
+	j.lefts, j.sorted = sorter.sortLeft(lefts), true
+	return nil
+}
+
+// Returns the next left row, or nil once they are over
+func (j *joinOperator) nextLeft() (database.RawRow, error) {
+	if !j.sorted {
+		return j.left.Next()
+	}
+
+	if len(j.lefts) == 0 {
+		return nil, nil
+	}
+
+	row := j.lefts[0]
+	j.lefts = j.lefts[1:]
+	return row, nil
+}
+
+func (j *joinOperator) Next() (database.RawRow, error) {
+	for !j.over {
+		if row, ok := j.nextCandidate(); ok {
+			return row, nil
+		}
+
+		// Left rows joined to no right row are given by left joins
+		if j.row != nil && !j.joined && j.kind == LEFT_JOIN {
+			row := j.padded(j.row)
+			j.row = nil
+			return row, nil
+		}
+
+		left, err := j.nextLeft()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if left == nil {
+			j.over = true
+			break
+		}
+
+		found, fetched, err := j.strategy.probe(left)
+
+		if err != nil {
+			return nil, err
+		}
+
+		j.row, j.found, j.fetched, j.joined = left, found, fetched, false
+	}
+
+	// Right rows joined to no left row are given by right joins
+	for j.kind == RIGHT_JOIN && j.unused < len(j.rights) {
+		j.unused++
+
+		if !j.used[j.unused-1] {
+			return j.rights[j.unused-1], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Returns the next pair of the current left row and a right row found for it that match the join condition
+func (j *joinOperator) nextCandidate() (database.RawRow, bool) {
+	for len(j.found) > 0 {
+		position := j.found[0]
+		j.found = j.found[1:]
+
+		if j.matches == nil || j.matches(j.row, j.rights[position]) {
+			j.used[position], j.joined = true, true
+			return joinRows(j.row, j.rights[position]), true
+		}
+	}
+
+	for len(j.fetched) > 0 {
+		right := j.fetched[0]
+		j.fetched = j.fetched[1:]
+
+		if j.matches == nil || j.matches(j.row, right) {
+			j.joined = true
+			return joinRows(j.row, right), true
+		}
+	}
+
+	return nil, false
+}
+
+func (j *joinOperator) padded(left database.RawRow) database.RawRow {
+	row := joinRows(left, nil)
+
+	for _, column := range j.pad {
+		if _, ok := row[column]; !ok {
+			row[column] = nil
+		}
+	}
+
+	return row
+}
+
+func (j *joinOperator) Close() {
+	j.rights, j.used, j.lefts, j.found, j.fetched = nil, nil, nil, nil, nil
+	j.binaryOperator.Close()
+}
+
+// Every right row is a candidate
+type nestedLoopStrategy struct {
+	positions []int
+}
+
+func (n *nestedLoopStrategy) build(rights []database.RawRow) error {
+	n.positions = make([]int, len(rights))
+	for i := range rights {
+		n.positions[i] = i
+	}
+
+	return nil
+}
+
+func (n *nestedLoopStrategy) probe(left database.RawRow) ([]int, []database.RawRow, error) {
+	return n.positions, nil, nil
+}
+
+type hashStrategy struct {
+	leftColumn  string
+	rightColumn string
+	table       map[interface{}][]int // Positions of the right rows by the value of their column
+}
+
+func (h *hashStrategy) build(rights []database.RawRow) error {
+	h.table = make(map[interface{}][]int)
+
+	for i, row := range rights {
+		if key, ok := hashKey(row[h.rightColumn]); ok {
+			h.table[key] = append(h.table[key], i)
+		}
+	}
+
+	return nil
+}
+
+func (h *hashStrategy) probe(left database.RawRow) ([]int, []database.RawRow, error) {
+	if key, ok := hashKey(left[h.leftColumn]); ok {
+		return h.table[key], nil, nil
+	}
+
+	return nil, nil, nil
+}
+
+type sortMergeStrategy struct {
+	leftColumn  string
+	rightColumn string
+	rights      []database.RawRow
+	order       []int // Positions of the right rows, sorted by their column
+	next        int   // First position of order whose value isn't smaller than the last left value
+}
+
+func (s *sortMergeStrategy) build(rights []database.RawRow) error {
+	s.rights, s.order, s.next = rights, make([]int, len(rights)), 0
+
+	for i := range rights {
+		s.order[i] = i
+	}
+
+	slices.SortStableFunc(s.order, func(a int, b int) int {
+		return compareValues(rights[a][s.rightColumn], rights[b][s.rightColumn])
+	})
+
+	return nil
+}
+
+func (s *sortMergeStrategy) sortLeft(lefts []database.RawRow) []database.RawRow {
+	sorted := slices.Clone(lefts)
+
+	slices.SortStableFunc(sorted, func(a database.RawRow, b database.RawRow) int {
+		return compareValues(a[s.leftColumn], b[s.leftColumn])
+	})
+
+	return sorted
+}
+
+// Left values come in order, so the right rows smaller than them are never found again
+func (s *sortMergeStrategy) probe(left database.RawRow) ([]int, []database.RawRow, error) {
+	value := left[s.leftColumn]
+
+	if value == nil {
+		return nil, nil, nil
+	}
+
+	for s.next < len(s.order) && compareValues(s.rights[s.order[s.next]][s.rightColumn], value) < 0 {
+		s.next++
+	}
+
+	end := s.next
+	for end < len(s.order) && compareValues(s.rights[s.order[end]][s.rightColumn], value) == 0 {
+		end++
+	}
+
+	return s.order[s.next:end], nil, nil
+}
+
+// Gets the right rows of each left row from the table, by the value of the left column
+type indexStrategy struct {
+	table       *database.Table
+	alias       string
+	leftColumn  string
+	rightColumn string                     // Indexed column of the table
+	filter      func(database.RawRow) bool // Comparsions of the table alone, nil when there are none
+}
+
+func (i *indexStrategy) build(rights []database.RawRow) error {
+	return nil
+}
+
+func (i *indexStrategy) probe(left database.RawRow) ([]int, []database.RawRow, error) {
+	value := left[i.leftColumn]
+
+	if value == nil {
+		return nil, nil, nil
+	}
+
+	rows, err := i.table.Get(i.rightColumn, value)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting rows of table %s by %s: %v", i.table.Name, i.rightColumn, err)
+	}
+
+	fetched := make([]database.RawRow, 0, len(rows))
+	for _, row := range rows {
+		if i.filter == nil || i.filter(row) {
+			fetched = append(fetched, qualifyRow(i.alias, row))
+		}
+	}
+
+	return nil, fetched, nil
+}
+
+// NestedLoopJoin joins the plan to the right one, comparing every pair of rows with the on function
+func (p *Plan) NestedLoopJoin(right *Plan, on func(left database.RawRow, right database.RawRow) bool) *Plan {
+	return p.join(right, func(left Operator, right Operator) Operator {
+		return &joinOperator{binaryOperator: binaryOperator{left, right}, strategy: &nestedLoopStrategy{}, matches: on, readAll: true}
+	})
+}
+
+/*
+HashJoin joins the rows of the plan to the rows of the right one where the value of leftColumn equals the value of
+rightColumn. Null values are never equal
+*/
+func (p *Plan) HashJoin(right *Plan, leftColumn string, rightColumn string) *Plan {
+	return p.join(right, func(left Operator, right Operator) Operator {
+		strategy := &hashStrategy{leftColumn: leftColumn, rightColumn: rightColumn}
+		return &joinOperator{binaryOperator: binaryOperator{left, right}, strategy: strategy, readAll: true}
+	})
+}
+
+// SortMergeJoin joins the same rows HashJoin does, sorting both sides by their columns instead
+func (p *Plan) SortMergeJoin(right *Plan, leftColumn string, rightColumn string) *Plan {
+	return p.join(right, func(left Operator, right Operator) Operator {
+		strategy := &sortMergeStrategy{leftColumn: leftColumn, rightColumn: rightColumn}
+		return &joinOperator{binaryOperator: binaryOperator{left, right}, strategy: strategy, readAll: true}
+	})
+}
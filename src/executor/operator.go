@@ -0,0 +1,159 @@
+package executor
+
+import (
+	"context"
+
+	database "github.com/nicolasvancan/monvandb/src/database"
+)
+
+/*
+Executor
+
+Queries are run by trees of operators, following the Volcano model: each operator gives its rows one at a time,
+pulling the rows it needs from its children as they are asked for. Scans are the leaves of the trees, reading the
+rows of tables, and the operators above them filter, transform, sort, limit, group and join those rows.
+
+Plans are built with the methods of Plan, each one putting a new operator on top of the plan, and run with Run, which
+returns the rows through an iterator:
+
+	rows, err := executor.ScanTable(db, "users").
+		Filter(func(row database.RawRow) bool { return row["age"].(int32) > 18 }).
+		Sort(executor.Desc("age")).
+		Limit(10, 0).
+		Project(executor.Column("name"), executor.Column("age")).
+		Run(ctx)
+
+	defer rows.Close()
+
+	for rows.Next() {
+		row := rows.Row()
+	}
+
+Rows of scans have the names of the columns as keys. Scans given an alias qualify them, as in u.name, so that the rows
+of joined tables don't have the same keys.
+
+Operators that need every row of their children before giving the first one, such as Sort and the right side of joins,
+keep them in memory, except for GroupBy, which spills the rows of the groups that don't fit to disk.
+*/
+
+/*
+Operator is a node of a plan. Open prepares it to give rows, Next gives the next row, or nil once there are no more,
+and Close releases whatever the operator holds. Operators are opened and closed once
+*/
+type Operator interface {
+	Open(ctx context.Context) error
+	Next() (database.RawRow, error)
+	Close()
+}
+
+/*
+Plan is a tree of operators, built from its leaves up. Plans built on top of another share its operators, so only one
+of them can be run, once
+*/
+type Plan struct {
+	root Operator
+	err  error // Error found while building the plan, returned by Run
+}
+
+// NewPlan returns a plan running the operator, so that operators of other packages can be put in plans
+func NewPlan(operator Operator) *Plan {
+	return &Plan{root: operator}
+}
+
+// Returns a new plan with the operator built on top of the plan
+func (p *Plan) with(build func(child Operator) Operator) *Plan {
+	if p.err != nil {
+		return p
+	}
+
+	return &Plan{root: build(p.root)}
+}
+
+// Operator returns the root operator of the plan
+func (p *Plan) Operator() Operator {
+	return p.root
+}
+
+// Run opens the plan, returning its rows
+func (p *Plan) Run(ctx context.Context) (*Rows, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	if err := p.root.Open(ctx); err != nil {
+		p.root.Close()
+		return nil, err
+	}
+
+	return &Rows{ctx: ctx, root: p.root}, nil
+}
+
+// Collect runs the plan, returning every one of its rows
+func (p *Plan) Collect(ctx context.Context) ([]database.RawRow, error) {
+	rows, err := p.Run(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	collected := make([]database.RawRow, 0)
+	for rows.Next() {
+		collected = append(collected, rows.Row())
+	}
+
+	return collected, rows.Err()
+}
+
+// Rows iterates the rows of a plan, like database.RowIterator. Rows must always be closed
+type Rows struct {
+	ctx  context.Context
+	root Operator
+	row  database.RawRow
+	done bool
+	err  error
+}
+
+// Next moves to the next row, returning false once there are no more rows or an error is found
+func (r *Rows) Next() bool {
+	if r.done {
+		return false
+	}
+
+	if err := r.ctx.Err(); err != nil {
+		r.err = err
+		r.Close()
+		return false
+	}
+
+	row, err := r.root.Next()
+
+	if err != nil || row == nil {
+		r.err = err
+		r.Close()
+		return false
+	}
+
+	r.row = row
+	return true
+}
+
+// Row returns the row the iterator is at, after Next returned true
+func (r *Rows) Row() database.RawRow {
+	return r.row
+}
+
+// Err returns the error that stopped the iterator, if any
+func (r *Rows) Err() error {
+	return r.err
+}
+
+// Close closes the operators of the plan. Closing it twice does nothing
+func (r *Rows) Close() {
+	if !r.done {
+		r.done = true
+		r.row = nil
+		r.root.Close()
+	}
+}
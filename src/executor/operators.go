@@ -0,0 +1,255 @@
+package executor
+
+import (
+	"context"
+	"slices"
+
+	database "github.com/nicolasvancan/monvandb/src/database"
+)
+
+// Operator with a single child, passing Open and Close to it
+type unaryOperator struct {
+	child Operator
+}
+
+func (u *unaryOperator) Open(ctx context.Context) error {
+	return u.child.Open(ctx)
+}
+
+func (u *unaryOperator) Close() {
+	u.child.Close()
+}
+
+type filterOperator struct {
+	unaryOperator
+	matches func(database.RawRow) bool
+}
+
+// Filter keeps the rows the function returns true for
+func (p *Plan) Filter(matches func(database.RawRow) bool) *Plan {
+	return p.with(func(child Operator) Operator {
+		return &filterOperator{unaryOperator: unaryOperator{child}, matches: matches}
+	})
+}
+
+func (f *filterOperator) Next() (database.RawRow, error) {
+	for {
+		row, err := f.child.Next()
+
+		if row == nil || err != nil {
+			return nil, err
+		}
+
+		if f.matches(row) {
+			return row, nil
+		}
+	}
+}
+
+// Projection is a value of the rows given by Project, named Name
+type Projection struct {
+	Name  string
+	Value func(database.RawRow) interface{}
+}
+
+// Column projects the value of a column
+func Column(name string) Projection {
+	return Projection{Name: name, Value: func(row database.RawRow) interface{} { return row[name] }}
+}
+
+// As renames the projection
+func (p Projection) As(name string) Projection {
+	p.Name = name
+	return p
+}
+
+type projectOperator struct {
+	unaryOperator
+	projections []Projection
+}
+
+// Project replaces the rows by rows made of the projections
+func (p *Plan) Project(projections ...Projection) *Plan {
+	return p.with(func(child Operator) Operator {
+		return &projectOperator{unaryOperator: unaryOperator{child}, projections: projections}
+	})
+}
+
+func (p *projectOperator) Next() (database.RawRow, error) {
+	row, err := p.child.Next()
+
+	if row == nil || err != nil {
+		return nil, err
+	}
+
+	projected := make(database.RawRow, len(p.projections))
+	for _, projection := range p.projections {
+		projected[projection.Name] = projection.Value(row)
+	}
+
+	return projected, nil
+}
+
+type qualifyOperator struct {
+	unaryOperator
+	alias string
+}
+
+// As qualifies the columns of the rows with the alias, as in alias.column
+func (p *Plan) As(alias string) *Plan {
+	return p.with(func(child Operator) Operator {
+		return &qualifyOperator{unaryOperator: unaryOperator{child}, alias: alias}
+	})
+}
+
+func (q *qualifyOperator) Next() (database.RawRow, error) {
+	row, err := q.child.Next()
+
+	if row == nil || err != nil {
+		return nil, err
+	}
+
+	return qualifyRow(q.alias, row), nil
+}
+
+func qualifyRow(alias string, row database.RawRow) database.RawRow {
+	qualified := make(database.RawRow, len(row))
+	for column, value := range row {
+		qualified[alias+"."+column] = value
+	}
+
+	return qualified
+}
+
+// SortKey is a column rows are sorted by, in database.ASC or database.DESC order
+type SortKey struct {
+	Column string
+	Order  int
+}
+
+func Asc(column string) SortKey {
+	return SortKey{Column: column, Order: database.ASC}
+}
+
+func Desc(column string) SortKey {
+	return SortKey{Column: column, Order: database.DESC}
+}
+
+type sortOperator struct {
+	unaryOperator
+	keys []SortKey
+	rows []database.RawRow
+}
+
+/*
+Sort sorts the rows by the keys, the first one deciding the order of the rows unless they have the same value for it,
+and so on. Rows with the same values keep the order they came in. Nulls come first in ascending order
+*/
+func (p *Plan) Sort(keys ...SortKey) *Plan {
+	return p.with(func(child Operator) Operator {
+		return &sortOperator{unaryOperator: unaryOperator{child}, keys: keys}
+	})
+}
+
+func (s *sortOperator) Open(ctx context.Context) error {
+	if err := s.child.Open(ctx); err != nil {
+		return err
+	}
+
+	rows, err := readAll(ctx, s.child)
+
+	if err != nil {
+		return err
+	}
+
+	slices.SortStableFunc(rows, func(a database.RawRow, b database.RawRow) int {
+		for _, key := range s.keys {
+			comparsion := compareValues(a[key.Column], b[key.Column])
+
+			if key.Order == database.DESC {
+				comparsion = -comparsion
+			}
+
+			if comparsion != 0 {
+				return comparsion
+			}
+		}
+
+		return 0
+	})
+
+	s.rows = rows
+	return nil
+}
+
+func (s *sortOperator) Next() (database.RawRow, error) {
+	if len(s.rows) == 0 {
+		return nil, nil
+	}
+
+	row := s.rows[0]
+	s.rows = s.rows[1:]
+	return row, nil
+}
+
+func (s *sortOperator) Close() {
+	s.rows = nil
+	s.child.Close()
+}
+
+type limitOperator struct {
+	unaryOperator
+	limit  int
+	offset int
+	read   int // Rows given so far, after the offset
+}
+
+// Limit skips the first offset rows, giving at most limit rows after them. A negative limit gives every row
+func (p *Plan) Limit(limit int, offset int) *Plan {
+	return p.with(func(child Operator) Operator {
+		return &limitOperator{unaryOperator: unaryOperator{child}, limit: limit, offset: offset}
+	})
+}
+
+func (l *limitOperator) Next() (database.RawRow, error) {
+	for l.offset > 0 {
+		row, err := l.child.Next()
+
+		if row == nil || err != nil {
+			return nil, err
+		}
+
+		l.offset--
+	}
+
+	if l.limit >= 0 && l.read >= l.limit {
+		return nil, nil
+	}
+
+	row, err := l.child.Next()
+
+	if row != nil {
+		l.read++
+	}
+
+	return row, err
+}
+
+// Reads every row left in the operator, stopping once the context is done
+func readAll(ctx context.Context, operator Operator) ([]database.RawRow, error) {
+	rows := make([]database.RawRow, 0)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		row, err := operator.Next()
+
+		if row == nil || err != nil {
+			return rows, err
+		}
+
+		rows = append(rows, row)
+	}
+}
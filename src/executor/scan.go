@@ -0,0 +1,97 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+
+	database "github.com/nicolasvancan/monvandb/src/database"
+)
+
+// Scans read the rows of a table through a database.RowIterator, which holds a snapshot of the table while opened
+type scanOperator struct {
+	table *database.Table
+	open  func(ctx context.Context) *database.RowIterator
+	rows  *database.RowIterator
+	full  bool // Whether it reads every row of the table
+}
+
+// FullScan reads every row of the table, sorted by its key
+func FullScan(table *database.Table) *Plan {
+	return &Plan{root: &scanOperator{table: table, open: table.Scan, full: true}}
+}
+
+// ScanTable reads every row of a table of the database
+func ScanTable(db *database.Database, tableName string) *Plan {
+	table, ok := db.Tables[tableName]
+
+	if !ok {
+		return &Plan{err: fmt.Errorf("table %s does not exist", tableName)}
+	}
+
+	return FullScan(table)
+}
+
+/*
+IndexScan reads the range of the options, from the data file of the table or of one of its indexes (see
+database.RangeOptions)
+*/
+func IndexScan(table *database.Table, options database.RangeOptions) *Plan {
+	return &Plan{root: &scanOperator{table: table, open: func(ctx context.Context) *database.RowIterator {
+		return database.RangeIterFromOptions(ctx, table, options)
+	}}}
+}
+
+/*
+RangeScan reads the rows matching the comparsions, as Table.Range does, choosing the range of keys read by the indexed
+columns compared
+*/
+func RangeScan(table *database.Table, comparsions []database.ColumnComparsion, order int) *Plan {
+	return &Plan{root: &scanOperator{table: table, open: func(ctx context.Context) *database.RowIterator {
+		return table.RangeIter(ctx, comparsions, -1, order)
+	}}}
+}
+
+func (s *scanOperator) Open(ctx context.Context) error {
+	s.rows = s.open(ctx)
+	return nil
+}
+
+func (s *scanOperator) Next() (database.RawRow, error) {
+	if !s.rows.Next() {
+		return nil, s.rows.Err()
+	}
+
+	return s.rows.Row(), nil
+}
+
+func (s *scanOperator) Close() {
+	if s.rows != nil {
+		s.rows.Close()
+	}
+}
+
+type rowsOperator struct {
+	rows []database.RawRow
+	next int
+}
+
+// FromRows reads the rows, such as the ones returned by Table.Range
+func FromRows(rows []database.RawRow) *Plan {
+	return &Plan{root: &rowsOperator{rows: rows}}
+}
+
+func (r *rowsOperator) Open(ctx context.Context) error {
+	r.next = 0
+	return nil
+}
+
+func (r *rowsOperator) Next() (database.RawRow, error) {
+	if r.next >= len(r.rows) {
+		return nil, nil
+	}
+
+	r.next++
+	return r.rows[r.next-1], nil
+}
+
+func (r *rowsOperator) Close() {}
@@ -0,0 +1,94 @@
+package executor
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"hash/fnv"
+	"io"
+	"os"
+)
+
+/*
+Spill files
+
+Operators holding more rows than fit in memory write some of them to temporary files, read back once the operator gets
+to them. Rows are written as records, the values the operator needs from them in a fixed order, encoded with gob, so
+their values must be of the types gob knows as interfaces: the basic ones, []byte and time.Time.
+
+Records are spread over many files by the hash of a key, the values of the grouped columns for aggregations, so that
+all the records of a key are in the same file, which holds a fraction of the keys. Files written again while they are
+read hash their keys another way, one for each level of spilling.
+*/
+
+const (
+	SPILL_PARTITIONS = 16 // Files the records spilled by an operator are spread over
+	SPILL_MAX_LEVEL  = 4  // Times records can be spilled again, after which they are kept in memory
+)
+
+type spillFile struct {
+	file    *os.File
+	writer  *bufio.Writer
+	encoder *gob.Encoder
+	decoder *gob.Decoder
+	level   int // Times its records were spilled
+}
+
+// Creates an empty spill file in dir, or in the temporary directory of the system when dir is empty
+func newSpillFile(dir string, level int) (*spillFile, error) {
+	file, err := os.CreateTemp(dir, "monvandb-spill-*")
+
+	if err != nil {
+		return nil, err
+	}
+
+	writer := bufio.NewWriter(file)
+	return &spillFile{file: file, writer: writer, encoder: gob.NewEncoder(writer), level: level}, nil
+}
+
+// Returns the file of the level the records of the key are spilled to
+func spillPartition(key string, level int) int {
+	hash := fnv.New32a()
+	hash.Write([]byte{byte(level)})
+	hash.Write([]byte(key))
+	return int(hash.Sum32() % SPILL_PARTITIONS)
+}
+
+func (s *spillFile) write(record []interface{}) error {
+	return s.encoder.Encode(record)
+}
+
+// Moves to the first record, once every one of them was written
+func (s *spillFile) rewind() error {
+	if err := s.writer.Flush(); err != nil {
+		return err
+	}
+
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	s.decoder = gob.NewDecoder(bufio.NewReader(s.file))
+	return nil
+}
+
+// Returns the next record, or nil once they are over
+func (s *spillFile) read() ([]interface{}, error) {
+	var record []interface{}
+
+	if err := s.decoder.Decode(&record); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// Closes and removes the file
+func (s *spillFile) remove() {
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
@@ -0,0 +1,312 @@
+package executor
+
+import (
+	"fmt"
+
+	database "github.com/nicolasvancan/monvandb/src/database"
+)
+
+/*
+Table joins
+
+JoinTable joins a table to the rows of a plan by the comparsions of the ON clause of the join, as lowered by the parser.
+Columns of the rows are qualified by the aliases of their tables, as From and As do, and comparsions name their tables by
+those aliases, which are the names of the tables unless they were given others. Comparsions with an empty TableName are
+of the joined table.
+
+Comparsions whose values are columns of other tables (IsOtherTable, with the alias of the other table in TableHash) join
+the table to the plan, and the others filter the rows of the table, or of the plan. Only comparsions joined by AND are
+supported, so that each of them can be checked on its own:
+
+	users := executor.From(db.Tables["users"], "u")
+
+	rows, err := users.JoinTable(executor.TableJoin{
+		Table: db.Tables["orders"],
+		Alias: "o",
+		Kind:  executor.LEFT_JOIN,
+		On:    comparsions, // o.user_id = u.id AND o.total > 10
+	}).Collect(ctx)
+
+Unless a strategy is given, the join gets the rows of the table by an indexed column, either its primary key or a column
+of Table.Indexes, compared as equal to a column of the plan, which is an index nested loop join. Without such a column,
+or in right joins, a hash join is used when a column of the table is compared as equal to a column of the plan, and a
+nested loop join otherwise.
+*/
+
+// Join strategies
+const (
+	AUTO_JOIN = iota
+	INDEX_NESTED_LOOP_JOIN
+	HASH_JOIN
+	SORT_MERGE_JOIN
+	NESTED_LOOP_JOIN
+)
+
+// TableJoin is a table joined to the rows of a plan by JoinTable
+type TableJoin struct {
+	Table    *database.Table
+	Alias    string // Qualifies the columns of the table, its name when empty
+	Kind     int    // INNER_JOIN, LEFT_JOIN or RIGHT_JOIN
+	On       []database.ColumnComparsion
+	Strategy int // AUTO_JOIN chooses the strategy by the comparsions and indexes of the table
+}
+
+// From reads every row of the table, qualifying its columns with the alias, or with the name of the table when empty
+func From(table *database.Table, alias string) *Plan {
+	if alias == "" {
+		alias = table.Name
+	}
+
+	return FullScan(table).As(alias)
+}
+
+// Comparsion of the joined rows, between two columns or a column and a value
+type joinCondition struct {
+	column    string // Qualified column compared
+	condition int
+	other     string // Qualified column the column is compared to, empty when compared to value
+	value     interface{}
+}
+
+// Reads a column of the joined rows, the right one replacing the left one
+func joinedValue(left database.RawRow, right database.RawRow, column string) interface{} {
+	if value, ok := right[column]; ok {
+		return value
+	}
+
+	return left[column]
+}
+
+func (c joinCondition) matches(left database.RawRow, right database.RawRow) bool {
+	value, other := joinedValue(left, right, c.column), c.value
+
+	if c.other != "" {
+		other = joinedValue(left, right, c.other)
+	}
+
+	if value == nil || other == nil {
+		return false
+	}
+
+	comparsion := compareValues(value, other)
+
+	switch c.condition {
+	case database.EQ:
+		return comparsion == 0
+	case database.NE:
+		return comparsion != 0
+	case database.GT:
+		return comparsion > 0
+	case database.GTE:
+		return comparsion >= 0
+	case database.LT:
+		return comparsion < 0
+	case database.LTE:
+		return comparsion <= 0
+	}
+
+	return false
+}
+
+// Column of the joined table compared as equal to a column of the plan
+type joinKey struct {
+	column string // Column of the table, not qualified
+	left   string // Qualified column of the plan
+}
+
+// Comparsions of a join, split by what they compare
+type joinComparsions struct {
+	keys     []joinKey                   // Equalities between columns of the table and of the plan
+	table    []database.ColumnComparsion // Comparsions of the table alone
+	residual []joinCondition             // Comparsions checked on the joined rows
+}
+
+// Conditions that can be checked on the joined rows
+var joinConditions = map[int]bool{
+	database.EQ:  true,
+	database.NE:  true,
+	database.GT:  true,
+	database.GTE: true,
+	database.LT:  true,
+	database.LTE: true,
+}
+
+/*
+Splits the comparsions of the join of the table named alias. Comparsions of the table alone are checked on the joined
+rows, as the residual ones, unless pushDown is set
+*/
+func splitJoinComparsions(alias string, on []database.ColumnComparsion, pushDown bool) (joinComparsions, error) {
+	split := joinComparsions{}
+
+	for _, comparsion := range on {
+		if comparsion.LayerLogicalOp != database.AND || comparsion.ParentLogicalOp != database.AND {
+			return split, fmt.Errorf("join of %s must have comparsions joined by AND", alias)
+		}
+
+		tableName, value := comparsion.TableName, comparsion.Value
+
+		if tableName == "" {
+			tableName = alias
+		}
+
+		condition := joinCondition{column: tableName + "." + comparsion.ColumnName, condition: comparsion.Condition, value: value.Value}
+
+		switch {
+		case value.IsOtherTable && value.TableHash == alias:
+			condition.other = alias + "." + value.ColumnName
+
+			if condition.condition == database.EQ && tableName != alias {
+				split.keys = append(split.keys, joinKey{column: value.ColumnName, left: condition.column})
+			}
+		case value.IsOtherTable:
+			condition.other = value.TableHash + "." + value.ColumnName
+
+			if condition.condition == database.EQ && tableName == alias {
+				split.keys = append(split.keys, joinKey{column: comparsion.ColumnName, left: condition.other})
+			}
+		case value.IsOtherColumn:
+			condition.other = tableName + "." + value.ColumnName
+		}
+
+		// Comparsions of the table alone filter its rows as Range does
+		if tableName == alias && !value.IsOtherTable && pushDown {
+			comparsion.TableName, comparsion.Id, comparsion.ParentId = alias, 0, -1
+			split.table = append(split.table, comparsion)
+			continue
+		}
+
+		if !joinConditions[condition.condition] {
+			return split, fmt.Errorf("comparsion of %s can't join %s, only =, <>, <, <=, > and >= can", condition.column, alias)
+		}
+
+		split.residual = append(split.residual, condition)
+	}
+
+	return split, nil
+}
+
+// Joins the pairs of rows matching every condition
+func matchingJoinConditions(conditions []joinCondition) func(left database.RawRow, right database.RawRow) bool {
+	if len(conditions) == 0 {
+		return nil
+	}
+
+	return func(left database.RawRow, right database.RawRow) bool {
+		for _, condition := range conditions {
+			if !condition.matches(left, right) {
+				return false
+			}
+		}
+
+		return true
+	}
+}
+
+// Returns the first key whose column is indexed in the table, or -1
+func indexedJoinKey(table *database.Table, keys []joinKey) int {
+	for i, key := range keys {
+		if table.IsColumnIndexed(key.column) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Chooses the strategy of the join and the key it uses, which is -1 for nested loop joins
+func chooseJoinStrategy(join TableJoin, keys []joinKey) (int, int, error) {
+	indexed := indexedJoinKey(join.Table, keys)
+
+	switch join.Strategy {
+	case AUTO_JOIN:
+		if indexed >= 0 && join.Kind != RIGHT_JOIN {
+			return INDEX_NESTED_LOOP_JOIN, indexed, nil
+		}
+
+		if len(keys) > 0 {
+			return HASH_JOIN, 0, nil
+		}
+
+		return NESTED_LOOP_JOIN, -1, nil
+	case INDEX_NESTED_LOOP_JOIN:
+		if join.Kind == RIGHT_JOIN {
+			return 0, 0, fmt.Errorf("index nested loop join of %s can't be a right join", join.Alias)
+		}
+
+		if indexed < 0 {
+			return 0, 0, fmt.Errorf("join of %s compares no indexed column of the table as equal to a column", join.Alias)
+		}
+
+		return INDEX_NESTED_LOOP_JOIN, indexed, nil
+	case HASH_JOIN, SORT_MERGE_JOIN:
+		if len(keys) == 0 {
+			return 0, 0, fmt.Errorf("join of %s compares no column of the table as equal to a column", join.Alias)
+		}
+
+		return join.Strategy, 0, nil
+	case NESTED_LOOP_JOIN:
+		return NESTED_LOOP_JOIN, -1, nil
+	}
+
+	return 0, 0, fmt.Errorf("join strategy %d does not exist", join.Strategy)
+}
+
+// JoinTable joins the table of the join to the rows of the plan, using the strategy of the join or the one it chooses
+func (p *Plan) JoinTable(join TableJoin) *Plan {
+	if join.Alias == "" {
+		join.Alias = join.Table.Name
+	}
+
+	if join.Kind != INNER_JOIN && join.Kind != LEFT_JOIN && join.Kind != RIGHT_JOIN {
+		return &Plan{err: fmt.Errorf("join kind %d does not exist", join.Kind)}
+	}
+
+	// Right joins give every row of the table, so its comparsions can't filter them out
+	split, err := splitJoinComparsions(join.Alias, join.On, join.Kind != RIGHT_JOIN)
+
+	if err != nil {
+		return &Plan{err: err}
+	}
+
+	strategy, key, err := chooseJoinStrategy(join, split.keys)
+
+	if err != nil {
+		return &Plan{err: err}
+	}
+
+	// Keys are residual comparsions as well, checking again the one used by the strategy does no harm
+	operator := &joinOperator{kind: join.Kind, matches: matchingJoinConditions(split.residual), readAll: true}
+
+	for _, column := range join.Table.Columns {
+		operator.pad = append(operator.pad, join.Alias+"."+column.Name)
+	}
+
+	var right *Plan
+
+	switch strategy {
+	case INDEX_NESTED_LOOP_JOIN:
+		indexStrategy := &indexStrategy{table: join.Table, alias: join.Alias, leftColumn: split.keys[key].left, rightColumn: split.keys[key].column}
+
+		if len(split.table) > 0 {
+			indexStrategy.filter = join.Table.Matcher(split.table)
+		}
+
+		// The table is read by the strategy, the right side gives no rows
+		operator.strategy, operator.readAll, right = indexStrategy, false, FromRows(nil)
+	case HASH_JOIN:
+		operator.strategy = &hashStrategy{leftColumn: split.keys[key].left, rightColumn: join.Alias + "." + split.keys[key].column}
+	case SORT_MERGE_JOIN:
+		operator.strategy = &sortMergeStrategy{leftColumn: split.keys[key].left, rightColumn: join.Alias + "." + split.keys[key].column}
+	default:
+		operator.strategy = &nestedLoopStrategy{}
+	}
+
+	if right == nil {
+		right = RangeScan(join.Table, split.table, database.ASC).As(join.Alias)
+	}
+
+	return p.join(right, func(left Operator, right Operator) Operator {
+		operator.binaryOperator = binaryOperator{left, right}
+		return operator
+	})
+}
@@ -0,0 +1,183 @@
+package executor
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+/*
+Values
+
+Rows carry the Go values of their columns (see the column types of the database package), so operators comparing
+them can't rely on their types: columns of different tables, or values converted by projections, may hold numbers of
+different types. Numbers are compared by their values, whatever their types. Values of different kinds are sorted by
+kind, with nulls first, followed by bools, numbers, strings, timestamps and blobs.
+*/
+
+const (
+	kindNull = iota
+	kindBool
+	kindInt
+	kindFloat
+	kindString
+	kindTime
+	kindBytes
+	kindOther
+)
+
+// Returns the kind of the value, along with the value as int64, float64, string, bool, time.Time or []byte
+func normalizeValue(value interface{}) (int, interface{}) {
+	if value == nil {
+		return kindNull, nil
+	}
+
+	switch v := value.(type) {
+	case bool:
+		return kindBool, v
+	case string:
+		return kindString, v
+	case []byte:
+		return kindBytes, v
+	case time.Time:
+		return kindTime, v
+	}
+
+	v := reflect.ValueOf(value)
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return kindInt, v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() <= math.MaxInt64 {
+			return kindInt, int64(v.Uint())
+		}
+
+		return kindFloat, float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return kindFloat, v.Float()
+	}
+
+	return kindOther, value
+}
+
+// Rank of the kind among the others, where numbers of any type share the same
+func rankOfKind(kind int) int {
+	if kind == kindFloat {
+		return kindInt
+	}
+
+	return kind
+}
+
+func toFloat(kind int, value interface{}) float64 {
+	if kind == kindInt {
+		return float64(value.(int64))
+	}
+
+	return value.(float64)
+}
+
+// Compares two values, returning -1, 0 or 1. Every pair of values can be compared
+func compareValues(a interface{}, b interface{}) int {
+	kindA, valueA := normalizeValue(a)
+	kindB, valueB := normalizeValue(b)
+
+	if rankA, rankB := rankOfKind(kindA), rankOfKind(kindB); rankA != rankB {
+		return cmp.Compare(rankA, rankB)
+	}
+
+	switch kindA {
+	case kindNull:
+		return 0
+	case kindBool:
+		return cmp.Compare(boolToInt(valueA.(bool)), boolToInt(valueB.(bool)))
+	case kindInt, kindFloat:
+		if kindA == kindInt && kindB == kindInt {
+			return cmp.Compare(valueA.(int64), valueB.(int64))
+		}
+
+		return cmp.Compare(toFloat(kindA, valueA), toFloat(kindB, valueB))
+	case kindString:
+		return cmp.Compare(valueA.(string), valueB.(string))
+	case kindTime:
+		return valueA.(time.Time).Compare(valueB.(time.Time))
+	case kindBytes:
+		return bytes.Compare(valueA.([]byte), valueB.([]byte))
+	}
+
+	// Values of other types are sorted by their types, and then by their Go syntax representations
+	if reflect.DeepEqual(a, b) {
+		return 0
+	}
+
+	if typeA, typeB := reflect.TypeOf(a).String(), reflect.TypeOf(b).String(); typeA != typeB {
+		return cmp.Compare(typeA, typeB)
+	}
+
+	return cmp.Compare(fmt.Sprintf("%#v", a), fmt.Sprintf("%#v", b))
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// Keys of timestamps and blobs, which are not equal to numbers and strings
+type timeKey int64
+type bytesKey string
+
+/*
+Returns a comparable key of the value, the same for values compareValues finds equal, so that they can be used as keys
+of maps. Null values have no key, since they are never equal to other values in joins
+*/
+func hashKey(value interface{}) (interface{}, bool) {
+	kind, normalized := normalizeValue(value)
+
+	switch kind {
+	case kindNull:
+		return nil, false
+	case kindFloat:
+		// Floats holding integers are equal to the integers
+		if f := normalized.(float64); f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+			return int64(f), true
+		}
+	case kindTime:
+		return timeKey(normalized.(time.Time).UnixNano()), true
+	case kindBytes:
+		return bytesKey(normalized.([]byte)), true
+	case kindOther:
+		if !reflect.TypeOf(normalized).Comparable() {
+			return nil, false
+		}
+	}
+
+	return normalized, true
+}
+
+/*
+Returns a key of many values. When any of them is null there is no key, unless nulls are equal, as they are for
+groups
+*/
+func hashKeys(values []interface{}, nullsEqual bool) (string, bool) {
+	var key strings.Builder
+
+	for _, value := range values {
+		k, ok := hashKey(value)
+
+		if !ok && (value != nil || !nullsEqual) {
+			return "", false
+		}
+
+		fmt.Fprintf(&key, "%#v\x00", k)
+	}
+
+	return key.String(), true
+}
@@ -0,0 +1,210 @@
+package files
+
+import (
+	"container/list"
+	"fmt"
+	"sort"
+)
+
+/*
+Buffer pool
+
+Pages of a DataFile are kept in memory by the buffer pool, so the bTree doesn't read the file every time it needs a
+node. The pool holds at most capacity pages. When it's full, the least recently used page that is not pinned is
+evicted, being written to the file first if it's dirty.
+
+Committed pages are put in the pool as dirty pages, and they are only written to the file when evicted or when the
+pool is flushed at checkpoints. Since they are already in the log, a crash before that loses nothing.
+*/
+
+const DEFAULT_BUFFER_POOL_SIZE = 1024 // Pages
+
+type BufferPoolStats struct {
+	Hits      uint64 // Pages found in the pool
+	Misses    uint64 // Pages read from the file
+	Evictions uint64 // Pages removed to make room for others
+	Writes    uint64 // Dirty pages written to the file
+	Pages     int    // Pages in the pool
+	Dirty     int    // Pages in the pool not written to the file yet
+}
+
+type bufferFrame struct {
+	page  uint64
+	data  []byte
+	pins  int
+	dirty bool
+	elem  *list.Element // Position in the lru list
+}
+
+type BufferPool struct {
+	capacity int
+	frames   map[uint64]*bufferFrame
+	lru      *list.List // Most recently used frames first
+	read     func(page uint64) ([]byte, error)
+	write    func(page uint64, data []byte) error
+	stats    BufferPoolStats
+}
+
+/*
+Creates a buffer pool holding at most capacity pages. Pages that are not in the pool are loaded with read, and dirty
+pages are written back with write
+*/
+func NewBufferPool(
+	capacity int,
+	read func(page uint64) ([]byte, error),
+	write func(page uint64, data []byte) error,
+) *BufferPool {
+	if capacity <= 0 {
+		capacity = DEFAULT_BUFFER_POOL_SIZE
+	}
+
+	return &BufferPool{
+		capacity: capacity,
+		frames:   make(map[uint64]*bufferFrame),
+		lru:      list.New(),
+		read:     read,
+		write:    write,
+	}
+}
+
+/*
+Returns the content of the page, loading it if it's not in the pool. The page can't be evicted until Unpin is
+called, and the returned slice is only valid until then
+*/
+func (b *BufferPool) Pin(page uint64) ([]byte, error) {
+	if frame, ok := b.frames[page]; ok {
+		b.stats.Hits++
+		frame.pins++
+		b.lru.MoveToFront(frame.elem)
+		return frame.data, nil
+	}
+
+	b.stats.Misses++
+	data, err := b.read(page)
+
+	if err != nil {
+		return nil, err
+	}
+
+	frame, err := b.newFrame(page, data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	frame.pins++
+	return frame.data, nil
+}
+
+// Releases a page pinned by Pin. Dirty must be true when the page content was changed
+func (b *BufferPool) Unpin(page uint64, dirty bool) {
+	frame, ok := b.frames[page]
+
+	if !ok || frame.pins == 0 {
+		panic(fmt.Sprintf("page %d is not pinned", page))
+	}
+
+	frame.pins--
+	frame.dirty = frame.dirty || dirty
+}
+
+// Replaces the content of a page, which is written to the file later
+func (b *BufferPool) Put(page uint64, data []byte) error {
+	frame, ok := b.frames[page]
+
+	if !ok {
+		var err error
+		if frame, err = b.newFrame(page, data); err != nil {
+			return err
+		}
+	} else {
+		copy(frame.data, data)
+		b.lru.MoveToFront(frame.elem)
+	}
+
+	frame.dirty = true
+	return nil
+}
+
+// Writes every dirty page to the file, in page order. Pages are kept in the pool
+func (b *BufferPool) Flush() error {
+	dirty := make([]uint64, 0)
+	for page, frame := range b.frames {
+		if frame.dirty {
+			dirty = append(dirty, page)
+		}
+	}
+
+	sort.Slice(dirty, func(i, j int) bool { return dirty[i] < dirty[j] })
+
+	for _, page := range dirty {
+		if err := b.writeFrame(b.frames[page]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *BufferPool) Stats() BufferPoolStats {
+	stats := b.stats
+	stats.Pages = len(b.frames)
+
+	for _, frame := range b.frames {
+		if frame.dirty {
+			stats.Dirty++
+		}
+	}
+
+	return stats
+}
+
+func (b *BufferPool) newFrame(page uint64, data []byte) (*bufferFrame, error) {
+	if len(b.frames) >= b.capacity {
+		if err := b.evict(); err != nil {
+			return nil, err
+		}
+	}
+
+	frame := &bufferFrame{page: page, data: copyPage(data)}
+	frame.elem = b.lru.PushFront(frame)
+	b.frames[page] = frame
+
+	return frame, nil
+}
+
+// Removes the least recently used page that is not pinned
+func (b *BufferPool) evict() error {
+	for elem := b.lru.Back(); elem != nil; elem = elem.Prev() {
+		frame := elem.Value.(*bufferFrame)
+
+		if frame.pins > 0 {
+			continue
+		}
+
+		if err := b.writeFrame(frame); err != nil {
+			return err
+		}
+
+		b.lru.Remove(elem)
+		delete(b.frames, frame.page)
+		b.stats.Evictions++
+		return nil
+	}
+
+	return fmt.Errorf("every page of the buffer pool is pinned")
+}
+
+func (b *BufferPool) writeFrame(frame *bufferFrame) error {
+	if !frame.dirty {
+		return nil
+	}
+
+	if err := b.write(frame.page, frame.data); err != nil {
+		return err
+	}
+
+	frame.dirty = false
+	b.stats.Writes++
+	return nil
+}
@@ -1,15 +1,74 @@
 package files
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"sync"
 
 	"github.com/nicolasvancan/monvandb/src/btree"
 )
 
 type DataFile struct {
-	path  string
-	bTree *btree.BTree
-	fp    *os.File
+	path            string
+	bTree           *btree.BTree
+	fp              *os.File
+	wal             *wal
+	pool            *BufferPool       // Nil when pages are served by the mmap pager
+	mmap            *mmapPager        // Nil unless the DataFile was opened with Mmap
+	pending         map[uint64][]byte // Pages changed by the running operation, put in the pager on commit
+	pages           uint64            // Number of pages, including the ones not committed yet
+	committedPages  uint64            // Number of pages after the last commit
+	committedHeader []byte            // Header page as it was after the last commit
+	tx              *FileTx           // Running transaction, nil when there is none
+	prepared        bool              // Whether the running transaction was prepared and waits for its coordinator
+	batchErr        error             // Error found by an operation of the running batch, which must be discarded
+	readOnly        bool              // Whether the DataFile was opened with ReadOnly
+
+	// Operations of many goroutines run alongside each other, see runOperation
+	latches  *btree.Latches    // Latches of the tree pages, see btree.Latches
+	txGuard  *btree.LatchGuard // Structure latch held by the running transaction, so that it runs alone
+	batchMu  sync.Mutex        // Guards the running transaction, whose operations run one at a time
+	closed   bool              // Whether Close was called, guarded by batchMu
+	commitMu sync.Mutex        // Keeps the log and the pager in the same commit order
+	applied  *sync.Cond        // Signaled on commitMu whenever a commit is put in the pager
+	logged   uint64            // Commits written to the log, guarded by commitMu
+	inPager  uint64            // Commits put in the pager, in the order they were logged, guarded by commitMu
+
+	// Snapshots are read by other goroutines, so everything they share with writers is guarded by mu
+	mu        sync.Mutex
+	seq       uint64                   // Number of commits since the DataFile was opened
+	snapshots map[uint64]int           // Number of open snapshots by the seq they read
+	versions  map[uint64][]pageVersion // Images of pages replaced by commits, kept for open snapshots
+}
+
+/*
+FileTx is the handle of a transaction started by Begin. Only operations done through it are part of the transaction,
+the ones done through the DataFile, by any goroutine, wait until it's committed or rolled back
+*/
+type FileTx struct {
+	dataFile *DataFile
+}
+
+var ErrFileTxDone = errors.New("the transaction of the data file has already been committed or rolled back")
+var ErrDataFileClosed = errors.New("the data file is closed")
+var ErrDataFileReadOnly = errors.New("the data file was opened read only")
+
+/*
+Options to open a DataFile. With Mmap, pages are served from a memory mapping of the file instead of the buffer pool.
+Get then reads pages without copying them while it holds their latches, and only copies the key values it returns
+*/
+type DataFileOptions struct {
+	BufferPoolSize int  // Maximum number of pages kept in memory, DEFAULT_BUFFER_POOL_SIZE when 0
+	Mmap           bool // Serves pages from a memory mapping of the file, the buffer pool is not used
+	// Opens the file without changing it, for inspection. The log is neither recovered nor written, so commits found in
+	// it are not seen, and writes fail with ErrDataFileReadOnly. The file must exist and can't be mapped
+	ReadOnly bool
+	// Tells whether a prepared transaction found in the log was committed by its coordinator, see TxLog. Prepared
+	// transactions are discarded when it's nil
+	IsCommitted func(txId uint64) bool
 }
 
 // Comparators
@@ -39,14 +98,35 @@ func (p *DataFile) GetBTree() *btree.BTree {
 }
 
 func OpenDataFile(path string) (*DataFile, error) {
+	return OpenDataFileWithOptions(path, DataFileOptions{})
+}
+
+func OpenDataFileWithOptions(path string, options DataFileOptions) (*DataFile, error) {
 	p := DataFile{
-		path:  path,
-		bTree: nil,
-		fp:    nil,
+		path:      path,
+		bTree:     nil,
+		fp:        nil,
+		pending:   make(map[uint64][]byte),
+		latches:   btree.NewLatches(),
+		snapshots: make(map[uint64]int),
+		versions:  make(map[uint64][]pageVersion),
 	}
 
 	p.path = path
-	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_SYNC, 0666)
+	p.applied = sync.NewCond(&p.commitMu)
+	p.readOnly = options.ReadOnly
+
+	if options.ReadOnly && options.Mmap {
+		return nil, fmt.Errorf("a read only data file can't be mapped")
+	}
+
+	flag := os.O_RDWR | os.O_CREATE
+	if options.ReadOnly {
+		flag = os.O_RDONLY
+	}
+
+	// Durability is given by the log, so pages don't need to be synced on every write
+	file, err := os.OpenFile(path, flag, 0666)
 
 	if err != nil {
 		return nil, err
@@ -54,6 +134,11 @@ func OpenDataFile(path string) (*DataFile, error) {
 
 	stat, _ := file.Stat()
 
+	if stat.Size() == 0 && options.ReadOnly {
+		file.Close()
+		return nil, fmt.Errorf("data file %s is empty", path)
+	}
+
 	// If the file is empty, create a new tree
 	if stat.Size() == 0 {
 		bTree := btree.NewTree(btree.PAGE_SIZE)
@@ -61,105 +146,825 @@ func OpenDataFile(path string) (*DataFile, error) {
 		p.bTree = bTree
 		// Write the tree to the file
 		file.WriteAt(bTree.GetBytes(), 0)
-		defer file.Sync()
+		file.Sync()
 	}
 
-	treeHeader := make([]byte, btree.PAGE_SIZE)
-	file.ReadAt(treeHeader, 0)
 	p.fp = file
+	if !options.Mmap {
+		p.pool = NewBufferPool(options.BufferPoolSize, p.readPage, p.writePage)
+	}
 
-	p.bTree = btree.LoadTree(treeHeader, btree.PAGE_SIZE)
+	// Recover every operation committed to the log and not written to the file yet
+	if !options.ReadOnly {
+		if err = p.recover(options.IsCommitted); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
 
-	err = p.loadCallbacks()
+	if err = p.loadHeader(); err != nil {
+		if p.wal != nil {
+			p.wal.close()
+		}
 
-	if err != nil {
-		return nil, err
+		file.Close()
+		return nil, fmt.Errorf("could not open data file %s: %w", path, err)
+	}
+
+	if options.Mmap {
+		if p.mmap, err = newMmapPager(file); err != nil {
+			p.wal.close()
+			file.Close()
+			return nil, err
+		}
 	}
 
+	p.setCallbacks(p.bTree, p.pending, false)
+
 	return &p, nil
 }
 
-// Get retrieves a value from the BTree
-func (p *DataFile) Get(key []byte) []btree.BTreeKeyValue {
-	return btree.BTreeGet(p.bTree, key)
+/*
+Get retrieves a value from the BTree. Returns an *btree.ErrPageCorrupted if a corrupted page is found, or an
+*btree.ErrPageRead if a page can't be read. It runs alongside operations of other goroutines, once the running
+transaction ends, but readers that must not see their commits between many reads must use a Snapshot
+*/
+func (p *DataFile) Get(key []byte) (keyValues []btree.BTreeKeyValue, err error) {
+	defer btree.RecoverPageCorrupted(&err)
+
+	guard := p.latches.Shared()
+	defer guard.Release()
+
+	// Pages are read from the mapping while they are latched, so what is returned must be copied out of it
+	keyValues = btree.ConcurrentBTreeGet(p.forkTree(nil, true), guard, key)
+	if p.mmap != nil {
+		keyValues = copyKeyValues(keyValues)
+	}
+
+	return keyValues, nil
 }
 
 // Insert inserts a key-value pair into the BTree
-func (p *DataFile) Insert(key []byte, value []byte) {
-	btree.BTreeInsert(p.bTree, key, value)
+func (p *DataFile) Insert(key []byte, value []byte) error {
+	return p.runOperation(func(tree *btree.BTree, guard *btree.LatchGuard) {
+		btree.ConcurrentBTreeInsert(tree, guard, key, value)
+	})
 }
 
 // Delete removes a key-value pair from the BTree
-func (p *DataFile) Delete(key []byte) {
-	btree.BTreeDelete(p.bTree, key)
+func (p *DataFile) Delete(key []byte) error {
+	return p.runOperation(func(tree *btree.BTree, guard *btree.LatchGuard) {
+		btree.ConcurrentBTreeDelete(tree, guard, key)
+	})
 }
 
 // Update updates a key-value pair in the BTree
-func (p *DataFile) Update(key []byte, value []byte) {
-	btree.BTreeUpdate(p.bTree, key, value)
+func (p *DataFile) Update(key []byte, value []byte) error {
+	return p.runOperation(func(tree *btree.BTree, guard *btree.LatchGuard) {
+		btree.ConcurrentBTreeUpdate(tree, guard, key, value)
+	})
+}
+
+/*
+BulkLoad fills an empty DataFile with key values sorted by their keys (see btree.BTreeBulkLoad), in one single commit.
+It's much faster than inserting them one by one
+*/
+func (p *DataFile) BulkLoad(keyValues []btree.BTreeKeyValue) error {
+	return p.Batch(func(tx *FileTx) error {
+		var loadErr error
+
+		err := tx.run(func(tree *btree.BTree, guard *btree.LatchGuard) {
+			loadErr = btree.BTreeBulkLoad(tree, keyValues)
+		})
+
+		if err != nil {
+			return err
+		}
+
+		return loadErr
+	})
+}
+
+/*
+Runs all operations done by fn through tx as one single commit, so either all of them are written or none of them.
+It's also faster than committing every operation, since the log is flushed only once. If fn returns an error, or any
+operation finds a corrupted page, every change is discarded
+*/
+func (p *DataFile) Batch(fn func(tx *FileTx) error) error {
+	tx, err := p.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+/*
+Begin starts a transaction, once the operations of other goroutines are done, waiting for the running transaction
+to end, if any. Operations done through the returned FileTx are part of it, and reads done through it see its
+changes. Returns ErrDataFileClosed once the DataFile is closed
+*/
+func (p *DataFile) Begin() (*FileTx, error) {
+	if p.readOnly {
+		return nil, ErrDataFileReadOnly
+	}
+
+	guard := p.latches.Exclusive()
+
+	p.batchMu.Lock()
+	defer p.batchMu.Unlock()
+
+	if p.closed {
+		guard.Release()
+		return nil, ErrDataFileClosed
+	}
+
+	p.tx = &FileTx{dataFile: p}
+	p.txGuard = guard
+	p.batchErr = nil
+	return p.tx, nil
+}
+
+// Insert inserts a key-value pair into the BTree as part of the transaction
+func (tx *FileTx) Insert(key []byte, value []byte) error {
+	return tx.run(func(tree *btree.BTree, guard *btree.LatchGuard) {
+		btree.ConcurrentBTreeInsert(tree, guard, key, value)
+	})
+}
+
+// Delete removes a key-value pair from the BTree as part of the transaction
+func (tx *FileTx) Delete(key []byte) error {
+	return tx.run(func(tree *btree.BTree, guard *btree.LatchGuard) {
+		btree.ConcurrentBTreeDelete(tree, guard, key)
+	})
+}
+
+// Update updates a key-value pair in the BTree as part of the transaction
+func (tx *FileTx) Update(key []byte, value []byte) error {
+	return tx.run(func(tree *btree.BTree, guard *btree.LatchGuard) {
+		btree.ConcurrentBTreeUpdate(tree, guard, key, value)
+	})
+}
+
+// Get retrieves a value from the BTree, as changed by the transaction
+func (tx *FileTx) Get(key []byte) (keyValues []btree.BTreeKeyValue, err error) {
+	if err := tx.lock(); err != nil {
+		return nil, err
+	}
+
+	defer tx.dataFile.batchMu.Unlock()
+	defer btree.RecoverPageCorrupted(&err)
+
+	return btree.BTreeGet(tx.dataFile.bTree, key), nil
+}
+
+/*
+GetIterator returns a crawler from the key, which sees the changes of the transaction. Once the transaction has ended,
+it's the one of DataFile.GetIterator
+*/
+func (tx *FileTx) GetIterator(key []byte) *btree.BTreeCrawler {
+	if err := tx.lock(); err != nil {
+		return tx.dataFile.GetIterator(key)
+	}
+
+	defer tx.dataFile.batchMu.Unlock()
+	return tx.dataFile.bTree.FindLeafForCrawling(key)
+}
+
+// Returns the tree as changed by the transaction, which only the goroutine running it may read until it ends
+func (tx *FileTx) GetBTree() *btree.BTree {
+	return tx.dataFile.bTree
+}
+
+// Returns the DataFile the transaction belongs to
+func (tx *FileTx) GetDataFile() *DataFile {
+	return tx.dataFile
 }
 
-// Get iterator
+// Commit commits the transaction, which is discarded instead if any of its operations found a corrupted page
+func (tx *FileTx) Commit() error {
+	if err := tx.lock(); err != nil {
+		return err
+	}
+
+	p := tx.dataFile
+	defer p.endTransaction()
+
+	if p.batchErr != nil {
+		p.rollback()
+		return p.batchErr
+	}
+
+	if err := p.commit(p.pending); err != nil {
+		p.rollback()
+		return err
+	}
+
+	clear(p.pending)
+	return nil
+}
+
+// Rollback discards every change of the transaction, even if it was prepared. It does nothing once it has ended
+func (tx *FileTx) Rollback() {
+	if tx.lock() != nil {
+		return
+	}
+
+	defer tx.dataFile.endTransaction()
+	tx.dataFile.rollback()
+}
+
+/*
+Prepare is the first phase of a transaction that spans many DataFiles. The changes are written to the log as part of
+the transaction txId of a TxLog, and they are kept aside until CommitPrepared or Rollback is called. If the process
+crashes before that, they are recovered only if the TxLog committed txId
+*/
+func (tx *FileTx) Prepare(txId uint64) error {
+	if err := tx.lock(); err != nil {
+		return err
+	}
+
+	p := tx.dataFile
+	err := p.batchErr
+	if err == nil && len(p.pending) > 0 {
+		p.commitMu.Lock()
+		if err = p.wal.prepare(p.pending, txId); err != nil {
+			err = fmt.Errorf("error writing log: %w", err)
+		}
+		p.commitMu.Unlock()
+	}
+
+	if err != nil {
+		p.rollback()
+		p.endTransaction()
+		return err
+	}
+
+	p.prepared = true
+	p.batchMu.Unlock()
+	return nil
+}
+
+// CommitPrepared makes the changes of a prepared transaction visible, once its TxLog committed it
+func (tx *FileTx) CommitPrepared() error {
+	if err := tx.lock(); err != nil {
+		return err
+	}
+
+	p := tx.dataFile
+	if !p.prepared {
+		p.batchMu.Unlock()
+		return fmt.Errorf("there is no prepared transaction")
+	}
+
+	defer p.endTransaction()
+
+	p.commitMu.Lock()
+	defer p.commitMu.Unlock()
+
+	err := p.apply(p.pending)
+	clear(p.pending)
+	return err
+}
+
+/*
+GetIterator returns a crawler from the key, once the running transaction ends. It holds copies of the pages found,
+and may see commits of other goroutines while crawling
+*/
 func (p *DataFile) GetIterator(key []byte) *btree.BTreeCrawler {
-	return p.bTree.FindLeafForCrawling(key)
+	guard := p.latches.Shared()
+	defer guard.Release()
+
+	return btree.ConcurrentFindLeafForCrawling(p.forkTree(nil, false), guard, key)
+}
+
+// Verify checks the whole tree, returning every problem found, including pages that can't be reached anymore
+func (p *DataFile) Verify() []error {
+	result := btree.Verify(p.bTree)
+	result.CheckUnreachablePages(p.pages)
+
+	return result.Problems
+}
+
+// Returns the buffer pool counters, useful to tune its size. They are all zero in mmap mode
+func (p *DataFile) GetBufferPoolStats() BufferPoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pool == nil {
+		return BufferPoolStats{}
+	}
+
+	return p.pool.Stats()
 }
 
-// ForceSync forces the os to flush the file to disk
+// Returns the log counters, which tell how many commits share each flush of the log to disk
+func (p *DataFile) GetWalStats() WalStats {
+	if p.readOnly {
+		return WalStats{}
+	}
+
+	return p.wal.getStats()
+}
+
+// ForceSync writes every committed page and forces the os to flush the file to disk, the log is not needed anymore after it
 func (p *DataFile) ForceSync() {
-	defer p.fp.Sync()
+	if p.readOnly {
+		return
+	}
+
+	p.commitMu.Lock()
+	defer p.commitMu.Unlock()
+	p.waitCommits()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.checkpoint()
 }
 
-// Close closes the file
+// Close closes the file, which can't be used by anyone anymore
 func (p *DataFile) Close() {
-	defer p.fp.Close()
+	p.batchMu.Lock()
+	p.closed = true
+	p.batchMu.Unlock()
+
+	p.commitMu.Lock()
+	defer p.commitMu.Unlock()
+	p.waitCommits()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Nothing was written to a read only DataFile, and it has no log open
+	if p.readOnly {
+		p.fp.Close()
+		return
+	}
+
+	p.checkpoint()
+
+	// Closing twice must not use the mapping after it's gone
+	if p.mmap != nil {
+		p.mmap.close()
+		p.mmap = nil
+	}
+
+	p.wal.close()
+	p.fp.Close()
+}
+
+/*
+Runs an operation and commits it. Operations of many goroutines run alongside each other, each one keeping the pages
+it changes to itself until they are committed, and the latches of the tree keep them from reading each other's pages
+midway (see btree.Latches). While a transaction is running, they wait for it to end, since it holds the structure
+latch.
+
+An operation that finds a corrupted page may have left the tree torn, so its changes are discarded
+*/
+func (p *DataFile) runOperation(op func(tree *btree.BTree, guard *btree.LatchGuard)) error {
+	if p.readOnly {
+		return ErrDataFileReadOnly
+	}
+
+	pending := make(map[uint64][]byte)
+	guard := p.latches.Shared()
+	// Latches are held until the changes are committed, so nobody reads them before
+	defer guard.Release()
+
+	if err := runCheckingCorruption(func() { op(p.forkTree(pending, false), guard) }); err != nil {
+		p.discard(guard)
+		return err
+	}
+
+	if err := p.commit(pending); err != nil {
+		p.discard(guard)
+		return err
+	}
+
+	return nil
+}
+
+/*
+Runs an operation of the transaction, which holds the structure latch, so operations run one at a time. An operation
+that finds a corrupted page may have left the tree torn, so the changes of the whole transaction are discarded
+*/
+func (tx *FileTx) run(op func(tree *btree.BTree, guard *btree.LatchGuard)) error {
+	if err := tx.lock(); err != nil {
+		return err
+	}
+
+	p := tx.dataFile
+	defer p.batchMu.Unlock()
+
+	if p.prepared {
+		return fmt.Errorf("the running transaction was prepared and can't be changed anymore")
+	}
+
+	err := runCheckingCorruption(func() { op(p.bTree, p.txGuard) })
+
+	if err != nil {
+		p.batchErr = err
+	}
+
+	return err
 }
 
-// loadCallbacks sets the callbacks for the BTree
-func (p *DataFile) loadCallbacks() error {
-	// Set callbacks
-	p.bTree.Set = func(node btree.TreeNode, page uint64) bool {
-		_, err := p.fp.WriteAt(node.GetBytes(), int64(page*btree.PAGE_SIZE))
+// Locks batchMu when tx is the running transaction, returning ErrFileTxDone otherwise, when nothing is held
+func (tx *FileTx) lock() error {
+	tx.dataFile.batchMu.Lock()
+
+	if tx.dataFile.tx != tx {
+		tx.dataFile.batchMu.Unlock()
+		return ErrFileTxDone
+	}
+
+	return nil
+}
+
+// Ends the running transaction, letting operations of other goroutines run again. batchMu is held and unlocked
+func (p *DataFile) endTransaction() {
+	p.tx = nil
+	p.prepared = false
+
+	if p.txGuard != nil {
+		p.txGuard.Release()
+		p.txGuard = nil
+	}
+
+	p.batchMu.Unlock()
+}
+
+// Runs op, returning the *btree.ErrPageCorrupted or *btree.ErrPageRead found by it, if any
+func runCheckingCorruption(op func()) (err error) {
+	defer btree.RecoverPageCorrupted(&err)
+
+	op()
+	return nil
+}
+
+/*
+Writes the changed pages to the log and then to the pager, which writes them to the file later. While the log is
+flushed, commits of other goroutines are written to it, so that they share the flush, and then they are put in the
+pager in the order they were logged, so both see them in the same order
+*/
+func (p *DataFile) commit(pending map[uint64][]byte) error {
+	if len(pending) == 0 {
+		return nil
+	}
+
+	p.commitMu.Lock()
+	size, err := p.wal.commit(pending)
+
+	if err != nil {
+		p.commitMu.Unlock()
+		return fmt.Errorf("error writing log: %w", err)
+	}
+
+	turn := p.logged
+	p.logged++
+	p.commitMu.Unlock()
+
+	err = p.wal.flush(size)
+
+	p.commitMu.Lock()
+	defer p.commitMu.Unlock()
+
+	for p.inPager != turn {
+		p.applied.Wait()
+	}
+
+	// Commits logged after this one go on even when it failed
+	p.inPager++
+	p.applied.Broadcast()
+
+	if err != nil {
+		return fmt.Errorf("error writing log: %w", err)
+	}
+
+	return p.apply(pending)
+}
+
+// Waits until every commit written to the log is in the pager. commitMu must be held
+func (p *DataFile) waitCommits() {
+	for p.inPager != p.logged {
+		p.applied.Wait()
+	}
+}
+
+/*
+Puts the pages of a transaction already in the log in the pager. Pages replaced are kept aside while there are
+snapshots that read them. commitMu must be held
+*/
+func (p *DataFile) apply(pending map[uint64][]byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.seq++
+
+	for _, page := range getSortedPages(pending) {
+		if err := p.keepVersion(page); err != nil {
+			return err
+		}
+
+		if err := p.putCommittedPage(page, pending[page]); err != nil {
+			return err
+		}
+	}
+
+	p.committedPages = p.pages
+	if header, ok := pending[0]; ok {
+		p.committedHeader = copyPage(header)
+	}
+
+	// The log can't be truncated while other commits wait for it to be flushed, the last of them does it
+	if p.wal.getSize() > WAL_CHECKPOINT_SIZE && p.inPager == p.logged {
+		return p.checkpoint()
+	}
+
+	return nil
+}
+
+// Discards every change of the running transaction, restoring the last committed header
+func (p *DataFile) rollback() {
+	clear(p.pending)
+	p.restoreHeader()
+}
+
+/*
+Discards what a failed operation did outside its own pages. Only operations that changed the structure of the tree,
+holding it exclusively, may have changed the header
+*/
+func (p *DataFile) discard(guard *btree.LatchGuard) {
+	if guard.IsExclusive() {
+		p.restoreHeader()
+	}
+}
+
+func (p *DataFile) restoreHeader() {
+	// GetBytes returns the header itself, so it's restored in place
+	copy(p.bTree.GetBytes(), p.committedHeader)
+	p.pages = p.committedPages
+}
+
+// Writes every committed page, flushes the file to disk and truncates the log, since it's not needed anymore
+func (p *DataFile) checkpoint() error {
+	if p.pool != nil {
+		if err := p.pool.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if p.mmap != nil {
+		if err := p.mmap.sync(); err != nil {
+			return err
+		}
+	}
+
+	if err := p.fp.Sync(); err != nil {
+		return err
+	}
+
+	return p.wal.truncate()
+}
+
+func (p *DataFile) recover(isCommitted func(txId uint64) bool) error {
+	wal, err := openWal(GetWalPath(p.path))
+
+	if err != nil {
+		return err
+	}
+
+	p.wal = wal
+
+	if err = wal.replay(p.writePages, isCommitted); err != nil {
+		wal.close()
+		return err
+	}
+
+	if err = p.checkpoint(); err != nil {
+		wal.close()
+		return err
+	}
+
+	return nil
+}
+
+/*
+Reads the header page and counts the pages of the file. Files written in another format version are rejected, and a
+header that doesn't match its checksum is reported as an *btree.ErrPageCorrupted for page 0
+*/
+func (p *DataFile) loadHeader() error {
+	header := make([]byte, btree.PAGE_SIZE)
+
+	if _, err := p.fp.ReadAt(header, 0); err == io.EOF {
+		return &btree.ErrPageCorrupted{Page: 0}
+	} else if err != nil {
+		return err
+	}
+
+	tree := btree.LoadTree(header, btree.PAGE_SIZE)
+
+	if err := tree.VerifyHeader(); err != nil {
+		return err
+	}
+
+	pages, err := p.getFilePages()
+
+	if err != nil {
+		return err
+	}
+
+	p.bTree = tree
+	p.committedHeader = copyPage(header)
+	p.pages = pages
+	p.committedPages = pages
+	return nil
+}
+
+func (p *DataFile) writePages(pages map[uint64][]byte) error {
+	for _, page := range getSortedPages(pages) {
+		if err := p.writePage(page, pages[page]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+Returns a page as it was in the last commit, from the buffer pool or from the mapping. Pages are copied, unless
+zeroCopy is set in mmap mode, when the mapping itself is returned. Commits write to the mapping, so it can only be
+used by reads holding the latch of the page, and nothing read from it may be kept once the latch is released.
+Operations can't use it either, since they change nodes before writing them
+*/
+func (p *DataFile) getCommittedPage(page uint64, zeroCopy bool) ([]byte, error) {
+	if p.mmap != nil {
+		data, err := p.mmap.page(page)
 
 		if err != nil {
-			return false
+			return nil, err
+		}
+
+		if !zeroCopy {
+			return copyPage(data), nil
 		}
 
+		return data, nil
+	}
+
+	data, err := p.pool.Pin(page)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer p.pool.Unpin(page, false)
+	return copyPage(data), nil
+}
+
+func (p *DataFile) putCommittedPage(page uint64, data []byte) error {
+	if p.mmap != nil {
+		return p.mmap.write(page, data)
+	}
+
+	return p.pool.Put(page, data)
+}
+
+/*
+Reads a page from the file for the buffer pool. Pages that don't match their checksum, or are beyond the end of the
+file, are corrupted. The header page is verified when the DataFile is opened
+*/
+func (p *DataFile) readPage(page uint64) ([]byte, error) {
+	data := make([]byte, btree.PAGE_SIZE)
+	_, err := p.fp.ReadAt(data, int64(page*btree.PAGE_SIZE))
+
+	if err == io.EOF {
+		return nil, &btree.ErrPageCorrupted{Page: page}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if page == 0 {
+		return data, nil
+	}
+
+	if err = btree.LoadTreeNode(data).VerifyChecksum(page); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (p *DataFile) writePage(page uint64, data []byte) error {
+	_, err := p.fp.WriteAt(data, int64(page*btree.PAGE_SIZE))
+	return err
+}
+
+func (p *DataFile) getFilePages() (uint64, error) {
+	stat, err := p.fp.Stat()
+
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64((stat.Size() + btree.PAGE_SIZE - 1) / btree.PAGE_SIZE), nil
+}
+
+// Returns the error the Get callback of a tree panics with when the page can't be read, see btree.RecoverPageCorrupted
+func getPageError(page uint64, err error) error {
+	var corrupted *btree.ErrPageCorrupted
+	if errors.As(err, &corrupted) {
+		return corrupted
+	}
+
+	return &btree.ErrPageRead{Page: page, Err: err}
+}
+
+func copyKeyValues(keyValues []btree.BTreeKeyValue) []btree.BTreeKeyValue {
+	copied := make([]btree.BTreeKeyValue, len(keyValues))
+	for i, keyValue := range keyValues {
+		copied[i] = btree.BTreeKeyValue{Key: bytes.Clone(keyValue.Key), Value: bytes.Clone(keyValue.Value)}
+	}
+
+	return copied
+}
+
+// Copies the page so that later changes made to the node don't change what will be written
+func copyPage(data []byte) []byte {
+	page := make([]byte, btree.PAGE_SIZE)
+	copy(page, data)
+	return page
+}
+
+// Returns a copy of the tree for one operation, sharing the header of the DataFile but keeping its own changed pages
+func (p *DataFile) forkTree(pending map[uint64][]byte, zeroCopy bool) *btree.BTree {
+	tree := *p.bTree
+	p.setCallbacks(&tree, pending, zeroCopy)
+
+	return &tree
+}
+
+/*
+setCallbacks sets the callbacks for a tree of the DataFile. Pages are kept in pending until they are committed, and
+committed pages are read through the pager, without being copied when zeroCopy is set
+*/
+func (p *DataFile) setCallbacks(tree *btree.BTree, pending map[uint64][]byte, zeroCopy bool) {
+	tree.Set = func(node btree.TreeNode, page uint64) bool {
+		pending[page] = copyPage(node.GetBytes())
 		return true
 	}
 
-	p.bTree.SetHeader = func(bTree btree.BTree) {
-		p.fp.WriteAt(bTree.GetBytes(), 0)
+	tree.SetHeader = func(bTree btree.BTree) {
+		pending[0] = copyPage(bTree.GetBytes())
 	}
 
-	p.bTree.Get = func(page uint64) btree.TreeNode {
-		data := make([]byte, btree.PAGE_SIZE)
-		_, err := p.fp.ReadAt(data, int64(page*btree.PAGE_SIZE))
+	tree.Get = func(page uint64) btree.TreeNode {
+		if data, ok := pending[page]; ok {
+			return *btree.LoadTreeNode(copyPage(data))
+		}
+
+		p.mu.Lock()
+		data, err := p.getCommittedPage(page, zeroCopy)
+		p.mu.Unlock()
 
 		if err != nil {
-			panic(err)
+			panic(getPageError(page, err))
 		}
 
 		return *btree.LoadTreeNode(data)
 	}
 
-	p.bTree.Del = nil
-
-	p.bTree.New = func(node btree.TreeNode) uint64 {
+	// Released pages are pushed to the free list, stored from the header page
+	tree.Del = func(page uint64) {
+		freePage := btree.NewFreePage(tree.GetFreeListHead())
+		pending[page] = copyPage(freePage.GetBytes())
+		tree.SetFreeListHead(page)
+		tree.SetHeader(*tree)
+	}
 
-		// get Stat from file
-		fileInfo, err := p.fp.Stat()
+	tree.New = func(node btree.TreeNode) uint64 {
+		// Reuse a released page before growing the file
+		if freePage := tree.GetFreeListHead(); freePage != 0 {
+			next := tree.Get(freePage)
+			tree.SetFreeListHead(next.GetFreePageNext())
+			tree.SetHeader(*tree)
+			pending[freePage] = copyPage(node.GetBytes())
 
-		if err != nil {
-			panic(err)
+			return freePage
 		}
 
 		// Without header
-		lastPage := (fileInfo.Size() / btree.PAGE_SIZE)
-
-		p.fp.WriteAt(node.GetBytes(), fileInfo.Size())
+		lastPage := p.pages
+		p.pages++
+		pending[lastPage] = copyPage(node.GetBytes())
 
-		return uint64(lastPage)
+		return lastPage
 	}
-
-	return nil
 }
@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"os"
 	"syscall"
+	"unsafe"
+
+	"github.com/nicolasvancan/monvandb/src/btree"
 )
 
 /* Map page */
@@ -35,3 +38,145 @@ func MmapPage(f *os.File, page uint64, pageSize uint64) (int, []byte, error) {
 
 	return int(fileInfo.Size()), pageData, nil
 }
+
+/*
+Mmap pager
+
+Instead of reading pages into the buffer pool, the whole file is mapped into memory and pages are served straight
+from the mapping, which is faster for read heavy workloads such as scans. Committed pages are copied into the mapping
+and flushed to disk with msync.
+
+The file is mapped in chunks of MMAP_CHUNK_PAGES pages, each one a mapping of its own, and a new chunk is mapped only
+when the file grows beyond the last one. Chunks are never mapped again, so pages keep their address until the pager
+is closed
+*/
+
+const MMAP_CHUNK_PAGES = 1024
+
+const mmapChunkSize = MMAP_CHUNK_PAGES * btree.PAGE_SIZE
+
+type mmapPager struct {
+	fp       *os.File
+	chunks   [][]byte        // Mappings of the chunks of the file, in order
+	size     int64           // File size
+	verified map[uint64]bool // Pages whose checksum was already verified
+}
+
+func newMmapPager(f *os.File) (*mmapPager, error) {
+	fileInfo, err := f.Stat()
+
+	if err != nil {
+		return nil, fmt.Errorf("stat %w", err)
+	}
+
+	m := &mmapPager{
+		fp:       f,
+		chunks:   make([][]byte, 0),
+		size:     fileInfo.Size(),
+		verified: make(map[uint64]bool),
+	}
+
+	if err = m.grow(m.size); err != nil {
+		m.close()
+		return nil, err
+	}
+
+	return m, nil
+}
+
+/*
+Returns the page straight from the mapping. The checksum of a page is verified the first time it's read, pages
+beyond the end of the file are corrupted
+*/
+func (m *mmapPager) page(page uint64) ([]byte, error) {
+	if int64((page+1)*btree.PAGE_SIZE) > m.size {
+		return nil, &btree.ErrPageCorrupted{Page: page}
+	}
+
+	data := m.slot(page)
+
+	if page != 0 && !m.verified[page] {
+		if err := btree.LoadTreeNode(data).VerifyChecksum(page); err != nil {
+			return nil, err
+		}
+
+		m.verified[page] = true
+	}
+
+	return data, nil
+}
+
+// Copies the page into the mapping, growing the file when needed
+func (m *mmapPager) write(page uint64, data []byte) error {
+	end := int64((page + 1) * btree.PAGE_SIZE)
+
+	if end > m.size {
+		if err := m.fp.Truncate(end); err != nil {
+			return err
+		}
+
+		m.size = end
+	}
+
+	if err := m.grow(end); err != nil {
+		return err
+	}
+
+	copy(m.slot(page), data)
+	m.verified[page] = true
+	return nil
+}
+
+// Flushes every page changed in the mapping to disk
+func (m *mmapPager) sync() error {
+	for _, chunk := range m.chunks {
+		_, _, errno := syscall.Syscall(
+			syscall.SYS_MSYNC,
+			uintptr(unsafe.Pointer(&chunk[0])),
+			uintptr(len(chunk)),
+			syscall.MS_SYNC)
+
+		if errno != 0 {
+			return fmt.Errorf("msync Error %w", errno)
+		}
+	}
+
+	return nil
+}
+
+func (m *mmapPager) close() error {
+	for _, chunk := range m.chunks {
+		if err := syscall.Munmap(chunk); err != nil {
+			return err
+		}
+	}
+
+	m.chunks = nil
+	return nil
+}
+
+// Returns the bytes of the page in the chunk mapping it, which must have been mapped already
+func (m *mmapPager) slot(page uint64) []byte {
+	offset := (page % MMAP_CHUNK_PAGES) * btree.PAGE_SIZE
+	return m.chunks[page/MMAP_CHUNK_PAGES][offset : offset+btree.PAGE_SIZE]
+}
+
+// Maps the chunks needed to reach size bytes that aren't mapped yet
+func (m *mmapPager) grow(size int64) error {
+	for int64(len(m.chunks))*mmapChunkSize < size {
+		chunk, err := syscall.Mmap(
+			int(m.fp.Fd()),
+			int64(len(m.chunks))*mmapChunkSize,
+			mmapChunkSize,
+			syscall.PROT_READ|syscall.PROT_WRITE,
+			syscall.MAP_SHARED)
+
+		if err != nil {
+			return fmt.Errorf("mmap Error %w", err)
+		}
+
+		m.chunks = append(m.chunks, chunk)
+	}
+
+	return nil
+}
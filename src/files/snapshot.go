@@ -0,0 +1,199 @@
+package files
+
+import (
+	"github.com/nicolasvancan/monvandb/src/btree"
+)
+
+/*
+Snapshots
+
+A snapshot is a read only view of a DataFile as it was in the last commit before it was taken. Writers keep changing
+the DataFile meanwhile, and the snapshot doesn't see any of their changes, so crawlers over it never find nodes that
+were split or merged after they started.
+
+Commits never destroy what a snapshot can read. Before a page is replaced, its committed image is kept aside as a
+version of the page, tagged with the commit that replaced it, whenever there is an open snapshot that may read it.
+A snapshot reads the oldest version replaced after it was taken, or the page itself if there is none. Versions are
+released once every snapshot that could read them is closed.
+
+Snapshots can be used by any goroutine, but each one of them by one goroutine at a time. They must be closed,
+otherwise the versions they hold are never released
+*/
+
+type pageVersion struct {
+	until uint64 // seq of the commit that replaced the image
+	data  []byte
+}
+
+type Snapshot struct {
+	dataFile *DataFile
+	bTree    *btree.BTree
+	seq      uint64 // Commits seen by the snapshot
+	closed   bool
+}
+
+// Snapshot returns a read only view of the last commit, which must be closed once it's not needed anymore
+func (p *DataFile) Snapshot() *Snapshot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := &Snapshot{
+		dataFile: p,
+		bTree:    btree.LoadTree(copyPage(p.committedHeader), btree.PAGE_SIZE),
+		seq:      p.seq,
+	}
+
+	p.snapshots[s.seq]++
+
+	s.bTree.Get = func(page uint64) btree.TreeNode {
+		data, err := p.getSnapshotPage(page, s.seq)
+
+		if err != nil {
+			panic(getPageError(page, err))
+		}
+
+		return *btree.LoadTreeNode(data)
+	}
+
+	s.bTree.Set = func(node btree.TreeNode, page uint64) bool {
+		panic("snapshots are read only")
+	}
+
+	s.bTree.SetHeader = func(bTree btree.BTree) {
+		panic("snapshots are read only")
+	}
+
+	s.bTree.New = func(node btree.TreeNode) uint64 {
+		panic("snapshots are read only")
+	}
+
+	s.bTree.Del = func(page uint64) {
+		panic("snapshots are read only")
+	}
+
+	return s
+}
+
+// GetBTree returns the tree seen by the snapshot, which can be crawled but not changed
+func (s *Snapshot) GetBTree() *btree.BTree {
+	return s.bTree
+}
+
+// Get retrieves a value as it was when the snapshot was taken. Returns an *btree.ErrPageCorrupted if a corrupted page is found
+func (s *Snapshot) Get(key []byte) (keyValues []btree.BTreeKeyValue, err error) {
+	defer btree.RecoverPageCorrupted(&err)
+
+	return btree.BTreeGet(s.bTree, key), nil
+}
+
+// Releases the versions kept for the snapshot. Closing it twice does nothing
+func (s *Snapshot) Close() {
+	p := s.dataFile
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.closed = true
+	p.snapshots[s.seq]--
+
+	if p.snapshots[s.seq] == 0 {
+		delete(p.snapshots, s.seq)
+	}
+
+	p.releaseVersions()
+}
+
+// Returns the number of page versions kept for open snapshots
+func (p *DataFile) GetPageVersions() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := 0
+	for _, versions := range p.versions {
+		n += len(versions)
+	}
+
+	return n
+}
+
+func (p *DataFile) getSnapshotPage(page uint64, seq uint64) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Versions are sorted by the commit that replaced them
+	for _, version := range p.versions[page] {
+		if version.until > seq {
+			return version.data, nil
+		}
+	}
+
+	return p.getCommittedPage(page, false)
+}
+
+/*
+Keeps the committed image of the page aside before the running commit replaces it, if any open snapshot may read it.
+The header is not needed, since snapshots have their own copy
+*/
+func (p *DataFile) keepVersion(page uint64) error {
+	if page == 0 || page >= p.committedPages {
+		return nil
+	}
+
+	// The committed image was written by the commit that replaced the last version
+	var since uint64 = 0
+	if versions := p.versions[page]; len(versions) > 0 {
+		since = versions[len(versions)-1].until
+	}
+
+	if !p.hasSnapshotSince(since) {
+		return nil
+	}
+
+	data, err := p.getCommittedPage(page, false)
+
+	if err != nil {
+		return err
+	}
+
+	p.versions[page] = append(p.versions[page], pageVersion{until: p.seq, data: data})
+	return nil
+}
+
+func (p *DataFile) hasSnapshotSince(seq uint64) bool {
+	for snapshotSeq := range p.snapshots {
+		if snapshotSeq >= seq {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Drops every version that no open snapshot can read anymore
+func (p *DataFile) releaseVersions() {
+	if len(p.snapshots) == 0 {
+		p.versions = make(map[uint64][]pageVersion)
+		return
+	}
+
+	oldest := p.seq
+	for seq := range p.snapshots {
+		oldest = min(oldest, seq)
+	}
+
+	for page, versions := range p.versions {
+		i := 0
+		for i < len(versions) && versions[i].until <= oldest {
+			i++
+		}
+
+		if i == len(versions) {
+			delete(p.versions, page)
+		} else {
+			p.versions[page] = versions[i:]
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package files
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+/*
+Transaction log (coordinator)
+
+A transaction that changes many DataFiles, like a table and its indexes, can't rely on the log of each one of them,
+since a crash between two commits would leave some DataFiles changed and others not. Those transactions are
+committed in two phases:
+
+ 1. Every DataFile writes its pages to its own log as prepared, tagged with the transaction id given by the TxLog
+ 2. The TxLog writes the commit record of the transaction and flushes it to disk
+
+The transaction is committed once the second step is done. When a DataFile is recovered, its prepared transactions
+are written again only if the TxLog has their commit record, otherwise they are discarded.
+
+Records
+
+	Commit | txId 8B | crc 4B
+*/
+
+const TX_LOG_RECORD_SIZE = WAL_TX_ID_LEN + WAL_CRC_LEN
+
+// TxLog is shared by every transaction of a database, so its methods can be called by many goroutines
+type TxLog struct {
+	mu        sync.Mutex
+	fp        *os.File
+	lastTxId  uint64          // Last transaction id given
+	committed map[uint64]bool // Transactions found in the log
+	size      int64           // Log size in bytes
+}
+
+func OpenTxLog(path string) (*TxLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+
+	if err != nil {
+		return nil, err
+	}
+
+	l := &TxLog{fp: file, committed: make(map[uint64]bool)}
+
+	if err = l.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Returns a new transaction id, greater than any other found in the log
+func (l *TxLog) Next() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lastTxId++
+	return l.lastTxId
+}
+
+// Writes the commit record of the transaction and flushes it to disk. Once it returns without error, it's committed
+func (l *TxLog) Commit(txId uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	record := binary.LittleEndian.AppendUint64(make([]byte, 0, TX_LOG_RECORD_SIZE), txId)
+	record = binary.LittleEndian.AppendUint32(record, crc32.Checksum(record, walCrcTable))
+
+	if _, err := l.fp.WriteAt(record, l.size); err != nil {
+		return err
+	}
+
+	if err := l.fp.Sync(); err != nil {
+		return err
+	}
+
+	l.size += TX_LOG_RECORD_SIZE
+	l.committed[txId] = true
+	return nil
+}
+
+func (l *TxLog) IsCommitted(txId uint64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.committed[txId]
+}
+
+/*
+Discards every commit record but the last one, which keeps new ids greater than the old ones. Must only be called
+once every DataFile that took part in the transactions of the log was recovered
+*/
+func (l *TxLog) Compact() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.size <= TX_LOG_RECORD_SIZE {
+		return nil
+	}
+
+	last := make([]byte, TX_LOG_RECORD_SIZE)
+	if _, err := l.fp.ReadAt(last, l.size-TX_LOG_RECORD_SIZE); err != nil {
+		return err
+	}
+
+	if _, err := l.fp.WriteAt(last, 0); err != nil {
+		return err
+	}
+
+	if err := l.fp.Truncate(TX_LOG_RECORD_SIZE); err != nil {
+		return err
+	}
+
+	l.size = TX_LOG_RECORD_SIZE
+	l.committed = map[uint64]bool{binary.LittleEndian.Uint64(last): true}
+	return l.fp.Sync()
+}
+
+func (l *TxLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.fp.Close()
+}
+
+// Reads every commit record, stopping at the first incomplete or corrupted one, which was never committed
+func (l *TxLog) load() error {
+	stat, err := l.fp.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	data := make([]byte, stat.Size())
+	if _, err := l.fp.ReadAt(data, 0); err != nil && err != io.EOF {
+		return err
+	}
+
+	for len(data) >= TX_LOG_RECORD_SIZE && isWalRecordValid(data[:TX_LOG_RECORD_SIZE]) {
+		txId := binary.LittleEndian.Uint64(data)
+		l.committed[txId] = true
+		l.lastTxId = max(l.lastTxId, txId)
+		l.size += TX_LOG_RECORD_SIZE
+		data = data[TX_LOG_RECORD_SIZE:]
+	}
+
+	return nil
+}
@@ -0,0 +1,322 @@
+package files
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/nicolasvancan/monvandb/src/btree"
+	"github.com/nicolasvancan/monvandb/src/utils"
+)
+
+/*
+Write ahead log (WAL)
+
+Every operation done in a DataFile (Insert, Delete, Update) may change a lot of pages, for instance, when a leaf is
+split, its parent, the new leaves, the free list and the header are all changed. If the process crashes in the middle
+of those writes, the tree would be left torn. To avoid it, pages changed by an operation are first written to the
+log, followed by a commit record, and only after the log is flushed to disk the pages are written to the DataFile.
+
+Commits of many goroutines share their flushes (group commit). Records are written to the log one commit at a time,
+without flushing it, and then the first committer to flush it becomes the leader, flushing every record written so
+far with one single fsync. Committers whose records were written meanwhile wait for it, and once it's done, those not
+covered by it elect another leader, which flushes all their records at once. See WalStats to know how many commits
+share each flush.
+
+When a DataFile is opened, every committed transaction found in the log is written again to the DataFile. Records
+after the last commit, or records that don't match their checksum, belong to a transaction that was never committed
+and are ignored. After that, the log is truncated, which is also done whenever the log gets bigger than
+WAL_CHECKPOINT_SIZE (checkpoint), after flushing the DataFile to disk.
+
+Transactions spanning many DataFiles end with a prepare record instead of a commit record. It carries the id given by
+the coordinator of the transaction (see TxLog), which is the one that knows whether it was committed, so prepared
+transactions are only written again when the coordinator says so.
+
+Records
+
+	Page    | type 1B | txId 8B | page 8B | page image PAGE_SIZE | crc 4B
+	Commit  | type 1B | txId 8B | nPages 4B | crc 4B
+	Prepare | type 1B | txId 8B | nPages 4B | coordinator txId 8B | crc 4B
+
+The crc is calculated over all the previous bytes of the record
+*/
+
+const (
+	WAL_RECORD_PAGE = iota + 1
+	WAL_RECORD_COMMIT
+	WAL_RECORD_PREPARE
+)
+
+const (
+	WAL_RECORD_TYPE_LEN     = 1
+	WAL_TX_ID_LEN           = 8
+	WAL_PAGE_LEN            = 8
+	WAL_N_PAGES_LEN         = 4
+	WAL_CRC_LEN             = 4
+	WAL_PAGE_RECORD_SIZE    = WAL_RECORD_TYPE_LEN + WAL_TX_ID_LEN + WAL_PAGE_LEN + btree.PAGE_SIZE + WAL_CRC_LEN
+	WAL_COMMIT_RECORD_SIZE  = WAL_RECORD_TYPE_LEN + WAL_TX_ID_LEN + WAL_N_PAGES_LEN + WAL_CRC_LEN
+	WAL_PREPARE_RECORD_SIZE = WAL_COMMIT_RECORD_SIZE + WAL_TX_ID_LEN
+	WAL_CHECKPOINT_SIZE     = 4 * 1024 * 1024
+)
+
+var walCrcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type wal struct {
+	fp   *os.File
+	txId uint64 // Last transaction id written
+	size int64  // Log size in bytes, guarded by mu, since flush reads it while the next records are written
+
+	// Group commit
+	mu       sync.Mutex
+	flushed  int64      // Log size already flushed to disk
+	flushing bool       // Whether a leader is flushing the log
+	done     *sync.Cond // Signaled by leaders once they are done
+	stats    WalStats
+}
+
+// Counters of the log, see GetWalStats
+type WalStats struct {
+	Commits uint64 // Transactions written to the log
+	Flushes uint64 // Times the log was flushed to disk for them
+}
+
+// Returns the path of the log used by the DataFile stored at the given path
+func GetWalPath(dataFilePath string) string {
+	return dataFilePath + "." + utils.TABLE_LOGS_FIILE
+}
+
+func openWal(path string) (*wal, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := file.Stat()
+
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	w := &wal{fp: file, size: stat.Size(), flushed: stat.Size()}
+	w.done = sync.NewCond(&w.mu)
+	return w, nil
+}
+
+/*
+Writes all page images followed by a commit record, without flushing the log, and returns the log size with them.
+The transaction is durable once flush is called with that size. Records are written one transaction at a time
+*/
+func (w *wal) commit(pages map[uint64][]byte) (int64, error) {
+	return w.write(pages, WAL_RECORD_COMMIT, 0)
+}
+
+/*
+Same as commit, but the transaction is part of the coordinator transaction coordinatorTxId, and it's only recovered
+if the coordinator committed it. The log is flushed before it returns
+*/
+func (w *wal) prepare(pages map[uint64][]byte, coordinatorTxId uint64) error {
+	size, err := w.write(pages, WAL_RECORD_PREPARE, coordinatorTxId)
+
+	if err != nil {
+		return err
+	}
+
+	return w.flush(size)
+}
+
+func (w *wal) write(pages map[uint64][]byte, recordType byte, coordinatorTxId uint64) (int64, error) {
+	w.txId++
+	record := make([]byte, 0, len(pages)*WAL_PAGE_RECORD_SIZE+WAL_COMMIT_RECORD_SIZE)
+
+	for _, page := range getSortedPages(pages) {
+		start := len(record)
+		record = append(record, WAL_RECORD_PAGE)
+		record = binary.LittleEndian.AppendUint64(record, w.txId)
+		record = binary.LittleEndian.AppendUint64(record, page)
+		record = append(record, pages[page]...)
+		record = binary.LittleEndian.AppendUint32(record, crc32.Checksum(record[start:], walCrcTable))
+	}
+
+	start := len(record)
+	record = append(record, recordType)
+	record = binary.LittleEndian.AppendUint64(record, w.txId)
+	record = binary.LittleEndian.AppendUint32(record, uint32(len(pages)))
+
+	if recordType == WAL_RECORD_PREPARE {
+		record = binary.LittleEndian.AppendUint64(record, coordinatorTxId)
+	}
+
+	record = binary.LittleEndian.AppendUint32(record, crc32.Checksum(record[start:], walCrcTable))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, err := w.fp.WriteAt(record, w.size); err != nil {
+		return 0, err
+	}
+
+	w.size += int64(len(record))
+	w.stats.Commits++
+	return w.size, nil
+}
+
+/*
+Flushes the log to disk up to size at least. While a leader flushes it, other committers wait, and the first of them
+whose records weren't covered by that flush becomes the next leader, flushing the records of all of them at once
+*/
+func (w *wal) flush(size int64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for w.flushed < size {
+		if w.flushing {
+			w.done.Wait()
+			continue
+		}
+
+		w.flushing = true
+		target := w.size
+		w.mu.Unlock()
+
+		err := w.fp.Sync()
+
+		w.mu.Lock()
+		w.flushing = false
+		w.done.Broadcast()
+
+		if err != nil {
+			return err
+		}
+
+		w.flushed = max(w.flushed, target)
+		w.stats.Flushes++
+	}
+
+	return nil
+}
+
+/*
+Reads the log from the beginning, calling apply for every committed transaction, in the order they were committed.
+Prepared transactions are applied only when isCommitted returns true for their coordinator id, and never when it's
+nil. Reading stops at the first incomplete or corrupted record, since nothing after it was ever committed
+*/
+func (w *wal) replay(apply func(pages map[uint64][]byte) error, isCommitted func(txId uint64) bool) error {
+	data := make([]byte, w.size)
+
+	if _, err := w.fp.ReadAt(data, 0); err != nil && err != io.EOF {
+		return err
+	}
+
+	pages := make(map[uint64][]byte)
+	for len(data) > 0 {
+		switch data[0] {
+		case WAL_RECORD_PAGE:
+			if len(data) < WAL_PAGE_RECORD_SIZE || !isWalRecordValid(data[:WAL_PAGE_RECORD_SIZE]) {
+				return nil
+			}
+
+			txId := binary.LittleEndian.Uint64(data[WAL_RECORD_TYPE_LEN:])
+			if len(pages) == 0 {
+				w.txId = txId
+			} else if txId != w.txId {
+				return nil
+			}
+
+			page := binary.LittleEndian.Uint64(data[WAL_RECORD_TYPE_LEN+WAL_TX_ID_LEN:])
+			pageStart := WAL_RECORD_TYPE_LEN + WAL_TX_ID_LEN + WAL_PAGE_LEN
+			pages[page] = data[pageStart : pageStart+btree.PAGE_SIZE]
+			data = data[WAL_PAGE_RECORD_SIZE:]
+		case WAL_RECORD_COMMIT, WAL_RECORD_PREPARE:
+			size := WAL_COMMIT_RECORD_SIZE
+			if data[0] == WAL_RECORD_PREPARE {
+				size = WAL_PREPARE_RECORD_SIZE
+			}
+
+			if len(data) < size || !isWalRecordValid(data[:size]) {
+				return nil
+			}
+
+			txId := binary.LittleEndian.Uint64(data[WAL_RECORD_TYPE_LEN:])
+			nPages := binary.LittleEndian.Uint32(data[WAL_RECORD_TYPE_LEN+WAL_TX_ID_LEN:])
+			if (len(pages) > 0 && txId != w.txId) || int(nPages) != len(pages) {
+				return nil
+			}
+
+			committed := data[0] == WAL_RECORD_COMMIT
+			if !committed && isCommitted != nil {
+				coordinatorTxId := binary.LittleEndian.Uint64(data[WAL_COMMIT_RECORD_SIZE-WAL_CRC_LEN:])
+				committed = isCommitted(coordinatorTxId)
+			}
+
+			if committed {
+				if err := apply(pages); err != nil {
+					return err
+				}
+			}
+
+			w.txId = txId
+			pages = make(map[uint64][]byte)
+			data = data[size:]
+		default:
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func isWalRecordValid(record []byte) bool {
+	crcStart := len(record) - WAL_CRC_LEN
+	return crc32.Checksum(record[:crcStart], walCrcTable) == binary.LittleEndian.Uint32(record[crcStart:])
+}
+
+/*
+Discards every record of the log. Must only be called after the DataFile is flushed to disk, and when no committer
+waits for a flush
+*/
+func (w *wal) truncate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.fp.Truncate(0); err != nil {
+		return err
+	}
+
+	w.size, w.flushed = 0, 0
+	return w.fp.Sync()
+}
+
+// Returns the size of the log in bytes
+func (w *wal) getSize() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.size
+}
+
+func (w *wal) getStats() WalStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.stats
+}
+
+func (w *wal) close() error {
+	return w.fp.Close()
+}
+
+// Pages are always written in the same order, so the log content doesn't depend on map iteration
+func getSortedPages(pages map[uint64][]byte) []uint64 {
+	sorted := make([]uint64, 0, len(pages))
+	for page := range pages {
+		sorted = append(sorted, page)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
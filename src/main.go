@@ -2,40 +2,68 @@ package main
 
 import (
 	"fmt"
-	"testing"
+	"os"
 
-	helper "github.com/nicolasvancan/monvandb/src/test/helper"
-	utils "github.com/nicolasvancan/monvandb/src/utils"
+	files "github.com/nicolasvancan/monvandb/src/files"
 )
 
-func TestBasicScan(t *testing.T) {
-	table := helper.GetMocktableReadyForTesting(t)
+const usage = `usage: monvandb <command> [arguments]
 
-	key, err := utils.Serialize(int64(1))
+commands:
+  check <path>    verifies the integrity of the data file stored at path
+`
 
-	if err != nil {
-		t.Errorf("error serializing key: %v", err)
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Print(usage)
+		os.Exit(2)
 	}
 
-	value := table.PDataFile.Get(key)
-	var k int64
-	err = utils.Deserialize(value[0].Key, &k)
+	switch os.Args[1] {
+	case "check":
+		if len(os.Args) != 3 {
+			fmt.Print(usage)
+			os.Exit(2)
+		}
+
+		os.Exit(check(os.Args[2]))
+	default:
+		fmt.Printf("unknown command %s\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+}
+
+/*
+Verifies every page of a data file, printing each problem found. Returns the exit code, 1 when the data file is
+broken. The data file is opened read only, so that checking it doesn't change it: operations left in its log are not
+recovered, and they are reported, since the pages checked may be missing them
+*/
+func check(path string) int {
+	dataFile, err := files.OpenDataFileWithOptions(path, files.DataFileOptions{ReadOnly: true})
+
 	if err != nil {
-		t.Errorf("error deserializing key: %v", err)
+		fmt.Printf("could not open %s: %v\n", path, err)
+		return 1
 	}
 
-	fmt.Printf("value: %d\n", k)
-	if value == nil {
-		t.Errorf("error getting value from datafile")
+	defer dataFile.Close()
+
+	problems := dataFile.Verify()
+
+	walPath := files.GetWalPath(path)
+	if stat, err := os.Stat(walPath); err == nil && stat.Size() > 0 {
+		problems = append(problems, fmt.Errorf("log %s has %d bytes not recovered yet", walPath, stat.Size()))
 	}
 
-}
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
 
-func main() {
-	TestBasicScan(&testing.T{})
-	a := 2
-	b := 3
+	if len(problems) > 0 {
+		fmt.Printf("%s: %d problems found\n", path, len(problems))
+		return 1
+	}
 
-	a, b = b, a
-	fmt.Println(a, b)
+	fmt.Printf("%s: ok\n", path)
+	return 0
 }
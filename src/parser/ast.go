@@ -0,0 +1,181 @@
+package parser
+
+import (
+	database "github.com/nicolasvancan/monvandb/src/database"
+)
+
+/*
+Syntax tree
+
+Parse returns one of the statements below. Every node keeps the position where it starts in the text of the
+statement, so that errors found after parsing, such as a column that doesn't exist, can point at it.
+
+WHERE clauses are kept as expressions, made of comparisons joined by AND, OR and NOT. Comparisons use the conditions
+of the database package (EQ, GT, LIKE and so on), and BETWEEN is parsed as two comparisons joined by AND. Their
+ColumnComparsion form, which is the one the tables take, is given by the Comparsions functions.
+*/
+
+type Node interface {
+	Pos() Pos
+}
+
+type Statement interface {
+	Node
+	statement()
+}
+
+type Expr interface {
+	Node
+	expr()
+}
+
+type node struct {
+	Position Pos
+}
+
+func (n node) Pos() Pos {
+	return n.Position
+}
+
+// TableRef is a table named by a statement, along with the alias given to it
+type TableRef struct {
+	node
+	Name  string
+	Alias string
+}
+
+// Whether columns qualified by the name are columns of the table
+func (t TableRef) isNamed(name string) bool {
+	return name == t.Name || (t.Alias != "" && name == t.Alias)
+}
+
+// SELECT [DISTINCT] fields FROM table [WHERE expr] [ORDER BY columns] [LIMIT n] [OFFSET n]
+type SelectStatement struct {
+	node
+	Distinct bool
+	Fields   []SelectField
+	From     TableRef
+	Where    Expr // nil without WHERE
+	OrderBy  []OrderByItem
+	Limit    int // -1 without LIMIT
+	Offset   int
+}
+
+// SelectField is a value selected. Star fields select every column, of their table when it's given
+type SelectField struct {
+	node
+	Star  bool
+	Table string // Table of star fields, as in t.*
+	Expr  Expr   // Column or literal selected, nil for star fields
+	Alias string
+}
+
+type OrderByItem struct {
+	node
+	Column *ColumnRef
+	Order  int // database.ASC or database.DESC
+}
+
+// INSERT INTO table [(columns)] VALUES (values), ...
+type InsertStatement struct {
+	node
+	Table   TableRef
+	Columns []string // Columns given, empty when the values are given for every column of the table, in order
+	Rows    [][]*Literal
+}
+
+// UPDATE table SET column = value, ... [WHERE expr]
+type UpdateStatement struct {
+	node
+	Table TableRef
+	Set   []Assignment
+	Where Expr
+}
+
+type Assignment struct {
+	node
+	Column string
+	Value  *Literal
+}
+
+// DELETE FROM table [WHERE expr]
+type DeleteStatement struct {
+	node
+	Table TableRef
+	Where Expr
+}
+
+/*
+CREATE TABLE name (column type [constraints], ... [, PRIMARY KEY (columns)])
+
+Columns are nullable unless they are NOT NULL or part of the PRIMARY KEY. DEFAULT NEXTVAL('name') sets the sequence
+of the column instead of a default value
+*/
+type CreateTableStatement struct {
+	node
+	Name    string
+	Columns []database.Column
+}
+
+// CREATE [UNIQUE] INDEX [CONCURRENTLY] name ON table (columns). CONCURRENTLY builds the index online
+type CreateIndexStatement struct {
+	node
+	Name    string
+	Table   string
+	Columns []string
+	Options database.IndexOptions
+}
+
+func (*SelectStatement) statement()      {}
+func (*InsertStatement) statement()      {}
+func (*UpdateStatement) statement()      {}
+func (*DeleteStatement) statement()      {}
+func (*CreateTableStatement) statement() {}
+func (*CreateIndexStatement) statement() {}
+
+// ColumnRef is a column, qualified by the name or alias of its table when Table is given
+type ColumnRef struct {
+	node
+	Table string
+	Name  string
+}
+
+// Literal is a value written in the statement: int64, float64, string, bool, []byte or nil for NULL
+type Literal struct {
+	node
+	Value interface{}
+}
+
+// ListExpr is the list of values of IN comparisons
+type ListExpr struct {
+	node
+	Items []*Literal
+}
+
+// ComparsionExpr compares two values with one of the conditions of the database package
+type ComparsionExpr struct {
+	node
+	Left      Expr
+	Condition int
+	Right     Expr
+}
+
+// LogicalExpr joins two expressions by database.AND or database.OR
+type LogicalExpr struct {
+	node
+	Op    int
+	Left  Expr
+	Right Expr
+}
+
+type NotExpr struct {
+	node
+	Expr Expr
+}
+
+func (*ColumnRef) expr()      {}
+func (*Literal) expr()        {}
+func (*ListExpr) expr()       {}
+func (*ComparsionExpr) expr() {}
+func (*LogicalExpr) expr()    {}
+func (*NotExpr) expr()        {}
@@ -0,0 +1,290 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+Lexer
+
+The lexer splits a statement into tokens, each one with the position it starts at. Keywords and identifiers are told
+apart only by the parser, since most keywords may also name tables and columns when quoted, with double quotes or
+backticks. Strings are written between single quotes, doubling the quote to have it in the string, and blobs as
+X'hex'. Comments start with -- and go until the end of the line.
+*/
+
+const (
+	TOKEN_EOF = iota
+	TOKEN_IDENT
+	TOKEN_QUOTED_IDENT
+	TOKEN_INT
+	TOKEN_FLOAT
+	TOKEN_STRING
+	TOKEN_BLOB
+	TOKEN_SYMBOL // Operators and punctuation
+)
+
+// Pos is a position in the text of a statement. Lines and columns start at 1, and columns count characters
+type Pos struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("line %d, column %d", p.Line, p.Column)
+}
+
+type token struct {
+	kind int
+	text string // Text of the token, without the quotes of strings and quoted identifiers
+	pos  Pos
+}
+
+// Describes the token in syntax errors
+func (t token) String() string {
+	switch t.kind {
+	case TOKEN_EOF:
+		return "end of statement"
+	case TOKEN_STRING:
+		return fmt.Sprintf("string '%s'", t.text)
+	case TOKEN_QUOTED_IDENT:
+		return fmt.Sprintf("identifier \"%s\"", t.text)
+	}
+
+	return fmt.Sprintf("\"%s\"", t.text)
+}
+
+// Whether the token is the keyword, which is written in upper case
+func (t token) is(keyword string) bool {
+	return t.kind == TOKEN_IDENT && strings.EqualFold(t.text, keyword)
+}
+
+func (t token) isSymbol(symbol string) bool {
+	return t.kind == TOKEN_SYMBOL && t.text == symbol
+}
+
+// SyntaxError is returned when a statement can't be parsed, pointing at where the problem was found
+type SyntaxError struct {
+	Pos     Pos
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at %s: %s", e.Pos, e.Message)
+}
+
+type lexer struct {
+	input string
+	pos   Pos
+}
+
+// Splits the input into tokens, ending with a TOKEN_EOF one
+func tokenize(input string) ([]token, error) {
+	l := &lexer{input: input, pos: Pos{Line: 1, Column: 1}}
+	tokens := make([]token, 0)
+
+	for {
+		tok, err := l.next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, tok)
+
+		if tok.kind == TOKEN_EOF {
+			return tokens, nil
+		}
+	}
+}
+
+func (l *lexer) peek(ahead int) rune {
+	offset := l.pos.Offset
+
+	for i := 0; i < ahead && offset < len(l.input); i++ {
+		_, size := utf8.DecodeRuneInString(l.input[offset:])
+		offset += size
+	}
+
+	if offset >= len(l.input) {
+		return 0
+	}
+
+	r, _ := utf8.DecodeRuneInString(l.input[offset:])
+	return r
+}
+
+func (l *lexer) advance() rune {
+	r, size := utf8.DecodeRuneInString(l.input[l.pos.Offset:])
+	l.pos.Offset += size
+
+	if r == '\n' {
+		l.pos.Line++
+		l.pos.Column = 1
+	} else {
+		l.pos.Column++
+	}
+
+	return r
+}
+
+func (l *lexer) done() bool {
+	return l.pos.Offset >= len(l.input)
+}
+
+// Skips spaces and comments
+func (l *lexer) skip() {
+	for !l.done() {
+		r := l.peek(0)
+
+		if unicode.IsSpace(r) {
+			l.advance()
+			continue
+		}
+
+		if r == '-' && l.peek(1) == '-' {
+			for !l.done() && l.peek(0) != '\n' {
+				l.advance()
+			}
+
+			continue
+		}
+
+		return
+	}
+}
+
+func (l *lexer) next() (token, error) {
+	l.skip()
+	start := l.pos
+
+	if l.done() {
+		return token{kind: TOKEN_EOF, pos: start}, nil
+	}
+
+	r := l.peek(0)
+
+	switch {
+	case (r == 'x' || r == 'X') && l.peek(1) == '\'':
+		return l.blob(start)
+	case r == '_' || unicode.IsLetter(r):
+		for !l.done() && (l.peek(0) == '_' || unicode.IsLetter(l.peek(0)) || unicode.IsDigit(l.peek(0))) {
+			l.advance()
+		}
+
+		return token{kind: TOKEN_IDENT, text: l.input[start.Offset:l.pos.Offset], pos: start}, nil
+	case unicode.IsDigit(r) || (r == '.' && unicode.IsDigit(l.peek(1))):
+		return l.number(start)
+	case r == '\'':
+		text, err := l.quoted('\'', "string")
+		return token{kind: TOKEN_STRING, text: text, pos: start}, err
+	case r == '"' || r == '`':
+		text, err := l.quoted(r, "identifier")
+
+		if err == nil && text == "" {
+			err = &SyntaxError{Pos: start, Message: "empty quoted identifier"}
+		}
+
+		return token{kind: TOKEN_QUOTED_IDENT, text: text, pos: start}, err
+	}
+
+	for _, symbol := range []string{"<=", ">=", "<>", "!=", "=", "<", ">", "(", ")", ",", ".", "*", ";", "-", "+"} {
+		if strings.HasPrefix(l.input[l.pos.Offset:], symbol) {
+			for range symbol {
+				l.advance()
+			}
+
+			return token{kind: TOKEN_SYMBOL, text: symbol, pos: start}, nil
+		}
+	}
+
+	return token{}, &SyntaxError{Pos: start, Message: fmt.Sprintf("unexpected character %q", r)}
+}
+
+func (l *lexer) number(start Pos) (token, error) {
+	kind := TOKEN_INT
+
+	for !l.done() && unicode.IsDigit(l.peek(0)) {
+		l.advance()
+	}
+
+	if l.peek(0) == '.' {
+		kind = TOKEN_FLOAT
+		l.advance()
+
+		for !l.done() && unicode.IsDigit(l.peek(0)) {
+			l.advance()
+		}
+	}
+
+	if r := l.peek(0); r == 'e' || r == 'E' {
+		kind = TOKEN_FLOAT
+		l.advance()
+
+		if r := l.peek(0); r == '+' || r == '-' {
+			l.advance()
+		}
+
+		if !unicode.IsDigit(l.peek(0)) {
+			return token{}, &SyntaxError{Pos: start, Message: "exponent of number has no digits"}
+		}
+
+		for !l.done() && unicode.IsDigit(l.peek(0)) {
+			l.advance()
+		}
+	}
+
+	// Numbers can't be followed by letters, as in 12abc
+	if r := l.peek(0); r == '_' || unicode.IsLetter(r) {
+		return token{}, &SyntaxError{Pos: l.pos, Message: fmt.Sprintf("unexpected character %q after number", r)}
+	}
+
+	return token{kind: kind, text: l.input[start.Offset:l.pos.Offset], pos: start}, nil
+}
+
+// Reads the text between quotes, where the quote is written twice
+func (l *lexer) quoted(quote rune, name string) (string, error) {
+	start := l.pos
+	l.advance()
+
+	var text strings.Builder
+	for !l.done() {
+		r := l.advance()
+
+		if r != quote {
+			text.WriteRune(r)
+			continue
+		}
+
+		if l.peek(0) != quote {
+			return text.String(), nil
+		}
+
+		text.WriteRune(l.advance())
+	}
+
+	return "", &SyntaxError{Pos: start, Message: fmt.Sprintf("%s is never closed", name)}
+}
+
+func (l *lexer) blob(start Pos) (token, error) {
+	l.advance()
+	text, err := l.quoted('\'', "blob")
+
+	if err != nil {
+		return token{}, err
+	}
+
+	if len(text)%2 != 0 || strings.IndexFunc(text, func(r rune) bool { return !isHexDigit(r) }) != -1 {
+		return token{}, &SyntaxError{Pos: start, Message: "blob must be written as pairs of hexadecimal digits"}
+	}
+
+	return token{kind: TOKEN_BLOB, text: text, pos: start}, nil
+}
+
+func isHexDigit(r rune) bool {
+	return (r >= '0' && r <= '9') || (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F')
+}
@@ -0,0 +1,304 @@
+package parser
+
+import (
+	"fmt"
+
+	database "github.com/nicolasvancan/monvandb/src/database"
+)
+
+/*
+Lowering
+
+Statements are lowered to the structures the tables take. WHERE expressions become the layers of []ColumnComparsion
+(see the database predicates): every chain of comparisons joined by the same operator is a layer, and the expressions
+between parenthesis in it are its children. NOT is pushed down to the comparisons first, inverting them and swapping
+AND and OR on its way, so the layers never need it:
+
+	WHERE a = 1 AND NOT (b = 2 OR c LIKE 'x%')  ->  WHERE a = 1 AND b <> 2 AND c NOT LIKE 'x%'
+
+Layers without comparisons of their own, such as (a = 1 OR b = 2) AND (c = 3 OR d = 4), take the comparisons of their
+first child, which is joined to the others as they were.
+
+Comparsions are written with the column on the left, so 10 < a becomes a > 10. Columns compared with columns of other
+tables are marked with IsOtherTable, leaving them to the joins.
+*/
+
+// Comparsions lowers the WHERE expression of statements over the tables. Columns without a table are of the first one
+func Comparsions(where Expr, tables ...TableRef) ([]database.ColumnComparsion, error) {
+	comparsions := make([]database.ColumnComparsion, 0)
+
+	if where == nil {
+		return comparsions, nil
+	}
+
+	if len(tables) == 0 {
+		return nil, fmt.Errorf("comparsions must be lowered over at least one table")
+	}
+
+	l := &lowering{tables: tables, comparsions: comparsions}
+
+	if err := l.lowerLayer(pushDownNot(where, false), -1, database.AND, nil); err != nil {
+		return nil, err
+	}
+
+	return l.comparsions, nil
+}
+
+// Comparsions returns the WHERE clause of the statement as the comparsions taken by Table.Range
+func (s *SelectStatement) Comparsions() ([]database.ColumnComparsion, error) {
+	return Comparsions(s.Where, s.From)
+}
+
+func (s *UpdateStatement) Comparsions() ([]database.ColumnComparsion, error) {
+	return Comparsions(s.Where, s.Table)
+}
+
+func (s *DeleteStatement) Comparsions() ([]database.ColumnComparsion, error) {
+	return Comparsions(s.Where, s.Table)
+}
+
+/*
+RawRows returns the rows of the statement. Values given without column names are given to the columns of the table, in
+order
+*/
+func (s *InsertStatement) RawRows(table *database.Table) ([]database.RawRow, error) {
+	columns := s.Columns
+
+	if len(columns) == 0 {
+		for _, column := range table.Columns {
+			columns = append(columns, column.Name)
+		}
+	}
+
+	rows := make([]database.RawRow, 0, len(s.Rows))
+	for _, values := range s.Rows {
+		if len(values) != len(columns) {
+			return nil, &SyntaxError{Pos: values[0].Pos(), Message: fmt.Sprintf("expected %d values for table %s, found %d", len(columns), table.Name, len(values))}
+		}
+
+		row := make(database.RawRow, len(columns))
+		for i, value := range values {
+			row[columns[i]] = value.Value
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// Changes returns the values the statement sets, by column
+func (s *UpdateStatement) Changes() database.RawRow {
+	changes := make(database.RawRow, len(s.Set))
+	for _, assignment := range s.Set {
+		changes[assignment.Column] = assignment.Value.Value
+	}
+
+	return changes
+}
+
+var invertedConditions = map[int]int{
+	database.EQ:    database.NE,
+	database.NE:    database.EQ,
+	database.GT:    database.LTE,
+	database.GTE:   database.LT,
+	database.LT:    database.GTE,
+	database.LTE:   database.GT,
+	database.IN:    database.NIN,
+	database.NIN:   database.IN,
+	database.LIKE:  database.NLIKE,
+	database.NLIKE: database.LIKE,
+}
+
+// Conditions of comparsions whose sides are swapped, as in 10 < a
+var swappedConditions = map[int]int{
+	database.GT:  database.LT,
+	database.GTE: database.LTE,
+	database.LT:  database.GT,
+	database.LTE: database.GTE,
+}
+
+// Returns the expression with NOT pushed down to its comparsions, negating it as well when negate is true
+func pushDownNot(expr Expr, negate bool) Expr {
+	switch e := expr.(type) {
+	case *NotExpr:
+		return pushDownNot(e.Expr, !negate)
+	case *LogicalExpr:
+		pushed := &LogicalExpr{node: e.node, Op: e.Op, Left: pushDownNot(e.Left, negate), Right: pushDownNot(e.Right, negate)}
+
+		if negate && e.Op == database.AND {
+			pushed.Op = database.OR
+		} else if negate {
+			pushed.Op = database.AND
+		}
+
+		return pushed
+	case *ComparsionExpr:
+		if negate {
+			return &ComparsionExpr{node: e.node, Left: e.Left, Condition: invertedConditions[e.Condition], Right: e.Right}
+		}
+	}
+
+	return expr
+}
+
+// Returns the operator of the expression along with the expressions it joins, which is AND for single comparsions
+func flatten(expr Expr) (int, []Expr) {
+	logical, ok := expr.(*LogicalExpr)
+
+	if !ok {
+		return database.AND, []Expr{expr}
+	}
+
+	operands := make([]Expr, 0)
+	for _, side := range []Expr{logical.Left, logical.Right} {
+		if other, ok := side.(*LogicalExpr); ok && other.Op == logical.Op {
+			_, sideOperands := flatten(other)
+			operands = append(operands, sideOperands...)
+		} else {
+			operands = append(operands, side)
+		}
+	}
+
+	return logical.Op, operands
+}
+
+type lowering struct {
+	tables      []TableRef
+	comparsions []database.ColumnComparsion
+	nextId      int
+}
+
+// Expression joined to a layer after its children
+type joinedExpr struct {
+	expr Expr
+	op   int
+}
+
+// Adds the layer of the expression, joined to its parent by parentOp, followed by the joined expressions
+func (l *lowering) lowerLayer(expr Expr, parentId int, parentOp int, joined []joinedExpr) error {
+	op, operands := flatten(expr)
+	comparsions, children := make([]*ComparsionExpr, 0), make([]Expr, 0)
+
+	for _, operand := range operands {
+		if comparsion, ok := operand.(*ComparsionExpr); ok {
+			comparsions = append(comparsions, comparsion)
+		} else {
+			children = append(children, operand)
+		}
+	}
+
+	// The first child becomes the layer, followed by the other children
+	if len(comparsions) == 0 {
+		rest := make([]joinedExpr, 0, len(children)-1+len(joined))
+		for _, child := range children[1:] {
+			rest = append(rest, joinedExpr{expr: child, op: op})
+		}
+
+		return l.lowerLayer(children[0], parentId, parentOp, append(rest, joined...))
+	}
+
+	id := l.nextId
+	l.nextId++
+
+	for _, expr := range comparsions {
+		comparsion, err := l.lowerComparsion(expr)
+
+		if err != nil {
+			return err
+		}
+
+		comparsion.Id, comparsion.ParentId = id, parentId
+		comparsion.LayerLogicalOp, comparsion.ParentLogicalOp = op, parentOp
+		l.comparsions = append(l.comparsions, comparsion)
+	}
+
+	for _, child := range children {
+		if err := l.lowerLayer(child, id, op, nil); err != nil {
+			return err
+		}
+	}
+
+	for _, other := range joined {
+		if err := l.lowerLayer(other.expr, id, other.op, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the name of the table of the column
+func (l *lowering) getTableName(column *ColumnRef) (string, error) {
+	if column.Table == "" {
+		return l.tables[0].Name, nil
+	}
+
+	for _, table := range l.tables {
+		if table.isNamed(column.Table) {
+			return table.Name, nil
+		}
+	}
+
+	return "", &SyntaxError{Pos: column.Pos(), Message: fmt.Sprintf("table %s of column %s is not in the statement", column.Table, column.Name)}
+}
+
+func (l *lowering) lowerComparsion(expr *ComparsionExpr) (database.ColumnComparsion, error) {
+	left, right, condition := expr.Left, expr.Right, expr.Condition
+
+	if _, ok := left.(*ColumnRef); !ok {
+		if _, ok := right.(*ColumnRef); ok && condition != database.LIKE && condition != database.NLIKE {
+			left, right = right, left
+
+			if swapped, ok := swappedConditions[condition]; ok {
+				condition = swapped
+			}
+		}
+	}
+
+	column, ok := left.(*ColumnRef)
+
+	if !ok {
+		return database.ColumnComparsion{}, &SyntaxError{Pos: expr.Pos(), Message: "comparsion must have a column on the left side"}
+	}
+
+	tableName, err := l.getTableName(column)
+
+	if err != nil {
+		return database.ColumnComparsion{}, err
+	}
+
+	comparsion := database.ColumnComparsion{ColumnName: column.Name, TableName: tableName, Condition: condition}
+
+	switch value := right.(type) {
+	case *ColumnRef:
+		otherTable, err := l.getTableName(value)
+
+		if err != nil {
+			return comparsion, err
+		}
+
+		comparsion.Value.IsOtherColumn = true
+		comparsion.Value.ColumnName = value.Name
+
+		if otherTable != tableName {
+			comparsion.Value.IsOtherTable = true
+			comparsion.Value.TableHash = otherTable
+		}
+	case *ListExpr:
+		values := make([]interface{}, len(value.Items))
+		for i, item := range value.Items {
+			values[i] = item.Value
+		}
+
+		comparsion.Value.Value = values
+	case *Literal:
+		if _, ok := value.Value.(string); !ok && (condition == database.LIKE || condition == database.NLIKE) {
+			return comparsion, &SyntaxError{Pos: value.Pos(), Message: "pattern of LIKE must be a string"}
+		}
+
+		comparsion.Value.Value = value.Value
+	}
+
+	return comparsion, nil
+}
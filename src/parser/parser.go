@@ -0,0 +1,1000 @@
+package parser
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	database "github.com/nicolasvancan/monvandb/src/database"
+)
+
+/*
+Parser
+
+Statements are parsed by recursive descent, one function per rule, reading the tokens given by the lexer. The first
+token that doesn't fit the statement stops the parser, returning a SyntaxError at its position that says what was
+expected there.
+
+Keywords are case insensitive, and reserved: they name tables and columns only when quoted.
+
+WHERE expressions follow the usual precedence, where NOT binds tighter than AND, which binds tighter than OR:
+
+	expr       := and (OR and)*
+	and        := not (AND not)*
+	not        := NOT not | comparsion
+	comparsion := '(' expr ')'
+	            | operand op operand
+	            | operand [NOT] IN '(' literal, ... ')'
+	            | operand [NOT] LIKE operand
+	            | operand [NOT] BETWEEN operand AND operand
+	operand    := [table.]column | literal
+*/
+
+var reservedWords = map[string]bool{
+	"SELECT": true, "DISTINCT": true, "FROM": true, "WHERE": true, "ORDER": true, "BY": true, "ASC": true,
+	"DESC": true, "LIMIT": true, "OFFSET": true, "INSERT": true, "INTO": true, "VALUES": true, "UPDATE": true,
+	"SET": true, "DELETE": true, "CREATE": true, "TABLE": true, "INDEX": true, "UNIQUE": true, "ON": true,
+	"PRIMARY": true, "KEY": true, "NOT": true, "NULL": true, "DEFAULT": true, "AND": true, "OR": true, "IN": true,
+	"LIKE": true, "BETWEEN": true, "TRUE": true, "FALSE": true, "AS": true, "CONCURRENTLY": true,
+	"AUTO_INCREMENT": true, "AUTOINCREMENT": true,
+}
+
+// Types of columns of CREATE TABLE, some of them taking a length that is ignored, as in VARCHAR(255)
+var columnTypes = map[string]int{
+	"INT":       database.COL_TYPE_INT,
+	"INTEGER":   database.COL_TYPE_INT,
+	"SMALLINT":  database.COL_TYPE_SMALL_INT,
+	"SMALL_INT": database.COL_TYPE_SMALL_INT,
+	"BIGINT":    database.COL_TYPE_BIG_INT,
+	"BIG_INT":   database.COL_TYPE_BIG_INT,
+	"STRING":    database.COL_TYPE_STRING,
+	"TEXT":      database.COL_TYPE_STRING,
+	"VARCHAR":   database.COL_TYPE_STRING,
+	"CHAR":      database.COL_TYPE_STRING,
+	"FLOAT":     database.COL_TYPE_FLOAT,
+	"REAL":      database.COL_TYPE_FLOAT,
+	"DOUBLE":    database.COL_TYPE_DOUBLE,
+	"BOOL":      database.COL_TYPE_BOOL,
+	"BOOLEAN":   database.COL_TYPE_BOOL,
+	"TIMESTAMP": database.COL_TYPE_TIMESTAMP,
+	"BLOB":      database.COL_TYPE_BLOB,
+}
+
+var comparsionSymbols = map[string]int{
+	"=":  database.EQ,
+	"!=": database.NE,
+	"<>": database.NE,
+	">":  database.GT,
+	">=": database.GTE,
+	"<":  database.LT,
+	"<=": database.LTE,
+}
+
+// Whether the token names a table, column or index
+func (t token) isIdentifier() bool {
+	return t.kind == TOKEN_QUOTED_IDENT || (t.kind == TOKEN_IDENT && !reservedWords[strings.ToUpper(t.text)])
+}
+
+type parser struct {
+	tokens []token
+	cur    int
+}
+
+// Parse parses a single statement, which may end with a semicolon
+func Parse(sql string) (Statement, error) {
+	statements, err := ParseStatements(sql)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(statements) != 1 {
+		return nil, &SyntaxError{Pos: Pos{Line: 1, Column: 1}, Message: fmt.Sprintf("expected one statement, found %d", len(statements))}
+	}
+
+	return statements[0], nil
+}
+
+// ParseStatements parses statements separated by semicolons
+func ParseStatements(sql string) ([]Statement, error) {
+	tokens, err := tokenize(sql)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	statements := make([]Statement, 0)
+
+	for {
+		for p.acceptSymbol(";") {
+		}
+
+		if p.peek().kind == TOKEN_EOF {
+			return statements, nil
+		}
+
+		statement, err := p.parseStatement()
+
+		if err != nil {
+			return nil, err
+		}
+
+		statements = append(statements, statement)
+
+		if !p.acceptSymbol(";") && p.peek().kind != TOKEN_EOF {
+			return nil, p.unexpected("end of statement")
+		}
+	}
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.cur]
+}
+
+func (p *parser) advance() token {
+	tok := p.tokens[p.cur]
+
+	if tok.kind != TOKEN_EOF {
+		p.cur++
+	}
+
+	return tok
+}
+
+// Error for the current token, which is not the expected one
+func (p *parser) unexpected(expected string) error {
+	return &SyntaxError{Pos: p.peek().pos, Message: fmt.Sprintf("expected %s, found %s", expected, p.peek())}
+}
+
+// Reads the keyword when it's the current token
+func (p *parser) accept(keyword string) bool {
+	if p.peek().is(keyword) {
+		p.advance()
+		return true
+	}
+
+	return false
+}
+
+func (p *parser) acceptSymbol(symbol string) bool {
+	if p.peek().isSymbol(symbol) {
+		p.advance()
+		return true
+	}
+
+	return false
+}
+
+func (p *parser) expect(keywords ...string) error {
+	for _, keyword := range keywords {
+		if !p.accept(keyword) {
+			return p.unexpected(keyword)
+		}
+	}
+
+	return nil
+}
+
+func (p *parser) expectSymbol(symbol string) error {
+	if !p.acceptSymbol(symbol) {
+		return p.unexpected(fmt.Sprintf("\"%s\"", symbol))
+	}
+
+	return nil
+}
+
+// Reads the name of a table, column or index
+func (p *parser) identifier(what string) (string, error) {
+	if tok := p.peek(); tok.isIdentifier() {
+		p.advance()
+		return tok.text, nil
+	}
+
+	return "", p.unexpected(what)
+}
+
+// Reads identifiers between parenthesis, separated by commas
+func (p *parser) identifierList(what string) ([]string, error) {
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0)
+	for {
+		name, err := p.identifier(what)
+
+		if err != nil {
+			return nil, err
+		}
+
+		names = append(names, name)
+
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+
+	return names, p.expectSymbol(")")
+}
+
+func (p *parser) parseStatement() (Statement, error) {
+	switch tok := p.peek(); {
+	case tok.is("SELECT"):
+		return p.parseSelect()
+	case tok.is("INSERT"):
+		return p.parseInsert()
+	case tok.is("UPDATE"):
+		return p.parseUpdate()
+	case tok.is("DELETE"):
+		return p.parseDelete()
+	case tok.is("CREATE"):
+		return p.parseCreate()
+	}
+
+	return nil, p.unexpected("SELECT, INSERT, UPDATE, DELETE or CREATE")
+}
+
+func (p *parser) parseSelect() (*SelectStatement, error) {
+	statement := &SelectStatement{node: node{p.advance().pos}, Limit: -1}
+	statement.Distinct = p.accept("DISTINCT")
+
+	for {
+		field, err := p.parseSelectField()
+
+		if err != nil {
+			return nil, err
+		}
+
+		statement.Fields = append(statement.Fields, field)
+
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+
+	if err := p.expect("FROM"); err != nil {
+		return nil, err
+	}
+
+	from, err := p.parseTableRef(true)
+
+	if err != nil {
+		return nil, err
+	}
+
+	statement.From = from
+
+	if statement.Where, err = p.parseWhere(); err != nil {
+		return nil, err
+	}
+
+	if p.accept("ORDER") {
+		if err := p.expect("BY"); err != nil {
+			return nil, err
+		}
+
+		for {
+			item, err := p.parseOrderByItem()
+
+			if err != nil {
+				return nil, err
+			}
+
+			statement.OrderBy = append(statement.OrderBy, item)
+
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+
+	if p.accept("LIMIT") {
+		if statement.Limit, err = p.parseCount("LIMIT"); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.accept("OFFSET") {
+		if statement.Offset, err = p.parseCount("OFFSET"); err != nil {
+			return nil, err
+		}
+	}
+
+	return statement, nil
+}
+
+func (p *parser) parseSelectField() (SelectField, error) {
+	field := SelectField{node: node{p.peek().pos}}
+
+	if p.acceptSymbol("*") {
+		field.Star = true
+		return field, nil
+	}
+
+	// Star of a table, as in t.*
+	if next := p.tokens[min(p.cur+1, len(p.tokens)-1)]; next.isSymbol(".") && p.tokens[min(p.cur+2, len(p.tokens)-1)].isSymbol("*") {
+		table, err := p.identifier("table name")
+
+		if err != nil {
+			return field, err
+		}
+
+		p.advance()
+		p.advance()
+		field.Star, field.Table = true, table
+		return field, nil
+	}
+
+	expr, err := p.parseOperand()
+
+	if err != nil {
+		return field, err
+	}
+
+	field.Expr = expr
+
+	if p.accept("AS") {
+		field.Alias, err = p.identifier("alias")
+	} else if p.peek().isIdentifier() {
+		field.Alias, err = p.identifier("alias")
+	}
+
+	return field, err
+}
+
+// Reads a table name, followed by its alias when they are allowed
+func (p *parser) parseTableRef(alias bool) (TableRef, error) {
+	ref := TableRef{node: node{p.peek().pos}}
+	name, err := p.identifier("table name")
+
+	if err != nil {
+		return ref, err
+	}
+
+	ref.Name = name
+
+	if !alias {
+		return ref, nil
+	}
+
+	if p.accept("AS") {
+		ref.Alias, err = p.identifier("alias")
+	} else if p.peek().isIdentifier() {
+		ref.Alias, err = p.identifier("alias")
+	}
+
+	return ref, err
+}
+
+func (p *parser) parseOrderByItem() (OrderByItem, error) {
+	item := OrderByItem{node: node{p.peek().pos}, Order: database.ASC}
+	column, err := p.parseColumnRef()
+
+	if err != nil {
+		return item, err
+	}
+
+	item.Column = column
+
+	if p.accept("DESC") {
+		item.Order = database.DESC
+	} else {
+		p.accept("ASC")
+	}
+
+	return item, nil
+}
+
+// Reads the number of rows of LIMIT and OFFSET
+func (p *parser) parseCount(clause string) (int, error) {
+	tok := p.peek()
+
+	if tok.kind != TOKEN_INT {
+		return 0, p.unexpected(fmt.Sprintf("number of rows of %s", clause))
+	}
+
+	count, err := strconv.Atoi(tok.text)
+
+	if err != nil {
+		return 0, &SyntaxError{Pos: tok.pos, Message: fmt.Sprintf("number of rows of %s is too big", clause)}
+	}
+
+	p.advance()
+	return count, nil
+}
+
+func (p *parser) parseWhere() (Expr, error) {
+	if !p.accept("WHERE") {
+		return nil, nil
+	}
+
+	return p.parseExpr()
+}
+
+func (p *parser) parseInsert() (*InsertStatement, error) {
+	statement := &InsertStatement{node: node{p.advance().pos}}
+
+	if err := p.expect("INTO"); err != nil {
+		return nil, err
+	}
+
+	table, err := p.parseTableRef(false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	statement.Table = table
+
+	if p.peek().isSymbol("(") {
+		if statement.Columns, err = p.identifierList("column name"); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.expect("VALUES"); err != nil {
+		return nil, err
+	}
+
+	for {
+		start := p.peek().pos
+
+		if err := p.expectSymbol("("); err != nil {
+			return nil, err
+		}
+
+		row := make([]*Literal, 0)
+		for {
+			value, err := p.parseLiteral()
+
+			if err != nil {
+				return nil, err
+			}
+
+			row = append(row, value)
+
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+
+		if len(statement.Columns) > 0 && len(row) != len(statement.Columns) {
+			return nil, &SyntaxError{Pos: start, Message: fmt.Sprintf("expected %d values, found %d", len(statement.Columns), len(row))}
+		}
+
+		if len(statement.Rows) > 0 && len(row) != len(statement.Rows[0]) {
+			return nil, &SyntaxError{Pos: start, Message: fmt.Sprintf("expected %d values as in the first row, found %d", len(statement.Rows[0]), len(row))}
+		}
+
+		statement.Rows = append(statement.Rows, row)
+
+		if !p.acceptSymbol(",") {
+			return statement, nil
+		}
+	}
+}
+
+func (p *parser) parseUpdate() (*UpdateStatement, error) {
+	statement := &UpdateStatement{node: node{p.advance().pos}}
+	table, err := p.parseTableRef(true)
+
+	if err != nil {
+		return nil, err
+	}
+
+	statement.Table = table
+
+	if err := p.expect("SET"); err != nil {
+		return nil, err
+	}
+
+	for {
+		assignment := Assignment{node: node{p.peek().pos}}
+
+		if assignment.Column, err = p.identifier("column name"); err != nil {
+			return nil, err
+		}
+
+		if err := p.expectSymbol("="); err != nil {
+			return nil, err
+		}
+
+		if assignment.Value, err = p.parseLiteral(); err != nil {
+			return nil, err
+		}
+
+		statement.Set = append(statement.Set, assignment)
+
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+
+	statement.Where, err = p.parseWhere()
+	return statement, err
+}
+
+func (p *parser) parseDelete() (*DeleteStatement, error) {
+	statement := &DeleteStatement{node: node{p.advance().pos}}
+
+	if err := p.expect("FROM"); err != nil {
+		return nil, err
+	}
+
+	table, err := p.parseTableRef(true)
+
+	if err != nil {
+		return nil, err
+	}
+
+	statement.Table = table
+	statement.Where, err = p.parseWhere()
+	return statement, err
+}
+
+func (p *parser) parseCreate() (Statement, error) {
+	start := p.advance().pos
+
+	if p.accept("TABLE") {
+		return p.parseCreateTable(start)
+	}
+
+	unique := p.accept("UNIQUE")
+
+	if p.accept("INDEX") {
+		return p.parseCreateIndex(start, unique)
+	}
+
+	if unique {
+		return nil, p.unexpected("INDEX")
+	}
+
+	return nil, p.unexpected("TABLE or INDEX")
+}
+
+func (p *parser) parseCreateTable(start Pos) (*CreateTableStatement, error) {
+	statement := &CreateTableStatement{node: node{start}}
+	name, err := p.identifier("table name")
+
+	if err != nil {
+		return nil, err
+	}
+
+	statement.Name = name
+
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+
+	for {
+		if p.peek().is("PRIMARY") {
+			if err := p.parsePrimaryKey(statement); err != nil {
+				return nil, err
+			}
+		} else {
+			column, err := p.parseColumnDefinition(statement)
+
+			if err != nil {
+				return nil, err
+			}
+
+			statement.Columns = append(statement.Columns, column)
+		}
+
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+
+	return statement, nil
+}
+
+func (p *parser) parseColumnDefinition(statement *CreateTableStatement) (database.Column, error) {
+	column := database.Column{Nullable: true}
+	start := p.peek().pos
+	name, err := p.identifier("column name or PRIMARY KEY")
+
+	if err != nil {
+		return column, err
+	}
+
+	for _, other := range statement.Columns {
+		if other.Name == name {
+			return column, &SyntaxError{Pos: start, Message: fmt.Sprintf("column %s is defined twice", name)}
+		}
+	}
+
+	column.Name = name
+
+	tok := p.peek()
+	columnType, ok := columnTypes[strings.ToUpper(tok.text)]
+
+	if tok.kind != TOKEN_IDENT || !ok {
+		return column, p.unexpected("column type")
+	}
+
+	p.advance()
+	column.Type = columnType
+
+	// Length of the type, as in VARCHAR(255)
+	if p.acceptSymbol("(") {
+		if _, err := p.parseCount("length of type"); err != nil {
+			return column, err
+		}
+
+		if err := p.expectSymbol(")"); err != nil {
+			return column, err
+		}
+	}
+
+	for {
+		switch tok := p.peek(); {
+		case p.accept("PRIMARY"):
+			if err := p.expect("KEY"); err != nil {
+				return column, err
+			}
+
+			column.Primary, column.Nullable = true, false
+		case p.accept("NOT"):
+			if err := p.expect("NULL"); err != nil {
+				return column, err
+			}
+
+			column.Nullable = false
+		case p.accept("NULL"):
+			if column.Primary {
+				return column, &SyntaxError{Pos: tok.pos, Message: fmt.Sprintf("column %s of the primary key can't be null", name)}
+			}
+
+			column.Nullable = true
+		case p.accept("AUTO_INCREMENT"), p.accept("AUTOINCREMENT"):
+			column.AutoIncrement = true
+		case p.accept("DEFAULT"):
+			if err := p.parseDefault(&column); err != nil {
+				return column, err
+			}
+		default:
+			return column, nil
+		}
+	}
+}
+
+// Reads the default value of a column, or the sequence given by DEFAULT NEXTVAL('name')
+func (p *parser) parseDefault(column *database.Column) error {
+	if !p.peek().is("NEXTVAL") {
+		value, err := p.parseLiteral()
+
+		if err != nil {
+			return err
+		}
+
+		column.Default = value.Value
+		return nil
+	}
+
+	p.advance()
+
+	if err := p.expectSymbol("("); err != nil {
+		return err
+	}
+
+	tok := p.peek()
+
+	if tok.kind != TOKEN_STRING {
+		return p.unexpected("name of sequence")
+	}
+
+	p.advance()
+	column.Sequence = tok.text
+	return p.expectSymbol(")")
+}
+
+// Reads PRIMARY KEY (columns), whose columns must be listed in the order they were defined
+func (p *parser) parsePrimaryKey(statement *CreateTableStatement) error {
+	start := p.advance().pos
+
+	if err := p.expect("KEY"); err != nil {
+		return err
+	}
+
+	names, err := p.identifierList("column name")
+
+	if err != nil {
+		return err
+	}
+
+	for _, column := range statement.Columns {
+		if column.Primary {
+			return &SyntaxError{Pos: start, Message: fmt.Sprintf("primary key is given twice, column %s is part of it already", column.Name)}
+		}
+	}
+
+	last := -1
+	for _, name := range names {
+		position := -1
+		for i := range statement.Columns {
+			if statement.Columns[i].Name == name {
+				position = i
+			}
+		}
+
+		if position == -1 {
+			return &SyntaxError{Pos: start, Message: fmt.Sprintf("column %s of the primary key is not defined", name)}
+		}
+
+		// Keys are made of the primary columns in the order of the table
+		if position <= last {
+			return &SyntaxError{Pos: start, Message: "columns of the primary key must be listed in the order they are defined"}
+		}
+
+		last = position
+		statement.Columns[position].Primary = true
+		statement.Columns[position].Nullable = false
+	}
+
+	return nil
+}
+
+func (p *parser) parseCreateIndex(start Pos, unique bool) (*CreateIndexStatement, error) {
+	statement := &CreateIndexStatement{node: node{start}}
+	statement.Options.Unique = unique
+	statement.Options.Online = p.accept("CONCURRENTLY")
+
+	name, err := p.identifier("index name")
+
+	if err != nil {
+		return nil, err
+	}
+
+	statement.Name = name
+
+	if err := p.expect("ON"); err != nil {
+		return nil, err
+	}
+
+	if statement.Table, err = p.identifier("table name"); err != nil {
+		return nil, err
+	}
+
+	if statement.Columns, err = p.identifierList("column name"); err != nil {
+		return nil, err
+	}
+
+	return statement, nil
+}
+
+func (p *parser) parseExpr() (Expr, error) {
+	left, err := p.parseAnd()
+
+	for err == nil && p.peek().is("OR") {
+		start := p.advance().pos
+		var right Expr
+
+		if right, err = p.parseAnd(); err == nil {
+			left = &LogicalExpr{node: node{start}, Op: database.OR, Left: left, Right: right}
+		}
+	}
+
+	return left, err
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseNot()
+
+	for err == nil && p.peek().is("AND") {
+		start := p.advance().pos
+		var right Expr
+
+		if right, err = p.parseNot(); err == nil {
+			left = &LogicalExpr{node: node{start}, Op: database.AND, Left: left, Right: right}
+		}
+	}
+
+	return left, err
+}
+
+func (p *parser) parseNot() (Expr, error) {
+	if tok := p.peek(); tok.is("NOT") {
+		p.advance()
+		expr, err := p.parseNot()
+		return &NotExpr{node: node{tok.pos}, Expr: expr}, err
+	}
+
+	return p.parseComparsion()
+}
+
+func (p *parser) parseComparsion() (Expr, error) {
+	if p.acceptSymbol("(") {
+		expr, err := p.parseExpr()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return expr, p.expectSymbol(")")
+	}
+
+	left, err := p.parseOperand()
+
+	if err != nil {
+		return nil, err
+	}
+
+	tok := p.peek()
+
+	if condition, ok := comparsionSymbols[tok.text]; ok && tok.kind == TOKEN_SYMBOL {
+		p.advance()
+		right, err := p.parseOperand()
+		return &ComparsionExpr{node: node{tok.pos}, Left: left, Condition: condition, Right: right}, err
+	}
+
+	not := p.accept("NOT")
+
+	switch {
+	case p.accept("IN"):
+		list, err := p.parseList()
+		condition := database.IN
+
+		if not {
+			condition = database.NIN
+		}
+
+		return &ComparsionExpr{node: node{tok.pos}, Left: left, Condition: condition, Right: list}, err
+	case p.accept("LIKE"):
+		right, err := p.parseOperand()
+		condition := database.LIKE
+
+		if not {
+			condition = database.NLIKE
+		}
+
+		return &ComparsionExpr{node: node{tok.pos}, Left: left, Condition: condition, Right: right}, err
+	case p.accept("BETWEEN"):
+		low, err := p.parseOperand()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.expect("AND"); err != nil {
+			return nil, err
+		}
+
+		high, err := p.parseOperand()
+
+		if err != nil {
+			return nil, err
+		}
+
+		var between Expr = &LogicalExpr{
+			node:  node{tok.pos},
+			Op:    database.AND,
+			Left:  &ComparsionExpr{node: node{tok.pos}, Left: left, Condition: database.GTE, Right: low},
+			Right: &ComparsionExpr{node: node{tok.pos}, Left: left, Condition: database.LTE, Right: high},
+		}
+
+		if not {
+			between = &NotExpr{node: node{tok.pos}, Expr: between}
+		}
+
+		return between, nil
+	}
+
+	if not {
+		return nil, p.unexpected("IN, LIKE or BETWEEN")
+	}
+
+	return nil, p.unexpected("comparsion operator")
+}
+
+// Reads the values of IN comparsions
+func (p *parser) parseList() (*ListExpr, error) {
+	list := &ListExpr{node: node{p.peek().pos}}
+
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+
+	for {
+		value, err := p.parseLiteral()
+
+		if err != nil {
+			return nil, err
+		}
+
+		list.Items = append(list.Items, value)
+
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+
+	return list, p.expectSymbol(")")
+}
+
+func (p *parser) parseOperand() (Expr, error) {
+	if p.peek().isIdentifier() {
+		return p.parseColumnRef()
+	}
+
+	return p.parseLiteral()
+}
+
+func (p *parser) parseColumnRef() (*ColumnRef, error) {
+	ref := &ColumnRef{node: node{p.peek().pos}}
+	name, err := p.identifier("column name")
+
+	if err != nil {
+		return nil, err
+	}
+
+	ref.Name = name
+
+	if p.acceptSymbol(".") {
+		ref.Table = name
+
+		if ref.Name, err = p.identifier("column name"); err != nil {
+			return nil, err
+		}
+	}
+
+	return ref, nil
+}
+
+func (p *parser) parseLiteral() (*Literal, error) {
+	tok := p.peek()
+	literal := &Literal{node: node{tok.pos}}
+	sign := ""
+
+	// Signs are only taken by numbers
+	if tok.isSymbol("-") || tok.isSymbol("+") {
+		p.advance()
+		sign = tok.text
+
+		if next := p.peek(); next.kind != TOKEN_INT && next.kind != TOKEN_FLOAT {
+			return nil, p.unexpected("number")
+		}
+	}
+
+	switch tok := p.peek(); {
+	case tok.kind == TOKEN_INT:
+		value, err := strconv.ParseInt(sign+tok.text, 10, 64)
+
+		if err != nil {
+			return nil, &SyntaxError{Pos: literal.Position, Message: fmt.Sprintf("number %s%s does not fit in 64 bits", sign, tok.text)}
+		}
+
+		literal.Value = value
+	case tok.kind == TOKEN_FLOAT:
+		value, err := strconv.ParseFloat(sign+tok.text, 64)
+
+		if err != nil {
+			return nil, &SyntaxError{Pos: literal.Position, Message: fmt.Sprintf("number %s%s is out of range", sign, tok.text)}
+		}
+
+		literal.Value = value
+	case tok.kind == TOKEN_STRING:
+		literal.Value = tok.text
+	case tok.kind == TOKEN_BLOB:
+		literal.Value, _ = hex.DecodeString(tok.text)
+	case tok.is("TRUE"), tok.is("FALSE"):
+		literal.Value = tok.is("TRUE")
+	case tok.is("NULL"):
+		literal.Value = nil
+	default:
+		return nil, p.unexpected("value")
+	}
+
+	p.advance()
+	return literal, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	database "github.com/nicolasvancan/monvandb/src/database"
+	executor "github.com/nicolasvancan/monvandb/src/executor"
+	helper "github.com/nicolasvancan/monvandb/src/test/helper"
+)
+
+func TestGroupByHavingAndDistinct(t *testing.T) {
+	table := helper.GetMocktableReadyForTesting(t)
+
+	// Albert has ids 1 to 449 by 7, one more than the other names
+	rows := collectPlan(t, executor.FullScan(table).GroupBy(executor.GroupBy{
+		Columns: []string{"name"},
+		Having:  func(row database.RawRow) bool { return row["count(*)"] == int64(65) },
+	}, executor.Count("")))
+
+	if got := rowsColumns(rows, "name", "count(*)"); got != "Albert,65" {
+		t.Errorf("expected only the group of Albert, got %s", got)
+	}
+
+	rows = collectPlan(t, executor.FullScan(table).HashAggregate(nil, executor.CountDistinct("name"), executor.Count("name")))
+
+	if got := rowsColumns(rows, "count(distinct name)", "count(name)"); got != "7,449" {
+		t.Errorf("expected 7 different names out of 449, got %s", got)
+	}
+
+	// Rows of a range are aggregated as well
+	rows = collectPlan(t, executor.FromRows(rangeForTesting(t, table, and(where("id", database.LTE, 14)), -1, database.ASC)).
+		HashAggregate([]string{"name"}, executor.Count(""), executor.CountDistinct("id")).
+		Sort(executor.Asc("name")))
+
+	if got := rowsColumns(rows, "name", "count(*)", "count(distinct id)"); got != "Albert,2,2 Alice,2,2 James,2,2 Joana,2,2 John,2,2 Maria,2,2 Peter,2,2" {
+		t.Errorf("expected two ids of each name in the range, got %s", got)
+	}
+}
+
+func TestGroupBySpillsToDisk(t *testing.T) {
+	table := helper.GetMocktableReadyForTesting(t)
+	aggregates := []executor.Aggregate{executor.Count(""), executor.Sum("id"), executor.Min("id"), executor.CountDistinct("id")}
+
+	expected := collectPlan(t, executor.FullScan(table).HashAggregate([]string{"name"}, aggregates...).Sort(executor.Asc("name")))
+	dir := t.TempDir()
+
+	// Two groups fit in memory, the other five are spilled, and their files are read once the first two are given
+	running, err := executor.FullScan(table).
+		GroupBy(executor.GroupBy{Columns: []string{"name"}, MaxGroups: 2, SpillDir: dir}, aggregates...).
+		Run(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if files, _ := os.ReadDir(dir); !running.Next() || len(files) == 0 {
+		t.Errorf("expected the groups that don't fit in memory in spill files")
+	}
+
+	running.Close()
+
+	spilled := collectPlan(t, executor.FullScan(table).
+		GroupBy(executor.GroupBy{Columns: []string{"name"}, MaxGroups: 2, SpillDir: dir}, aggregates...).
+		Sort(executor.Asc("name")))
+
+	if !reflect.DeepEqual(spilled, expected) {
+		t.Errorf("expected the same groups spilling them, got %v instead of %v", spilled, expected)
+	}
+
+	// A group for each id is spilled many times
+	rows := collectPlan(t, executor.FullScan(table).
+		GroupBy(executor.GroupBy{Columns: []string{"id", "name"}, MaxGroups: 10, SpillDir: dir}, executor.Count("")).
+		Sort(executor.Asc("id")))
+
+	if len(rows) != 449 || rowIds(rows[:3]) != "1 2 3" || rows[448]["id"] != int64(449) || rows[448]["count(*)"] != int64(1) {
+		t.Errorf("expected a group for each of the 449 ids, got %d", len(rows))
+	}
+
+	if files, err := os.ReadDir(dir); err != nil || len(files) > 0 {
+		t.Errorf("expected the spill files to be removed, found %d", len(files))
+	}
+}
+
+func TestAggregateIndexedExtremes(t *testing.T) {
+	db, users := helper.CreateMockDatabaseWithTableAndIndex(t)
+	orders := createOrdersTable(t, db)
+
+	if err := db.CreateIndex("orders", "user_id", "orders_user_index"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Null users are skipped reading the index
+	expected := database.RawRow{"min(user_id)": int32(7), "max(user_id)": int32(9999), "max(id)": int32(5)}
+	rows := collectPlan(t, executor.FullScan(orders).HashAggregate(nil, executor.Min("user_id"), executor.Max("user_id"), executor.Max("id")))
+
+	if len(rows) != 1 || !reflect.DeepEqual(rows[0], expected) {
+		t.Errorf("expected %v from the indexes, got %v", expected, rows)
+	}
+
+	scanned := collectPlan(t, executor.FullScan(orders).
+		Filter(func(row database.RawRow) bool { return true }).
+		HashAggregate(nil, executor.Min("user_id"), executor.Max("user_id"), executor.Max("id")))
+
+	if !reflect.DeepEqual(scanned, rows) {
+		t.Errorf("expected the same extremes scanning the table, got %v", scanned)
+	}
+
+	// Empty tables have null extremes, which HAVING may filter out
+	rows = collectPlan(t, executor.FullScan(users).HashAggregate(nil, executor.Min("id")))
+
+	if len(rows) != 1 || rows[0]["min(id)"] != nil {
+		t.Errorf("expected a null minimum of an empty table, got %v", rows)
+	}
+
+	rows = collectPlan(t, executor.FullScan(users).GroupBy(executor.GroupBy{
+		Having: func(row database.RawRow) bool { return row["max(id)"] != nil },
+	}, executor.Max("id")))
+
+	if len(rows) != 0 {
+		t.Errorf("expected the null maximum to be filtered out, got %v", rows)
+	}
+}
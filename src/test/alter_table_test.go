@@ -0,0 +1,209 @@
+package main
+
+/*
+Tests for altering the columns of tables. Rows stored before a change must still be read with the columns the table
+has after it, before and after being rewritten
+*/
+
+import (
+	"testing"
+
+	database "github.com/nicolasvancan/monvandb/src/database"
+	helper "github.com/nicolasvancan/monvandb/src/test/helper"
+	utils "github.com/nicolasvancan/monvandb/src/utils"
+)
+
+func getCompositeKeyRow(t *testing.T, table *database.Table, tenant int32, id int32) database.RawRow {
+	t.Helper()
+
+	rows, err := table.GetByKey(tenant, id)
+
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("expected one row for (%d, %d), got %v with error %v", tenant, id, rows, err)
+	}
+
+	return rows[0]
+}
+
+func TestAddAndDropColumn(t *testing.T) {
+	_, table := helper.CreateMockDatabaseWithCompositeKeyTable(t)
+	insertCompositeKeyRows(t, table)
+
+	if err := table.AddColumn(database.Column{Name: "score", Type: database.COL_TYPE_INT}); err == nil {
+		t.Error("a column that isn't nullable should need a default value")
+	}
+
+	if err := table.AddColumn(database.Column{Name: "name", Type: database.COL_TYPE_INT, Nullable: true}); err == nil {
+		t.Error("a column with a name already used should not be added")
+	}
+
+	if err := table.AddColumn(database.Column{Name: "score", Type: database.COL_TYPE_INT, Default: int32(10)}); err != nil {
+		t.Fatalf("error adding column: %v", err)
+	}
+
+	// Rows stored already take the default value
+	if row := getCompositeKeyRow(t, table, 1, 1); row["score"] != int32(10) || row["name"] != "1-1" {
+		t.Errorf("expected the default score, got %v", row)
+	}
+
+	if _, err := table.Update([]database.RawRow{{"tenant": int32(1), "id": int32(1), "name": "1-1", "score": int32(5)}}); err != nil {
+		t.Fatalf("error updating: %v", err)
+	}
+
+	if err := table.DropColumn("name"); err != nil {
+		t.Fatalf("error dropping column: %v", err)
+	}
+
+	if err := table.DropColumn("id"); err == nil {
+		t.Error("a column of the key should not be dropped")
+	}
+
+	row := getCompositeKeyRow(t, table, 1, 1)
+
+	if _, ok := row["name"]; ok || row["score"] != int32(5) {
+		t.Errorf("expected the row without name, got %v", row)
+	}
+
+	// A column added with a name dropped before doesn't read the old values
+	if err := table.AddColumn(database.Column{Name: "name", Type: database.COL_TYPE_STRING, Nullable: true}); err != nil {
+		t.Fatalf("error adding column: %v", err)
+	}
+
+	if row := getCompositeKeyRow(t, table, 2, 2); row["name"] != nil {
+		t.Errorf("expected no name, got %v", row)
+	}
+}
+
+func TestRenameColumn(t *testing.T) {
+	db, table := helper.CreateMockDatabaseWithCompositeKeyTable(t)
+	insertCompositeKeyRows(t, table)
+	db.CreateCompositeIndex("table_composite", []string{"name", "id"}, "name_index", database.IndexOptions{})
+
+	if err := table.RenameColumn("name", "id"); err == nil {
+		t.Error("a column should not be renamed to a name already used")
+	}
+
+	if err := table.RenameColumn("name", "label"); err != nil {
+		t.Fatalf("error renaming column: %v", err)
+	}
+
+	if err := table.RenameColumn("id", "number"); err != nil {
+		t.Fatalf("error renaming key column: %v", err)
+	}
+
+	rows, err := table.Get("label", "2-3")
+
+	if err != nil || len(rows) != 1 || rows[0]["number"] != int32(3) {
+		t.Errorf("expected the row to be found by its renamed columns, got %v with error %v", rows, err)
+	}
+
+	if table.Indexes["label,number"] == nil {
+		t.Errorf("index should be found by its renamed columns, found %v", table.Indexes)
+	}
+
+	reloadedTable, _ := reloadMockDatabase(t).GetTable("table_composite")
+	rows, err = reloadedTable.GetByKey(int32(3), int32(4))
+
+	if err != nil || len(rows) != 1 || rows[0]["label"] != "3-4" {
+		t.Errorf("expected the row to be loaded with its renamed columns, got %v with error %v", rows, err)
+	}
+}
+
+func TestRenameColumnKeepsWhatReadersHold(t *testing.T) {
+	db, table := helper.CreateMockDatabaseWithCompositeKeyTable(t)
+	insertCompositeKeyRows(t, table)
+	db.CreateIndex("table_composite", "name", "name_index")
+	columns, key, index := table.Columns, table.CompositeKey, table.Indexes["name"]
+
+	if err := table.RenameColumn("name", "label"); err != nil {
+		t.Fatalf("error renaming column: %v", err)
+	}
+
+	if err := table.RenameColumn("id", "number"); err != nil {
+		t.Fatalf("error renaming key column: %v", err)
+	}
+
+	// Readers that got the columns and the index before the renames go on with them unchanged
+	if columns[1].Name != "id" || columns[2].Name != "name" || key[1].Name != "id" || index.Column != "name" || index.Columns[0] != "name" {
+		t.Errorf("expected the renames to replace the columns, the key and the index, got %v, %v and %v", columns, key, index.Columns)
+	}
+
+	rows, err := table.Get("label", "2-3")
+
+	if err != nil || len(rows) != 1 || rows[0]["number"] != int32(3) {
+		t.Errorf("expected the row to be found through the renamed index, got %v with error %v", rows, err)
+	}
+}
+
+func TestChangeColumnType(t *testing.T) {
+	_, table := helper.CreateMockDatabaseWithCompositeKeyTable(t)
+	insertCompositeKeyRows(t, table)
+	table.AddColumn(database.Column{Name: "score", Type: database.COL_TYPE_INT, Nullable: true})
+	table.Update([]database.RawRow{{"tenant": int32(1), "id": int32(1), "name": "1-1", "score": int32(7)}})
+
+	// Names can't be numbers, so nothing changes
+	if err := table.ChangeColumnType("name", database.COL_TYPE_INT); err == nil {
+		t.Error("a column with values that can't be converted should keep its type")
+	}
+
+	if row := getCompositeKeyRow(t, table, 1, 2); row["name"] != "1-2" {
+		t.Errorf("expected the name unchanged, got %v", row)
+	}
+
+	if err := table.ChangeColumnType("id", database.COL_TYPE_BIG_INT); err == nil {
+		t.Error("a column of the key should keep its type")
+	}
+
+	if err := table.ChangeColumnType("score", database.COL_TYPE_BIG_INT); err != nil {
+		t.Fatalf("error changing type: %v", err)
+	}
+
+	// Values stored before are converted when read
+	if row := getCompositeKeyRow(t, table, 1, 1); row["score"] != int64(7) {
+		t.Errorf("expected the score converted, got %v of type %T", row["score"], row["score"])
+	}
+
+	if err := table.ChangeColumnType("score", database.COL_TYPE_STRING); err != nil {
+		t.Fatalf("error changing type: %v", err)
+	}
+
+	reloadedTable, _ := reloadMockDatabase(t).GetTable("table_composite")
+
+	if row := getCompositeKeyRow(t, reloadedTable, 1, 1); row["score"] != "7" {
+		t.Errorf("expected the score converted after loading, got %v of type %T", row["score"], row["score"])
+	}
+}
+
+func TestRewriteRows(t *testing.T) {
+	_, table := helper.CreateMockDatabaseWithCompositeKeyTable(t)
+	insertCompositeKeyRows(t, table)
+	table.AddColumn(database.Column{Name: "score", Type: database.COL_TYPE_SMALL_INT, Default: int16(3)})
+	table.ChangeColumnType("score", database.COL_TYPE_DOUBLE)
+	table.DropColumn("name")
+
+	before, _ := table.RangeByKey(nil, nil, -1, database.ASC)
+
+	if err := <-table.RewriteRowsInBackground(); err != nil {
+		t.Fatalf("error rewriting rows: %v", err)
+	}
+
+	after, err := table.RangeByKey(nil, nil, -1, database.ASC)
+
+	if err != nil || len(after) != len(before) || len(after) != 12 {
+		t.Fatalf("expected 12 rows after rewriting, got %d with error %v", len(after), err)
+	}
+
+	for i := range after {
+		if len(after[i]) != 3 || after[i]["score"] != float64(3) || after[i]["id"] != before[i]["id"] {
+			t.Errorf("expected %v, got %v", before[i], after[i])
+		}
+	}
+
+	// Rows rewritten are stored with the current columns only
+	var stored []database.ColumnValue
+	utils.Deserialize(table.PDataFile.GetIterator(nil).GetKeyValue().Value, &stored)
+
+	if len(stored) != 3 || stored[2].Col != table.Columns[2].Id {
+		t.Errorf("expected the row stored with the current columns, got %v", stored)
+	}
+}
@@ -26,8 +26,8 @@ func TestNodeCreation(t *testing.T) {
 		t.Error("Should have 0 items")
 	}
 
-	if bTree.GetFreeBytes(newNode) != 4080 {
-		t.Error("Should be 4080 free bytes")
+	if bTree.GetFreeBytes(newNode) != 4076 {
+		t.Error("Should be 4076 free bytes")
 	}
 
 }
@@ -46,8 +46,8 @@ func TestLeafCreation(t *testing.T) {
 		t.Error("Should have 0 items")
 	}
 
-	if bTree.GetFreeBytes(newLeaf) != 4070 {
-		t.Error("Should be 4070 free bytes")
+	if bTree.GetFreeBytes(newLeaf) != 4068 {
+		t.Error("Should be 4068 free bytes")
 	}
 }
 
@@ -67,8 +67,8 @@ func TestInternalNodeInsertion(t *testing.T) {
 		t.Errorf("Number of Items should be 1, found %d\n", newNode.GetNItens())
 	}
 
-	if bTree.GetFreeBytes(newNode) != 4069 {
-		t.Errorf("Number of FreeBytes shoud be 4069, found %d\n", newNode.GetNItens())
+	if bTree.GetFreeBytes(newNode) != 4065 {
+		t.Errorf("Number of FreeBytes shoud be 4065, found %d\n", newNode.GetNItens())
 	}
 
 	if !bytes.Equal(newNode.GetNodeChildByIndex(0).GetKey(), key) {
@@ -83,8 +83,8 @@ func TestInternalNodeInsertion(t *testing.T) {
 		t.Errorf("Number of Items should be 2, found %d\n", newNode.GetNItens())
 	}
 
-	if bTree.GetFreeBytes(newNode) != 4058 {
-		t.Errorf("Number of FreeBytes shoud be 4058, found %d\n", newNode.GetNItens())
+	if bTree.GetFreeBytes(newNode) != 4054 {
+		t.Errorf("Number of FreeBytes shoud be 4054, found %d\n", newNode.GetNItens())
 	}
 
 	if !bytes.Equal(newNode.GetNodeChildByIndex(0).GetKey(), key) {
@@ -115,8 +115,8 @@ func TestInternalLeafInsertion(t *testing.T) {
 		t.Errorf("Number of Items should be 1, found %d\n", newNode.GetNItens())
 	}
 
-	if bTree.GetFreeBytes(newNode) != 4054 {
-		t.Errorf("Number of FreeBytes shoud be 4054, found %d\n", newNode.GetNItens())
+	if bTree.GetFreeBytes(newNode) != 4052 {
+		t.Errorf("Number of FreeBytes shoud be 4052, found %d\n", newNode.GetNItens())
 	}
 
 	if !bytes.Equal(newNode.GetLeafKeyValueByIndex(0).GetKey(), key) {
@@ -134,8 +134,8 @@ func TestInternalLeafInsertion(t *testing.T) {
 		t.Errorf("Number of Items should be 2, found %d\n", newNode.GetNItens())
 	}
 
-	if bTree.GetFreeBytes(newNode) != 4037 {
-		t.Errorf("Number of FreeBytes shoud be 4037, found %d\n", bTree.GetFreeBytes(newNode))
+	if bTree.GetFreeBytes(newNode) != 4035 {
+		t.Errorf("Number of FreeBytes shoud be 4035, found %d\n", bTree.GetFreeBytes(newNode))
 	}
 
 	if !bytes.Equal(newNode.GetLeafKeyValueByIndex(0).GetKey(), key) {
@@ -3,10 +3,13 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"math/rand"
 	"strconv"
 	"testing"
 
 	bTree "github.com/nicolasvancan/monvandb/src/btree"
+	files "github.com/nicolasvancan/monvandb/src/files"
 	helper "github.com/nicolasvancan/monvandb/src/test/helper"
 )
 
@@ -258,11 +261,20 @@ func TestDeletionOfAnEntireLeaf(t *testing.T) {
 		bTree.BTreeDelete(tree, allKeys[i])
 	}
 
-	// Print root page
+	// The leaf borrows values from its sibling before getting empty, so both remain half full
 	rootPage := tree.Get(tree.GetRoot())
-	nItens := rootPage.GetNItens()
-	if nItens != 1 {
-		t.Error("Should be only one item")
+	if rootPage.GetNItens() != 2 {
+		t.Errorf("Should have two items, found %d", rootPage.GetNItens())
+	}
+
+	if remainingKeys := getAllKeysCheckingTreeStructure(t, tree); len(remainingKeys) != 282-len(allKeys) {
+		t.Errorf("Should have %d keys left, found %d", 282-len(allKeys), len(remainingKeys))
+	}
+
+	for i := 0; i < len(allKeys); i++ {
+		if len(bTree.BTreeGet(tree, allKeys[i])) != 0 {
+			t.Errorf("Key %s should have been deleted", allKeys[i])
+		}
 	}
 }
 
@@ -457,3 +469,348 @@ func TestCrawlingForBTreeReachingEndAndBegin(t *testing.T) {
 	}
 
 }
+
+// Reads every leaf of the tree in order, checking that keys are sorted and that leaves point to their parents
+func getAllKeysCheckingTreeStructure(t *testing.T, tree *bTree.BTree) [][]byte {
+	keys := make([][]byte, 0)
+	mappedLeaves := bTree.MapAllLeavesToArray(tree)
+
+	for _, mappedLeaf := range mappedLeaves {
+		leaf := tree.Get(mappedLeaf.TreeNode)
+
+		if len(mappedLeaf.History) > 0 && leaf.GetParentAddr() != mappedLeaf.History[len(mappedLeaf.History)-1] {
+			t.Errorf("leaf %d should point to parent %d, found %d", mappedLeaf.TreeNode, mappedLeaf.History[len(mappedLeaf.History)-1], leaf.GetParentAddr())
+		}
+
+		for i := 0; i < int(leaf.GetNItens()); i++ {
+			key := leaf.GetLeafKeyValueByIndex(uint16(i)).GetKey()
+			if len(keys) > 0 && bytes.Compare(keys[len(keys)-1], key) > 0 {
+				t.Fatalf("keys out of order between %v and %v", keys[len(keys)-1], key)
+			}
+			keys = append(keys, append([]byte{}, key...))
+		}
+	}
+
+	return keys
+}
+
+func TestDeletionMergesUnderflowedLeaves(t *testing.T) {
+	dataFile, _ := openDataFileForTesting(t)
+	tree := dataFile.GetBTree()
+	value := make([]byte, 100)
+
+	dataFile.Batch(func(tx *files.FileTx) error {
+		for i := 0; i < 3000; i++ {
+			tx.Insert(uint32Key(i), value)
+		}
+		return nil
+	})
+
+	leavesBefore := len(bTree.MapAllLeavesToArray(tree))
+
+	// Only one of every ten keys is kept
+	for i := 0; i < 3000; i++ {
+		if i%10 != 0 {
+			dataFile.Delete(uint32Key(i))
+		}
+	}
+
+	keys := getAllKeysCheckingTreeStructure(t, tree)
+	if len(keys) != 300 {
+		t.Errorf("should have 300 keys left, found %d", len(keys))
+	}
+
+	mappedLeaves := bTree.MapAllLeavesToArray(tree)
+	if len(mappedLeaves) > leavesBefore/5 {
+		t.Errorf("leaves should have been merged, there were %d and now there are %d", leavesBefore, len(mappedLeaves))
+	}
+
+	for _, mappedLeaf := range mappedLeaves {
+		leaf := tree.Get(mappedLeaf.TreeNode)
+		if freeBytes := bTree.GetFreeBytes(&leaf); int(freeBytes) > bTree.PAGE_SIZE-bTree.NODE_UNDERFLOW_BYTES {
+			t.Errorf("leaf %d is underflowed, it has %d free bytes", mappedLeaf.TreeNode, freeBytes)
+		}
+	}
+
+	for i := 0; i < 3000; i++ {
+		if found := len(getForTesting(t, dataFile, uint32Key(i))); (i%10 == 0) != (found == 1) {
+			t.Errorf("unexpected result for key %d, found %d values", i, found)
+		}
+	}
+}
+
+func TestDeletionCollapsesRootIntoLeaf(t *testing.T) {
+	dataFile, _ := openDataFileForTesting(t)
+	tree := dataFile.GetBTree()
+	value := make([]byte, 100)
+
+	dataFile.Batch(func(tx *files.FileTx) error {
+		for i := 0; i < 3000; i++ {
+			tx.Insert(uint32Key(i), value)
+		}
+		return nil
+	})
+
+	if root := tree.Get(tree.GetRoot()); root.GetType() != bTree.TREE_NODE {
+		t.Fatal("root should be an internal node after loading the tree")
+	}
+
+	for i := 10; i < 3000; i++ {
+		dataFile.Delete(uint32Key(i))
+	}
+
+	root := tree.Get(tree.GetRoot())
+	if root.GetType() != bTree.TREE_LEAF || root.GetNItens() != 10 {
+		t.Errorf("root should be a leaf with 10 items, found type %d with %d items", root.GetType(), root.GetNItens())
+	}
+
+	if root.GetParentAddr() != 0 {
+		t.Errorf("root should not have a parent, found %d", root.GetParentAddr())
+	}
+}
+
+func TestRandomInsertionsAndDeletions(t *testing.T) {
+	dataFile, _ := openDataFileForTesting(t)
+	tree := dataFile.GetBTree()
+	random := rand.New(rand.NewSource(42))
+	keys := random.Perm(5000)
+
+	dataFile.Batch(func(tx *files.FileTx) error {
+		for _, k := range keys {
+			tx.Insert(uint32Key(k), make([]byte, 20+k%200))
+		}
+		return nil
+	})
+
+	if allKeys := getAllKeysCheckingTreeStructure(t, tree); len(allKeys) != 5000 {
+		t.Fatalf("should have 5000 keys, found %d", len(allKeys))
+	}
+
+	// Half of the keys are deleted in another random order
+	deleted := make(map[int]bool)
+	for _, k := range random.Perm(5000)[:2500] {
+		dataFile.Delete(uint32Key(k))
+		deleted[k] = true
+	}
+
+	if allKeys := getAllKeysCheckingTreeStructure(t, tree); len(allKeys) != 2500 {
+		t.Errorf("should have 2500 keys, found %d", len(allKeys))
+	}
+
+	for k := 0; k < 5000; k++ {
+		res := getForTesting(t, dataFile, uint32Key(k))
+		if deleted[k] && len(res) != 0 {
+			t.Errorf("key %d should have been deleted", k)
+		}
+
+		if !deleted[k] && (len(res) != 1 || len(res[0].Value) != 20+k%200) {
+			t.Errorf("should have found key %d", k)
+		}
+	}
+}
+
+// Values with up to 1.5 KB, and one of every five with 3 up to 12 KB, which need sequences
+func randomValueSizeForTesting(random *rand.Rand) int {
+	if random.Intn(5) == 0 {
+		return 3*1024 + random.Intn(9*1024)
+	}
+
+	return 1 + random.Intn(1536)
+}
+
+// Checks the tree against the keys it should have, and verifies its structure
+func assertTreeMatchesModel(t *testing.T, tree *bTree.BTree, model map[string][]byte, step string) {
+	for _, problem := range bTree.Verify(tree).Problems {
+		t.Fatalf("after %s: unexpected problem: %v", step, problem)
+	}
+
+	allKeys := getAllKeysCheckingTreeStructure(t, tree)
+	if len(allKeys) != len(model) {
+		t.Fatalf("after %s: should have %d keys, found %d", step, len(model), len(allKeys))
+	}
+
+	for _, key := range allKeys {
+		if _, ok := model[string(key)]; !ok {
+			t.Fatalf("after %s: key %s should not be in the tree", step, key)
+		}
+	}
+}
+
+func TestRandomInsertionsOfMixedSizeValues(t *testing.T) {
+	tree := newMemoryTreeForTesting()
+	random := rand.New(rand.NewSource(0))
+	model := make(map[string][]byte)
+
+	for len(model) < 300 {
+		key := fmt.Sprintf("k%05d", random.Intn(1000))
+		value := make([]byte, randomValueSizeForTesting(random))
+		random.Read(value)
+
+		if _, ok := model[key]; ok {
+			continue
+		}
+
+		bTree.BTreeInsert(tree, []byte(key), value)
+		model[key] = value
+		assertTreeMatchesModel(t, tree, model, "inserting "+key)
+	}
+
+	for key, value := range model {
+		if res := bTree.BTreeGet(tree, []byte(key)); len(res) != 1 || !bytes.Equal(res[0].Value, value) {
+			t.Errorf("key %s should have its value", key)
+		}
+	}
+}
+
+func TestRandomInsertionsAndDeletionsOfMixedSizeValues(t *testing.T) {
+	tree := newMemoryTreeForTesting()
+	random := rand.New(rand.NewSource(0))
+	model := make(map[string][]byte)
+
+	for i := 0; i < 1500; i++ {
+		key := fmt.Sprintf("k%05d", random.Intn(500))
+		_, exists := model[key]
+
+		// Deletions are less frequent than insertions, so the tree grows up to several levels
+		if exists && random.Intn(3) == 0 {
+			bTree.BTreeDelete(tree, []byte(key))
+			delete(model, key)
+			assertTreeMatchesModel(t, tree, model, "deleting "+key)
+			continue
+		}
+
+		if exists {
+			continue
+		}
+
+		value := make([]byte, randomValueSizeForTesting(random))
+		random.Read(value)
+		bTree.BTreeInsert(tree, []byte(key), value)
+		model[key] = value
+		assertTreeMatchesModel(t, tree, model, "inserting "+key)
+	}
+
+	for key, value := range model {
+		if res := bTree.BTreeGet(tree, []byte(key)); len(res) != 1 || !bytes.Equal(res[0].Value, value) {
+			t.Errorf("key %s should have its value", key)
+		}
+	}
+}
+
+// Inserts the even keys from 0 up to 2 * n in order, so every leaf but the last one gets full
+func fillUpLeavesWithEvenKeys(tree *bTree.BTree, n int) {
+	for i := 0; i < n; i++ {
+		bTree.BTreeInsert(tree, []byte(fmt.Sprintf("%06d", 2*i)), make([]byte, 100))
+	}
+}
+
+func TestInsertionShiftsOverflowToRightSibling(t *testing.T) {
+	tree := newMemoryTreeForTesting()
+	fillUpLeavesWithEvenKeys(tree, 350)
+
+	leaves := bTree.MapAllLeavesToArray(tree)
+	sibling := tree.Get(leaves[1].TreeNode)
+	siblingItems := sibling.GetNItens()
+
+	// Some room is made in the second leaf, without getting it underflowed
+	for i := 0; i < 3; i++ {
+		bTree.BTreeDelete(tree, sibling.GetLeafKeyValueByIndex(uint16(i)).GetKey())
+	}
+
+	// The first leaf is full, its last value is moved to the second one instead of splitting it
+	bTree.BTreeInsert(tree, []byte("000001"), make([]byte, 100))
+
+	if len(bTree.MapAllLeavesToArray(tree)) != len(leaves) {
+		t.Errorf("should still have %d leaves, found %d", len(leaves), len(bTree.MapAllLeavesToArray(tree)))
+	}
+
+	sibling = tree.Get(leaves[1].TreeNode)
+	if sibling.GetNItens() != siblingItems-2 {
+		t.Errorf("second leaf should have %d items, found %d", siblingItems-2, sibling.GetNItens())
+	}
+
+	// The parent must point to the second leaf by its new first key
+	root := tree.Get(tree.GetRoot())
+	if !bytes.Equal(root.GetNodeChildByIndex(1).GetKey(), sibling.GetLeafKeyValueByIndex(0).GetKey()) {
+		t.Errorf("parent key should be %s, found %s", sibling.GetLeafKeyValueByIndex(0).GetKey(), root.GetNodeChildByIndex(1).GetKey())
+	}
+
+	for _, problem := range bTree.Verify(tree).Problems {
+		t.Errorf("unexpected problem: %v", problem)
+	}
+
+	if allKeys := getAllKeysCheckingTreeStructure(t, tree); len(allKeys) != 348 {
+		t.Errorf("should have 348 keys, found %d", len(allKeys))
+	}
+}
+
+func TestInsertionSplitsLeafWhenRightSiblingIsFull(t *testing.T) {
+	tree := newMemoryTreeForTesting()
+	fillUpLeavesWithEvenKeys(tree, 350)
+	leaves := bTree.MapAllLeavesToArray(tree)
+
+	// Both the first leaf and its sibling are full
+	bTree.BTreeInsert(tree, []byte("000001"), make([]byte, 100))
+
+	if len(bTree.MapAllLeavesToArray(tree)) != len(leaves)+1 {
+		t.Errorf("should have %d leaves, found %d", len(leaves)+1, len(bTree.MapAllLeavesToArray(tree)))
+	}
+
+	for _, problem := range bTree.Verify(tree).Problems {
+		t.Errorf("unexpected problem: %v", problem)
+	}
+
+	if allKeys := getAllKeysCheckingTreeStructure(t, tree); len(allKeys) != 351 {
+		t.Errorf("should have 351 keys, found %d", len(allKeys))
+	}
+}
+
+/*
+Values are only shifted into a sibling under the same parent. Once the tree has many levels, the next leaf of a
+parent's last child belongs to another parent, and the leaf is split instead, keeping the keys of both parents right
+*/
+func TestInsertionIntoLastChildOfAParentSplitsIt(t *testing.T) {
+	tree := newMemoryTreeForTesting()
+	// Long keys make internal nodes hold a few children, so the tree grows up to three levels
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("%0400d", i))
+	}
+
+	for i := 0; i < 600; i++ {
+		bTree.BTreeInsert(tree, key(2*i), make([]byte, 100))
+	}
+
+	// The first leaf that is the last child of its parent, so the next leaf has another parent
+	mappedLeaves := bTree.MapAllLeavesToArray(tree)
+	var lastChild bTree.TreeNode
+	for i := 0; i < len(mappedLeaves)-1; i++ {
+		parent := mappedLeaves[i].History[len(mappedLeaves[i].History)-1]
+		if parent != mappedLeaves[i+1].History[len(mappedLeaves[i+1].History)-1] {
+			lastChild = tree.Get(mappedLeaves[i].TreeNode)
+			break
+		}
+	}
+
+	if len(mappedLeaves[0].History) < 2 {
+		t.Fatal("the tree should have internal nodes below the root")
+	}
+
+	// A key lower than the last one of the leaf, which is full
+	lastKey := lastChild.GetLeafKeyValueByIndex(lastChild.GetNItens() - 1).GetKey()
+	var n int
+	fmt.Sscanf(string(lastKey), "%d", &n)
+
+	bTree.BTreeInsert(tree, key(n-1), make([]byte, 100))
+
+	if len(bTree.MapAllLeavesToArray(tree)) != len(mappedLeaves)+1 {
+		t.Errorf("should have %d leaves, found %d", len(mappedLeaves)+1, len(bTree.MapAllLeavesToArray(tree)))
+	}
+
+	for _, problem := range bTree.Verify(tree).Problems {
+		t.Errorf("unexpected problem: %v", problem)
+	}
+
+	if allKeys := getAllKeysCheckingTreeStructure(t, tree); len(allKeys) != 601 {
+		t.Errorf("should have 601 keys, found %d", len(allKeys))
+	}
+}
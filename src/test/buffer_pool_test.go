@@ -0,0 +1,172 @@
+package main
+
+/*
+Tests for the buffer pool. Pages are read from the file only when they are not in the pool, the least recently used
+ones are evicted first, and dirty pages are written back before being evicted
+*/
+
+import (
+	"os"
+	"testing"
+
+	bTree "github.com/nicolasvancan/monvandb/src/btree"
+	files "github.com/nicolasvancan/monvandb/src/files"
+)
+
+// Buffer pool over fake pages, recording every page read and written
+func newBufferPoolForTesting(capacity int) (*files.BufferPool, *[]uint64, *[]uint64) {
+	reads := make([]uint64, 0)
+	writes := make([]uint64, 0)
+
+	pool := files.NewBufferPool(
+		capacity,
+		func(page uint64) ([]byte, error) {
+			reads = append(reads, page)
+			return make([]byte, bTree.PAGE_SIZE), nil
+		},
+		func(page uint64, data []byte) error {
+			writes = append(writes, page)
+			return nil
+		},
+	)
+
+	return pool, &reads, &writes
+}
+
+func pinAndUnpin(t *testing.T, pool *files.BufferPool, page uint64) {
+	if _, err := pool.Pin(page); err != nil {
+		t.Fatalf("error pinning page %d: %v", page, err)
+	}
+
+	pool.Unpin(page, false)
+}
+
+func TestBufferPoolEvictsLeastRecentlyUsedPage(t *testing.T) {
+	pool, reads, _ := newBufferPoolForTesting(2)
+
+	pinAndUnpin(t, pool, 1)
+	pinAndUnpin(t, pool, 2)
+	pinAndUnpin(t, pool, 1)
+	// Page 2 is the least recently used one
+	pinAndUnpin(t, pool, 3)
+	pinAndUnpin(t, pool, 1)
+
+	if len(*reads) != 3 {
+		t.Errorf("expected 3 reads, got %v", *reads)
+	}
+
+	pinAndUnpin(t, pool, 2)
+
+	if len(*reads) != 4 {
+		t.Errorf("page 2 should have been read again, reads %v", *reads)
+	}
+
+	stats := pool.Stats()
+	if stats.Hits != 2 || stats.Misses != 4 || stats.Evictions != 2 || stats.Pages != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestBufferPoolKeepsPinnedPages(t *testing.T) {
+	pool, _, _ := newBufferPoolForTesting(1)
+
+	if _, err := pool.Pin(1); err != nil {
+		t.Fatalf("error pinning page 1: %v", err)
+	}
+
+	if _, err := pool.Pin(2); err == nil {
+		t.Error("should not evict a pinned page")
+	}
+
+	pool.Unpin(1, false)
+	pinAndUnpin(t, pool, 2)
+}
+
+func TestBufferPoolWritesDirtyPagesBack(t *testing.T) {
+	pool, _, writes := newBufferPoolForTesting(2)
+
+	pool.Put(1, make([]byte, bTree.PAGE_SIZE))
+	pool.Put(2, make([]byte, bTree.PAGE_SIZE))
+
+	if len(*writes) != 0 {
+		t.Errorf("dirty pages should not be written before eviction, writes %v", *writes)
+	}
+
+	// Page 1 is evicted
+	pinAndUnpin(t, pool, 3)
+
+	if len(*writes) != 1 || (*writes)[0] != 1 {
+		t.Errorf("page 1 should have been written when evicted, writes %v", *writes)
+	}
+
+	if err := pool.Flush(); err != nil {
+		t.Fatalf("error flushing: %v", err)
+	}
+
+	if len(*writes) != 2 || (*writes)[1] != 2 {
+		t.Errorf("page 2 should have been written on flush, writes %v", *writes)
+	}
+
+	if stats := pool.Stats(); stats.Dirty != 0 || stats.Writes != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestDataFileReadsThroughBufferPool(t *testing.T) {
+	dataFile, _ := openDataFileForTesting(t)
+	fillDataFileForChecksum(t, dataFile)
+
+	before := dataFile.GetBufferPoolStats()
+
+	for i := 0; i < 200; i++ {
+		getForTesting(t, dataFile, uint32Key(i))
+	}
+
+	after := dataFile.GetBufferPoolStats()
+
+	// Every committed page is already in the pool
+	if after.Misses != before.Misses {
+		t.Errorf("pages should have been found in the pool, misses went from %d to %d", before.Misses, after.Misses)
+	}
+
+	if after.Hits <= before.Hits {
+		t.Error("reads should have hit the pool")
+	}
+}
+
+func TestDataFileWithSmallBufferPool(t *testing.T) {
+	path := t.TempDir() + string(os.PathSeparator) + "small_pool.db"
+	dataFile, err := files.OpenDataFileWithOptions(path, files.DataFileOptions{BufferPoolSize: 4})
+
+	if err != nil {
+		t.Fatalf("error opening data file: %v", err)
+	}
+
+	dataFile.Batch(func(tx *files.FileTx) error {
+		for i := 0; i < 2000; i++ {
+			tx.Insert(uint32Key(i), make([]byte, 100))
+		}
+		return nil
+	})
+
+	if stats := dataFile.GetBufferPoolStats(); stats.Evictions == 0 || stats.Pages > 4 {
+		t.Errorf("pages should have been evicted, stats %+v", stats)
+	}
+
+	assertNoProblems(t, dataFile)
+	dataFile.Close()
+
+	reopened, err := files.OpenDataFileWithOptions(path, files.DataFileOptions{BufferPoolSize: 4})
+
+	if err != nil {
+		t.Fatalf("error opening data file: %v", err)
+	}
+
+	t.Cleanup(reopened.Close)
+
+	for i := 0; i < 2000; i++ {
+		if len(getForTesting(t, reopened, uint32Key(i))) != 1 {
+			t.Errorf("should have found key %d", i)
+		}
+	}
+}
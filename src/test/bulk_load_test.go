@@ -0,0 +1,110 @@
+package main
+
+/*
+Tests for bulk loads, which build a tree from sorted key values at once. The tree must be as valid as one built by
+inserting them, and keep working as usual afterwards
+*/
+
+import (
+	"bytes"
+	"testing"
+
+	bTree "github.com/nicolasvancan/monvandb/src/btree"
+)
+
+// Sorted key values, with repeated keys and some values large enough to need sequences
+func bulkLoadKeyValues(n int) []bTree.BTreeKeyValue {
+	keyValues := make([]bTree.BTreeKeyValue, n)
+	for i := range keyValues {
+		value := make([]byte, 30+i%40)
+		if i%500 == 0 {
+			value = make([]byte, bTree.PAGE_SIZE*2)
+		}
+
+		value[0] = byte(i)
+		keyValues[i] = bTree.BTreeKeyValue{Key: uint32Key(i / 2), Value: value}
+	}
+
+	return keyValues
+}
+
+func assertTreeHasNoProblems(t *testing.T, tree *bTree.BTree) {
+	t.Helper()
+
+	for _, problem := range bTree.Verify(tree).Problems {
+		t.Errorf("unexpected problem: %v", problem)
+	}
+}
+
+func TestBulkLoadBuildsAValidTree(t *testing.T) {
+	tree := newMemoryTreeForTesting()
+	keyValues := bulkLoadKeyValues(20000)
+
+	if err := bTree.BTreeBulkLoad(tree, keyValues); err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+
+	assertTreeHasNoProblems(t, tree)
+
+	// Crawling finds every value in the order it was given
+	crawler := bTree.GoToFirstLeaf(tree)
+	for i, keyValue := range keyValues {
+		kv := crawler.GetKeyValue()
+
+		if kv == nil || !bytes.Equal(kv.Key, keyValue.Key) || !bytes.Equal(kv.Value, keyValue.Value) {
+			t.Fatalf("value %d should have been crawled, found %v", i, kv)
+		}
+
+		crawler.Next()
+	}
+
+	for i := 0; i < len(keyValues); i += 2 {
+		if found := bTree.BTreeGet(tree, keyValues[i].Key); len(found) != 2 {
+			t.Fatalf("key %d should have been found with its two values, found %d values", i/2, len(found))
+		}
+	}
+}
+
+func TestBulkLoadedTreeKeepsChanging(t *testing.T) {
+	tree := newMemoryTreeForTesting()
+	keyValues := make([]bTree.BTreeKeyValue, 3000)
+	for i := range keyValues {
+		keyValues[i] = bTree.BTreeKeyValue{Key: uint32Key(i * 2), Value: make([]byte, 50)}
+	}
+
+	bTree.BTreeBulkLoad(tree, keyValues)
+
+	// Odd keys go between the loaded ones, filling the room left in their leaves
+	for i := 0; i < 3000; i++ {
+		bTree.BTreeInsert(tree, uint32Key(i*2+1), make([]byte, 50))
+	}
+
+	for i := 0; i < 6000; i += 3 {
+		bTree.BTreeDelete(tree, uint32Key(i))
+	}
+
+	assertTreeHasNoProblems(t, tree)
+
+	if n := len(crawlKeys(tree)); n != 4000 {
+		t.Errorf("expected 4000 keys, found %d", n)
+	}
+}
+
+func TestBulkLoadRejectsInvalidInput(t *testing.T) {
+	tree := newMemoryTreeForTesting()
+	unsorted := []bTree.BTreeKeyValue{{Key: uint32Key(2)}, {Key: uint32Key(1)}}
+
+	if err := bTree.BTreeBulkLoad(tree, unsorted); err == nil {
+		t.Error("unsorted keys should have been rejected")
+	}
+
+	if tree.GetRoot() != 0 {
+		t.Error("tree should have been left empty")
+	}
+
+	bTree.BTreeInsert(tree, uint32Key(1), []byte("value"))
+
+	if err := bTree.BTreeBulkLoad(tree, bulkLoadKeyValues(10)); err == nil {
+		t.Error("loading a tree that is not empty should have failed")
+	}
+}
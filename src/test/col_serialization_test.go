@@ -84,7 +84,11 @@ func TestSerializationWithDataFile(t *testing.T) {
 	}
 
 	// Create new variable to hold value returned from DataFile
-	serializedRow2 := dFile.Get([]byte("1"))
+	serializedRow2, err := dFile.Get([]byte("1"))
+
+	if err != nil {
+		t.Errorf("Error getting data from data file: %s", err)
+	}
 
 	if serializedRow2 == nil {
 		t.Errorf("Error getting data from data file")
@@ -0,0 +1,174 @@
+package main
+
+/*
+Tests for tables whose key is made of many columns. Rows must be stored by every key column, found by any prefix of
+them, and ranges on the leading columns must find exactly the rows within them
+*/
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	database "github.com/nicolasvancan/monvandb/src/database"
+	helper "github.com/nicolasvancan/monvandb/src/test/helper"
+)
+
+// Rows for tenants 1 to 3, with ids 1 to 4 each
+func createCompositeKeyTableWithRows(t *testing.T) *database.Table {
+	_, table := helper.CreateMockDatabaseWithCompositeKeyTable(t)
+	insertCompositeKeyRows(t, table)
+
+	return table
+}
+
+func insertCompositeKeyRows(t *testing.T, table *database.Table) {
+	rows := make([]database.RawRow, 0)
+
+	// Inserted out of order, the table must sort them
+	for id := int32(4); id > 0; id-- {
+		for tenant := int32(3); tenant > 0; tenant-- {
+			rows = append(rows, database.RawRow{"tenant": tenant, "id": id, "name": fmt.Sprintf("%d-%d", tenant, id)})
+		}
+	}
+
+	if _, err := table.Insert(rows); err != nil {
+		t.Fatalf("error inserting rows: %v", err)
+	}
+}
+
+// Returns the names of the rows, which are made of their keys
+func rowNames(rows []database.RawRow) string {
+	names := make([]string, len(rows))
+	for i, row := range rows {
+		names[i] = fmt.Sprint(row["name"])
+	}
+
+	return strings.Join(names, " ")
+}
+
+func assertRowNames(t *testing.T, rows []database.RawRow, err error, expected string) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if names := rowNames(rows); names != expected {
+		t.Errorf("expected rows [%s], got [%s]", expected, names)
+	}
+}
+
+func TestCompositeKeyIsStoredWithTheTable(t *testing.T) {
+	_, table := helper.CreateMockDatabaseWithCompositeKeyTable(t)
+
+	reloaded, err := database.LoadTable(table.Path)
+
+	if err != nil {
+		t.Fatalf("error loading table: %v", err)
+	}
+
+	defer reloaded.PDataFile.Close()
+
+	if reloaded.PrimaryKey != nil || len(reloaded.CompositeKey) != 2 || reloaded.CompositeKey[1].Name != "id" {
+		t.Errorf("expected the key (tenant, id), got %v and %v", reloaded.PrimaryKey, reloaded.CompositeKey)
+	}
+}
+
+func TestCompositeKeyLookups(t *testing.T) {
+	table := createCompositeKeyTableWithRows(t)
+
+	rows, err := table.GetByKey(int32(2), int32(3))
+	assertRowNames(t, rows, err, "2-3")
+
+	rows, err = table.GetByKey(int32(2))
+	assertRowNames(t, rows, err, "2-1 2-2 2-3 2-4")
+
+	rows, err = table.GetByKey(int32(4))
+	assertRowNames(t, rows, err, "")
+
+	// The leading column is found by the key, the other one by scanning the table
+	rows, err = table.Get("tenant", int32(3))
+	assertRowNames(t, rows, err, "3-1 3-2 3-3 3-4")
+
+	rows, err = table.Get("id", int32(1))
+	assertRowNames(t, rows, err, "1-1 2-1 3-1")
+
+	if _, err = table.GetByKey(int32(1), int32(1), int32(1)); err == nil {
+		t.Error("more values than key columns should fail")
+	}
+}
+
+func TestCompositeKeyUniqueness(t *testing.T) {
+	table := createCompositeKeyTableWithRows(t)
+
+	if _, err := table.Insert([]database.RawRow{{"tenant": int32(1), "id": int32(1)}}); err == nil {
+		t.Error("inserting an existing key should fail")
+	}
+
+	// The same id is fine for another tenant, but not twice in the same insertion
+	if _, err := table.Insert([]database.RawRow{{"tenant": int32(4), "id": int32(1)}}); err != nil {
+		t.Errorf("error inserting a new key: %v", err)
+	}
+
+	duplicated := []database.RawRow{{"tenant": int32(5), "id": int32(1)}, {"tenant": int32(5), "id": int32(1)}}
+	if _, err := table.Insert(duplicated); err == nil {
+		t.Error("inserting the same key twice should fail")
+	}
+
+	rows, err := table.GetByKey(int32(5))
+	assertRowNames(t, rows, err, "")
+}
+
+func TestCompositeKeyRanges(t *testing.T) {
+	table := createCompositeKeyTableWithRows(t)
+
+	rows, err := table.RangeByKey([]any{int32(2)}, []any{int32(3)}, -1, database.ASC)
+	assertRowNames(t, rows, err, "2-1 2-2 2-3 2-4 3-1 3-2 3-3 3-4")
+
+	rows, err = table.RangeByKey([]any{int32(1), int32(2)}, []any{int32(1), int32(3)}, -1, database.ASC)
+	assertRowNames(t, rows, err, "1-2 1-3")
+
+	rows, err = table.RangeByKey([]any{int32(1), int32(3)}, []any{int32(2), int32(1)}, -1, database.DESC)
+	assertRowNames(t, rows, err, "2-1 1-4 1-3")
+
+	rows, err = table.RangeByKey(nil, []any{int32(1)}, 2, database.DESC)
+	assertRowNames(t, rows, err, "1-4 1-3")
+
+	rows, err = table.RangeByKey([]any{int32(3)}, nil, 2, database.ASC)
+	assertRowNames(t, rows, err, "3-1 3-2")
+}
+
+func TestRangeOnLeadingKeyColumn(t *testing.T) {
+	table := createCompositeKeyTableWithRows(t)
+	comparsion := func(condition int, value int32) []database.ColumnComparsion {
+		return []database.ColumnComparsion{{
+			ColumnName: "tenant",
+			Condition:  condition,
+			Value:      database.ColumnConditionValue{Value: value},
+		}}
+	}
+
+	assertRowNames(t, rangeForTesting(t, table, comparsion(database.GT, 2), -1, database.ASC), nil, "3-1 3-2 3-3 3-4")
+	assertRowNames(t, rangeForTesting(t, table, comparsion(database.LTE, 1), -1, database.ASC), nil, "1-1 1-2 1-3 1-4")
+	assertRowNames(t, rangeForTesting(t, table, comparsion(database.LT, 3), -1, database.DESC), nil, "2-4 2-3 2-2 2-1 1-4 1-3 1-2 1-1")
+	assertRowNames(t, rangeForTesting(t, table, comparsion(database.GTE, 3), -1, database.DESC), nil, "3-4 3-3 3-2 3-1")
+}
+
+func TestCompositeKeyUpdateAndDelete(t *testing.T) {
+	table := createCompositeKeyTableWithRows(t)
+
+	if _, err := table.Update([]database.RawRow{{"tenant": int32(2), "id": int32(2), "name": "updated"}}); err != nil {
+		t.Fatalf("error updating: %v", err)
+	}
+
+	if _, err := table.Delete([]database.RawRow{{"tenant": int32(2), "id": int32(3)}}); err != nil {
+		t.Fatalf("error deleting: %v", err)
+	}
+
+	rows, err := table.GetByKey(int32(2))
+	assertRowNames(t, rows, err, "2-1 updated 2-4")
+
+	rows, err = table.GetByKey(int32(1))
+	assertRowNames(t, rows, err, "1-1 1-2 1-3 1-4")
+}
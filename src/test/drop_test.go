@@ -0,0 +1,127 @@
+package main
+
+/*
+Tests for dropping indexes, tables and databases. Whatever was dropped must be gone from the metadata files and from
+disk, while the rest keeps working and is loaded again as usual
+*/
+
+import (
+	"os"
+	"testing"
+
+	database "github.com/nicolasvancan/monvandb/src/database"
+	helper "github.com/nicolasvancan/monvandb/src/test/helper"
+	utils "github.com/nicolasvancan/monvandb/src/utils"
+)
+
+func reloadMockDatabase(t *testing.T) *database.Database {
+	t.Helper()
+
+	db, err := database.LoadDatabase(utils.GetPath("databases") + utils.SEPARATOR + "mock")
+
+	if err != nil {
+		t.Fatalf("error loading database: %v", err)
+	}
+
+	return db
+}
+
+func assertPathRemoved(t *testing.T, path string) {
+	t.Helper()
+
+	if utils.FileExists(path) {
+		t.Errorf("%s should have been removed", path)
+	}
+}
+
+func TestDropIndex(t *testing.T) {
+	db, table := helper.CreateMockDatabaseWithCompositeKeyTable(t)
+	insertCompositeKeyRows(t, table)
+
+	db.CreateIndex("table_composite", "id", "id_index")
+	db.CreateIndexWithOptions("table_composite", "name", "name_index", database.IndexOptions{Unique: true})
+	indexPath := table.Indexes["id"].Path
+
+	if err := db.DropIndex("table_composite", "id_index"); err != nil {
+		t.Fatalf("error dropping index: %v", err)
+	}
+
+	if table.Indexes["id"] != nil {
+		t.Error("index should have been removed from the table")
+	}
+
+	assertPathRemoved(t, indexPath)
+
+	// Rows are still found, by scanning the table
+	rows, err := table.Get("id", int32(2))
+	assertRowNames(t, rows, err, "1-2 2-2 3-2")
+
+	if err := db.DropIndex("table_composite", "id_index"); err == nil {
+		t.Error("dropping an index that doesn't exist should fail")
+	}
+
+	reloadedTable, _ := reloadMockDatabase(t).GetTable("table_composite")
+
+	if reloadedTable.Indexes["id"] != nil || reloadedTable.Indexes["name"] == nil {
+		t.Errorf("only the index left should have been loaded, found %v", reloadedTable.Indexes)
+	}
+
+	// The index can be created again
+	if err := db.CreateIndex("table_composite", "id", "id_index"); err != nil {
+		t.Fatalf("error creating index again: %v", err)
+	}
+
+	assertIdIndexMatchesTable(t, table, 4)
+}
+
+func TestDropTable(t *testing.T) {
+	db, table := helper.CreateMockDatabaseWithCompositeKeyTable(t)
+	insertCompositeKeyRows(t, table)
+	db.CreateIndex("table_composite", "id", "id_index")
+
+	if err := db.DropTable("table_composite"); err != nil {
+		t.Fatalf("error dropping table: %v", err)
+	}
+
+	if _, err := db.GetTable("table_composite"); err == nil {
+		t.Error("table should have been removed from the database")
+	}
+
+	assertPathRemoved(t, table.Path)
+
+	if _, err := reloadMockDatabase(t).GetTable("table_composite"); err == nil {
+		t.Error("table should not have been loaded")
+	}
+
+	// Files left by a table dropped before a crash are not used by a new table with the same name
+	os.MkdirAll(table.Path, os.ModePerm)
+	os.WriteFile(table.Path+utils.SEPARATOR+utils.METDATA_FILE, []byte("left behind"), 0644)
+
+	err := db.CreateTable("table_composite", []database.Column{{Name: "id", Type: database.COL_TYPE_INT, Primary: true}})
+
+	if err != nil {
+		t.Fatalf("error creating table again: %v", err)
+	}
+
+	recreated, _ := db.GetTable("table_composite")
+	rows, err := recreated.RangeByKey(nil, nil, -1, database.ASC)
+
+	if err != nil || len(rows) != 0 {
+		t.Errorf("new table should be empty, found %v rows, error %v", len(rows), err)
+	}
+}
+
+func TestDropDatabase(t *testing.T) {
+	db, table := helper.CreateMockDatabaseWithCompositeKeyTable(t)
+	insertCompositeKeyRows(t, table)
+
+	if err := database.DropDatabase(db); err != nil {
+		t.Fatalf("error dropping database: %v", err)
+	}
+
+	assertPathRemoved(t, db.Path)
+
+	if _, err := database.LoadDatabase(db.Path); err == nil {
+		t.Error("database should not be loaded anymore")
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	database "github.com/nicolasvancan/monvandb/src/database"
+	executor "github.com/nicolasvancan/monvandb/src/executor"
+	helper "github.com/nicolasvancan/monvandb/src/test/helper"
+)
+
+func collectPlan(t *testing.T, plan *executor.Plan) []database.RawRow {
+	t.Helper()
+	rows, err := plan.Collect(context.Background())
+
+	if err != nil {
+		t.Fatalf("error running plan: %v", err)
+	}
+
+	return rows
+}
+
+// Writes the columns of every row, as in "1,Joana 2,Albert"
+func rowsColumns(rows []database.RawRow, columns ...string) string {
+	formatted := make([]string, len(rows))
+	for i, row := range rows {
+		values := make([]string, len(columns))
+		for j, column := range columns {
+			values[j] = fmt.Sprint(row[column])
+		}
+
+		formatted[i] = strings.Join(values, ",")
+	}
+
+	return strings.Join(formatted, " ")
+}
+
+func TestExecutorFilterSortLimitProject(t *testing.T) {
+	table := helper.GetMocktableReadyForTesting(t)
+
+	plan := executor.FullScan(table).
+		Filter(func(row database.RawRow) bool { return row["name"] == "Joana" }).
+		Sort(executor.Desc("id")).
+		Limit(3, 1).
+		Project(executor.Column("id"), executor.Column("name").As("n"))
+
+	rows := collectPlan(t, plan)
+
+	if got := rowsColumns(rows, "id", "n"); got != "441,Joana 434,Joana 427,Joana" {
+		t.Errorf("expected the second to fourth greatest ids of Joana, got %s", got)
+	}
+
+	if len(rows) > 0 && len(rows[0]) != 2 {
+		t.Errorf("expected rows with the projected columns only, got %v", rows[0])
+	}
+
+	// Sorting by many keys keeps the order of the first one for rows with the same value
+	rows = collectPlan(t, executor.FullScan(table).Limit(14, 0).Sort(executor.Asc("name"), executor.Desc("id")).Limit(4, 0))
+
+	if got := rowsColumns(rows, "name", "id"); got != "Albert,8 Albert,1 Alice,13 Alice,6" {
+		t.Errorf("expected rows sorted by name and id, got %s", got)
+	}
+}
+
+func TestExecutorIndexAndRangeScans(t *testing.T) {
+	table := helper.GetMocktableReadyForTesting(t)
+
+	options := database.MergeOperationsBasedOnIndexedColumnsAndReturnRangeOptions(table, and(
+		where("id", database.GTE, 10),
+		where("id", database.LT, 13),
+	))
+
+	if got := rowIds(collectPlan(t, executor.IndexScan(table, options))); got != "10 11 12" {
+		t.Errorf("expected ids 10 to 12 from the range, got %s", got)
+	}
+
+	rows := collectPlan(t, executor.RangeScan(table, and(where("id", database.GT, 440), where("name", database.EQ, "Joana")), database.DESC))
+
+	if got := rowIds(rows); got != "448 441" {
+		t.Errorf("expected the filtered range in descending order, got %s", got)
+	}
+}
+
+func TestExecutorHashAggregate(t *testing.T) {
+	table := helper.GetMocktableReadyForTesting(t)
+
+	rows := collectPlan(t, executor.FullScan(table).
+		HashAggregate([]string{"name"}, executor.Count(""), executor.Min("id"), executor.Max("id"), executor.Sum("id"), executor.Avg("id").As("average")).
+		Sort(executor.Asc("name")))
+
+	if len(rows) != 7 {
+		t.Fatalf("expected a group for each of the 7 names, got %d", len(rows))
+	}
+
+	// Joana has every id multiple of 7
+	joana := rows[3]
+	expected := database.RawRow{
+		"name":     "Joana",
+		"count(*)": int64(64),
+		"min(id)":  int64(7),
+		"max(id)":  int64(448),
+		"sum(id)":  int64(14560),
+		"average":  227.5,
+	}
+
+	if !reflect.DeepEqual(joana, expected) {
+		t.Errorf("expected %v, got %v", expected, joana)
+	}
+
+	// Without groups there is a single row, even without rows to aggregate
+	rows = collectPlan(t, executor.FullScan(table).
+		Filter(func(row database.RawRow) bool { return false }).
+		HashAggregate(nil, executor.Count(""), executor.Sum("id")))
+
+	if len(rows) != 1 || rows[0]["count(*)"] != int64(0) || rows[0]["sum(id)"] != nil {
+		t.Errorf("expected a count of 0 and a null sum, got %v", rows)
+	}
+}
+
+// Creates a table of orders of the users of the mock table
+func createOrdersTable(t *testing.T, db *database.Database) *database.Table {
+	err := db.CreateTable("orders", []database.Column{
+		{Name: "id", Type: database.COL_TYPE_INT, Primary: true},
+		{Name: "user_id", Type: database.COL_TYPE_INT, Nullable: true},
+		{Name: "total", Type: database.COL_TYPE_DOUBLE},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orders := db.Tables["orders"]
+	_, err = orders.Insert([]database.RawRow{
+		{"id": 1, "user_id": 7, "total": 10.5},
+		{"id": 2, "user_id": 14, "total": 3.0},
+		{"id": 3, "user_id": 7, "total": 1.5},
+		{"id": 4, "user_id": 9999, "total": 8.0},
+		{"id": 5, "user_id": nil, "total": 2.0},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return orders
+}
+
+func TestExecutorJoins(t *testing.T) {
+	db, table := helper.CreateMockDatabaseWithTableAndIndex(t)
+
+	for i := 1; i <= 20; i++ {
+		if _, err := table.Insert([]database.RawRow{{"id": i, "name": fmt.Sprintf("user %d", i), "email": fmt.Sprintf("%d@teste.com", i)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	createOrdersTable(t, db)
+
+	users := executor.ScanTable(db, "table_teste").As("u")
+	orders := executor.ScanTable(db, "orders").As("o")
+
+	hash := collectPlan(t, users.HashJoin(orders, "u.id", "o.user_id").Sort(executor.Asc("o.id")))
+	expected := "1,user 7,10.5 2,user 14,3 3,user 7,1.5"
+
+	if got := rowsColumns(hash, "o.id", "u.name", "o.total"); got != expected {
+		t.Errorf("expected hash join rows %s, got %s", expected, got)
+	}
+
+	users = executor.ScanTable(db, "table_teste").As("u")
+	orders = executor.ScanTable(db, "orders").As("o")
+
+	nested := collectPlan(t, orders.NestedLoopJoin(users, func(left database.RawRow, right database.RawRow) bool {
+		return left["o.user_id"] != nil && fmt.Sprint(left["o.user_id"]) == fmt.Sprint(right["u.id"])
+	}))
+
+	if got := rowsColumns(nested, "o.id", "u.name", "o.total"); got != expected {
+		t.Errorf("expected nested loop join rows %s, got %s", expected, got)
+	}
+
+	// Joined plans are grouped as any other
+	totals := collectPlan(t, executor.ScanTable(db, "table_teste").As("u").
+		HashJoin(executor.ScanTable(db, "orders").As("o"), "u.id", "o.user_id").
+		HashAggregate([]string{"u.name"}, executor.Sum("o.total").As("total")).
+		Sort(executor.Desc("total")))
+
+	if got := rowsColumns(totals, "u.name", "total"); got != "user 7,12 user 14,3" {
+		t.Errorf("expected the totals of each user, got %s", got)
+	}
+
+	if _, err := executor.ScanTable(db, "missing").HashJoin(orders, "id", "user_id").Run(context.Background()); err == nil {
+		t.Errorf("expected an error running a plan of a missing table")
+	}
+}
+
+func TestExecutorSortsValuesOfOtherTypes(t *testing.T) {
+	rows := []database.RawRow{{"v": [2]int{1, 5}}, {"v": [2]int{0, 9}}, {"v": [2]int{1, 2}}}
+
+	// Values of the same type that isn't a column type are still told apart
+	if got := rowsColumns(collectPlan(t, executor.FromRows(rows).Sort(executor.Asc("v"))), "v"); got != "[0 9] [1 2] [1 5]" {
+		t.Errorf("expected the arrays sorted, got %s", got)
+	}
+
+	if got := rowsColumns(collectPlan(t, executor.FromRows(rows).HashAggregate(nil, executor.Max("v"))), "max(v)"); got != "[1 5]" {
+		t.Errorf("expected the greatest array, got %s", got)
+	}
+}
+
+func TestExecutorStopsWithContext(t *testing.T) {
+	table := helper.GetMocktableReadyForTesting(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rows, err := executor.FullScan(table).Filter(func(row database.RawRow) bool { return true }).Run(ctx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rows.Close()
+
+	read := 0
+	for rows.Next() {
+		if read++; read == 10 {
+			cancel()
+		}
+	}
+
+	if read != 10 || rows.Err() != context.Canceled {
+		t.Errorf("expected 10 rows and a canceled context, got %d rows and %v", read, rows.Err())
+	}
+
+	// Sorts read every row when opened, so they fail before giving any
+	if _, err := executor.FullScan(table).Sort(executor.Asc("name")).Run(ctx); err != context.Canceled {
+		t.Errorf("expected a canceled context opening the sort, got %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+/*
+Tests for the free pages list. Pages released by the bTree must be reused by new allocations,
+so the data file doesn't grow forever when rows are deleted and inserted again.
+*/
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+
+	bTree "github.com/nicolasvancan/monvandb/src/btree"
+	files "github.com/nicolasvancan/monvandb/src/files"
+	helper "github.com/nicolasvancan/monvandb/src/test/helper"
+)
+
+func openDataFileForTesting(t *testing.T) (*files.DataFile, string) {
+	dataFilePath := t.TempDir() + string(os.PathSeparator) + "free_list.db"
+	dataFile, err := files.OpenDataFile(dataFilePath)
+
+	if err != nil {
+		t.Fatalf("error opening data file: %v", err)
+	}
+
+	t.Cleanup(dataFile.Close)
+	return dataFile, dataFilePath
+}
+
+// Gets the key values stored for key, failing the test on errors
+func getForTesting(t *testing.T, dataFile *files.DataFile, key []byte) []bTree.BTreeKeyValue {
+	keyValues, err := dataFile.Get(key)
+
+	if err != nil {
+		t.Fatalf("error getting key %v: %v", key, err)
+	}
+
+	return keyValues
+}
+
+func uint32Key(i int) []byte {
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint32(key, uint32(i))
+	return key
+}
+
+// Committed pages may still be in the buffer pool, so they are written before measuring the data file
+func dataFileSize(t *testing.T, dataFile *files.DataFile, path string) int64 {
+	dataFile.ForceSync()
+	return fileSize(t, path)
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	fs, err := os.Stat(path)
+
+	if err != nil {
+		t.Fatalf("error reading file stat: %v", err)
+	}
+
+	return fs.Size()
+}
+
+func TestReleasedPagesAreReused(t *testing.T) {
+	dataFile, path := openDataFileForTesting(t)
+	value := make([]byte, 100)
+
+	for i := 0; i < 2000; i++ {
+		dataFile.Insert(uint32Key(i), value)
+	}
+
+	sizeAfterFirstLoad := dataFileSize(t, dataFile, path)
+
+	for i := 0; i < 2000; i++ {
+		dataFile.Delete(uint32Key(i))
+	}
+
+	if dataFile.GetBTree().GetRoot() != 0 {
+		t.Errorf("root should be 0 after deleting every key, found %d", dataFile.GetBTree().GetRoot())
+	}
+
+	if dataFile.GetBTree().GetFreeListHead() == 0 {
+		t.Error("free list should not be empty after deleting every key")
+	}
+
+	for i := 0; i < 2000; i++ {
+		dataFile.Insert(uint32Key(i), value)
+	}
+
+	if size := dataFileSize(t, dataFile, path); size > sizeAfterFirstLoad {
+		t.Errorf("file should not grow when reinserting, before %d after %d", sizeAfterFirstLoad, size)
+	}
+
+	for i := 0; i < 2000; i++ {
+		if len(getForTesting(t, dataFile, uint32Key(i))) != 1 {
+			t.Errorf("should have found key %d", i)
+		}
+	}
+}
+
+func TestFileSizeStaysBoundedUnderChurn(t *testing.T) {
+	dataFile, path := openDataFileForTesting(t)
+	value := make([]byte, 100)
+
+	for i := 0; i < 1000; i++ {
+		dataFile.Insert(uint32Key(i), value)
+	}
+
+	sizeAfterFirstLoad := dataFileSize(t, dataFile, path)
+
+	// Sliding window, the oldest keys are deleted while new ones arrive
+	for round := 0; round < 20; round++ {
+		for i := round * 200; i < (round+1)*200; i++ {
+			dataFile.Delete(uint32Key(i))
+			dataFile.Insert(uint32Key(i+1000), value)
+		}
+	}
+
+	// A few extra pages may be needed for internal nodes, never a file proportional to the churn
+	if size := dataFileSize(t, dataFile, path); size > sizeAfterFirstLoad+4*bTree.PAGE_SIZE {
+		t.Errorf("file grew from %d to %d under churn", sizeAfterFirstLoad, size)
+	}
+
+	for i := 4000; i < 5000; i++ {
+		if len(getForTesting(t, dataFile, uint32Key(i))) != 1 {
+			t.Errorf("should have found key %d", i)
+		}
+	}
+
+	if len(getForTesting(t, dataFile, uint32Key(3999))) != 0 {
+		t.Error("key 3999 should have been deleted")
+	}
+}
+
+func TestLeafSequencesAreReleased(t *testing.T) {
+	dataFile, path := openDataFileForTesting(t)
+	largeValue := helper.CreateValueOf16kLen()
+
+	for i := 0; i < 250; i++ {
+		dataFile.Insert(uint32Key(i), make([]byte, 100))
+	}
+
+	for i := 250; i < 260; i++ {
+		dataFile.Insert(uint32Key(i), largeValue)
+	}
+
+	sizeAfterFirstLoad := dataFileSize(t, dataFile, path)
+
+	for round := 0; round < 5; round++ {
+		for i := 250; i < 260; i++ {
+			dataFile.Update(uint32Key(i), largeValue)
+		}
+	}
+
+	if size := dataFileSize(t, dataFile, path); size > sizeAfterFirstLoad {
+		t.Errorf("updating large values should reuse the sequence pages, before %d after %d", sizeAfterFirstLoad, size)
+	}
+
+	res := getForTesting(t, dataFile, uint32Key(253))
+	if len(res) != 1 || len(res[0].Value) != len(largeValue) {
+		t.Error("should have found the whole large value for key 253")
+	}
+}
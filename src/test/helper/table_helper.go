@@ -8,6 +8,12 @@ import (
 )
 
 func CreateMockTableAndIndex(t *testing.T) *database.Table {
+	_, table := CreateMockDatabaseWithTableAndIndex(t)
+	return table
+}
+
+// Same as CreateMockTableAndIndex, also returning the database the table belongs to
+func CreateMockDatabaseWithTableAndIndex(t *testing.T) (*database.Database, *database.Table) {
 	// Create a new database and store it to file
 	CreateBasePaths(t)
 	CreateDatabaseFileAndSetFile(t)
@@ -60,7 +66,7 @@ func CreateMockTableAndIndex(t *testing.T) *database.Table {
 		t.Errorf("error creating index: %v", err)
 	}
 
-	return table
+	return db, table
 }
 
 func CreateMockTableAndIndexWithRows(t *testing.T) *database.Table {
@@ -82,3 +88,48 @@ func CreateMockTableAndIndexWithRows(t *testing.T) *database.Table {
 
 	return table
 }
+
+/*
+Creates a table whose key is made of two columns, tenant and id, in a new database. Rows of different tenants may
+share the same id
+*/
+func CreateMockDatabaseWithCompositeKeyTable(t *testing.T) (*database.Database, *database.Table) {
+	CreateBasePaths(t)
+	CreateDatabaseFileAndSetFile(t)
+
+	db, err := database.LoadDatabase(utils.GetPath("databases") + utils.SEPARATOR + "mock")
+
+	if err != nil {
+		t.Fatalf("error loading database: %v", err)
+	}
+
+	err = db.CreateTable("table_composite", []database.Column{
+		{
+			Name:    "tenant",
+			Type:    database.COL_TYPE_INT,
+			Primary: true,
+		},
+		{
+			Name:    "id",
+			Type:    database.COL_TYPE_INT,
+			Primary: true,
+		},
+		{
+			Name:     "name",
+			Type:     database.COL_TYPE_STRING,
+			Nullable: true,
+		},
+	})
+
+	if err != nil {
+		t.Fatalf("error creating table: %v", err)
+	}
+
+	table, err := db.GetTable("table_composite")
+
+	if err != nil {
+		t.Fatalf("error getting table: %v", err)
+	}
+
+	return db, table
+}
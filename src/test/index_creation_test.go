@@ -0,0 +1,249 @@
+package main
+
+/*
+Tests for creating indexes on tables that have rows already. The index must find every row of the table, whether it
+was built offline or online, with rows being written alongside it
+*/
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	database "github.com/nicolasvancan/monvandb/src/database"
+	helper "github.com/nicolasvancan/monvandb/src/test/helper"
+	utils "github.com/nicolasvancan/monvandb/src/utils"
+)
+
+// Names of the rows, sorted, since rows with the same index key may be found in any order
+func sortedRowNames(rows []database.RawRow) string {
+	names := strings.Split(rowNames(rows), " ")
+	sort.Strings(names)
+
+	return strings.Join(names, " ")
+}
+
+// Asserts that the index of the id column finds the same rows as the table key, for ids from 1 to maxId
+func assertIdIndexMatchesTable(t *testing.T, table *database.Table, maxId int32) {
+	t.Helper()
+
+	all, err := table.RangeByKey(nil, nil, -1, database.ASC)
+
+	if err != nil {
+		t.Fatalf("error reading the table: %v", err)
+	}
+
+	for id := int32(1); id <= maxId; id++ {
+		expected := make([]database.RawRow, 0)
+		for _, row := range all {
+			if row["id"] == id {
+				expected = append(expected, row)
+			}
+		}
+
+		rows, err := table.Get("id", id)
+
+		if err != nil {
+			t.Fatalf("error reading the index: %v", err)
+		}
+
+		if sortedRowNames(rows) != sortedRowNames(expected) {
+			t.Errorf("id %d should find rows [%s], found [%s]", id, sortedRowNames(expected), sortedRowNames(rows))
+		}
+	}
+}
+
+func TestCreateIndexIndexesExistingRows(t *testing.T) {
+	db, table := helper.CreateMockDatabaseWithCompositeKeyTable(t)
+	insertCompositeKeyRows(t, table)
+
+	if err := db.CreateIndex("table_composite", "id", "id_index"); err != nil {
+		t.Fatalf("error creating index: %v", err)
+	}
+
+	assertIdIndexMatchesTable(t, table, 4)
+	assertNoProblems(t, table.Indexes["id"].PDataFile)
+
+	// Rows inserted afterwards are indexed as usual
+	table.Insert([]database.RawRow{{"tenant": int32(4), "id": int32(2), "name": "4-2"}})
+
+	assertIdIndexMatchesTable(t, table, 4)
+
+	if err := db.CreateIndex("table_composite", "id", "other_id_index"); err == nil {
+		t.Error("indexing the same column twice should fail")
+	}
+}
+
+func TestCreatedIndexIsLoadedWithTheTable(t *testing.T) {
+	db, table := helper.CreateMockDatabaseWithCompositeKeyTable(t)
+	insertCompositeKeyRows(t, table)
+
+	if err := db.CreateIndex("table_composite", "id", "id_index"); err != nil {
+		t.Fatalf("error creating index: %v", err)
+	}
+
+	reloaded, err := database.LoadDatabase(utils.GetPath("databases") + utils.SEPARATOR + "mock")
+
+	if err != nil {
+		t.Fatalf("error loading database: %v", err)
+	}
+
+	reloadedTable, _ := reloaded.GetTable("table_composite")
+	index := reloadedTable.Indexes["id"]
+
+	if index == nil || index.PDataFile == nil {
+		t.Fatalf("index should have been loaded, found %v", index)
+	}
+
+	assertIdIndexMatchesTable(t, reloadedTable, 4)
+}
+
+/*
+While an index is built online, another goroutine keeps inserting, updating and deleting rows. Once both are done,
+the index must find exactly the rows of the table
+*/
+func TestCreateIndexOnlineAcceptsWrites(t *testing.T) {
+	db, table := helper.CreateMockDatabaseWithCompositeKeyTable(t)
+	rows := make([]database.RawRow, 0)
+
+	// Enough rows for many batches of the build
+	for tenant := int32(1); tenant <= 2; tenant++ {
+		for id := int32(1); id <= 600; id++ {
+			rows = append(rows, database.RawRow{"tenant": tenant, "id": id, "name": fmt.Sprintf("%d-%d", tenant, id)})
+		}
+	}
+
+	if _, err := table.Insert(rows); err != nil {
+		t.Fatalf("error inserting rows: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for id := int32(2); id <= 600; id += 2 {
+			var err error
+
+			switch id % 3 {
+			case 0:
+				_, err = table.Delete([]database.RawRow{{"tenant": int32(1), "id": id, "name": fmt.Sprintf("1-%d", id)}})
+			case 1:
+				_, err = table.Update([]database.RawRow{{"tenant": int32(2), "id": id, "name": fmt.Sprintf("updated-%d", id)}})
+			default:
+				_, err = table.Insert([]database.RawRow{{"tenant": int32(3), "id": id, "name": fmt.Sprintf("3-%d", id)}})
+			}
+
+			if err != nil {
+				t.Errorf("error writing id %d: %v", id, err)
+			}
+		}
+	}()
+
+	err := db.CreateIndexWithOptions("table_composite", "id", "id_index", database.IndexOptions{Online: true})
+	wg.Wait()
+
+	if err != nil {
+		t.Fatalf("error creating index: %v", err)
+	}
+
+	assertIdIndexMatchesTable(t, table, 600)
+	assertNoProblems(t, table.Indexes["id"].PDataFile)
+}
+
+func TestCreateIndexRejectsRepeatedName(t *testing.T) {
+	db, table := helper.CreateMockDatabaseWithCompositeKeyTable(t)
+	insertCompositeKeyRows(t, table)
+
+	if err := db.CreateIndex("table_composite", "id", "id_index"); err != nil {
+		t.Fatalf("error creating index: %v", err)
+	}
+
+	if err := db.CreateIndex("table_composite", "name", "id_index"); err == nil {
+		t.Fatal("creating an index with the name of another one should fail")
+	}
+
+	// The files of the index are left as they were
+	if _, ok := table.Indexes["name"]; ok {
+		t.Error("the index with the repeated name should not have been added")
+	}
+
+	assertIdIndexMatchesTable(t, table, 4)
+	assertNoProblems(t, table.Indexes["id"].PDataFile)
+}
+
+func TestFailedIndexCreationLeavesNothingBehind(t *testing.T) {
+	db, table := helper.CreateMockDatabaseWithCompositeKeyTable(t)
+	insertCompositeKeyRows(t, table)
+
+	// The table metadata file can't be replaced by a directory that isn't empty
+	metadataPath := table.Path + utils.SEPARATOR + utils.METDATA_FILE
+	metadata, _ := os.ReadFile(metadataPath)
+	os.Remove(metadataPath)
+	os.MkdirAll(metadataPath+utils.SEPARATOR+"dir", os.ModePerm)
+
+	if err := db.CreateIndex("table_composite", "id", "id_index"); err == nil {
+		t.Fatal("creating an index whose table metadata can't be written should fail")
+	}
+
+	if _, ok := table.Indexes["id"]; ok {
+		t.Error("the index should have been removed from the table")
+	}
+
+	if _, err := os.Stat(table.Path + utils.SEPARATOR + "id_index.index.db"); !os.IsNotExist(err) {
+		t.Errorf("the index file should have been removed, got %v", err)
+	}
+
+	os.RemoveAll(metadataPath)
+	os.WriteFile(metadataPath, metadata, 0644)
+
+	// Both the name and the columns can be used again
+	if err := db.CreateIndex("table_composite", "id", "id_index"); err != nil {
+		t.Fatalf("error creating index: %v", err)
+	}
+
+	assertIdIndexMatchesTable(t, table, 4)
+}
+
+func TestConcurrentIndexCreationsOfTheSameName(t *testing.T) {
+	db, table := helper.CreateMockDatabaseWithCompositeKeyTable(t)
+	insertCompositeKeyRows(t, table)
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			column := []string{"id", "name"}[i%2]
+			errs[i] = db.CreateIndexWithOptions("table_composite", column, "repeated_index", database.IndexOptions{Online: true})
+		}(i)
+	}
+
+	wg.Wait()
+
+	created := 0
+	for _, err := range errs {
+		if err == nil {
+			created++
+		}
+	}
+
+	if created != 1 {
+		t.Fatalf("exactly one index should have been created, got %d (%v)", created, errs)
+	}
+
+	for _, index := range table.Indexes {
+		assertNoProblems(t, index.PDataFile)
+	}
+
+	if index, ok := table.Indexes["id"]; ok && index.Name == "repeated_index" {
+		assertIdIndexMatchesTable(t, table, 4)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+/*
+Tests for index entries, which point to the rows of the table by their keys. Rows with the same value in the indexed
+column have entries of their own, and writing a row changes only its own entries
+*/
+
+import (
+	"context"
+	"testing"
+
+	database "github.com/nicolasvancan/monvandb/src/database"
+	helper "github.com/nicolasvancan/monvandb/src/test/helper"
+)
+
+// Creates the composite key table with its rows and an index for the given column
+func createIndexedCompositeKeyTable(t *testing.T, column string) *database.Table {
+	db, table := helper.CreateMockDatabaseWithCompositeKeyTable(t)
+	insertCompositeKeyRows(t, table)
+
+	if err := db.CreateIndex("table_composite", column, column+"_index"); err != nil {
+		t.Fatalf("error creating index: %v", err)
+	}
+
+	return table
+}
+
+func assertIndexEntries(t *testing.T, table *database.Table, column string, expected int) {
+	t.Helper()
+
+	if n := len(crawlKeys(table.Indexes[column].PDataFile.GetBTree())); n != expected {
+		t.Errorf("expected %d entries in the index, found %d", expected, n)
+	}
+}
+
+func TestIndexEntriesOfRepeatedValues(t *testing.T) {
+	table := createIndexedCompositeKeyTable(t, "id")
+	assertIndexEntries(t, table, "id", 12)
+
+	// Rows with the same id are found in the order of their keys
+	rows, err := table.Get("id", int32(2))
+	assertRowNames(t, rows, err, "1-2 2-2 3-2")
+
+	// Deleting one of them leaves the others
+	if _, err := table.Delete([]database.RawRow{{"tenant": int32(2), "id": int32(2)}}); err != nil {
+		t.Fatalf("error deleting: %v", err)
+	}
+
+	rows, err = table.Get("id", int32(2))
+	assertRowNames(t, rows, err, "1-2 3-2")
+	assertIndexEntries(t, table, "id", 11)
+
+	// The entry still points to the updated row
+	if _, err := table.Update([]database.RawRow{{"tenant": int32(1), "id": int32(2), "name": "updated"}}); err != nil {
+		t.Fatalf("error updating: %v", err)
+	}
+
+	rows, err = table.Get("id", int32(2))
+	assertRowNames(t, rows, err, "updated 3-2")
+	assertIndexEntries(t, table, "id", 11)
+	assertNoProblems(t, table.Indexes["id"].PDataFile)
+}
+
+func TestUpdateMovesIndexEntry(t *testing.T) {
+	table := createIndexedCompositeKeyTable(t, "name")
+
+	if _, err := table.Update([]database.RawRow{{"tenant": int32(1), "id": int32(1), "name": "3-4"}}); err != nil {
+		t.Fatalf("error updating: %v", err)
+	}
+
+	rows, err := table.Get("name", "1-1")
+	assertRowNames(t, rows, err, "")
+
+	rows, err = table.Get("name", "3-4")
+	if err != nil || len(rows) != 2 || rows[0]["tenant"] != int32(1) || rows[1]["tenant"] != int32(3) {
+		t.Errorf("expected the updated row and the one of tenant 3, found %v", rows)
+	}
+
+	assertIndexEntries(t, table, "name", 12)
+}
+
+func TestInvalidUpdateKeepsRowAndIndexEntry(t *testing.T) {
+	table := createIndexedCompositeKeyTable(t, "name")
+
+	// The row is validated before the stored one is replaced, so a failed update changes nothing
+	if _, err := table.Update([]database.RawRow{{"tenant": int32(1), "id": int32(1), "name": true}}); err == nil {
+		t.Fatal("updating the name with a bool should fail")
+	}
+
+	rows, err := table.Get("name", "1-1")
+	assertRowNames(t, rows, err, "1-1")
+
+	rows, err = table.Get("id", int32(1))
+	assertRowNames(t, rows, err, "1-1 2-1 3-1")
+	assertIndexEntries(t, table, "name", 12)
+}
+
+func TestUpdateFailingInAnIndexKeepsRowAndEntries(t *testing.T) {
+	db, table := helper.CreateMockDatabaseWithCompositeKeyTable(t)
+	insertCompositeKeyRows(t, table)
+
+	if err := db.CreateIndex("table_composite", "name", "name_index"); err != nil {
+		t.Fatalf("error creating index: %v", err)
+	}
+
+	if err := db.CreateCompositeIndex("table_composite", []string{"name", "id"}, "name_id_index", database.IndexOptions{}); err != nil {
+		t.Fatalf("error creating index: %v", err)
+	}
+
+	// Both indexes change with the name, and the entries of the second one can't be read anymore
+	broken := table.Indexes["name,id"]
+	broken.PDataFile = corruptPageOfDataFile(t, broken.PDataFile, broken.Path, broken.PDataFile.GetBTree().GetRoot())
+
+	if _, err := table.Update([]database.RawRow{{"tenant": int32(1), "id": int32(1), "name": "updated"}}); err == nil {
+		t.Fatal("updating a row whose index is corrupted should fail")
+	}
+
+	rows, err := table.GetByKey(int32(1), int32(1))
+	assertRowNames(t, rows, err, "1-1")
+
+	rows, err = table.Get("name", "1-1")
+	assertRowNames(t, rows, err, "1-1")
+
+	rows, err = table.Get("name", "updated")
+	assertRowNames(t, rows, err, "")
+	assertIndexEntries(t, table, "name", 12)
+	assertNoProblems(t, table.Indexes["name"].PDataFile)
+}
+
+func TestRangeThroughIndex(t *testing.T) {
+	table := createIndexedCompositeKeyTable(t, "id")
+	comparsion := func(condition int, value int32) []database.ColumnComparsion {
+		return []database.ColumnComparsion{{
+			ColumnName: "id",
+			Condition:  condition,
+			Value:      database.ColumnConditionValue{Value: value},
+		}}
+	}
+
+	assertRowNames(t, rangeForTesting(t, table, comparsion(database.GT, 3), -1, database.ASC), nil, "1-4 2-4 3-4")
+	assertRowNames(t, rangeForTesting(t, table, comparsion(database.LTE, 1), -1, database.ASC), nil, "1-1 2-1 3-1")
+	assertRowNames(t, rangeForTesting(t, table, comparsion(database.LT, 2), -1, database.DESC), nil, "3-1 2-1 1-1")
+	assertRowNames(t, rangeForTesting(t, table, comparsion(database.GTE, 4), 2, database.DESC), nil, "3-4 2-4")
+}
+
+func TestIndexRangeSkipsRowsChangedSinceTheirEntries(t *testing.T) {
+	table := createIndexedCompositeKeyTable(t, "name")
+	options := database.MergeOperationsBasedOnIndexedColumnsAndReturnRangeOptions(table, and(where("name", database.LTE, "1-4")))
+
+	if options.PDataFile != table.Indexes["name"].PDataFile {
+		t.Fatalf("expected the range to be read from the index")
+	}
+
+	// The row is written to the table alone, as if the index snapshot was taken before the write and the table one after
+	changed := table.FromRawRowToKeyValue(database.RawRow{"tenant": int32(1), "id": int32(1), "name": "9-9"})
+
+	if err := table.PDataFile.Update(changed.Key, changed.Value); err != nil {
+		t.Fatalf("error updating row: %v", err)
+	}
+
+	rows := database.RangeIterFromOptions(context.Background(), table, options)
+	defer rows.Close()
+
+	found := make([]database.RawRow, 0)
+	for rows.Next() {
+		found = append(found, rows.Row())
+	}
+
+	assertRowNames(t, found, rows.Err(), "1-2 1-3 1-4")
+}
@@ -0,0 +1,168 @@
+package main
+
+/*
+Tests for the order preserving key encoding. Encoded keys must be sorted by bytes.Compare exactly as the values they
+hold, and decoding them must give the values back
+*/
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	database "github.com/nicolasvancan/monvandb/src/database"
+	helper "github.com/nicolasvancan/monvandb/src/test/helper"
+	utils "github.com/nicolasvancan/monvandb/src/utils"
+)
+
+// Every value must be encoded to a key strictly greater than the previous one
+func assertKeysAreSorted(t *testing.T, values []interface{}) {
+	var previous []byte = nil
+	for _, value := range values {
+		key, err := utils.EncodeKey(value)
+
+		if err != nil {
+			t.Fatalf("error encoding %v: %v", value, err)
+		}
+
+		if previous != nil && bytes.Compare(previous, key) >= 0 {
+			t.Errorf("key for %v should be greater than the previous one", value)
+		}
+
+		previous = key
+	}
+}
+
+func TestIntegerKeysAreSorted(t *testing.T) {
+	assertKeysAreSorted(t, []interface{}{
+		int64(math.MinInt64), int64(-300), int32(-1), 0, int8(1), uint16(255), int64(256), int64(math.MaxInt64),
+	})
+}
+
+func TestFloatKeysAreSorted(t *testing.T) {
+	assertKeysAreSorted(t, []interface{}{
+		math.Inf(-1), -1e10, -1.5, float32(-0.25), 0.0, 1e-9, 0.5, 2.0, 1e300, math.Inf(1),
+	})
+}
+
+func TestStringAndBlobKeysAreSorted(t *testing.T) {
+	assertKeysAreSorted(t, []interface{}{"", "\x00", "\x00\x00", "\x00a", "a", "a\x00", "ab", "b", "ba"})
+	assertKeysAreSorted(t, []interface{}{[]byte{}, []byte{0}, []byte{0, 0xFF}, []byte{1}, []byte{0xFF}})
+}
+
+func TestMixedKeysAreSorted(t *testing.T) {
+	now := time.Now()
+	assertKeysAreSorted(t, []interface{}{
+		nil, false, true, now.Add(-time.Hour), now, now.Add(time.Hour),
+	})
+}
+
+func TestIntegerTypesGiveTheSameKey(t *testing.T) {
+	k1, _ := utils.EncodeKey(int32(10))
+	k2, _ := utils.EncodeKey(int64(10))
+	k3, _ := utils.EncodeKey(uint8(10))
+
+	if !bytes.Equal(k1, k2) || !bytes.Equal(k2, k3) {
+		t.Error("integers with the same value should give the same key")
+	}
+
+	if _, err := utils.EncodeKey(uint64(math.MaxUint64)); err == nil {
+		t.Error("should not encode integers greater than max int64")
+	}
+
+	if _, err := utils.EncodeKey(struct{}{}); err == nil {
+		t.Error("should not encode structs")
+	}
+}
+
+func TestCompositeKeysAreSorted(t *testing.T) {
+	composites := [][]interface{}{
+		{"a", int64(-1)},
+		{"a", int64(2)},
+		{"a\x00", int64(0)},
+		{"ab", int64(-5)},
+		{"b", nil},
+		{"b", int64(1)},
+	}
+
+	var previous []byte = nil
+	for _, composite := range composites {
+		key, _ := utils.EncodeKey(composite...)
+		if previous != nil && bytes.Compare(previous, key) >= 0 {
+			t.Errorf("composite key %v should be greater than the previous one", composite)
+		}
+		previous = key
+	}
+}
+
+func TestKeysCanBeDecoded(t *testing.T) {
+	now := time.Now().UTC()
+	values := []interface{}{nil, true, int64(-42), -3.25, "with\x00zero", []byte{0, 1, 0}, now}
+
+	key, err := utils.EncodeKey(values...)
+	if err != nil {
+		t.Fatalf("error encoding key: %v", err)
+	}
+
+	decoded, err := utils.DecodeKey(key)
+	if err != nil {
+		t.Fatalf("error decoding key: %v", err)
+	}
+
+	if len(decoded) != len(values) {
+		t.Fatalf("expected %d values, got %d", len(values), len(decoded))
+	}
+
+	if decoded[0] != nil || decoded[1] != true || decoded[2] != int64(-42) || decoded[3] != -3.25 || decoded[4] != "with\x00zero" {
+		t.Errorf("decoded values don't match, got %v", decoded)
+	}
+
+	if !bytes.Equal(decoded[5].([]byte), []byte{0, 1, 0}) {
+		t.Errorf("expected blob to be decoded, got %v", decoded[5])
+	}
+
+	if !decoded[6].(time.Time).Equal(now) {
+		t.Errorf("expected %v, got %v", now, decoded[6])
+	}
+
+	if _, err := utils.DecodeKey([]byte{utils.KEY_TAG_STRING, 'a'}); err == nil {
+		t.Error("should not decode unterminated strings")
+	}
+}
+
+func TestTableRangeWithNegativeKeys(t *testing.T) {
+	table := helper.CreateMockTableAndIndex(t)
+
+	for i := -100; i < 100; i++ {
+		keyValue := table.FromRawRowToKeyValue(database.RawRow{"id": int64(i), "name": "name", "email": "email"})
+		table.PDataFile.Insert(keyValue.Key, keyValue.Value)
+	}
+
+	from, _ := table.EncodeKeyForColumn("id", int32(-10))
+	to, _ := table.EncodeKeyForColumn("id", int32(10))
+
+	rows, err := database.RangeFromOptions(table, database.RangeOptions{
+		From:        from,
+		To:          to,
+		FComparator: database.GTE,
+		TComparator: database.GT,
+		Order:       database.ASC,
+		Limit:       -1,
+		PDataFile:   table.PDataFile,
+	})
+
+	if err != nil {
+		t.Fatalf("error getting range: %v", err)
+	}
+
+	if len(rows) != 21 {
+		t.Fatalf("expected 21 rows, got %d", len(rows))
+	}
+
+	for i, row := range rows {
+		if row["id"] != int64(i-10) {
+			t.Errorf("expected id %d at position %d, got %v", i-10, i, row["id"])
+		}
+	}
+}
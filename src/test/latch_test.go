@@ -0,0 +1,294 @@
+package main
+
+/*
+Tests for concurrent operations. Many goroutines hammer the same tree, or the same data file, inserting, deleting,
+updating and reading keys, and every key must be found as expected once they are done. Run them with -race to check
+that nothing is shared unguarded
+*/
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+
+	bTree "github.com/nicolasvancan/monvandb/src/btree"
+	files "github.com/nicolasvancan/monvandb/src/files"
+)
+
+const (
+	LATCH_TEST_GOROUTINES = 8
+	LATCH_TEST_KEYS       = 400 // Keys written by each goroutine
+)
+
+// Keys of all goroutines are interleaved, so they keep changing the same leaves
+func latchTestKey(goroutine int, i int) []byte {
+	return uint32Key(i*LATCH_TEST_GOROUTINES + goroutine)
+}
+
+func latchTestValue(goroutine int, i int, version string) []byte {
+	// Some values are large enough to need sequences
+	size := 20 + i%50
+	if i%97 == 0 {
+		size = bTree.PAGE_SIZE * 2
+	}
+
+	return append([]byte(fmt.Sprintf("%d-%d-%s", goroutine, i, version)), make([]byte, size)...)
+}
+
+// Creates a tree kept in memory whose callbacks can be used by many goroutines
+func newMemoryTreeForTesting() *bTree.BTree {
+	tree := bTree.NewTree(bTree.PAGE_SIZE)
+	pages := map[uint64][]byte{}
+	var lastPage uint64 = 0
+	var mu sync.Mutex
+
+	tree.Get = func(page uint64) bTree.TreeNode {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return *bTree.LoadTreeNode(append([]byte(nil), pages[page]...))
+	}
+
+	tree.Set = func(node bTree.TreeNode, page uint64) bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		pages[page] = append([]byte(nil), node.GetBytes()...)
+		return true
+	}
+
+	tree.New = func(node bTree.TreeNode) uint64 {
+		mu.Lock()
+		defer mu.Unlock()
+
+		lastPage++
+		pages[lastPage] = append([]byte(nil), node.GetBytes()...)
+		return lastPage
+	}
+
+	tree.Del = func(page uint64) {}
+	tree.SetHeader = func(bTree.BTree) {}
+
+	return tree
+}
+
+/*
+Runs fn for every goroutine at the same time, waiting for all of them. Keys are written from both ends, so goroutines
+also meet at the same leaves from different directions
+*/
+func runGoroutinesForTesting(fn func(goroutine int, i int)) {
+	var wg sync.WaitGroup
+
+	for g := 0; g < LATCH_TEST_GOROUTINES; g++ {
+		wg.Add(1)
+		go func(goroutine int) {
+			defer wg.Done()
+
+			for n := 0; n < LATCH_TEST_KEYS; n++ {
+				i := n
+				if goroutine%2 == 1 {
+					i = LATCH_TEST_KEYS - 1 - n
+				}
+
+				fn(goroutine, i)
+			}
+		}(g)
+	}
+
+	wg.Wait()
+}
+
+// Expected value of a key after inserting every key, deleting a third of them and updating another third
+func expectedLatchTestValue(goroutine int, i int) []byte {
+	switch i % 3 {
+	case 0:
+		return nil
+	case 1:
+		return latchTestValue(goroutine, i, "updated")
+	}
+
+	return latchTestValue(goroutine, i, "inserted")
+}
+
+func assertLatchTestKeys(t *testing.T, get func(key []byte) []bTree.BTreeKeyValue) {
+	for g := 0; g < LATCH_TEST_GOROUTINES; g++ {
+		for i := 0; i < LATCH_TEST_KEYS; i++ {
+			keyValues := get(latchTestKey(g, i))
+			expected := expectedLatchTestValue(g, i)
+
+			if expected == nil && len(keyValues) != 0 {
+				t.Errorf("key %d of goroutine %d should have been deleted, found %d values", i, g, len(keyValues))
+			}
+
+			if expected != nil && (len(keyValues) != 1 || !bytes.Equal(keyValues[0].Value, expected)) {
+				t.Errorf("key %d of goroutine %d should have one value %q, found %d values", i, g, expected[:10], len(keyValues))
+			}
+		}
+	}
+}
+
+func TestConcurrentBTreeOperations(t *testing.T) {
+	tree := newMemoryTreeForTesting()
+	latches := bTree.NewLatches()
+
+	runGoroutinesForTesting(func(goroutine int, i int) {
+		key := latchTestKey(goroutine, i)
+
+		guard := latches.Shared()
+		bTree.ConcurrentBTreeInsert(tree, guard, key, latchTestValue(goroutine, i, "inserted"))
+		guard.Release()
+
+		// Every goroutine must see its own writes
+		guard = latches.Shared()
+		keyValues := bTree.ConcurrentBTreeGet(tree, guard, key)
+		guard.Release()
+
+		if len(keyValues) != 1 {
+			t.Errorf("key %d of goroutine %d should have been found once, found %d values", i, goroutine, len(keyValues))
+		}
+	})
+
+	runGoroutinesForTesting(func(goroutine int, i int) {
+		guard := latches.Shared()
+		defer guard.Release()
+
+		switch i % 3 {
+		case 0:
+			bTree.ConcurrentBTreeDelete(tree, guard, latchTestKey(goroutine, i))
+		case 1:
+			bTree.ConcurrentBTreeUpdate(tree, guard, latchTestKey(goroutine, i), latchTestValue(goroutine, i, "updated"))
+		}
+	})
+
+	assertLatchTestKeys(t, func(key []byte) []bTree.BTreeKeyValue {
+		return bTree.BTreeGet(tree, key)
+	})
+
+	for _, problem := range bTree.Verify(tree).Problems {
+		t.Errorf("unexpected problem: %v", problem)
+	}
+}
+
+func TestConcurrentCrawlersFindTheirKeys(t *testing.T) {
+	tree := newMemoryTreeForTesting()
+	latches := bTree.NewLatches()
+
+	runGoroutinesForTesting(func(goroutine int, i int) {
+		key := latchTestKey(goroutine, i)
+
+		guard := latches.Shared()
+		bTree.ConcurrentBTreeInsert(tree, guard, key, latchTestValue(goroutine, i, "inserted"))
+		guard.Release()
+
+		guard = latches.Shared()
+		crawler := bTree.ConcurrentFindLeafForCrawling(tree, guard, key)
+		guard.Release()
+
+		if kv := crawler.GetKeyValue(); kv == nil || !bytes.Equal(kv.Key, key) {
+			t.Errorf("crawler should start at key %d of goroutine %d, found %v", i, goroutine, kv)
+		}
+	})
+}
+
+func TestConcurrentDataFileOperations(t *testing.T) {
+	dataFile, path := openDataFileForTesting(t)
+
+	runGoroutinesForTesting(func(goroutine int, i int) {
+		key := latchTestKey(goroutine, i)
+
+		if err := dataFile.Insert(key, latchTestValue(goroutine, i, "inserted")); err != nil {
+			t.Errorf("error inserting: %v", err)
+		}
+
+		if keyValues := getForTesting(t, dataFile, key); len(keyValues) != 1 {
+			t.Errorf("key %d of goroutine %d should have been found once, found %d values", i, goroutine, len(keyValues))
+		}
+	})
+
+	runGoroutinesForTesting(func(goroutine int, i int) {
+		var err error
+
+		switch i % 3 {
+		case 0:
+			err = dataFile.Delete(latchTestKey(goroutine, i))
+		case 1:
+			err = dataFile.Update(latchTestKey(goroutine, i), latchTestValue(goroutine, i, "updated"))
+		}
+
+		if err != nil {
+			t.Errorf("error changing key %d of goroutine %d: %v", i, goroutine, err)
+		}
+	})
+
+	assertLatchTestKeys(t, func(key []byte) []bTree.BTreeKeyValue {
+		return getForTesting(t, dataFile, key)
+	})
+	assertNoProblems(t, dataFile)
+
+	// Every commit must have reached the log
+	dataFile.Close()
+	reopened := openDataFileWithOptionsForTesting(t, path, files.DataFileOptions{})
+
+	assertLatchTestKeys(t, func(key []byte) []bTree.BTreeKeyValue {
+		return getForTesting(t, reopened, key)
+	})
+	assertNoProblems(t, reopened)
+}
+
+/*
+Batches run alone, so while one goroutine writes batches of ten keys and another one writes single keys alongside
+them, snapshots must see whole batches only, and every key must be found once both are done
+*/
+func TestBatchesAmongConcurrentOperations(t *testing.T) {
+	dataFile, _ := openDataFileForTesting(t)
+	var wg sync.WaitGroup
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+
+		for batch := 0; batch < 50; batch++ {
+			dataFile.Batch(func(tx *files.FileTx) error {
+				for i := 0; i < 10; i++ {
+					tx.Insert(uint32Key(batch*10+i), make([]byte, 50))
+				}
+				return nil
+			})
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < 500; i++ {
+			dataFile.Insert(uint32Key(100000+i), make([]byte, 50))
+		}
+	}()
+
+	for n := 0; n < 500; {
+		snapshot := dataFile.Snapshot()
+		keys := crawlKeys(snapshot.GetBTree())
+		snapshot.Close()
+
+		// Keys of the batches are the smallest ones
+		n = 0
+		for n < len(keys) && bytes.Compare(keys[n], uint32Key(100000)) < 0 {
+			n++
+		}
+
+		if n%10 != 0 {
+			t.Fatalf("snapshot saw part of a batch, %d keys", n)
+		}
+	}
+
+	wg.Wait()
+
+	for i := 0; i < 500; i++ {
+		if len(getForTesting(t, dataFile, uint32Key(100000+i))) != 1 {
+			t.Errorf("key %d should have been inserted", 100000+i)
+		}
+	}
+
+	assertNoProblems(t, dataFile)
+}
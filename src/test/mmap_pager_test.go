@@ -0,0 +1,189 @@
+package main
+
+/*
+Tests for the mmap pager. A data file opened with Mmap must behave exactly as one read through the buffer pool, even
+when the mapping has to grow, and the benchmarks compare both pagers for point reads and full scans
+*/
+
+import (
+	"os"
+	"testing"
+
+	bTree "github.com/nicolasvancan/monvandb/src/btree"
+	files "github.com/nicolasvancan/monvandb/src/files"
+)
+
+var mmapOptions = files.DataFileOptions{Mmap: true}
+
+func openDataFileWithOptionsForTesting(t testing.TB, path string, options files.DataFileOptions) *files.DataFile {
+	dataFile, err := files.OpenDataFileWithOptions(path, options)
+
+	if err != nil {
+		t.Fatalf("error opening data file: %v", err)
+	}
+
+	t.Cleanup(dataFile.Close)
+	return dataFile
+}
+
+func TestMmapPagerReadsWhatWasWritten(t *testing.T) {
+	path := t.TempDir() + string(os.PathSeparator) + "mmap.db"
+	dataFile := openDataFileWithOptionsForTesting(t, path, mmapOptions)
+
+	fillDataFileForChecksum(t, dataFile)
+	dataFile.Delete(uint32Key(50))
+	dataFile.Update(uint32Key(60), []byte("updated"))
+
+	for i := 0; i < 200; i++ {
+		keyValues := getForTesting(t, dataFile, uint32Key(i))
+
+		switch {
+		case i == 50 && len(keyValues) != 0:
+			t.Error("key 50 should have been deleted")
+		case i == 60 && (len(keyValues) != 1 || string(keyValues[0].Value) != "updated"):
+			t.Errorf("key 60 should have been updated, got %v", keyValues)
+		case i != 50 && len(keyValues) != 1:
+			t.Errorf("should have found key %d", i)
+		}
+	}
+
+	if stats := dataFile.GetBufferPoolStats(); stats != (files.BufferPoolStats{}) {
+		t.Errorf("buffer pool should not be used in mmap mode, stats %+v", stats)
+	}
+
+	assertNoProblems(t, dataFile)
+}
+
+func TestMmapPagerGrowsMapping(t *testing.T) {
+	path := t.TempDir() + string(os.PathSeparator) + "mmap_grow.db"
+	dataFile := openDataFileWithOptionsForTesting(t, path, mmapOptions)
+
+	// Each value takes almost a whole page, so the file goes beyond the first chunk of the mapping
+	n := files.MMAP_CHUNK_PAGES + 500
+	dataFile.Batch(func(tx *files.FileTx) error {
+		for i := 0; i < n; i++ {
+			tx.Insert(uint32Key(i), make([]byte, bTree.PAGE_SIZE-200))
+		}
+		return nil
+	})
+
+	if size := dataFileSize(t, dataFile, path); size <= files.MMAP_CHUNK_PAGES*bTree.PAGE_SIZE {
+		t.Fatalf("data file should be larger than one chunk, got %d bytes", size)
+	}
+
+	dataFile.Close()
+	reopened := openDataFileWithOptionsForTesting(t, path, mmapOptions)
+
+	for i := 0; i < n; i++ {
+		if len(getForTesting(t, reopened, uint32Key(i))) != 1 {
+			t.Errorf("should have found key %d", i)
+		}
+	}
+
+	assertNoProblems(t, reopened)
+}
+
+func TestMmapPagerReportsCorruptedPage(t *testing.T) {
+	path := t.TempDir() + string(os.PathSeparator) + "mmap_corrupted.db"
+	dataFile := openDataFileWithOptionsForTesting(t, path, mmapOptions)
+	fillDataFileForChecksum(t, dataFile)
+
+	root := dataFile.GetBTree().GetRoot()
+	dataFile.Close()
+	corruptPage(t, path, root)
+
+	reopened := openDataFileWithOptionsForTesting(t, path, mmapOptions)
+	_, err := reopened.Get(uint32Key(10))
+	assertPageCorrupted(t, err, root)
+}
+
+func TestMmapPagerValuesOutliveCommits(t *testing.T) {
+	path := t.TempDir() + string(os.PathSeparator) + "mmap_values.db"
+	dataFile := openDataFileWithOptionsForTesting(t, path, mmapOptions)
+	fillDataFileForChecksum(t, dataFile)
+	dataFile.Update(uint32Key(10), []byte("value-1"))
+
+	keyValues := getForTesting(t, dataFile, uint32Key(10))
+
+	// The leaf is written in place in the mapping, at the same offset
+	if err := dataFile.Update(uint32Key(10), []byte("value-2")); err != nil {
+		t.Fatalf("error updating: %v", err)
+	}
+
+	if len(keyValues) != 1 || string(keyValues[0].Value) != "value-1" {
+		t.Errorf("values read before a commit should not be changed by it, got %v", keyValues)
+	}
+
+	if got := getForTesting(t, dataFile, uint32Key(10)); len(got) != 1 || string(got[0].Value) != "value-2" {
+		t.Errorf("expected the updated value, got %v", got)
+	}
+}
+
+// Benchmarks
+
+const benchmarkKeys = 20000
+
+var benchmarkPagers = []struct {
+	name    string
+	options files.DataFileOptions
+}{
+	{"ReadAt", files.DataFileOptions{}},
+	{"ReadAtSmallPool", files.DataFileOptions{BufferPoolSize: 16}},
+	{"Mmap", mmapOptions},
+}
+
+// Writes the keys used by the benchmarks and reopens the data file, so that no page is in memory yet
+func openDataFileForBenchmark(b *testing.B, options files.DataFileOptions) *files.DataFile {
+	path := b.TempDir() + string(os.PathSeparator) + "benchmark.db"
+	dataFile := openDataFileWithOptionsForTesting(b, path, files.DataFileOptions{})
+
+	dataFile.Batch(func(tx *files.FileTx) error {
+		for i := 0; i < benchmarkKeys; i++ {
+			tx.Insert(uint32Key(i), make([]byte, 100))
+		}
+		return nil
+	})
+
+	dataFile.Close()
+	return openDataFileWithOptionsForTesting(b, path, options)
+}
+
+func BenchmarkPointGet(b *testing.B) {
+	for _, pager := range benchmarkPagers {
+		b.Run(pager.name, func(b *testing.B) {
+			dataFile := openDataFileForBenchmark(b, pager.options)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if keyValues, err := dataFile.Get(uint32Key((i * 7919) % benchmarkKeys)); err != nil || len(keyValues) != 1 {
+					b.Fatalf("error getting key: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkFullScan(b *testing.B) {
+	for _, pager := range benchmarkPagers {
+		b.Run(pager.name, func(b *testing.B) {
+			dataFile := openDataFileForBenchmark(b, pager.options)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				crawler := bTree.GoToFirstLeaf(dataFile.GetBTree())
+				n := 0
+
+				for crawler.GetKeyValue() != nil {
+					n++
+					if err := crawler.Next(); err != nil {
+						break
+					}
+				}
+
+				if n != benchmarkKeys {
+					b.Fatalf("expected %d keys, got %d", benchmarkKeys, n)
+				}
+			}
+		})
+	}
+}
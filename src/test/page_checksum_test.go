@@ -0,0 +1,199 @@
+package main
+
+/*
+Tests for page checksums. Every page written by the bTree carries a checksum, so a page changed on disk must be
+reported as corrupted, with its page number, instead of being read as garbage
+*/
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	bTree "github.com/nicolasvancan/monvandb/src/btree"
+	files "github.com/nicolasvancan/monvandb/src/files"
+)
+
+// Flips one byte of the given page directly in the file, as if the disk had changed it
+func corruptPage(t *testing.T, path string, page uint64) {
+	fp, err := os.OpenFile(path, os.O_RDWR, 0666)
+
+	if err != nil {
+		t.Fatalf("error opening data file: %v", err)
+	}
+
+	defer fp.Close()
+
+	offset := int64(page*bTree.PAGE_SIZE) + bTree.PAGE_SIZE/2
+	b := make([]byte, 1)
+	fp.ReadAt(b, offset)
+	b[0] ^= 0xFF
+	fp.WriteAt(b, offset)
+}
+
+// Corrupts the page with the data file closed, so that the page is read from disk again once it's reopened
+func corruptPageOfDataFile(t *testing.T, dataFile *files.DataFile, path string, page uint64) *files.DataFile {
+	dataFile.Close()
+	corruptPage(t, path, page)
+
+	reopened, err := files.OpenDataFile(path)
+
+	if err != nil {
+		t.Fatalf("error opening data file: %v", err)
+	}
+
+	t.Cleanup(reopened.Close)
+	return reopened
+}
+
+func assertPageCorrupted(t *testing.T, err error, page uint64) {
+	var corrupted *bTree.ErrPageCorrupted
+
+	if !errors.As(err, &corrupted) {
+		t.Fatalf("expected ErrPageCorrupted, got %v", err)
+	}
+
+	if corrupted.Page != page {
+		t.Errorf("expected page %d to be corrupted, got %d", page, corrupted.Page)
+	}
+}
+
+func fillDataFileForChecksum(t *testing.T, dataFile *files.DataFile) {
+	err := dataFile.Batch(func(tx *files.FileTx) error {
+		for i := 0; i < 200; i++ {
+			tx.Insert(uint32Key(i), make([]byte, 100))
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("error inserting keys: %v", err)
+	}
+}
+
+func TestNodeChecksumDetectsChanges(t *testing.T) {
+	leaf := bTree.NewNodeLeaf()
+	leaf.PutLeafNewKeyValue([]byte("1"), []byte("value"))
+	data := leaf.GetBytes()
+
+	if err := bTree.LoadTreeNode(data).VerifyChecksum(7); err != nil {
+		t.Errorf("checksum should match, got %v", err)
+	}
+
+	data[bTree.LEAF_VAL_START_OFFSET] ^= 0x01
+	assertPageCorrupted(t, bTree.LoadTreeNode(data).VerifyChecksum(7), 7)
+}
+
+func TestGetReportsCorruptedPage(t *testing.T) {
+	dataFile, path := openDataFileForTesting(t)
+	fillDataFileForChecksum(t, dataFile)
+
+	root := dataFile.GetBTree().GetRoot()
+	dataFile = corruptPageOfDataFile(t, dataFile, path, root)
+
+	_, err := dataFile.Get(uint32Key(10))
+	assertPageCorrupted(t, err, root)
+}
+
+func TestInsertOnCorruptedPageIsDiscarded(t *testing.T) {
+	dataFile, path := openDataFileForTesting(t)
+	fillDataFileForChecksum(t, dataFile)
+
+	root := dataFile.GetBTree().GetRoot()
+	dataFile = corruptPageOfDataFile(t, dataFile, path, root)
+
+	assertPageCorrupted(t, dataFile.Insert(uint32Key(1000), []byte("value")), root)
+
+	// Once the page is fixed, the tree is the same as before the insertion
+	corruptPage(t, path, root)
+
+	if len(getForTesting(t, dataFile, uint32Key(1000))) != 0 {
+		t.Error("insertion that found a corrupted page should have been discarded")
+	}
+
+	if len(getForTesting(t, dataFile, uint32Key(199))) != 1 {
+		t.Error("should have found key 199")
+	}
+}
+
+func TestCrawlerReportsCorruptedLeaf(t *testing.T) {
+	dataFile, path := openDataFileForTesting(t)
+	fillDataFileForChecksum(t, dataFile)
+
+	leaves := bTree.MapAllLeavesToArray(dataFile.GetBTree())
+	lastLeaf := leaves[len(leaves)-1].TreeNode
+	dataFile = corruptPageOfDataFile(t, dataFile, path, lastLeaf)
+
+	crawler := bTree.GoToFirstLeaf(dataFile.GetBTree())
+
+	var err error
+	for err == nil {
+		err = crawler.Next()
+	}
+
+	assertPageCorrupted(t, err, lastLeaf)
+}
+
+// Opens the data file expecting an error, which is returned
+func openDataFileExpectingError(t *testing.T, path string) error {
+	dataFile, err := files.OpenDataFile(path)
+
+	if err == nil {
+		dataFile.Close()
+		t.Fatal("opening the data file should fail")
+	}
+
+	return err
+}
+
+func TestCorruptedHeaderIsDetected(t *testing.T) {
+	dataFile, path := openDataFileForTesting(t)
+	fillDataFileForChecksum(t, dataFile)
+	dataFile.Close()
+
+	// Flips a byte of the tree name, which is covered by the checksum of the header
+	fp, _ := os.OpenFile(path, os.O_RDWR, 0666)
+	fp.WriteAt([]byte{0xFF}, bTree.BTREE_OFFSET_NAME+1)
+	fp.Close()
+
+	assertPageCorrupted(t, openDataFileExpectingError(t, path), 0)
+}
+
+func TestFilesOfAnotherFormatVersionAreRejected(t *testing.T) {
+	dataFile, path := openDataFileForTesting(t)
+	fillDataFileForChecksum(t, dataFile)
+	dataFile.Close()
+
+	// Files written before the header had a version have zeros there
+	fp, _ := os.OpenFile(path, os.O_RDWR, 0666)
+	fp.WriteAt(make([]byte, bTree.BTREE_VERSION_SIZE), bTree.BTREE_OFFSET_VERSION)
+	fp.Close()
+
+	err := openDataFileExpectingError(t, path)
+
+	if !strings.Contains(err.Error(), "format version 0 is not supported") {
+		t.Errorf("expected the format version to be rejected, got %v", err)
+	}
+}
+
+func TestPagesThatCantBeReadAreReturnedAsErrors(t *testing.T) {
+	path := t.TempDir() + string(os.PathSeparator) + "read_error.db"
+	dataFile, err := files.OpenDataFileWithOptions(path, files.DataFileOptions{BufferPoolSize: 1})
+
+	if err != nil {
+		t.Fatalf("error opening data file: %v", err)
+	}
+
+	fillDataFileForChecksum(t, dataFile)
+
+	// Pages not in the buffer pool can't be read once the file is closed
+	dataFile.Close()
+
+	_, err = dataFile.Get(uint32Key(0))
+
+	var readErr *bTree.ErrPageRead
+	if !errors.As(err, &readErr) || !errors.Is(err, os.ErrClosed) {
+		t.Errorf("expected ErrPageRead caused by the closed file, got %v", err)
+	}
+}
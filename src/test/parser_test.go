@@ -0,0 +1,271 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	database "github.com/nicolasvancan/monvandb/src/database"
+	parser "github.com/nicolasvancan/monvandb/src/parser"
+	helper "github.com/nicolasvancan/monvandb/src/test/helper"
+)
+
+func parse[T parser.Statement](t *testing.T, sql string) T {
+	t.Helper()
+	statement, err := parser.Parse(sql)
+
+	if err != nil {
+		t.Fatalf("error parsing %s: %v", sql, err)
+	}
+
+	typed, ok := statement.(T)
+
+	if !ok {
+		t.Fatalf("expected %T parsing %s, got %T", typed, sql, statement)
+	}
+
+	return typed
+}
+
+func TestParseSelect(t *testing.T) {
+	statement := parse[*parser.SelectStatement](t, `
+		select distinct id, u.name AS n, "order" FROM users u
+		WHERE id > 10
+		ORDER BY name DESC, id
+		LIMIT 5 OFFSET 10;`)
+
+	if !statement.Distinct || statement.From.Name != "users" || statement.From.Alias != "u" {
+		t.Errorf("expected DISTINCT from users u, got %+v", statement)
+	}
+
+	if len(statement.Fields) != 3 {
+		t.Fatalf("expected 3 fields, got %d", len(statement.Fields))
+	}
+
+	name, ok := statement.Fields[1].Expr.(*parser.ColumnRef)
+
+	if !ok || name.Table != "u" || name.Name != "name" || statement.Fields[1].Alias != "n" {
+		t.Errorf("expected u.name AS n, got %+v", statement.Fields[1])
+	}
+
+	if order, ok := statement.Fields[2].Expr.(*parser.ColumnRef); !ok || order.Name != "order" {
+		t.Errorf("expected the quoted column order, got %+v", statement.Fields[2])
+	}
+
+	if pos := statement.Fields[1].Pos(); pos.Line != 2 || pos.Column != 23 {
+		t.Errorf("expected u.name at line 2, column 23, got %s", pos)
+	}
+
+	if len(statement.OrderBy) != 2 || statement.OrderBy[0].Column.Name != "name" || statement.OrderBy[0].Order != database.DESC || statement.OrderBy[1].Order != database.ASC {
+		t.Errorf("expected ORDER BY name DESC, id, got %+v", statement.OrderBy)
+	}
+
+	if statement.Limit != 5 || statement.Offset != 10 {
+		t.Errorf("expected LIMIT 5 OFFSET 10, got %d and %d", statement.Limit, statement.Offset)
+	}
+
+	if star := parse[*parser.SelectStatement](t, "SELECT * FROM users"); !star.Fields[0].Star || star.Limit != -1 || star.Where != nil {
+		t.Errorf("expected SELECT * without WHERE nor LIMIT, got %+v", star)
+	}
+}
+
+func TestParseWriteStatements(t *testing.T) {
+	table := helper.GetMocktableReadyForTesting(t)
+
+	insert := parse[*parser.InsertStatement](t, "INSERT INTO table_teste (email, id, name) VALUES ('ana@teste.com', 1000, 'Ana'), ('b@teste.com', 1001, 'Bia')")
+	rows, err := insert.RawRows(table)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []database.RawRow{
+		{"id": int64(1000), "name": "Ana", "email": "ana@teste.com"},
+		{"id": int64(1001), "name": "Bia", "email": "b@teste.com"},
+	}
+
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("expected rows %v, got %v", expected, rows)
+	}
+
+	if _, err := table.Insert(rows); err != nil {
+		t.Errorf("error inserting the parsed rows: %v", err)
+	}
+
+	// Values without column names are given to every column of the table
+	insert = parse[*parser.InsertStatement](t, "INSERT INTO table_teste VALUES (1002, 'Carla')")
+
+	if _, err := insert.RawRows(table); err == nil || err.Error() != "syntax error at line 1, column 33: expected 3 values for table table_teste, found 2" {
+		t.Errorf("expected an error for the missing value, got %v", err)
+	}
+
+	update := parse[*parser.UpdateStatement](t, "UPDATE table_teste SET name = NULL, email = X'0aff' WHERE id = 1001")
+
+	if changes := update.Changes(); changes["name"] != nil || len(changes) != 2 || !reflect.DeepEqual(changes["email"], []byte{0x0a, 0xff}) {
+		t.Errorf("expected name and email to be set, got %v", changes)
+	}
+
+	if comparsions, err := update.Comparsions(); err != nil || len(comparsions) != 1 || comparsions[0].TableName != "table_teste" {
+		t.Errorf("expected the comparsion of WHERE id = 1001, got %v (%v)", comparsions, err)
+	}
+
+	remove := parse[*parser.DeleteStatement](t, "DELETE FROM table_teste")
+
+	if comparsions, err := remove.Comparsions(); err != nil || len(comparsions) != 0 {
+		t.Errorf("expected no comparsions without WHERE, got %v (%v)", comparsions, err)
+	}
+}
+
+func TestParseCreateStatements(t *testing.T) {
+	db, _ := helper.CreateMockDatabaseWithTableAndIndex(t)
+
+	if err := db.CreateSequence("codes", database.SequenceOptions{Start: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	create := parse[*parser.CreateTableStatement](t, `
+		CREATE TABLE orders (
+			id BIGINT AUTO_INCREMENT,
+			line SMALLINT,
+			code INT DEFAULT NEXTVAL('codes'),
+			customer VARCHAR(40) NOT NULL DEFAULT 'anonymous',
+			total DOUBLE,
+			PRIMARY KEY (id, line)
+		)`)
+
+	expected := []database.Column{
+		{Name: "id", Type: database.COL_TYPE_BIG_INT, AutoIncrement: true, Primary: true},
+		{Name: "line", Type: database.COL_TYPE_SMALL_INT, Primary: true},
+		{Name: "code", Type: database.COL_TYPE_INT, Nullable: true, Sequence: "codes"},
+		{Name: "customer", Type: database.COL_TYPE_STRING, Default: "anonymous"},
+		{Name: "total", Type: database.COL_TYPE_DOUBLE, Nullable: true},
+	}
+
+	if create.Name != "orders" || !reflect.DeepEqual(create.Columns, expected) {
+		t.Fatalf("expected columns %+v, got %+v", expected, create.Columns)
+	}
+
+	if err := db.CreateTable(create.Name, create.Columns); err != nil {
+		t.Fatalf("error creating the parsed table: %v", err)
+	}
+
+	index := parse[*parser.CreateIndexStatement](t, "CREATE UNIQUE INDEX CONCURRENTLY orders_customer ON orders (customer, total)")
+
+	if index.Name != "orders_customer" || index.Table != "orders" || !index.Options.Unique || !index.Options.Online {
+		t.Errorf("expected a unique index built online, got %+v", index)
+	}
+
+	if err := db.CreateCompositeIndex(index.Table, index.Columns, index.Name, index.Options); err != nil {
+		t.Errorf("error creating the parsed index: %v", err)
+	}
+}
+
+func TestParseSyntaxErrors(t *testing.T) {
+	cases := []struct {
+		sql     string
+		line    int
+		column  int
+		message string
+	}{
+		{"SELECT FROM users", 1, 8, `expected value, found "FROM"`},
+		{"SELECT * FROM users WHERE id IN (1, 2", 1, 38, `expected ")", found end of statement`},
+		{"SELECT * FROM users\nWHERE name = 'John", 2, 14, "string is never closed"},
+		{"SELECT * FROM users WHERE id >", 1, 31, "expected value, found end of statement"},
+		{"SELECT * FROM users WHERE id NOT = 1", 1, 34, `expected IN, LIKE or BETWEEN, found "="`},
+		{"SELECT * FROM users LIMIT 'ten'", 1, 27, "expected number of rows of LIMIT, found string 'ten'"},
+		{"SELECT * FROM users users2 users3", 1, 28, `expected end of statement, found "users3"`},
+		{"SELECT * FROM select", 1, 15, `expected table name, found "select"`},
+		{"INSERT INTO users (id, name) VALUES (1)", 1, 37, "expected 2 values, found 1"},
+		{"CREATE TABLE t (id INT PRIMARY KEY, id STRING)", 1, 37, "column id is defined twice"},
+		{"CREATE TABLE t (id UUID)", 1, 20, `expected column type, found "UUID"`},
+		{"CREATE VIEW v", 1, 8, `expected TABLE or INDEX, found "VIEW"`},
+		{"DROP TABLE users", 1, 1, `expected SELECT, INSERT, UPDATE, DELETE or CREATE, found "DROP"`},
+		{"SELECT * FROM users WHERE id = 1 # 2", 1, 34, "unexpected character '#'"},
+	}
+
+	for _, c := range cases {
+		_, err := parser.Parse(c.sql)
+
+		var syntaxError *parser.SyntaxError
+		if !errors.As(err, &syntaxError) {
+			t.Errorf("expected a syntax error parsing %q, got %v", c.sql, err)
+			continue
+		}
+
+		if syntaxError.Pos.Line != c.line || syntaxError.Pos.Column != c.column || syntaxError.Message != c.message {
+			t.Errorf("parsing %q expected %q at line %d, column %d, got %v", c.sql, c.message, c.line, c.column, err)
+		}
+	}
+}
+
+func TestWhereComparsionsLayers(t *testing.T) {
+	statement := parse[*parser.SelectStatement](t, "SELECT * FROM users u WHERE 10 < u.id AND NOT (name = 'Joana' OR id IN (1, 2))")
+	comparsions, err := statement.Comparsions()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The NOT turns the OR into an AND, so every comparsion is in the same layer
+	expected := []database.ColumnComparsion{
+		comparsion("id", database.GT, int64(10), 0, -1, database.AND, database.AND),
+		comparsion("name", database.NE, "Joana", 0, -1, database.AND, database.AND),
+		comparsion("id", database.NIN, []interface{}{int64(1), int64(2)}, 0, -1, database.AND, database.AND),
+	}
+
+	if !reflect.DeepEqual(comparsions, expected) {
+		t.Errorf("expected %+v, got %+v", expected, comparsions)
+	}
+
+	statement = parse[*parser.SelectStatement](t, "SELECT * FROM users WHERE (id < 3 OR id > 440) AND (name = 'Joana' OR name LIKE 'Al%')")
+	comparsions, _ = statement.Comparsions()
+
+	expected = []database.ColumnComparsion{
+		comparsion("id", database.LT, int64(3), 0, -1, database.OR, database.AND),
+		comparsion("id", database.GT, int64(440), 0, -1, database.OR, database.AND),
+		comparsion("name", database.EQ, "Joana", 1, 0, database.OR, database.AND),
+		comparsion("name", database.LIKE, "Al%", 1, 0, database.OR, database.AND),
+	}
+
+	if !reflect.DeepEqual(comparsions, expected) {
+		t.Errorf("expected %+v, got %+v", expected, comparsions)
+	}
+
+	statement = parse[*parser.SelectStatement](t, "SELECT * FROM users WHERE other.id = 1")
+
+	if _, err := statement.Comparsions(); err == nil || err.Error() != "syntax error at line 1, column 27: table other of column id is not in the statement" {
+		t.Errorf("expected an error for the unknown table, got %v", err)
+	}
+}
+
+func TestWhereComparsionsFilterRange(t *testing.T) {
+	table := helper.GetMocktableReadyForTesting(t)
+
+	cases := []struct {
+		where    string
+		expected string
+	}{
+		{"id BETWEEN 5 AND 8", "5 6 7 8"},
+		{"id NOT BETWEEN 3 AND 447", "1 2 448 449"},
+		{"id < 3 OR (name = 'Joana' AND id > 440)", "1 2 441 448"},
+		{"(id < 3 OR id > 446) AND (name = 'Joana' OR name LIKE 'Al%')", "1 447 448 449"},
+		{"id > 445 AND NOT name = 'Joana'", "446 447 449"},
+		{"NOT (id >= 3 AND id <= 446) AND name <> 'Albert'", "2 447 448"},
+		{"id IN (7, 14, 500) OR 449 <= id", "7 14 449"},
+		{"id < 20 AND name LIKE 'J_ne'", ""},
+	}
+
+	for _, c := range cases {
+		statement := parse[*parser.SelectStatement](t, "SELECT * FROM users WHERE "+c.where)
+		comparsions, err := statement.Comparsions()
+
+		if err != nil {
+			t.Errorf("error lowering %s: %v", c.where, err)
+			continue
+		}
+
+		if got := rowIds(rangeForTesting(t, table, comparsions, -1, database.ASC)); got != c.expected {
+			t.Errorf("WHERE %s expected ids [%s], got [%s]", c.where, c.expected, got)
+		}
+	}
+}
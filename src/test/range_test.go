@@ -12,8 +12,8 @@ func TestBasicScan(t *testing.T) {
 	table := helper.GetMocktableReadyForTesting(t)
 
 	// Create a range from 1 to 3
-	from, _ := utils.Serialize(int64(1))
-	to, _ := utils.Serialize(int64(3))
+	from, _ := utils.EncodeKey(int64(1))
+	to, _ := utils.EncodeKey(int64(3))
 
 	rangeOf := database.RangeOptions{
 		From:        from,
@@ -63,7 +63,7 @@ func TestBasicScanWholeData(t *testing.T) {
 func TestFullScanForNilFromField(t *testing.T) {
 	table := helper.GetMocktableReadyForTesting(t)
 
-	to, _ := utils.Serialize(int64(3))
+	to, _ := utils.EncodeKey(int64(3))
 
 	rangeOf := database.RangeOptions{
 		From:        nil,
@@ -89,7 +89,7 @@ func TestFullScanForNilFromField(t *testing.T) {
 func TestFullScanForNilToField(t *testing.T) {
 	table := helper.GetMocktableReadyForTesting(t)
 
-	from, _ := utils.Serialize(int64(447))
+	from, _ := utils.EncodeKey(int64(447))
 
 	rangeOf := database.RangeOptions{
 		From:        from,
@@ -107,7 +107,8 @@ func TestFullScanForNilToField(t *testing.T) {
 		t.Errorf("error getting range: %v", err)
 	}
 
-	if len(rangeData) != 2 {
+	// Ids 447, 448 and 449
+	if len(rangeData) != 3 {
 		t.Errorf("error getting value from datafile %d\n", len(rangeData))
 	}
 }
@@ -139,3 +140,29 @@ func TestScanForLimit(t *testing.T) {
 		t.Errorf("error getting value from datafile %d\n", rangeData[0]["id"])
 	}
 }
+
+func TestScanFromKeyGreaterThanEveryKey(t *testing.T) {
+	table := helper.GetMocktableReadyForTesting(t)
+
+	from, _ := utils.EncodeKey(int64(1000))
+
+	rangeOf := database.RangeOptions{
+		From:        from,
+		To:          nil,
+		FComparator: database.GTE,
+		TComparator: database.GT,
+		Order:       database.ASC,
+		Limit:       -1,
+		PDataFile:   table.PDataFile,
+	}
+
+	rangeData, err := database.RangeFromOptions(table, rangeOf)
+
+	if err != nil {
+		t.Errorf("error getting range: %v", err)
+	}
+
+	if len(rangeData) != 0 {
+		t.Errorf("expected no rows, got %d\n", len(rangeData))
+	}
+}
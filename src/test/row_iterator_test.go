@@ -0,0 +1,104 @@
+package main
+
+/*
+Tests for row iterators, which must return the same rows as Range while reading them one at a time from the snapshot
+taken when they were created
+*/
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	database "github.com/nicolasvancan/monvandb/src/database"
+	helper "github.com/nicolasvancan/monvandb/src/test/helper"
+)
+
+func collectIterator(t *testing.T, rows *database.RowIterator) []database.RawRow {
+	t.Helper()
+	defer rows.Close()
+
+	collected := make([]database.RawRow, 0)
+	for rows.Next() {
+		collected = append(collected, rows.Row())
+	}
+
+	if err := rows.Err(); err != nil {
+		t.Fatalf("error iterating rows: %v", err)
+	}
+
+	return collected
+}
+
+func TestScanIterator(t *testing.T) {
+	table := helper.GetMocktableReadyForTesting(t)
+	rows := table.Scan(context.Background())
+
+	// Rows written after the iterator is created are not read by it
+	table.Delete([]database.RawRow{{"id": int64(1)}, {"id": int64(449)}})
+
+	collected := collectIterator(t, rows)
+
+	if len(collected) != 449 || collected[0]["id"] != int64(1) || collected[448]["id"] != int64(449) {
+		t.Errorf("expected the 449 rows in order, got %d rows", len(collected))
+	}
+
+	if rows.Next() {
+		t.Error("a closed iterator should have no rows")
+	}
+
+	if collected := collectIterator(t, table.Scan(context.Background())); len(collected) != 447 {
+		t.Errorf("expected 447 rows once two were deleted, got %d", len(collected))
+	}
+}
+
+func TestRangeIterator(t *testing.T) {
+	table := helper.GetMocktableReadyForTesting(t)
+
+	queries := [][]database.ColumnComparsion{
+		helper.QueryOne,
+		helper.QueryTwo,
+		and(where("name", database.EQ, "Joana")),
+		nil,
+	}
+
+	for _, query := range queries {
+		for _, order := range []int{database.ASC, database.DESC} {
+			expected := rowIds(rangeForTesting(t, table, query, 5, order))
+			got := rowIds(collectIterator(t, table.RangeIter(context.Background(), query, 5, order)))
+
+			if got != expected {
+				t.Errorf("expected ids [%s], got [%s]", expected, got)
+			}
+		}
+	}
+}
+
+func TestRangeIteratorThroughIndex(t *testing.T) {
+	table := helper.CreateMockTableAndIndexWithRows(t)
+	query := and(where("email", database.GTE, "o"))
+
+	if got := rowIds(collectIterator(t, table.RangeIter(context.Background(), query, -1, database.ASC))); got != "2" {
+		t.Errorf("expected the row of paulo@paulo.com, got [%s]", got)
+	}
+}
+
+func TestIteratorStopsWithContext(t *testing.T) {
+	table := helper.GetMocktableReadyForTesting(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rows := table.Scan(ctx)
+	defer rows.Close()
+
+	read := 0
+	for rows.Next() {
+		if read++; read == 10 {
+			cancel()
+		}
+	}
+
+	if read != 10 || !errors.Is(rows.Err(), context.Canceled) {
+		t.Errorf("expected 10 rows read before the context was canceled, got %d with error %v", read, rows.Err())
+	}
+}
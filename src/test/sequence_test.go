@@ -0,0 +1,233 @@
+package main
+
+/*
+Tests for sequences, standalone and of auto increment columns. A value must never be given twice, not even after the
+database is loaded again
+*/
+
+import (
+	"sync"
+	"testing"
+
+	database "github.com/nicolasvancan/monvandb/src/database"
+	helper "github.com/nicolasvancan/monvandb/src/test/helper"
+)
+
+func assertNextVal(t *testing.T, db *database.Database, name string, expected int64) {
+	t.Helper()
+
+	value, err := db.NextVal(name)
+
+	if err != nil || value != expected {
+		t.Errorf("expected next value %d of %s, got %d with error %v", expected, name, value, err)
+	}
+}
+
+func createAutoIncrementTable(t *testing.T, db *database.Database) *database.Table {
+	t.Helper()
+
+	err := db.CreateTable("auto", []database.Column{
+		{Name: "id", Type: database.COL_TYPE_INT, Primary: true, AutoIncrement: true},
+		{Name: "name", Type: database.COL_TYPE_STRING, Nullable: true},
+	})
+
+	if err != nil {
+		t.Fatalf("error creating table: %v", err)
+	}
+
+	table, _ := db.GetTable("auto")
+	return table
+}
+
+func TestSequence(t *testing.T) {
+	db, _ := helper.CreateMockDatabaseWithCompositeKeyTable(t)
+
+	if err := db.CreateSequence("seq", database.SequenceOptions{Start: 10, Increment: 5, Cache: 3}); err != nil {
+		t.Fatalf("error creating sequence: %v", err)
+	}
+
+	if err := db.CreateSequence("seq", database.SequenceOptions{}); err == nil {
+		t.Error("a sequence with a name already used should not be created")
+	}
+
+	if _, err := db.CurrVal("seq"); err == nil {
+		t.Error("a sequence should have no current value before giving one")
+	}
+
+	assertNextVal(t, db, "seq", 10)
+	assertNextVal(t, db, "seq", 15)
+
+	if value, err := db.CurrVal("seq"); err != nil || value != 15 {
+		t.Errorf("expected current value 15, got %d with error %v", value, err)
+	}
+
+	// Values reserved before loading the database again are skipped
+	reloaded := reloadMockDatabase(t)
+	assertNextVal(t, reloaded, "seq", 25)
+
+	if err := reloaded.SetVal("seq", 100); err != nil {
+		t.Fatalf("error setting value: %v", err)
+	}
+
+	// Values from 105 to 115 were reserved at once
+	assertNextVal(t, reloaded, "seq", 105)
+	assertNextVal(t, reloadMockDatabase(t), "seq", 120)
+
+	reloaded.CreateSequence("countdown", database.SequenceOptions{Start: 3, Increment: -2})
+	assertNextVal(t, reloaded, "countdown", 3)
+	assertNextVal(t, reloaded, "countdown", 1)
+
+	if err := reloaded.DropSequence("seq"); err != nil {
+		t.Fatalf("error dropping sequence: %v", err)
+	}
+
+	if _, err := reloadMockDatabase(t).NextVal("seq"); err == nil {
+		t.Error("a sequence dropped should not be loaded")
+	}
+}
+
+func TestAutoIncrementSequence(t *testing.T) {
+	db, _ := helper.CreateMockDatabaseWithCompositeKeyTable(t)
+	table := createAutoIncrementTable(t, db)
+
+	table.Insert([]database.RawRow{{"name": "a"}, {"name": "b"}, {"id": int32(10), "name": "c"}})
+
+	// The greatest value deleted is not given again
+	table.Delete([]database.RawRow{{"id": int32(2)}})
+	table.Insert([]database.RawRow{{"name": "d"}})
+
+	rows, err := table.RangeByKey(nil, nil, -1, database.ASC)
+
+	if err != nil || len(rows) != 3 || rows[0]["id"] != int32(1) || rows[1]["id"] != int32(3) {
+		t.Errorf("expected ids 1, 3 and 10, got %v with error %v", rows, err)
+	}
+
+	reloadedTable, _ := reloadMockDatabase(t).GetTable("auto")
+
+	// Writers running at once get values of their own
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if _, err := reloadedTable.Insert([]database.RawRow{{"name": "concurrent"}}); err != nil {
+				t.Errorf("error inserting: %v", err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	rows, _ = reloadedTable.Get("name", "concurrent")
+
+	if len(rows) != 8 {
+		t.Errorf("expected 8 rows inserted concurrently, got %d", len(rows))
+	}
+
+	for _, row := range rows {
+		if row["id"].(int32) <= 3 || row["id"] == int32(10) {
+			t.Errorf("value %v was given before", row["id"])
+		}
+	}
+}
+
+func TestAutoIncrementSequenceStartsAfterStoredValues(t *testing.T) {
+	db, _ := helper.CreateMockDatabaseWithCompositeKeyTable(t)
+	table := createAutoIncrementTable(t, db)
+
+	// Rows written with their values before the sequence is created
+	table.Insert([]database.RawRow{{"id": int32(41), "name": "a"}, {"id": int32(7), "name": "b"}})
+	table.Insert([]database.RawRow{{"name": "c"}})
+
+	rows, err := table.Get("name", "c")
+
+	if err != nil || len(rows) != 1 || rows[0]["id"] != int32(42) {
+		t.Errorf("expected id 42, got %v with error %v", rows, err)
+	}
+
+	// A table created again with the same name starts over
+	if err := db.DropTable("auto"); err != nil {
+		t.Fatalf("error dropping table: %v", err)
+	}
+
+	table = createAutoIncrementTable(t, db)
+	table.Insert([]database.RawRow{{"name": "d"}})
+
+	rows, err = table.Get("name", "d")
+
+	if err != nil || len(rows) != 1 || rows[0]["id"] != int32(1) {
+		t.Errorf("expected id 1, got %v with error %v", rows, err)
+	}
+}
+
+func TestAutoIncrementSequenceIsRenamedWithItsColumn(t *testing.T) {
+	db, _ := helper.CreateMockDatabaseWithCompositeKeyTable(t)
+	table := createAutoIncrementTable(t, db)
+
+	table.Insert([]database.RawRow{{"name": "a"}, {"name": "b"}, {"name": "c"}})
+	table.Delete([]database.RawRow{{"id": int32(3)}})
+
+	if err := table.RenameColumn("id", "key"); err != nil {
+		t.Fatalf("error renaming column: %v", err)
+	}
+
+	// The sequence keeps the values it gave under the new name
+	table.Insert([]database.RawRow{{"name": "d"}})
+	rows, err := table.Get("name", "d")
+
+	if err != nil || len(rows) != 1 || rows[0]["key"] != int32(4) {
+		t.Errorf("expected key 4, got %v with error %v", rows, err)
+	}
+
+	if _, err := db.CurrVal("auto.id"); err == nil {
+		t.Error("the sequence should not be found by the old name of the column")
+	}
+
+	reloaded := reloadMockDatabase(t)
+	reloadedTable, _ := reloaded.GetTable("auto")
+	reloadedTable.Insert([]database.RawRow{{"name": "e"}})
+	rows, err = reloadedTable.Get("name", "e")
+
+	if err != nil || len(rows) != 1 || rows[0]["key"].(int32) <= 4 {
+		t.Errorf("expected a key greater than 4 after loading the database again, got %v with error %v", rows, err)
+	}
+}
+
+func TestColumnSequence(t *testing.T) {
+	db, _ := helper.CreateMockDatabaseWithCompositeKeyTable(t)
+
+	columns := []database.Column{
+		{Name: "id", Type: database.COL_TYPE_BIG_INT, Primary: true, Sequence: "ids"},
+		{Name: "name", Type: database.COL_TYPE_STRING, Nullable: true},
+	}
+
+	if err := db.CreateTable("first", columns); err == nil {
+		t.Error("a table should not use a sequence that doesn't exist")
+	}
+
+	db.CreateSequence("ids", database.SequenceOptions{Start: 100})
+
+	// Both tables take their ids from the same sequence
+	for _, name := range []string{"first", "second"} {
+		if err := db.CreateTable(name, columns); err != nil {
+			t.Fatalf("error creating table: %v", err)
+		}
+
+		table, _ := db.GetTable(name)
+		table.Insert([]database.RawRow{{"name": name}})
+	}
+
+	assertNextVal(t, db, "ids", 102)
+
+	second, _ := db.GetTable("second")
+	rows, err := second.GetByKey(int64(101))
+
+	if err != nil || len(rows) != 1 || rows[0]["name"] != "second" {
+		t.Errorf("expected the row of the second table with id 101, got %v with error %v", rows, err)
+	}
+
+	if err := db.DropSequence("ids"); err == nil {
+		t.Error("a sequence used by columns should not be dropped")
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+/*
+Tests for snapshots. A snapshot must keep returning the data file as it was when it was taken, however it's changed
+afterwards, and the page versions kept for it must be released once it's closed
+*/
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"sync"
+	"testing"
+
+	bTree "github.com/nicolasvancan/monvandb/src/btree"
+	database "github.com/nicolasvancan/monvandb/src/database"
+	files "github.com/nicolasvancan/monvandb/src/files"
+	helper "github.com/nicolasvancan/monvandb/src/test/helper"
+)
+
+// Crawls the whole tree, returning every key found in order
+func crawlKeys(tree *bTree.BTree) [][]byte {
+	keys := make([][]byte, 0)
+	crawler := bTree.GoToFirstLeaf(tree)
+
+	for kv := crawler.GetKeyValue(); kv != nil; kv = crawler.GetKeyValue() {
+		keys = append(keys, kv.Key)
+
+		if crawler.Next() != nil {
+			break
+		}
+	}
+
+	return keys
+}
+
+func assertKeysInRange(t *testing.T, keys [][]byte, from int, to int) {
+	if len(keys) != to-from {
+		t.Fatalf("expected %d keys, got %d", to-from, len(keys))
+	}
+
+	for i, key := range keys {
+		if !bytes.Equal(key, uint32Key(from+i)) {
+			t.Fatalf("expected key %d at position %d, got %v", from+i, i, key)
+		}
+	}
+}
+
+func TestSnapshotDoesNotSeeLaterCommits(t *testing.T) {
+	dataFile, _ := openDataFileForTesting(t)
+	fillDataFileForChecksum(t, dataFile)
+
+	snapshot := dataFile.Snapshot()
+	defer snapshot.Close()
+
+	dataFile.Batch(func(tx *files.FileTx) error {
+		for i := 0; i < 100; i++ {
+			tx.Delete(uint32Key(i))
+		}
+
+		for i := 200; i < 400; i++ {
+			tx.Insert(uint32Key(i), make([]byte, 100))
+		}
+		return nil
+	})
+	dataFile.Update(uint32Key(150), []byte("updated"))
+
+	assertKeysInRange(t, crawlKeys(snapshot.GetBTree()), 0, 200)
+
+	keyValues, err := snapshot.Get(uint32Key(150))
+
+	if err != nil || len(keyValues) != 1 || len(keyValues[0].Value) != 100 {
+		t.Errorf("snapshot should see the value before the update, got %v (%v)", keyValues, err)
+	}
+
+	// A new snapshot sees every commit
+	latest := dataFile.Snapshot()
+	defer latest.Close()
+
+	assertKeysInRange(t, crawlKeys(latest.GetBTree()), 100, 400)
+}
+
+func TestSnapshotCrawlerSurvivesSplits(t *testing.T) {
+	dataFile, _ := openDataFileForTesting(t)
+	fillDataFileForChecksum(t, dataFile)
+
+	snapshot := dataFile.Snapshot()
+	defer snapshot.Close()
+
+	crawler := bTree.GoToFirstLeaf(snapshot.GetBTree())
+	keys := make([][]byte, 0)
+
+	for i := 0; i < 100; i++ {
+		keys = append(keys, crawler.GetKeyValue().Key)
+		crawler.Next()
+	}
+
+	// Every leaf the crawler still has to visit is split meanwhile
+	for i := 0; i < 200; i++ {
+		dataFile.Update(uint32Key(i), make([]byte, 1000))
+	}
+
+	for kv := crawler.GetKeyValue(); kv != nil; kv = crawler.GetKeyValue() {
+		keys = append(keys, kv.Key)
+
+		if crawler.Next() != nil {
+			break
+		}
+	}
+
+	assertKeysInRange(t, keys, 0, 200)
+}
+
+func TestPageVersionsAreReleased(t *testing.T) {
+	dataFile, _ := openDataFileForTesting(t)
+	fillDataFileForChecksum(t, dataFile)
+
+	// Without snapshots, nothing is kept
+	dataFile.Update(uint32Key(1), []byte("first"))
+
+	if n := dataFile.GetPageVersions(); n != 0 {
+		t.Errorf("no versions should be kept without snapshots, found %d", n)
+	}
+
+	older := dataFile.Snapshot()
+	dataFile.Update(uint32Key(1), []byte("second"))
+	newer := dataFile.Snapshot()
+	dataFile.Update(uint32Key(1), []byte("third"))
+
+	if n := dataFile.GetPageVersions(); n == 0 {
+		t.Fatal("versions should be kept for open snapshots")
+	}
+
+	older.Close()
+
+	if keyValues, _ := newer.Get(uint32Key(1)); len(keyValues) != 1 || string(keyValues[0].Value) != "second" {
+		t.Errorf("newer snapshot should still see its version, got %v", keyValues)
+	}
+
+	newer.Close()
+
+	if n := dataFile.GetPageVersions(); n != 0 {
+		t.Errorf("every version should be released once snapshots are closed, found %d", n)
+	}
+}
+
+func TestSnapshotsInMmapMode(t *testing.T) {
+	dataFile := openDataFileWithOptionsForTesting(t, t.TempDir()+string(os.PathSeparator)+"mmap_snapshot.db", mmapOptions)
+	fillDataFileForChecksum(t, dataFile)
+
+	snapshot := dataFile.Snapshot()
+	defer snapshot.Close()
+
+	for i := 0; i < 200; i++ {
+		dataFile.Delete(uint32Key(i))
+	}
+
+	assertKeysInRange(t, crawlKeys(snapshot.GetBTree()), 0, 200)
+}
+
+/*
+Readers crawl snapshots while a writer inserts keys in batches of ten. Every snapshot must see whole batches only,
+with every key in order. Run it with -race to check that readers and the writer share nothing unguarded
+*/
+func TestConcurrentSnapshotsAndWriter(t *testing.T) {
+	dataFile, _ := openDataFileForTesting(t)
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				snapshot := dataFile.Snapshot()
+				keys := crawlKeys(snapshot.GetBTree())
+				snapshot.Close()
+
+				if len(keys)%10 != 0 {
+					t.Errorf("snapshot saw part of a batch, %d keys", len(keys))
+					return
+				}
+
+				for i, key := range keys {
+					if binary.BigEndian.Uint32(key) != uint32(i) {
+						t.Errorf("expected key %d at position %d, got %v", i, i, key)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	for batch := 0; batch < 100; batch++ {
+		dataFile.Batch(func(tx *files.FileTx) error {
+			for i := 0; i < 10; i++ {
+				tx.Insert(uint32Key(batch*10+i), make([]byte, 50))
+			}
+			return nil
+		})
+	}
+
+	close(done)
+	wg.Wait()
+
+	if n := dataFile.GetPageVersions(); n != 0 {
+		t.Errorf("every version should be released once snapshots are closed, found %d", n)
+	}
+}
+
+func TestTableReadsDontSeeUncommittedTransactions(t *testing.T) {
+	db, table := helper.CreateMockDatabaseWithTableAndIndex(t)
+	tx := beginForTesting(t, db)
+	tx.Insert("table_teste", txRows)
+
+	assertRowsInTableAndIndex(t, table, 1, "john@john.com", 0)
+
+	rows, err := database.RangeFromOptions(table, database.RangeOptions{Limit: -1, PDataFile: table.PDataFile})
+
+	if err != nil || len(rows) != 0 {
+		t.Errorf("range should not see uncommitted rows, got %v (%v)", rows, err)
+	}
+
+	tx.Commit()
+	assertRowsInTableAndIndex(t, table, 1, "john@john.com", 1)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	database "github.com/nicolasvancan/monvandb/src/database"
+	executor "github.com/nicolasvancan/monvandb/src/executor"
+	parser "github.com/nicolasvancan/monvandb/src/parser"
+	helper "github.com/nicolasvancan/monvandb/src/test/helper"
+)
+
+// Lowers the condition of a join of the tables, the first one being the joined table
+func joinOn(t *testing.T, condition string, tables ...string) []database.ColumnComparsion {
+	t.Helper()
+	statement := parse[*parser.SelectStatement](t, fmt.Sprintf("SELECT * FROM %s WHERE %s", tables[0], condition))
+
+	refs := make([]parser.TableRef, len(tables))
+	for i, table := range tables {
+		refs[i] = parser.TableRef{Name: table}
+	}
+
+	comparsions, err := parser.Comparsions(statement.Where, refs...)
+
+	if err != nil {
+		t.Fatalf("error lowering %s: %v", condition, err)
+	}
+
+	return comparsions
+}
+
+// Creates the mock table with 20 users and the table of their orders
+func createUsersAndOrders(t *testing.T) (*database.Table, *database.Table) {
+	db, users := helper.CreateMockDatabaseWithTableAndIndex(t)
+
+	for i := 1; i <= 20; i++ {
+		if _, err := users.Insert([]database.RawRow{{"id": i, "name": fmt.Sprintf("user %d", i), "email": fmt.Sprintf("%d@teste.com", i)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return users, createOrdersTable(t, db)
+}
+
+func TestJoinTableStrategies(t *testing.T) {
+	users, orders := createUsersAndOrders(t)
+	on := joinOn(t, "table_teste.id = orders.user_id", "table_teste", "orders")
+	expected := "1,user 7,10.5 2,user 14,3 3,user 7,1.5"
+
+	// Every strategy joins the same rows, automatically by the primary key of the users
+	strategies := []int{executor.AUTO_JOIN, executor.INDEX_NESTED_LOOP_JOIN, executor.HASH_JOIN, executor.SORT_MERGE_JOIN, executor.NESTED_LOOP_JOIN}
+
+	for _, strategy := range strategies {
+		rows := collectPlan(t, executor.From(orders, "").
+			JoinTable(executor.TableJoin{Table: users, On: on, Strategy: strategy}).
+			Sort(executor.Asc("orders.id")))
+
+		if got := rowsColumns(rows, "orders.id", "table_teste.name", "orders.total"); got != expected {
+			t.Errorf("expected rows %s joining with strategy %d, got %s", expected, strategy, got)
+		}
+	}
+
+	// The user of the orders isn't indexed, so it can't be joined by an index
+	join := executor.TableJoin{Table: orders, On: on, Strategy: executor.INDEX_NESTED_LOOP_JOIN}
+
+	if _, err := executor.From(users, "").JoinTable(join).Run(context.Background()); err == nil {
+		t.Errorf("expected an error joining by a column without index")
+	}
+
+	join.Strategy = executor.AUTO_JOIN
+	rows := collectPlan(t, executor.From(users, "").JoinTable(join).Sort(executor.Asc("orders.id")))
+
+	if got := rowsColumns(rows, "orders.id", "table_teste.name", "orders.total"); got != expected {
+		t.Errorf("expected rows %s joining with a hash join, got %s", expected, got)
+	}
+
+	join.On = joinOn(t, "orders.user_id = table_teste.id OR orders.total > 5", "orders", "table_teste")
+
+	if _, err := executor.From(users, "").JoinTable(join).Run(context.Background()); err == nil {
+		t.Errorf("expected an error joining by comparsions joined by OR")
+	}
+}
+
+func TestJoinTableOuterJoins(t *testing.T) {
+	users, orders := createUsersAndOrders(t)
+	from := func() *executor.Plan {
+		return executor.From(users, "").Filter(func(row database.RawRow) bool {
+			id := fmt.Sprint(row["table_teste.id"])
+			return id == "6" || id == "7" || id == "8"
+		})
+	}
+
+	// Users without orders have null orders, comparsions of the orders filter them before joining
+	rows := collectPlan(t, from().JoinTable(executor.TableJoin{
+		Table: orders,
+		Kind:  executor.LEFT_JOIN,
+		On:    joinOn(t, "orders.user_id = table_teste.id AND orders.total > 2", "orders", "table_teste"),
+	}).Sort(executor.Asc("table_teste.id"), executor.Asc("orders.id")))
+
+	if got := rowsColumns(rows, "table_teste.id", "orders.id", "orders.total"); got != "6,<nil>,<nil> 7,1,10.5 8,<nil>,<nil>" {
+		t.Errorf("expected users 6 to 8 with their orders over 2, got %s", got)
+	}
+
+	if _, ok := rows[0]["orders.user_id"]; !ok {
+		t.Errorf("expected the columns of the orders in users without orders, got %v", rows[0])
+	}
+
+	// Comparsions of the orders in right joins keep them, as orders without users
+	for _, strategy := range []int{executor.AUTO_JOIN, executor.SORT_MERGE_JOIN, executor.NESTED_LOOP_JOIN} {
+		rows = collectPlan(t, executor.From(users, "").JoinTable(executor.TableJoin{
+			Table:    orders,
+			Kind:     executor.RIGHT_JOIN,
+			On:       joinOn(t, "orders.user_id = table_teste.id AND orders.total > 2", "orders", "table_teste"),
+			Strategy: strategy,
+		}).Sort(executor.Asc("orders.id")))
+
+		if got := rowsColumns(rows, "orders.id", "table_teste.name"); got != "1,user 7 2,user 14 3,<nil> 4,<nil> 5,<nil>" {
+			t.Errorf("expected every order with the users of those over 2 using strategy %d, got %s", strategy, got)
+		}
+	}
+
+	join := executor.TableJoin{Table: users, Kind: executor.RIGHT_JOIN, On: joinOn(t, "table_teste.id = orders.user_id", "table_teste", "orders"), Strategy: executor.INDEX_NESTED_LOOP_JOIN}
+
+	if _, err := executor.From(orders, "").JoinTable(join).Run(context.Background()); err == nil {
+		t.Errorf("expected an error using an index nested loop in a right join")
+	}
+}
+
+func TestJoinManyTables(t *testing.T) {
+	users, orders := createUsersAndOrders(t)
+
+	column := func(table string, name string, condition int, otherTable string, other string) database.ColumnComparsion {
+		comparsion := where(name, condition, nil)
+		comparsion.TableName = table
+		comparsion.Value = database.ColumnConditionValue{IsOtherColumn: true, IsOtherTable: true, ColumnName: other, TableHash: otherTable}
+		return comparsion
+	}
+
+	// Pairs of different orders of the same user
+	rows := collectPlan(t, executor.From(orders, "o").
+		JoinTable(executor.TableJoin{Table: users, Alias: "u", On: and(column("u", "id", database.EQ, "o", "user_id"))}).
+		JoinTable(executor.TableJoin{Table: orders, Alias: "p", On: and(
+			column("p", "user_id", database.EQ, "u", "id"),
+			column("p", "id", database.NE, "o", "id"),
+		)}).
+		Sort(executor.Asc("o.id")))
+
+	if got := rowsColumns(rows, "u.name", "o.id", "p.id"); got != "user 7,1,3 user 7,3,1" {
+		t.Errorf("expected the pairs of orders of user 7, got %s", got)
+	}
+
+	totals := collectPlan(t, executor.From(users, "u").
+		JoinTable(executor.TableJoin{Table: orders, Alias: "o", On: and(column("o", "user_id", database.EQ, "u", "id"))}).
+		HashAggregate([]string{"u.name"}, executor.Sum("o.total").As("total")).
+		Sort(executor.Desc("total")))
+
+	if got := rowsColumns(totals, "u.name", "total"); got != "user 7,12 user 14,3" {
+		t.Errorf("expected the totals of each user, got %s", got)
+	}
+}
@@ -8,6 +8,7 @@ A lot of different queries will be part of this test. The idea is to test the ra
 */
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	database "github.com/nicolasvancan/monvandb/src/database"
@@ -15,6 +16,18 @@ import (
 	utils "github.com/nicolasvancan/monvandb/src/utils"
 )
 
+// Returns the int64 encoded in a range boundary, 0 when there is none
+func decodeInt64Key(key []byte) int64 {
+	values, err := utils.DecodeKey(key)
+
+	if err != nil || len(values) == 0 {
+		return 0
+	}
+
+	value, _ := values[0].(int64)
+	return value
+}
+
 /*
 Query
 SELECT * FROM users WHERE id > 10 AND id < 20
@@ -29,8 +42,8 @@ func TestMergeOperationsCaseOne(t *testing.T) {
 	fmt.Printf("%d\n", rangeOptions.To)
 	var from int64
 	var to int64
-	utils.Deserialize(rangeOptions.From, &from)
-	utils.Deserialize(rangeOptions.To, &to)
+	from = decodeInt64Key(rangeOptions.From)
+	to = decodeInt64Key(rangeOptions.To)
 
 	if from != 10 {
 		t.Errorf("expected 10 as result, got %v", from)
@@ -55,8 +68,8 @@ func TestMergeOperationsCaseTwo(t *testing.T) {
 	var from int64
 	var to int64
 
-	utils.Deserialize(rangeOptions.From, &from)
-	utils.Deserialize(rangeOptions.To, &to)
+	from = decodeInt64Key(rangeOptions.From)
+	to = decodeInt64Key(rangeOptions.To)
 
 	if from != 10 {
 		t.Errorf("expected 10 as result, got %v", from)
@@ -102,14 +115,9 @@ func TestMergeOperationsCaseFour(t *testing.T) {
 	rangeOptions := database.MergeOperationsBasedOnIndexedColumnsAndReturnRangeOptions(table, helper.QueryFour)
 
 	var from int64
-	var to int64
 
 	if rangeOptions.From != nil {
-		utils.Deserialize(rangeOptions.From, &from)
-	}
-
-	if rangeOptions.To != nil {
-		utils.Deserialize(rangeOptions.To, &to)
+		from = decodeInt64Key(rangeOptions.From)
 	}
 
 	if from != 10 {
@@ -121,3 +129,138 @@ func TestMergeOperationsCaseFour(t *testing.T) {
 	}
 
 }
+
+func comparsion(column string, condition int, value interface{}, id int, parentId int, layerOp int, parentOp int) database.ColumnComparsion {
+	return database.ColumnComparsion{
+		ColumnName:      column,
+		TableName:       "users",
+		Condition:       condition,
+		Value:           database.ColumnConditionValue{Value: value},
+		Id:              id,
+		ParentId:        parentId,
+		LayerLogicalOp:  layerOp,
+		ParentLogicalOp: parentOp,
+	}
+}
+
+// Comparisons of the base layer joined by AND
+func and(comparsions ...database.ColumnComparsion) []database.ColumnComparsion {
+	return comparsions
+}
+
+func where(column string, condition int, value interface{}) database.ColumnComparsion {
+	return comparsion(column, condition, value, 0, -1, database.AND, database.AND)
+}
+
+// Reads the range of the table, failing the test on errors
+func rangeForTesting(t *testing.T, table *database.Table, input []database.ColumnComparsion, limit int, order int) []database.RawRow {
+	t.Helper()
+	rows, err := table.Range(input, limit, order)
+
+	if err != nil {
+		t.Fatalf("error reading range: %v", err)
+	}
+
+	return rows
+}
+
+func rowIds(rows []database.RawRow) string {
+	ids := make([]string, len(rows))
+	for i, row := range rows {
+		ids[i] = fmt.Sprint(row["id"])
+	}
+
+	return strings.Join(ids, " ")
+}
+
+func assertRangeIds(t *testing.T, rows []database.RawRow, expected string) {
+	t.Helper()
+
+	if got := rowIds(rows); got != expected {
+		t.Errorf("expected ids [%s], got [%s]", expected, got)
+	}
+}
+
+func TestRangeFiltersComparsions(t *testing.T) {
+	table := helper.GetMocktableReadyForTesting(t)
+
+	// SELECT * FROM users WHERE id > 10 AND id < 20 AND name = 'Joana'
+	assertRangeIds(t, rangeForTesting(t, table, helper.QueryTwo, -1, database.ASC), "14")
+
+	rows := rangeForTesting(t, table, and(where("id", database.IN, []int{1, 2, 3}), where("name", database.NE, "Albert")), -1, database.ASC)
+	assertRangeIds(t, rows, "2 3")
+
+	rows = rangeForTesting(t, table, and(where("id", database.NIN, []int64{442, 443}), where("id", database.GTE, 440), where("id", database.LTE, 444)), -1, database.ASC)
+	assertRangeIds(t, rows, "440 441 444")
+
+	rows = rangeForTesting(t, table, and(where("name", database.LIKE, "J%"), where("name", database.NLIKE, "%s"), where("id", database.GTE, 440)), -1, database.ASC)
+	assertRangeIds(t, rows, "441 443 448")
+
+	rows = rangeForTesting(t, table, and(where("name", database.LIKE, "_lbert"), where("id", database.LT, 20)), -1, database.ASC)
+	assertRangeIds(t, rows, "1 8 15")
+
+	// Comparisons of columns that are not indexed don't bound the keys of the table
+	rows = rangeForTesting(t, table, and(where("name", database.LT, "B"), where("id", database.GT, 440)), -1, database.ASC)
+	assertRangeIds(t, rows, "442 447 449")
+
+	if rows := rangeForTesting(t, table, nil, -1, database.ASC); len(rows) != 449 {
+		t.Errorf("expected every row without comparisons, got %d", len(rows))
+	}
+}
+
+func TestRangeEvaluatesLayers(t *testing.T) {
+	table := helper.GetMocktableReadyForTesting(t)
+
+	// SELECT * FROM users WHERE id < 3 OR (name = 'Joana' AND id > 440)
+	query := []database.ColumnComparsion{
+		comparsion("id", database.LT, 3, 0, -1, database.AND, database.AND),
+		comparsion("name", database.EQ, "Joana", 1, 0, database.AND, database.OR),
+		comparsion("id", database.GT, 440, 1, 0, database.AND, database.OR),
+	}
+
+	assertRangeIds(t, rangeForTesting(t, table, query, -1, database.ASC), "1 2 441 448")
+
+	// SELECT * FROM users WHERE id > 445 AND (name = 'Joana' OR name = 'Albert')
+	query = []database.ColumnComparsion{
+		comparsion("id", database.GT, 445, 0, -1, database.AND, database.AND),
+		comparsion("name", database.EQ, "Joana", 1, 0, database.OR, database.AND),
+		comparsion("name", database.EQ, "Albert", 1, 0, database.OR, database.AND),
+	}
+
+	assertRangeIds(t, rangeForTesting(t, table, query, -1, database.ASC), "448 449")
+
+	// SELECT * FROM users WHERE id > 445 AND NOT (name = 'Joana')
+	query = []database.ColumnComparsion{
+		comparsion("id", database.GT, 445, 0, -1, database.AND, database.AND),
+		comparsion("name", database.EQ, "Joana", 1, 0, database.AND, database.NOT),
+	}
+
+	assertRangeIds(t, rangeForTesting(t, table, query, -1, database.ASC), "446 447 449")
+}
+
+func TestRangeLimitsFilteredRows(t *testing.T) {
+	table := helper.GetMocktableReadyForTesting(t)
+	query := and(where("name", database.EQ, "Joana"))
+
+	assertRangeIds(t, rangeForTesting(t, table, query, 3, database.ASC), "7 14 21")
+	assertRangeIds(t, rangeForTesting(t, table, query, 3, database.DESC), "448 441 434")
+}
+
+func TestRangeReportsCorruptedPage(t *testing.T) {
+	_, table := helper.CreateMockDatabaseWithTableAndIndex(t)
+
+	if _, err := table.Insert(txRows); err != nil {
+		t.Fatalf("error inserting rows: %v", err)
+	}
+
+	root := table.PDataFile.GetBTree().GetRoot()
+	table.PDataFile = corruptPageOfDataFile(t, table.PDataFile, table.Path+utils.SEPARATOR+utils.TABLE_FILE, root)
+
+	rows, err := table.Range(nil, -1, database.ASC)
+
+	if rows != nil {
+		t.Errorf("expected no rows from a corrupted page, got %v", rows)
+	}
+
+	assertPageCorrupted(t, err, root)
+}
@@ -0,0 +1,433 @@
+package main
+
+/*
+Tests for transactions. Changes done through a Tx to a table and its indexes must be committed or discarded all
+together, and a crash in the middle of a commit must be recovered as the TxLog decided
+*/
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	database "github.com/nicolasvancan/monvandb/src/database"
+	files "github.com/nicolasvancan/monvandb/src/files"
+	helper "github.com/nicolasvancan/monvandb/src/test/helper"
+	utils "github.com/nicolasvancan/monvandb/src/utils"
+)
+
+var txRows = []database.RawRow{
+	{
+		"id":    int32(1),
+		"name":  "John",
+		"email": "john@john.com",
+	},
+	{
+		"id":    int32(2),
+		"name":  "Maria",
+		"email": "maria@john.com",
+	},
+}
+
+func beginForTesting(t *testing.T, db *database.Database) *database.Tx {
+	tx, err := db.Begin()
+
+	if err != nil {
+		t.Fatalf("error beginning transaction: %v", err)
+	}
+
+	return tx
+}
+
+// Asserts how many rows are found for the value, both through the primary key and through the email index
+func assertRowsInTableAndIndex(t *testing.T, table *database.Table, id int32, email string, expected int) {
+	rows, err := table.Get("id", id)
+
+	if err != nil || len(rows) != expected {
+		t.Errorf("expected %d rows with id %d, got %v (%v)", expected, id, rows, err)
+	}
+
+	rows, err = table.Get("email", email)
+
+	if err != nil || len(rows) != expected {
+		t.Errorf("expected %d rows with email %s, got %v (%v)", expected, email, rows, err)
+	}
+}
+
+func TestTxCommitsTableAndIndexes(t *testing.T) {
+	db, table := helper.CreateMockDatabaseWithTableAndIndex(t)
+	tx := beginForTesting(t, db)
+
+	if _, err := tx.Insert("table_teste", txRows); err != nil {
+		t.Fatalf("error inserting rows: %v", err)
+	}
+
+	// The transaction sees its own changes
+	rows, err := tx.Get("table_teste", "email", "maria@john.com")
+
+	if err != nil || len(rows) != 1 {
+		t.Errorf("transaction should see its own rows, got %v (%v)", rows, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		t.Fatalf("error committing: %v", err)
+	}
+
+	assertRowsInTableAndIndex(t, table, 1, "john@john.com", 1)
+	assertRowsInTableAndIndex(t, table, 2, "maria@john.com", 1)
+}
+
+func TestTxRollbackDiscardsTableAndIndexes(t *testing.T) {
+	db, table := helper.CreateMockDatabaseWithTableAndIndex(t)
+	tx := beginForTesting(t, db)
+
+	if _, err := tx.Insert("table_teste", txRows); err != nil {
+		t.Fatalf("error inserting rows: %v", err)
+	}
+
+	tx.Rollback()
+
+	assertRowsInTableAndIndex(t, table, 1, "john@john.com", 0)
+	assertRowsInTableAndIndex(t, table, 2, "maria@john.com", 0)
+
+	if _, err := tx.Insert("table_teste", txRows); !errors.Is(err, database.ErrTxDone) {
+		t.Errorf("expected ErrTxDone, got %v", err)
+	}
+
+	// Tables can be changed again once the transaction is over
+	if _, err := table.Insert(txRows[:1]); err != nil {
+		t.Errorf("error inserting after rollback: %v", err)
+	}
+
+	assertRowsInTableAndIndex(t, table, 1, "john@john.com", 1)
+}
+
+func TestTxFailureRollsBackEveryChange(t *testing.T) {
+	db, table := helper.CreateMockDatabaseWithTableAndIndex(t)
+	tx := beginForTesting(t, db)
+
+	if _, err := tx.Insert("table_teste", txRows[:1]); err != nil {
+		t.Fatalf("error inserting rows: %v", err)
+	}
+
+	// The second row already exists in the transaction
+	if _, err := tx.Insert("table_teste", txRows); err == nil {
+		t.Fatal("inserting a duplicated row should fail")
+	}
+
+	if err := tx.Commit(); !errors.Is(err, database.ErrTxDone) {
+		t.Errorf("expected ErrTxDone, got %v", err)
+	}
+
+	assertRowsInTableAndIndex(t, table, 1, "john@john.com", 0)
+}
+
+func TestTxRangeReportsCorruptedPage(t *testing.T) {
+	db, table := helper.CreateMockDatabaseWithTableAndIndex(t)
+
+	if _, err := table.Insert(txRows); err != nil {
+		t.Fatalf("error inserting rows: %v", err)
+	}
+
+	root := table.PDataFile.GetBTree().GetRoot()
+	table.PDataFile = corruptPageOfDataFile(t, table.PDataFile, table.Path+utils.SEPARATOR+utils.TABLE_FILE, root)
+
+	tx := beginForTesting(t, db)
+	defer tx.Rollback()
+
+	rows, err := tx.Range("table_teste", nil, -1, database.ASC)
+
+	if rows != nil {
+		t.Errorf("expected no rows from a corrupted page, got %v", rows)
+	}
+
+	assertPageCorrupted(t, err, root)
+}
+
+func TestTxLogKeepsDecisions(t *testing.T) {
+	path := t.TempDir() + string(os.PathSeparator) + "tx.dblg"
+	txLog, err := files.OpenTxLog(path)
+
+	if err != nil {
+		t.Fatalf("error opening tx log: %v", err)
+	}
+
+	first, second, third := txLog.Next(), txLog.Next(), txLog.Next()
+	txLog.Commit(first)
+	txLog.Commit(third)
+	txLog.Close()
+
+	reopened, err := files.OpenTxLog(path)
+
+	if err != nil {
+		t.Fatalf("error opening tx log: %v", err)
+	}
+
+	defer reopened.Close()
+
+	if !reopened.IsCommitted(first) || reopened.IsCommitted(second) || !reopened.IsCommitted(third) {
+		t.Errorf("decisions were not kept: %d %d %d", first, second, third)
+	}
+
+	reopened.Compact()
+
+	if next := reopened.Next(); next <= third {
+		t.Errorf("new ids must be greater than old ones, got %d after %d", next, third)
+	}
+}
+
+/*
+Prepares an insertion in two data files and returns the content they would leave behind if the process crashed
+right after it
+*/
+func prepareInsertionAndCrash(t *testing.T, txId uint64) ([][]byte, [][]byte) {
+	contents := make([][]byte, 0)
+	logs := make([][]byte, 0)
+
+	for i := 0; i < 2; i++ {
+		dataFile, path := openDataFileForTesting(t)
+		tx, _ := dataFile.Begin()
+		tx.Insert(uint32Key(i), []byte("value"))
+
+		if err := tx.Prepare(txId); err != nil {
+			t.Fatalf("error preparing: %v", err)
+		}
+
+		contents = append(contents, readFileForTesting(t, path))
+		logs = append(logs, readFileForTesting(t, files.GetWalPath(path)))
+	}
+
+	return contents, logs
+}
+
+func TestPreparedTransactionIsRecoveredAsDecided(t *testing.T) {
+	contents, logs := prepareInsertionAndCrash(t, 7)
+
+	for _, committed := range []bool{true, false} {
+		isCommitted := func(txId uint64) bool { return committed && txId == 7 }
+
+		for i := range contents {
+			dataFile := openCrashedDataFileWithOptions(t, contents[i], logs[i], files.DataFileOptions{IsCommitted: isCommitted})
+
+			if found := len(getForTesting(t, dataFile, uint32Key(i))) == 1; found != committed {
+				t.Errorf("data file %d: expected key found to be %v when the transaction committed is %v", i, found, committed)
+			}
+
+			assertNoProblems(t, dataFile)
+		}
+	}
+}
+
+func TestPreparedTransactionCantBeChanged(t *testing.T) {
+	dataFile, _ := openDataFileForTesting(t)
+	tx, _ := dataFile.Begin()
+	tx.Insert(uint32Key(1), []byte("value"))
+	tx.Prepare(1)
+
+	if err := tx.Insert(uint32Key(2), []byte("value")); err == nil {
+		t.Error("a prepared transaction should not accept new operations")
+	}
+
+	if err := tx.CommitPrepared(); err != nil {
+		t.Fatalf("error committing: %v", err)
+	}
+
+	if len(getForTesting(t, dataFile, uint32Key(1))) != 1 || len(getForTesting(t, dataFile, uint32Key(2))) != 0 {
+		t.Error("only the prepared insertion should have been committed")
+	}
+}
+
+// Starts fn in a goroutine, fails if it doesn't block and returns a function that waits for its result
+func runBlockedForTesting(t *testing.T, fn func() error) func() error {
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("operation should wait for the running transaction, finished with %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	return func() error { return <-done }
+}
+
+func TestPlainWritesWaitForRunningTx(t *testing.T) {
+	db, table := helper.CreateMockDatabaseWithTableAndIndex(t)
+	tx := beginForTesting(t, db)
+
+	if _, err := tx.Insert("table_teste", txRows[:1]); err != nil {
+		t.Fatalf("error inserting rows: %v", err)
+	}
+
+	wait := runBlockedForTesting(t, func() error {
+		_, err := table.Insert(txRows[1:])
+		return err
+	})
+
+	// The plain insertion didn't join the transaction, so it is not discarded with it
+	tx.Rollback()
+
+	if err := wait(); err != nil {
+		t.Fatalf("error inserting after the transaction: %v", err)
+	}
+
+	assertRowsInTableAndIndex(t, table, 1, "john@john.com", 0)
+	assertRowsInTableAndIndex(t, table, 2, "maria@john.com", 1)
+}
+
+func TestSecondTxWaitsForTheFirst(t *testing.T) {
+	db, table := helper.CreateMockDatabaseWithTableAndIndex(t)
+	first := beginForTesting(t, db)
+
+	if _, err := first.Insert("table_teste", txRows[:1]); err != nil {
+		t.Fatalf("error inserting rows: %v", err)
+	}
+
+	second := beginForTesting(t, db)
+	wait := runBlockedForTesting(t, func() error {
+		if _, err := second.Insert("table_teste", txRows[1:]); err != nil {
+			return err
+		}
+
+		return second.Commit()
+	})
+
+	if err := first.Commit(); err != nil {
+		t.Fatalf("error committing: %v", err)
+	}
+
+	if err := wait(); err != nil {
+		t.Fatalf("second transaction should run once the first is over: %v", err)
+	}
+
+	assertRowsInTableAndIndex(t, table, 1, "john@john.com", 1)
+	assertRowsInTableAndIndex(t, table, 2, "maria@john.com", 1)
+}
+
+func TestFileTxOwnsItsOperations(t *testing.T) {
+	dataFile, _ := openDataFileForTesting(t)
+	tx, err := dataFile.Begin()
+
+	if err != nil {
+		t.Fatalf("error beginning: %v", err)
+	}
+
+	tx.Insert(uint32Key(1), []byte("value"))
+
+	waitInsert := runBlockedForTesting(t, func() error { return dataFile.Insert(uint32Key(2), []byte("value")) })
+	waitBegin := runBlockedForTesting(t, func() error {
+		next, err := dataFile.Begin()
+
+		if err == nil {
+			next.Rollback()
+		}
+
+		return err
+	})
+
+	tx.Rollback()
+
+	if err := tx.Insert(uint32Key(3), []byte("value")); !errors.Is(err, files.ErrFileTxDone) {
+		t.Errorf("expected ErrFileTxDone, got %v", err)
+	}
+
+	if err := waitInsert(); err != nil {
+		t.Errorf("error inserting after the transaction: %v", err)
+	}
+
+	if err := waitBegin(); err != nil {
+		t.Errorf("error beginning after the transaction: %v", err)
+	}
+
+	if len(getForTesting(t, dataFile, uint32Key(1))) != 0 || len(getForTesting(t, dataFile, uint32Key(2))) != 1 {
+		t.Error("only the plain insertion should have been kept")
+	}
+
+	dataFile.Close()
+
+	if err := dataFile.Batch(func(tx *files.FileTx) error { return nil }); !errors.Is(err, files.ErrDataFileClosed) {
+		t.Errorf("expected ErrDataFileClosed from a closed data file, got %v", err)
+	}
+}
+
+/*
+Txs of many goroutines commit rows to two tables with indexes at the same time, sharing the TxLog of the database.
+Some of them change both tables, always in the same order
+*/
+func TestConcurrentTxsOnTwoTables(t *testing.T) {
+	db, table := helper.CreateMockDatabaseWithTableAndIndex(t)
+
+	if err := db.CreateTable("table_other", table.Columns); err != nil {
+		t.Fatalf("error creating table: %v", err)
+	}
+
+	if err := db.CreateIndex("table_other", "email", "email_other_index"); err != nil {
+		t.Fatalf("error creating index: %v", err)
+	}
+
+	tableNames := []string{"table_teste", "table_other"}
+	const goroutines, txsPerGoroutine = 4, 10
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines*txsPerGoroutine)
+
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(goroutine int) {
+			defer wg.Done()
+
+			for i := 0; i < txsPerGoroutine; i++ {
+				id := int32(goroutine*txsPerGoroutine + i)
+				row := database.RawRow{"id": id, "name": "name", "email": fmt.Sprintf("%d@john.com", id)}
+				names := tableNames
+				if i%3 != 0 {
+					names = tableNames[goroutine%2 : goroutine%2+1]
+				}
+
+				tx, err := db.Begin()
+
+				for _, name := range names {
+					if err == nil {
+						_, err = tx.Insert(name, []database.RawRow{row})
+					}
+				}
+
+				if err == nil {
+					err = tx.Commit()
+				}
+
+				if err != nil {
+					errs <- err
+				}
+			}
+		}(g)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("error running transaction: %v", err)
+	}
+
+	for g := 0; g < goroutines; g++ {
+		for i := 0; i < txsPerGoroutine; i++ {
+			id := int32(g*txsPerGoroutine + i)
+
+			for n, name := range tableNames {
+				expected := 0
+				if i%3 == 0 || g%2 == n {
+					expected = 1
+				}
+
+				other, _ := db.GetTable(name)
+				assertRowsInTableAndIndex(t, other, id, fmt.Sprintf("%d@john.com", id), expected)
+			}
+		}
+	}
+}
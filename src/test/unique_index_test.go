@@ -0,0 +1,164 @@
+package main
+
+/*
+Tests for unique indexes and indexes of many columns. Writes repeating the values of a unique index must fail with a
+ConstraintError, leaving the table as it was
+*/
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	database "github.com/nicolasvancan/monvandb/src/database"
+	helper "github.com/nicolasvancan/monvandb/src/test/helper"
+	utils "github.com/nicolasvancan/monvandb/src/utils"
+)
+
+func assertConstraintError(t *testing.T, err error, index string) {
+	t.Helper()
+
+	var constraintErr *database.ConstraintError
+	if !errors.As(err, &constraintErr) {
+		t.Fatalf("expected a constraint error, got %v", err)
+	}
+
+	if constraintErr.Index != index {
+		t.Errorf("expected index %s to be violated, got %s", index, constraintErr.Index)
+	}
+}
+
+func TestUniqueIndexRejectsDuplicates(t *testing.T) {
+	db, table := helper.CreateMockDatabaseWithCompositeKeyTable(t)
+	insertCompositeKeyRows(t, table)
+
+	if err := db.CreateIndexWithOptions("table_composite", "name", "name_index", database.IndexOptions{Unique: true}); err != nil {
+		t.Fatalf("error creating index: %v", err)
+	}
+
+	_, err := table.Insert([]database.RawRow{{"tenant": int32(4), "id": int32(1), "name": "1-1"}})
+	assertConstraintError(t, err, "name_index")
+
+	rows, err := table.GetByKey(int32(4))
+	assertRowNames(t, rows, err, "")
+
+	_, err = table.Update([]database.RawRow{{"tenant": int32(2), "id": int32(2), "name": "1-1"}})
+	assertConstraintError(t, err, "name_index")
+
+	rows, err = table.GetByKey(int32(2), int32(2))
+	assertRowNames(t, rows, err, "2-2")
+
+	// A row keeps its own value, and null values are never repeated
+	if _, err := table.Update([]database.RawRow{{"tenant": int32(1), "id": int32(1), "name": "1-1"}}); err != nil {
+		t.Errorf("updating a row with its own value should work, got %v", err)
+	}
+
+	nullNames := []database.RawRow{{"tenant": int32(5), "id": int32(1), "name": nil}, {"tenant": int32(5), "id": int32(2), "name": nil}}
+	if _, err := table.Insert(nullNames); err != nil {
+		t.Errorf("rows with null values should be inserted, got %v", err)
+	}
+
+	// Once a value is gone, it can be used again
+	if _, err := table.Update([]database.RawRow{{"tenant": int32(1), "id": int32(1), "name": "renamed"}}); err != nil {
+		t.Fatalf("error updating: %v", err)
+	}
+
+	if _, err := table.Insert([]database.RawRow{{"tenant": int32(4), "id": int32(1), "name": "1-1"}}); err != nil {
+		t.Errorf("a value no longer used should be inserted, got %v", err)
+	}
+
+	assertIndexEntries(t, table, "name", 15)
+}
+
+func TestUniqueIndexCantBeCreatedOverDuplicates(t *testing.T) {
+	db, table := helper.CreateMockDatabaseWithCompositeKeyTable(t)
+	insertCompositeKeyRows(t, table)
+	table.Update([]database.RawRow{{"tenant": int32(2), "id": int32(2), "name": "1-1"}})
+
+	for _, online := range []bool{false, true} {
+		err := db.CreateIndexWithOptions("table_composite", "name", "name_index", database.IndexOptions{Unique: true, Online: online})
+		assertConstraintError(t, err, "name_index")
+
+		if table.Indexes["name"] != nil {
+			t.Errorf("index that failed should not be in the table")
+		}
+	}
+
+	if err := db.CreateIndex("table_composite", "name", "name_index"); err != nil {
+		t.Errorf("index that is not unique should be created, got %v", err)
+	}
+}
+
+func TestCompositeIndex(t *testing.T) {
+	db, table := helper.CreateMockDatabaseWithCompositeKeyTable(t)
+	insertCompositeKeyRows(t, table)
+
+	err := db.CreateCompositeIndex("table_composite", []string{"id", "name"}, "id_name_index", database.IndexOptions{Unique: true})
+
+	if err != nil {
+		t.Fatalf("error creating index: %v", err)
+	}
+
+	// The index finds rows by its first column, sorted by the second one
+	rows, err := table.Get("id", int32(2))
+	assertRowNames(t, rows, err, "1-2 2-2 3-2")
+
+	// Only rows repeating both columns are duplicates
+	_, err = table.Insert([]database.RawRow{{"tenant": int32(4), "id": int32(2), "name": "1-2"}})
+	assertConstraintError(t, err, "id_name_index")
+
+	if _, err := table.Insert([]database.RawRow{{"tenant": int32(4), "id": int32(3), "name": "1-2"}}); err != nil {
+		t.Errorf("row repeating only one of the columns should be inserted, got %v", err)
+	}
+
+	reloaded, err := database.LoadDatabase(utils.GetPath("databases") + utils.SEPARATOR + "mock")
+
+	if err != nil {
+		t.Fatalf("error loading database: %v", err)
+	}
+
+	reloadedTable, _ := reloaded.GetTable("table_composite")
+	index := reloadedTable.Indexes["id,name"]
+
+	if index == nil || !index.Unique || len(index.Columns) != 2 || index.Columns[1] != "name" {
+		t.Fatalf("index should have been loaded with its columns, found %v", index)
+	}
+
+	rows, err = reloadedTable.Get("id", int32(3))
+	assertRowNames(t, rows, err, "1-2 1-3 2-3 3-3")
+}
+
+func TestUniqueIndexWithConcurrentWriters(t *testing.T) {
+	db, table := helper.CreateMockDatabaseWithCompositeKeyTable(t)
+
+	if err := db.CreateIndexWithOptions("table_composite", "name", "name_index", database.IndexOptions{Unique: true}); err != nil {
+		t.Fatalf("error creating index: %v", err)
+	}
+
+	// Every writer tries to insert the same name, only one of them succeeds
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = table.Insert([]database.RawRow{{"tenant": int32(i), "id": int32(1), "name": "same"}})
+		}(i)
+	}
+
+	wg.Wait()
+
+	inserted := 0
+	for _, err := range errs {
+		if err == nil {
+			inserted++
+			continue
+		}
+
+		assertConstraintError(t, err, "name_index")
+	}
+
+	if inserted != 1 {
+		t.Errorf("expected one row inserted, got %d", inserted)
+	}
+}
@@ -5,8 +5,11 @@ Tests validator from database package
 */
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"testing"
+	"time"
 
 	database "github.com/nicolasvancan/monvandb/src/database"
 	helper "github.com/nicolasvancan/monvandb/src/test/helper"
@@ -126,7 +129,8 @@ func TestValidatorForAutoIncrement(t *testing.T) {
 		t.Errorf("error validating columns should be nil: %v", err)
 	}
 
-	if row["id"] != int64(450) {
+	// Values take the Go type of their columns
+	if row["id"] != int32(450) {
 		t.Errorf("error should be 450 and got: %d", row["id"])
 	}
 }
@@ -174,3 +178,107 @@ func TestDefaultInsertion(t *testing.T) {
 		t.Errorf("error should be 450 and got: %d", row["id"])
 	}
 }
+
+func createTableWithEveryType(t *testing.T) *database.Table {
+	db, _ := helper.CreateMockDatabaseWithCompositeKeyTable(t)
+
+	err := db.CreateTable("typed", []database.Column{
+		{Name: "id", Type: database.COL_TYPE_BIG_INT, Primary: true},
+		{Name: "small", Type: database.COL_TYPE_SMALL_INT, Nullable: true},
+		{Name: "int", Type: database.COL_TYPE_INT, Nullable: true},
+		{Name: "float", Type: database.COL_TYPE_FLOAT, Nullable: true},
+		{Name: "double", Type: database.COL_TYPE_DOUBLE, Nullable: true},
+		{Name: "string", Type: database.COL_TYPE_STRING, Nullable: true},
+		{Name: "bool", Type: database.COL_TYPE_BOOL, Nullable: true},
+		{Name: "timestamp", Type: database.COL_TYPE_TIMESTAMP, Nullable: true},
+		{Name: "blob", Type: database.COL_TYPE_BLOB, Nullable: true},
+	})
+
+	if err != nil {
+		t.Fatalf("error creating table: %v", err)
+	}
+
+	table, _ := db.GetTable("typed")
+	return table
+}
+
+func TestValidatorCoercesValuesToColumnTypes(t *testing.T) {
+	table := createTableWithEveryType(t)
+
+	row := database.RawRow{
+		"id":        1,
+		"small":     int64(-300),
+		"int":       float64(42),
+		"float":     1,
+		"double":    float32(0.5),
+		"string":    "text",
+		"bool":      true,
+		"timestamp": "2024-03-01T10:00:00Z",
+		"blob":      []byte{1, 2},
+	}
+
+	if _, err := table.Insert([]database.RawRow{row}); err != nil {
+		t.Fatalf("error inserting row: %v", err)
+	}
+
+	rows, err := table.GetByKey(int64(1))
+
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("expected the row inserted, got %v with error %v", rows, err)
+	}
+
+	expected := database.RawRow{
+		"id":        int64(1),
+		"small":     int16(-300),
+		"int":       int32(42),
+		"float":     float32(1),
+		"double":    float64(0.5),
+		"string":    "text",
+		"bool":      true,
+		"timestamp": time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC),
+		"blob":      []byte{1, 2},
+	}
+
+	for column, value := range expected {
+		if fmt.Sprintf("%T %v", rows[0][column], rows[0][column]) != fmt.Sprintf("%T %v", value, value) {
+			t.Errorf("column %s: expected %T %v, got %T %v", column, value, value, rows[0][column], rows[0][column])
+		}
+	}
+}
+
+func TestValidatorRejectsValuesOfOtherTypes(t *testing.T) {
+	table := createTableWithEveryType(t)
+
+	invalid := []struct {
+		column string
+		value  interface{}
+	}{
+		{"small", 40000},
+		{"small", float64(32768)},
+		{"id", float64(math.MaxInt64)},
+		{"int", int64(1) << 40},
+		{"int", 1.5},
+		{"int", "12"},
+		{"float", "1.5"},
+		{"string", 12},
+		{"bool", "true"},
+		{"timestamp", "yesterday"},
+		{"timestamp", 1700000000},
+		{"blob", "bytes"},
+	}
+
+	for i, c := range invalid {
+		_, err := table.Insert([]database.RawRow{{"id": i, c.column: c.value}})
+
+		var typeErr *database.ColumnTypeError
+		if !errors.As(err, &typeErr) || typeErr.Column != c.column || typeErr.Value != c.value {
+			t.Errorf("%v for column %s: expected a column type error, got %v", c.value, c.column, err)
+		}
+	}
+
+	rows, err := table.RangeByKey(nil, nil, -1, database.ASC)
+
+	if err != nil || len(rows) != 0 {
+		t.Errorf("no row should have been inserted, got %v with error %v", rows, err)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+/*
+Tests for the integrity check. Trees built by the bTree functions must be reported as consistent, while trees
+broken on purpose must have every problem reported
+*/
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	bTree "github.com/nicolasvancan/monvandb/src/btree"
+	files "github.com/nicolasvancan/monvandb/src/files"
+)
+
+func assertNoProblems(t *testing.T, dataFile *files.DataFile) {
+	for _, problem := range dataFile.Verify() {
+		t.Errorf("unexpected problem: %v", problem)
+	}
+}
+
+func assertProblemFound(t *testing.T, problems []error, page uint64, problem string) {
+	for _, p := range problems {
+		var verifyError *bTree.VerifyError
+		if errors.As(p, &verifyError) && verifyError.Page == page && strings.Contains(verifyError.Problem, problem) {
+			return
+		}
+	}
+
+	t.Errorf("expected problem \"%s\" at page %d, found %v", problem, page, problems)
+}
+
+func TestVerifyAcceptsTreesAfterOperations(t *testing.T) {
+	dataFile, _ := openDataFileForTesting(t)
+	random := rand.New(rand.NewSource(7))
+	largeValue := make([]byte, 3*bTree.PAGE_SIZE)
+
+	assertNoProblems(t, dataFile)
+
+	// Keys are unique, as they are for primary keys
+	present := make(map[int]bool)
+	dataFile.Batch(func(tx *files.FileTx) error {
+		for i := 0; i < 3000; i++ {
+			k := random.Intn(5000)
+
+			switch {
+			case present[k]:
+				tx.Delete(uint32Key(k))
+			case i%500 == 0:
+				tx.Insert(uint32Key(k), largeValue)
+			default:
+				tx.Insert(uint32Key(k), make([]byte, random.Intn(200)))
+			}
+
+			present[k] = !present[k]
+		}
+		return nil
+	})
+
+	assertNoProblems(t, dataFile)
+}
+
+func TestVerifyReportsKeysOutOfOrder(t *testing.T) {
+	dataFile, _ := openDataFileForTesting(t)
+	fillDataFileForChecksum(t, dataFile)
+	tree := dataFile.GetBTree()
+
+	// A key greater than every other one is put in the first leaf, without going through the bTree functions
+	firstLeaf := bTree.MapAllLeavesToArray(tree)[0].TreeNode
+	leaf := tree.Get(firstLeaf)
+	leaf.PutLeafNewKeyValue(uint32Key(10000), []byte("value"))
+	tree.Set(leaf, firstLeaf)
+
+	problems := dataFile.Verify()
+	assertProblemFound(t, problems, firstLeaf, "out of the range given by the parent")
+}
+
+func TestVerifyReportsUnreachablePages(t *testing.T) {
+	dataFile, _ := openDataFileForTesting(t)
+	fillDataFileForChecksum(t, dataFile)
+
+	lost := dataFile.GetBTree().New(*bTree.NewNodeLeaf())
+
+	assertProblemFound(t, dataFile.Verify(), lost, "neither in the tree nor in the free list")
+}
+
+func TestVerifyReportsCorruptedPages(t *testing.T) {
+	dataFile, path := openDataFileForTesting(t)
+	fillDataFileForChecksum(t, dataFile)
+
+	leaves := bTree.MapAllLeavesToArray(dataFile.GetBTree())
+	leaf := leaves[len(leaves)/2].TreeNode
+	dataFile = corruptPageOfDataFile(t, dataFile, path, leaf)
+
+	problems := dataFile.Verify()
+	found := false
+
+	for _, problem := range problems {
+		var corrupted *bTree.ErrPageCorrupted
+		if errors.As(problem, &corrupted) && corrupted.Page == leaf {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Errorf("expected page %d to be reported as corrupted, found %v", leaf, problems)
+	}
+}
+
+func TestReadOnlyDataFileIsNotChangedByVerify(t *testing.T) {
+	dataFile, path := openDataFileForTesting(t)
+	fillDataFileForChecksum(t, dataFile)
+	dataFile.ForceSync()
+
+	// Insertions left in the log, as if the process crashed before a checkpoint
+	for i := 0; i < 10; i++ {
+		dataFile.Insert(uint32Key(100000+i), []byte("value"))
+	}
+
+	content := readFileForTesting(t, path)
+	log := readFileForTesting(t, files.GetWalPath(path))
+
+	crashedPath := t.TempDir() + string(os.PathSeparator) + "crashed.db"
+	os.WriteFile(crashedPath, content, 0666)
+	os.WriteFile(files.GetWalPath(crashedPath), log, 0666)
+
+	readOnly, err := files.OpenDataFileWithOptions(crashedPath, files.DataFileOptions{ReadOnly: true})
+
+	if err != nil {
+		t.Fatalf("error opening data file read only: %v", err)
+	}
+
+	assertNoProblems(t, readOnly)
+
+	if len(getForTesting(t, readOnly, uint32Key(100000))) != 0 {
+		t.Error("insertions left in the log should not be recovered")
+	}
+
+	if err := readOnly.Insert(uint32Key(1), []byte("value")); !errors.Is(err, files.ErrDataFileReadOnly) {
+		t.Errorf("expected ErrDataFileReadOnly, got %v", err)
+	}
+
+	readOnly.Close()
+
+	if !bytes.Equal(readFileForTesting(t, crashedPath), content) {
+		t.Error("the data file should not have been changed")
+	}
+
+	if !bytes.Equal(readFileForTesting(t, files.GetWalPath(crashedPath)), log) {
+		t.Error("the log should not have been changed")
+	}
+
+	if _, err := files.OpenDataFileWithOptions(path+".missing", files.DataFileOptions{ReadOnly: true}); err == nil {
+		t.Error("opening a missing data file read only should fail")
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+/*
+Tests for the DataFile write ahead log. Crashes are simulated by copying the DataFile as it was before some operations
+together with the log truncated at arbitrary points, then opening the copy, which must recover every committed
+operation and nothing else
+*/
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+
+	files "github.com/nicolasvancan/monvandb/src/files"
+)
+
+func readFileForTesting(t *testing.T, path string) []byte {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		t.Fatalf("error reading file %s: %v", path, err)
+	}
+
+	return data
+}
+
+/*
+Creates a DataFile with the given content and log, as if the process crashed and left them behind, and opens it
+*/
+func openCrashedDataFile(t *testing.T, dataFileContent []byte, log []byte) *files.DataFile {
+	return openCrashedDataFileWithOptions(t, dataFileContent, log, files.DataFileOptions{})
+}
+
+func openCrashedDataFileWithOptions(
+	t *testing.T,
+	dataFileContent []byte,
+	log []byte,
+	options files.DataFileOptions,
+) *files.DataFile {
+	path := t.TempDir() + string(os.PathSeparator) + "crashed.db"
+
+	if err := os.WriteFile(path, dataFileContent, 0666); err != nil {
+		t.Fatalf("error writing data file: %v", err)
+	}
+
+	if err := os.WriteFile(files.GetWalPath(path), log, 0666); err != nil {
+		t.Fatalf("error writing log: %v", err)
+	}
+
+	dataFile, err := files.OpenDataFileWithOptions(path, options)
+
+	if err != nil {
+		t.Fatalf("error opening crashed data file: %v", err)
+	}
+
+	t.Cleanup(dataFile.Close)
+	return dataFile
+}
+
+func TestOperationsAreLoggedUntilCheckpoint(t *testing.T) {
+	dataFile, path := openDataFileForTesting(t)
+
+	if err := dataFile.Insert(uint32Key(1), []byte("value")); err != nil {
+		t.Fatalf("error inserting: %v", err)
+	}
+
+	if fileSize(t, files.GetWalPath(path)) == 0 {
+		t.Error("log should hold the insertion")
+	}
+
+	dataFile.ForceSync()
+
+	if size := fileSize(t, files.GetWalPath(path)); size != 0 {
+		t.Errorf("log should be empty after a checkpoint, found %d bytes", size)
+	}
+}
+
+func TestRecoveryAfterCrashAtAnyPointOfTheLog(t *testing.T) {
+	dataFile, path := openDataFileForTesting(t)
+	value := make([]byte, 300)
+
+	for i := 0; i < 50; i++ {
+		dataFile.Insert(uint32Key(i), value)
+	}
+
+	// Everything before this point is in the data file
+	dataFile.ForceSync()
+	dataFileBeforeCrash := readFileForTesting(t, path)
+
+	// Log size after each committed operation
+	commitOffsets := make([]int64, 0)
+	for i := 50; i < 80; i++ {
+		dataFile.Insert(uint32Key(i), value)
+		commitOffsets = append(commitOffsets, fileSize(t, files.GetWalPath(path)))
+	}
+
+	log := readFileForTesting(t, files.GetWalPath(path))
+	cuts := []int64{0, 1, int64(len(log))}
+	for _, offset := range commitOffsets {
+		cuts = append(cuts, offset-1, offset, offset+17)
+	}
+
+	for _, cut := range cuts {
+		if cut < 0 || cut > int64(len(log)) {
+			continue
+		}
+
+		t.Run(fmt.Sprintf("cut at %d", cut), func(t *testing.T) {
+			recovered := openCrashedDataFile(t, dataFileBeforeCrash, log[:cut])
+
+			committed := 0
+			for _, offset := range commitOffsets {
+				if offset <= cut {
+					committed++
+				}
+			}
+
+			for i := 0; i < 80; i++ {
+				found := len(getForTesting(t, recovered, uint32Key(i))) == 1
+				if found != (i < 50+committed) {
+					t.Errorf("key %d found: %v, with %d committed operations", i, found, committed)
+				}
+			}
+		})
+	}
+}
+
+func TestRecoveryIsIdempotent(t *testing.T) {
+	dataFile, path := openDataFileForTesting(t)
+
+	for i := 0; i < 100; i++ {
+		dataFile.Insert(uint32Key(i), make([]byte, 200))
+	}
+
+	for i := 0; i < 100; i += 2 {
+		dataFile.Delete(uint32Key(i))
+	}
+
+	// The data file may already have some of the pages written, replaying the log again must not change anything
+	recovered := openCrashedDataFile(t, readFileForTesting(t, path), readFileForTesting(t, files.GetWalPath(path)))
+
+	for i := 0; i < 100; i++ {
+		if found := len(getForTesting(t, recovered, uint32Key(i))) == 1; found != (i%2 == 1) {
+			t.Errorf("key %d found: %v", i, found)
+		}
+	}
+}
+
+func TestRecoveryIgnoresCorruptedRecords(t *testing.T) {
+	dataFile, path := openDataFileForTesting(t)
+	dataFile.ForceSync()
+	dataFileBeforeCrash := readFileForTesting(t, path)
+
+	dataFile.Insert(uint32Key(1), []byte("first"))
+	firstCommit := fileSize(t, files.GetWalPath(path))
+	dataFile.Insert(uint32Key(2), []byte("second"))
+
+	// A byte of the second transaction is changed, as if it was torn
+	log := readFileForTesting(t, files.GetWalPath(path))
+	log[firstCommit+100] ^= 0xFF
+
+	recovered := openCrashedDataFile(t, dataFileBeforeCrash, log)
+
+	if len(getForTesting(t, recovered, uint32Key(1))) != 1 {
+		t.Error("first insertion should have been recovered")
+	}
+
+	if len(getForTesting(t, recovered, uint32Key(2))) != 0 {
+		t.Error("second insertion should have been discarded")
+	}
+}
+
+func TestBatchIsCommittedAtOnce(t *testing.T) {
+	dataFile, path := openDataFileForTesting(t)
+	dataFile.ForceSync()
+	dataFileBeforeCrash := readFileForTesting(t, path)
+
+	err := dataFile.Batch(func(tx *files.FileTx) error {
+		for i := 0; i < 200; i++ {
+			tx.Insert(uint32Key(i), make([]byte, 100))
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("error running batch: %v", err)
+	}
+
+	log := readFileForTesting(t, files.GetWalPath(path))
+
+	// Without the last byte, the commit record is lost and so is the whole batch
+	if recovered := openCrashedDataFile(t, dataFileBeforeCrash, log[:len(log)-1]); len(getForTesting(t, recovered, uint32Key(0))) != 0 {
+		t.Error("batch without commit record should have been discarded")
+	}
+
+	recovered := openCrashedDataFile(t, dataFileBeforeCrash, log)
+	for i := 0; i < 200; i++ {
+		if len(getForTesting(t, recovered, uint32Key(i))) != 1 {
+			t.Errorf("should have found key %d", i)
+		}
+	}
+}
+
+func TestBatchIsDiscardedOnError(t *testing.T) {
+	dataFile, _ := openDataFileForTesting(t)
+
+	for i := 0; i < 10; i++ {
+		dataFile.Insert(uint32Key(i), make([]byte, 100))
+	}
+
+	err := dataFile.Batch(func(tx *files.FileTx) error {
+		for i := 10; i < 500; i++ {
+			tx.Insert(uint32Key(i), make([]byte, 100))
+		}
+		return fmt.Errorf("something went wrong")
+	})
+
+	if err == nil {
+		t.Error("batch should return the error")
+	}
+
+	if len(getForTesting(t, dataFile, uint32Key(100))) != 0 {
+		t.Error("keys inserted by the batch should have been discarded")
+	}
+
+	// The tree is still usable
+	dataFile.Insert(uint32Key(10), make([]byte, 100))
+	for i := 0; i <= 10; i++ {
+		if len(getForTesting(t, dataFile, uint32Key(i))) != 1 {
+			t.Errorf("should have found key %d", i)
+		}
+	}
+}
+
+func TestConcurrentCommitsShareLogFlushes(t *testing.T) {
+	dataFile, path := openDataFileForTesting(t)
+
+	for i := 0; i < 1600; i++ {
+		dataFile.Insert(uint32Key(i), make([]byte, 10))
+	}
+
+	dataFile.ForceSync()
+	dataFileBeforeCrash := readFileForTesting(t, path)
+	before := dataFile.GetWalStats()
+
+	// Goroutines update keys of leaves of their own, which aren't split, so they commit alongside each other, even
+	// with one single cpu, whose goroutine would otherwise keep running while the log is flushed
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				dataFile.Update(uint32Key(g*200+i), bytes.Repeat([]byte{byte(g + 1)}, 10))
+			}
+		}(g)
+	}
+
+	wg.Wait()
+
+	// Commits written while a leader flushed the log are flushed together by the next one
+	stats := dataFile.GetWalStats()
+	commits, flushes := stats.Commits-before.Commits, stats.Flushes-before.Flushes
+
+	if commits != 200 || flushes == 0 || flushes >= commits {
+		t.Errorf("expected 200 commits sharing fewer flushes, got %d commits and %d flushes", commits, flushes)
+	}
+
+	recovered := openCrashedDataFile(t, dataFileBeforeCrash, readFileForTesting(t, files.GetWalPath(path)))
+	for i := 0; i < 1600; i++ {
+		expected := make([]byte, 10)
+		if i%200 < 25 {
+			expected = bytes.Repeat([]byte{byte(i/200 + 1)}, 10)
+		}
+
+		if values := getForTesting(t, recovered, uint32Key(i)); len(values) != 1 || !bytes.Equal(values[0].Value, expected) {
+			t.Errorf("expected key %d with value %v, got %v", i, expected, values)
+		}
+	}
+}
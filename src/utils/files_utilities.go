@@ -3,6 +3,7 @@ package utils
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 )
 
 func init() {
@@ -15,6 +16,8 @@ const (
 	METDATA_FILE     = "metadata.json"
 	TABLE_FILE       = "table.db"
 	TABLE_LOGS_FIILE = "hist.dblg"
+	TX_LOG_FILE      = "tx.dblg"
+	SEQUENCES_FILE   = "sequences.json"
 	INDICES_FOLDER   = "indices"
 )
 
@@ -67,6 +70,79 @@ func WriteToFile(path string, data []byte) error {
 	return nil
 }
 
+/*
+Writes data to a new file next to path and renames it over path, so that path holds either its old content or the new
+one, even after a crash. Unlike WriteToFile, the file may not exist yet
+*/
+func WriteFileAtomically(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+
+	if err != nil {
+		return err
+	}
+
+	// The temporary file is gone once renamed
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+
+	return SyncFolder(filepath.Dir(path))
+}
+
+// Flushes the entries of a folder, so that files created, renamed or removed in it are kept after a crash
+func SyncFolder(path string) error {
+	d, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer d.Close()
+	return d.Sync()
+}
+
+/*
+Removes a folder and everything in it. It's renamed first, so that a crash midway doesn't leave part of its files at
+path, and whatever was left by a crash before is removed as well
+*/
+func RemoveFolder(path string) error {
+	removed := path + ".removed"
+
+	if err := os.RemoveAll(removed); err != nil {
+		return err
+	}
+
+	if err := os.Rename(path, removed); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	if err := SyncFolder(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(removed)
+}
+
 func CreateFolder(path string, mode os.FileMode) error {
 	return os.MkdirAll(path, mode)
 }
@@ -0,0 +1,253 @@
+package utils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+/*
+Order preserving encoding for bTree keys
+
+The bTree compares keys with bytes.Compare, so keys must be encoded in a way that comparing the encoded bytes gives
+the same result as comparing the original values. Gob doesn't guarantee it (negative numbers, floats and strings with
+different lengths are sorted wrongly), therefore every key is encoded with the functions below.
+
+Every value starts with a one byte tag, followed by its payload:
+
+	nil       | tag
+	bool      | tag | 1B (0 or 1)
+	int       | tag | 8B big endian with the sign bit flipped
+	float     | tag | 8B big endian IEEE 754, negatives have all bits flipped, positives only the sign bit
+	string    | tag | bytes with 0x00 escaped as 0x00 0xFF | 0x00 0x01
+	blob      | tag | same as string
+	timestamp | tag | unix nanoseconds encoded as int
+
+All integer types are encoded as int64 and float32 as float64, so the same number always gives the same key. Since
+no encoded value is the prefix of another one, composite keys are the concatenation of the encoded values, and they
+are sorted by the first value, then the second one and so on.
+*/
+
+// Key tags. Values of different types are sorted by their tags
+const (
+	KEY_TAG_NIL = iota
+	KEY_TAG_BOOL
+	KEY_TAG_INT
+	KEY_TAG_FLOAT
+	KEY_TAG_STRING
+	KEY_TAG_BLOB
+	KEY_TAG_TIMESTAMP
+)
+
+const (
+	KEY_ESCAPE      = 0x00
+	KEY_ESCAPED_00  = 0xFF
+	KEY_TERMINATOR  = 0x01
+	KEY_NUMBER_SIZE = 8
+)
+
+// Encodes one or more values into a single key. More than one value produces a composite key
+func EncodeKey(values ...interface{}) ([]byte, error) {
+	key := make([]byte, 0)
+
+	for _, value := range values {
+		encoded, err := encodeKeyValue(value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		key = append(key, encoded...)
+	}
+
+	return key, nil
+}
+
+/*
+Decodes a key created by EncodeKey, returning all the values it contains. Integers are always returned as int64,
+floats as float64 and timestamps as UTC time.Time
+*/
+func DecodeKey(key []byte) ([]interface{}, error) {
+	values := make([]interface{}, 0)
+
+	for len(key) > 0 {
+		value, read, err := decodeKeyValue(key)
+
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, value)
+		key = key[read:]
+	}
+
+	return values, nil
+}
+
+func encodeKeyValue(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return []byte{KEY_TAG_NIL}, nil
+	case bool:
+		if v {
+			return []byte{KEY_TAG_BOOL, 1}, nil
+		}
+		return []byte{KEY_TAG_BOOL, 0}, nil
+	case int:
+		return encodeInt(KEY_TAG_INT, int64(v)), nil
+	case int8:
+		return encodeInt(KEY_TAG_INT, int64(v)), nil
+	case int16:
+		return encodeInt(KEY_TAG_INT, int64(v)), nil
+	case int32:
+		return encodeInt(KEY_TAG_INT, int64(v)), nil
+	case int64:
+		return encodeInt(KEY_TAG_INT, v), nil
+	case uint:
+		return encodeUint(uint64(v))
+	case uint8:
+		return encodeUint(uint64(v))
+	case uint16:
+		return encodeUint(uint64(v))
+	case uint32:
+		return encodeUint(uint64(v))
+	case uint64:
+		return encodeUint(v)
+	case float32:
+		return encodeFloat(float64(v)), nil
+	case float64:
+		return encodeFloat(v), nil
+	case string:
+		return encodeBytes(KEY_TAG_STRING, []byte(v)), nil
+	case []byte:
+		return encodeBytes(KEY_TAG_BLOB, v), nil
+	case time.Time:
+		return encodeInt(KEY_TAG_TIMESTAMP, v.UnixNano()), nil
+	}
+
+	return nil, fmt.Errorf("type %T can't be used as key", value)
+}
+
+func encodeInt(tag byte, v int64) []byte {
+	r := make([]byte, 1+KEY_NUMBER_SIZE)
+	r[0] = tag
+	// Flipping the sign bit puts negative numbers before positive ones
+	binary.BigEndian.PutUint64(r[1:], uint64(v)^(1<<63))
+	return r
+}
+
+func encodeUint(v uint64) ([]byte, error) {
+	if v > math.MaxInt64 {
+		return nil, fmt.Errorf("value %d overflows int64 and can't be used as key", v)
+	}
+
+	return encodeInt(KEY_TAG_INT, int64(v)), nil
+}
+
+func encodeFloat(v float64) []byte {
+	// -0 and 0 are the same key
+	if v == 0 {
+		v = 0
+	}
+
+	bits := math.Float64bits(v)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+
+	r := make([]byte, 1+KEY_NUMBER_SIZE)
+	r[0] = KEY_TAG_FLOAT
+	binary.BigEndian.PutUint64(r[1:], bits)
+	return r
+}
+
+func encodeBytes(tag byte, v []byte) []byte {
+	r := make([]byte, 0, len(v)+3)
+	r = append(r, tag)
+
+	for _, b := range v {
+		r = append(r, b)
+		if b == KEY_ESCAPE {
+			r = append(r, KEY_ESCAPED_00)
+		}
+	}
+
+	return append(r, KEY_ESCAPE, KEY_TERMINATOR)
+}
+
+// Decodes the first value of the key, returning it with the number of bytes read
+func decodeKeyValue(key []byte) (interface{}, int, error) {
+	switch key[0] {
+	case KEY_TAG_NIL:
+		return nil, 1, nil
+	case KEY_TAG_BOOL:
+		if len(key) < 2 {
+			return nil, 0, fmt.Errorf("truncated bool in key")
+		}
+		return key[1] == 1, 2, nil
+	case KEY_TAG_INT, KEY_TAG_TIMESTAMP:
+		if len(key) < 1+KEY_NUMBER_SIZE {
+			return nil, 0, fmt.Errorf("truncated number in key")
+		}
+
+		v := int64(binary.BigEndian.Uint64(key[1:1+KEY_NUMBER_SIZE]) ^ (1 << 63))
+		if key[0] == KEY_TAG_TIMESTAMP {
+			return time.Unix(0, v).UTC(), 1 + KEY_NUMBER_SIZE, nil
+		}
+		return v, 1 + KEY_NUMBER_SIZE, nil
+	case KEY_TAG_FLOAT:
+		if len(key) < 1+KEY_NUMBER_SIZE {
+			return nil, 0, fmt.Errorf("truncated number in key")
+		}
+
+		bits := binary.BigEndian.Uint64(key[1 : 1+KEY_NUMBER_SIZE])
+		if bits&(1<<63) != 0 {
+			bits &^= 1 << 63
+		} else {
+			bits = ^bits
+		}
+		return math.Float64frombits(bits), 1 + KEY_NUMBER_SIZE, nil
+	case KEY_TAG_STRING, KEY_TAG_BLOB:
+		v, read, err := decodeBytes(key[1:])
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if key[0] == KEY_TAG_STRING {
+			return string(v), 1 + read, nil
+		}
+		return v, 1 + read, nil
+	}
+
+	return nil, 0, fmt.Errorf("unknown key tag %d", key[0])
+}
+
+func decodeBytes(key []byte) ([]byte, int, error) {
+	r := make([]byte, 0)
+
+	for i := 0; i < len(key); i++ {
+		if key[i] != KEY_ESCAPE {
+			r = append(r, key[i])
+			continue
+		}
+
+		if i+1 >= len(key) {
+			break
+		}
+
+		switch key[i+1] {
+		case KEY_TERMINATOR:
+			return r, i + 2, nil
+		case KEY_ESCAPED_00:
+			r = append(r, KEY_ESCAPE)
+			i++
+		default:
+			return nil, 0, fmt.Errorf("invalid escape sequence in key")
+		}
+	}
+
+	return nil, 0, fmt.Errorf("unterminated bytes in key")
+}
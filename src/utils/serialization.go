@@ -5,8 +5,14 @@ import (
 	"encoding/gob"
 	"encoding/json"
 	"fmt"
+	"time"
 )
 
+// Values are serialized as interfaces, whose types must be registered unless they are basic ones
+func init() {
+	gob.Register(time.Time{})
+}
+
 func Deserialize(value []byte, dst interface{}) error {
 
 	// Create a new buffer from the serialized data